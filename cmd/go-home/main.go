@@ -1,18 +1,43 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
 	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"time"
 
+	"github.com/IktaS/go-home/internal/app/config"
+	"github.com/IktaS/go-home/internal/app/handlers"
 	"github.com/IktaS/go-home/internal/app/store"
+	"github.com/IktaS/go-home/internal/app/store/postgres"
 	"github.com/IktaS/go-home/internal/app/store/sqlite"
+	"github.com/IktaS/go-home/internal/pkg/auth"
+	"github.com/IktaS/go-home/internal/pkg/bus"
+	"github.com/IktaS/go-home/internal/pkg/coap"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/event"
+	"github.com/IktaS/go-home/internal/pkg/health"
+	"github.com/IktaS/go-home/internal/pkg/logging"
+	"github.com/IktaS/go-home/internal/pkg/mqtt"
+	"github.com/IktaS/go-home/internal/pkg/pki"
+	"github.com/IktaS/go-home/internal/pkg/rule"
+	"github.com/IktaS/go-home/internal/pkg/schedule"
+	"github.com/IktaS/go-home/internal/pkg/user"
 	"github.com/joho/godotenv"
 )
 
-func getLocalIP() string {
+// getLocalIP returns the local IP of the hub, with the port of the listen address when it has one
+func getLocalIP(listen string) string {
+	_, port, err := net.SplitHostPort(listen)
+	if err != nil {
+		port = ""
+	}
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
 		return ""
@@ -21,25 +46,61 @@ func getLocalIP() string {
 		// check the address type and if it is not a loopback the display it
 		if ipnet, ok := address.(*net.IPNet); ok && !ipnet.IP.IsLoopback() {
 			if ipnet.IP.To4() != nil {
-				if os.Getenv("PORT") == "" {
+				if port == "" {
 					return ipnet.IP.String()
 				}
-				return ipnet.IP.String() + ":" + os.Getenv("PORT")
+				return ipnet.IP.String() + ":" + port
 			}
 		}
 	}
 	return ""
 }
 
+// redactURI hides the access token a request URI may carry, so it is not written to the log
+func redactURI(u *url.URL) string {
+	query := u.Query()
+	if query.Get(handlers.AccessTokenParam) == "" {
+		return u.RequestURI()
+	}
+	query.Set(handlers.AccessTokenParam, "REDACTED")
+	return u.Path + "?" + query.Encode()
+}
+
+// logInfo logs a message of the hub at the info level
+func logInfo(v ...interface{}) {
+	logging.Println(logging.Info, v...)
+}
+
+// logWarn logs a message of the hub at the warn level
+func logWarn(v ...interface{}) {
+	logging.Println(logging.Warn, v...)
+}
+
+// logError logs a message of the hub at the error level
+func logError(v ...interface{}) {
+	logging.Println(logging.Error, v...)
+}
+
+// loggingMiddleware logs requests at the info level, with the client and how long they took at the debug level
 func loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Do stuff here
-		log.Println(r.RequestURI)
+		if logging.Enabled(logging.Debug) {
+			start := time.Now()
+			next.ServeHTTP(w, r)
+			log.Printf("%v %v from %v in %v\n", r.Method, redactURI(r.URL), r.RemoteAddr, time.Since(start))
+			return
+		}
+		logInfo(redactURI(r.URL))
 		// Call the next handler, which can be another middleware in the chain, or the final handler.
 		next.ServeHTTP(w, r)
 	})
 }
 
+// timeoutMiddleware answers 503 to a request that takes longer than the request timeout, it is not used on streams
+func (s *Server) timeoutMiddleware(next http.Handler) http.Handler {
+	return http.TimeoutHandler(next, time.Duration(s.config.Timeouts.Request), "Request Timed Out")
+}
+
 func loadEnv() {
 	env := os.Getenv("ENV")
 	if "" == env {
@@ -52,45 +113,303 @@ func loadEnv() {
 	}
 	godotenv.Load(".env." + env)
 	godotenv.Load() // The Original .env
+}
+
+// newIssuer makes the issuer of the hub codes of c, the codes it issues are logged
+func newIssuer(c config.HubCode) *auth.Issuer {
+	return &auth.Issuer{
+		TTL:       time.Duration(c.TTL),
+		SingleUse: c.SingleUse,
+		Announce: func(code string) {
+			log.Println("Hub code	:\t" + code)
+		},
+	}
+}
+
+// rotateHubCode issues a fresh hub code, and keeps rotating it when it expires
+func rotateHubCode(repo store.Repo, issuer *auth.Issuer) error {
+	_, err := issuer.Rotate(repo)
+	if err != nil {
+		return err
+	}
+	if issuer.TTL == 0 {
+		return nil
+	}
+	go func() {
+		for range time.Tick(issuer.TTL) {
+			_, err := issuer.Rotate(repo)
+			if err != nil {
+				logError("Cannot rotate hub code : " + err.Error())
+			}
+		}
+	}()
+	return nil
+}
+
+// connectMQTT connects the server to the MQTT broker of its config when it is set, so devices can select the mqtt transport,
+// the events they publish are pushed the same as over HTTP
+func connectMQTT(s *Server) error {
+	c := s.config.MQTT
+	if c.Broker == "" {
+		return nil
+	}
+	client, err := mqtt.Dial(mqtt.Options{
+		Broker:   c.Broker,
+		ClientID: c.ClientID,
+		Username: c.Username,
+		Password: c.Password,
+	})
+	if err != nil {
+		return err
+	}
+	eventHandlers := &handlers.EventHandlers{Bus: s.bus, RequireCert: s.config.TLS.DeviceCertRequired}
+	transport, err := mqtt.NewTransport(client, c.TopicPrefix, func(id string, service string, secret string, body []byte) error {
+		_, err := eventHandlers.PushEvent(s.store, id, service, secret, body)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	s.addTransport(device.TransportMQTT, transport)
+	logInfo("MQTT broker	:\t" + c.Broker)
+	return nil
+}
+
+// connectCoAP lets devices select the coap transport unless the feature is off, the inbound services of the devices
+// already on it are observed again, and the events they notify are pushed the same as over HTTP
+func connectCoAP(s *Server) error {
+	if !s.config.Features.CoAP {
+		return nil
+	}
+	eventHandlers := &handlers.EventHandlers{Bus: s.bus, RequireCert: s.config.TLS.DeviceCertRequired}
+	transport := coap.NewTransport(func(id string, service string, secret string, body []byte) error {
+		_, err := eventHandlers.PushEvent(s.store, id, service, secret, body)
+		return err
+	})
+	s.addTransport(device.TransportCoAP, transport)
+	devices, err := s.store.GetAll()
+	if err != nil {
+		return err
+	}
+	for _, d := range devices {
+		if d.TransportName() != device.TransportCoAP {
+			continue
+		}
+		go func(d *device.Device) {
+			err := transport.Observe(d)
+			if err != nil {
+				logWarn(fmt.Sprintf("Cannot observe device %v : %v", d.ID, err))
+			}
+		}(d)
+	}
+	return nil
+}
+
+// pruneEvents applies the event retention now, and keeps applying it every interval
+func pruneEvents(repo store.Repo, retention event.Retention, interval time.Duration) {
+	prune := func() {
+		n, err := retention.Apply(repo, time.Now())
+		if err != nil {
+			logError("Cannot prune events : " + err.Error())
+			return
+		}
+		if n > 0 {
+			logInfo(fmt.Sprintf("Pruned %v events", n))
+		}
+	}
+	prune()
+	go func() {
+		for range time.Tick(interval) {
+			prune()
+		}
+	}()
+}
 
-	if os.Getenv("PORT") == "" {
-		os.Setenv("APP_URL", os.Getenv("URL"))
-	} else {
-		os.Setenv("APP_URL", os.Getenv("URL")+":"+os.Getenv("PORT"))
+// bootstrapAdmin creates the admin user of the auth config when the hub has no user yet
+func bootstrapAdmin(repo store.Repo, c config.Auth) error {
+	users, err := repo.GetUsers()
+	if err != nil {
+		return err
+	}
+	if len(users) > 0 {
+		return nil
+	}
+	if c.AdminPassword == "" {
+		logWarn("No user yet, create an admin with `go-home user add -role admin [name]` or ADMIN_PASSWORD")
+		return nil
+	}
+	u, err := user.New(c.AdminName, c.AdminPassword, user.RoleAdmin)
+	if err != nil {
+		return err
 	}
+	err = repo.SaveUser(u)
+	if err != nil {
+		return err
+	}
+	logInfo("Created admin	:\t" + u.Name)
+	return nil
 }
 
 //Server defines what the server have
 type Server struct {
-	store store.Repo
-	srv   *http.Server
+	store       store.Repo
+	bus         *bus.Bus
+	config      *config.Config
+	callOptions device.CallOptions
+	ca          *pki.CA
+	rules       *rule.Cache
+	hubCodes    *auth.Issuer
+	srv         *http.Server
+}
+
+// addTransport lets devices select a transport
+func (s *Server) addTransport(name string, t device.Transport) {
+	if s.callOptions.Transports == nil {
+		s.callOptions.Transports = make(map[string]device.Transport)
+	}
+	s.callOptions.Transports[name] = t
 }
 
-//NewServer initialize a new server
-func NewServer() *Server {
-	s := &Server{}
+//NewServer initialize a new server of config c, changes to devices are published on b,
+//it serves TLS and calls devices with the certificate of the hub when TLS is configured,
+//devices only have to present their own certificate when device certificates are required, as devices enrolled before the CA have none
+func NewServer(repo store.Repo, b *bus.Bus, c *config.Config) (*Server, error) {
+	t, err := loadTLS(c.TLS)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{store: repo, bus: b, config: c, callOptions: c.CallOptions(), ca: t.ca, rules: rule.NewCache(repo), hubCodes: newIssuer(c.HubCode)}
+	if t.client != nil {
+		s.addTransport(device.TransportHTTP, &device.HTTPTransport{Client: t.client, VerifyDeviceID: c.TLS.DeviceCertRequired})
+	}
 	r := s.routes()
 	r.Use(loggingMiddleware)
 	srv := &http.Server{
 		Handler: r,
-		Addr:    os.Getenv("APP_URL"),
+		Addr:    c.Listen,
 		// Good practice: enforce timeouts for servers you create!
-		WriteTimeout: 15 * time.Second,
-		ReadTimeout:  15 * time.Second,
+		// Writes are bounded per route by timeoutMiddleware, as streams stay open
+		ReadTimeout: time.Duration(c.Timeouts.Read),
+		TLSConfig:   t.config,
 	}
 	s.srv = srv
-	return s
+	return s, nil
+}
+
+// storeFlags adds the -sqlite and -postgres flags of a command to fs, they select the store instead of the config
+func storeFlags(fs *flag.FlagSet) func(defaults config.Store) config.Store {
+	sqlitePath := fs.String("sqlite", "", "path of the sqlite database, the store of the config by default")
+	postgresDSN := fs.String("postgres", "", "DSN of the postgres database, used instead of sqlite when set")
+	return func(defaults config.Store) config.Store {
+		if *postgresDSN != "" {
+			return config.Store{Backend: config.StorePostgres, DSN: *postgresDSN}
+		}
+		if *sqlitePath != "" {
+			return config.Store{Backend: config.StoreSQLite, DSN: *sqlitePath}
+		}
+		return defaults
+	}
+}
+
+// openStore opens the store of the config, the database of a sqlite or postgres store is migrated
+func openStore(c config.Store) (store.Repo, io.Closer, error) {
+	switch c.Backend {
+	case config.StorePostgres:
+		p, err := postgres.NewPostgreSQLStore(c.DSN)
+		if err != nil {
+			return nil, nil, err
+		}
+		return p, p.DB, nil
+	case config.StoreMemory:
+		p, err := sqlite.NewSQLiteStore(sqlite.Memory)
+		if err != nil {
+			return nil, nil, err
+		}
+		return p, p.DB, nil
+	default:
+		p, err := sqlite.NewSQLiteStore(c.DSN)
+		if err != nil {
+			return nil, nil, err
+		}
+		return p, p.DB, nil
+	}
 }
 
 func main() {
 	loadEnv()
-	repo, err := sqlite.NewSQLiteStore("sqlite.db")
+	if len(os.Args) > 1 && (os.Args[1] == "migrate" || os.Args[1] == "user") {
+		// commands take the store of the config file and env, and their own flags
+		c, err := config.Load(flag.NewFlagSet("go-home", flag.ContinueOnError), nil)
+		if err != nil {
+			log.Fatal("Invalid config :\n" + err.Error())
+		}
+		if os.Args[1] == "migrate" {
+			err = runMigrate(os.Args[2:], c.Store, os.Stdout)
+		} else {
+			err = runUser(os.Args[2:], c.Store, os.Stdin, os.Stdout)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	c, err := config.Load(flag.CommandLine, os.Args[1:])
+	if err != nil {
+		log.Fatal("Invalid config :\n" + err.Error())
+	}
+	logging.SetLevel(c.LogLevel)
+	log.Fatal(run(c))
+}
+
+// run runs the hub of config c until it stops serving, the store is closed once it returns
+func run(c *config.Config) error {
+	repo, closer, err := openStore(c.Store)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+	err = bootstrapAdmin(repo, c.Auth)
+	if err != nil {
+		return err
+	}
+	b := bus.New()
+	server, err := NewServer(repo, b, c)
+	if err != nil {
+		return err
+	}
+	err = rotateHubCode(repo, server.hubCodes)
+	if err != nil {
+		return err
+	}
+	if retention := c.Retention(); retention.Enabled() {
+		pruneEvents(repo, retention, time.Hour)
+	}
+	err = connectMQTT(server)
 	if err != nil {
-		panic(err)
+		return err
+	}
+	err = connectCoAP(server)
+	if err != nil {
+		return err
+	}
+	if interval := time.Duration(c.Health.Interval); interval > 0 {
+		monitor := health.NewMonitor(repo, interval, time.Duration(c.Health.Timeout))
+		monitor.Probe = health.NewProber(server.callOptions)
+		monitor.Bus = b
+		go monitor.Run(context.Background())
+	}
+	if c.Features.Rules {
+		go rule.NewEngine(server.rules, repo, b, server.callOptions).Run(context.Background())
+	}
+	if c.Features.Schedules {
+		scheduler := schedule.NewScheduler(repo, repo, server.callOptions)
+		scheduler.MissedGrace = time.Duration(c.Schedules.MissedGrace)
+		go scheduler.Run(context.Background())
+	}
+	logInfo("App running in	:\t" + server.srv.Addr)
+	logInfo("App local IP	:\t" + getLocalIP(server.srv.Addr))
+	if server.srv.TLSConfig != nil {
+		return server.srv.ListenAndServeTLS("", "")
 	}
-	server := NewServer()
-	server.store = repo
-	log.Println("App running in	:\t" + server.srv.Addr)
-	log.Println("App local IP	:\t" + getLocalIP())
-	log.Fatal(server.srv.ListenAndServe())
+	return server.srv.ListenAndServe()
 }
@@ -1,8 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/IktaS/go-home/internal/app/config"
+	"github.com/IktaS/go-home/internal/app/store/sqlite"
+	"github.com/IktaS/go-home/internal/pkg/bus"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/user"
+	"github.com/stretchr/testify/assert"
 )
 
 func Test_main(t *testing.T) {
@@ -10,3 +23,249 @@ func Test_main(t *testing.T) {
 	os.Setenv("PORT", "5575")
 	main()
 }
+
+func Test_runMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "migrate.db")
+
+	var out bytes.Buffer
+	err := runMigrate([]string{"-sqlite", path, "-dry-run"}, config.Store{}, &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Pending")
+	assert.NotContains(t, out.String(), "Applied")
+
+	out.Reset()
+	err = runMigrate([]string{"-sqlite", path}, config.Store{}, &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Applied")
+
+	out.Reset()
+	err = runMigrate([]string{"-sqlite", path}, config.Store{}, &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Schema is up to date")
+}
+
+func Test_runUser(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "user.db")
+
+	var out bytes.Buffer
+	err := runUser([]string{"-sqlite", path, "add", "-role", "admin", "alice"}, config.Store{}, strings.NewReader("correct horse\n"), &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "Added user\t: alice (admin)")
+
+	err = runUser([]string{"-sqlite", path, "add", "alice"}, config.Store{}, strings.NewReader("correct horse\n"), &out)
+	assert.Error(t, err, "names are unique")
+	err = runUser([]string{"-sqlite", path, "add", "bob"}, config.Store{}, strings.NewReader("horse"), &out)
+	assert.Error(t, err, "passwords are long enough")
+
+	out.Reset()
+	err = runUser([]string{"-sqlite", path, "list"}, config.Store{}, nil, &out)
+	assert.NoError(t, err)
+	assert.Contains(t, out.String(), "admin\talice")
+
+	err = runUser([]string{"-sqlite", path, "delete", "alice"}, config.Store{}, nil, &out)
+	assert.NoError(t, err)
+	err = runUser([]string{"-sqlite", path, "delete", "alice"}, config.Store{}, nil, &out)
+	assert.Error(t, err)
+	err = runUser([]string{"-sqlite", path, "rename"}, config.Store{}, nil, &out)
+	assert.Error(t, err)
+}
+
+func Test_redactURI(t *testing.T) {
+	u, err := url.Parse("/events/ws?type=device.event&access_token=secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "/events/ws?access_token=REDACTED&type=device.event", redactURI(u))
+	u, err = url.Parse("/device/?group=kitchen")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, "/device/?group=kitchen", redactURI(u))
+}
+
+func Test_connectCoAP(t *testing.T) {
+	repo, err := sqlite.NewSQLiteStore(filepath.Join(t.TempDir(), "coap.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.DB.Close()
+	s, err := NewServer(repo, bus.New(), config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, connectCoAP(s))
+	assert.True(t, s.callOptions.HasTransport(device.TransportCoAP))
+
+	c := config.Default()
+	c.Features.CoAP = false
+	s, err = NewServer(repo, bus.New(), c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, connectCoAP(s))
+	assert.False(t, s.callOptions.HasTransport(device.TransportCoAP))
+}
+
+func Test_loadTLS(t *testing.T) {
+	tests := []struct {
+		name       string
+		c          config.TLS
+		wantErr    bool
+		wantConfig bool
+		wantCA     bool
+	}{
+		{
+			name: "Plain HTTP",
+		},
+		{
+			name:       "Local CA",
+			c:          config.TLS{CADir: filepath.Join(t.TempDir(), "ca"), DeviceCertRequired: true},
+			wantConfig: true,
+			wantCA:     true,
+		},
+		{
+			name:    "Missing certificate",
+			c:       config.TLS{CertFile: "hub.crt", KeyFile: "hub.key"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := loadTLS(tt.c)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantConfig, h.config != nil)
+			assert.Equal(t, tt.wantCA, h.ca != nil)
+			assert.Equal(t, tt.wantCA, h.client != nil)
+		})
+	}
+}
+
+func Test_openStore(t *testing.T) {
+	repo, closer, err := openStore(config.Store{Backend: config.StoreMemory})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer closer.Close()
+	assert.NoError(t, bootstrapAdmin(repo, config.Auth{AdminName: "admin", AdminPassword: "correct horse"}))
+	users, err := repo.GetUsers()
+	assert.NoError(t, err)
+	assert.Len(t, users, 1)
+
+	var out bytes.Buffer
+	err = runUser([]string{"list"}, config.Store{Backend: config.StoreMemory}, nil, &out)
+	assert.Error(t, err, "a memory store is not managed from the command line")
+	err = runMigrate(nil, config.Store{Backend: config.StoreMemory}, &out)
+	assert.Error(t, err)
+}
+
+func TestServer_routes(t *testing.T) {
+	repo, err := sqlite.NewSQLiteStore(filepath.Join(t.TempDir(), "routes.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.DB.Close()
+	viewer, err := user.New("viewer", "correct horse", user.RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, repo.SaveUser(viewer))
+	token, _, err := user.NewToken(repo, viewer, user.TokenKey, "test", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := NewServer(repo, bus.New(), config.Default())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := s.routes()
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		token      string
+		wantStatus int
+	}{
+		{
+			name:       "Devices without a token",
+			method:     "GET",
+			url:        "/device/",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "Devices as viewer",
+			method:     "GET",
+			url:        "/device/",
+			token:      token,
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Service call as viewer",
+			method:     "POST",
+			url:        "/device/00000000-0000-0000-0000-000000000000/service/on",
+			token:      token,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "Users as viewer",
+			method:     "GET",
+			url:        "/user/",
+			token:      token,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "Hub code as viewer",
+			method:     "POST",
+			url:        "/hubcode",
+			token:      token,
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "Device event without a token",
+			method:     "POST",
+			url:        "/device/00000000-0000-0000-0000-000000000000/event/pressed",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Login without a token",
+			method:     "POST",
+			url:        "/auth/login",
+			wantStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.url, nil)
+			if tt.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.token)
+			}
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestNewServer_verifyDeviceID(t *testing.T) {
+	repo, err := sqlite.NewSQLiteStore(filepath.Join(t.TempDir(), "tls.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer repo.DB.Close()
+	caDir := filepath.Join(t.TempDir(), "ca")
+	for _, required := range []bool{false, true} {
+		c := config.Default()
+		c.TLS = config.TLS{CADir: caDir, DeviceCertRequired: required}
+		s, err := NewServer(repo, bus.New(), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		transport, ok := s.callOptions.Transports[device.TransportHTTP].(*device.HTTPTransport)
+		if assert.True(t, ok) {
+			assert.Equal(t, required, transport.VerifyDeviceID, "devices present their certificate only when it is required")
+		}
+	}
+}
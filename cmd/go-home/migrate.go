@@ -0,0 +1,69 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"io"
+
+	"github.com/IktaS/go-home/internal/app/config"
+	"github.com/IktaS/go-home/internal/app/store/migrations"
+	"github.com/IktaS/go-home/internal/app/store/postgres"
+	"github.com/IktaS/go-home/internal/app/store/sqlite"
+)
+
+// runMigrate implements `go-home migrate`, it reports the schema version and applies pending migrations
+// of the store of the flags, or else of defaults
+func runMigrate(args []string, defaults config.Store, out io.Writer) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	fs.SetOutput(out)
+	selectStore := storeFlags(fs)
+	dryRun := fs.Bool("dry-run", false, "only report pending migrations")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	var db *sql.DB
+	var dialect migrations.Dialect
+	switch c := selectStore(defaults); c.Backend {
+	case config.StorePostgres:
+		db, err = postgres.Open(c.DSN)
+		dialect = migrations.Postgres
+	case config.StoreSQLite:
+		db, err = sqlite.Open(c.DSN)
+		dialect = migrations.SQLite
+	default:
+		return fmt.Errorf("the %v store has no schema to migrate", c.Backend)
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	m := migrations.NewMigrator(db, dialect)
+	current, err := m.Current()
+	if err != nil {
+		return err
+	}
+	pending, err := m.Pending()
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Schema version\t: %v (latest %v)\n", current, migrations.Latest())
+	if len(pending) == 0 {
+		fmt.Fprintln(out, "Schema is up to date")
+		return nil
+	}
+	for _, p := range pending {
+		fmt.Fprintf(out, "Pending\t\t: %v %v\n", p.Version, p.Description)
+	}
+	if *dryRun {
+		return nil
+	}
+	applied, err := m.Up()
+	for _, a := range applied {
+		fmt.Fprintf(out, "Applied\t\t: %v %v\n", a.Version, a.Description)
+	}
+	return err
+}
@@ -1,25 +1,116 @@
 package main
 
 import (
+	"time"
+
 	"github.com/IktaS/go-home/internal/app/handlers"
+	"github.com/IktaS/go-home/internal/pkg/user"
 	"github.com/gorilla/mux"
 )
 
 func (s *Server) routes() *mux.Router {
 	r := mux.NewRouter().StrictSlash(true)
 
+	//Auth Handler, every route but the ones devices call requires a user with a role
+	authHandlers := &handlers.AuthHandlers{TokenTTL: time.Duration(s.config.Auth.TokenTTL)}
+	viewer := authHandlers.Require(s.store, user.RoleViewer)
+	operator := authHandlers.Require(s.store, user.RoleOperator)
+	admin := authHandlers.Require(s.store, user.RoleAdmin)
+	authRouter := r.PathPrefix("/auth").Subrouter()
+	authRouter.Use(s.timeoutMiddleware)
+	authRouter.HandleFunc("/login", authHandlers.HandleLogin(s.store)).Methods("POST")
+	authRouter.Handle("/logout", viewer(authHandlers.HandleLogout(s.store))).Methods("POST")
+	authRouter.Handle("/me", viewer(authHandlers.HandleGetMe())).Methods("GET")
+	authRouter.Handle("/key/", viewer(authHandlers.HandleGetKeys(s.store))).Methods("GET")
+	authRouter.Handle("/key/", viewer(authHandlers.HandleCreateKey(s.store))).Methods("POST")
+	authRouter.Handle("/key/{id}", viewer(authHandlers.HandleDeleteKey(s.store))).Methods("DELETE")
+
+	//User Handler
+	userHandlers := &handlers.UserHandlers{}
+	userRouter := r.PathPrefix("/user").Subrouter()
+	userRouter.Use(s.timeoutMiddleware, admin)
+	userRouter.HandleFunc("/", userHandlers.HandleGetAllUser(s.store)).Methods("GET")
+	userRouter.HandleFunc("/", userHandlers.HandleCreateUser(s.store)).Methods("POST")
+	userRouter.HandleFunc("/{id}", userHandlers.HandleGetUser(s.store)).Methods("GET")
+	userRouter.HandleFunc("/{id}", userHandlers.HandlePatchUser(s.store)).Methods("PATCH")
+	userRouter.HandleFunc("/{id}", userHandlers.HandleDeleteUser(s.store)).Methods("DELETE")
+
+	//Event Handler, devices push events with their secret and without a user
+	eventHandlers := &handlers.EventHandlers{Bus: s.bus, RequireCert: s.config.TLS.DeviceCertRequired}
+	r.Handle("/device/{id}/event/{service}", s.timeoutMiddleware(eventHandlers.HandleDeviceEvent(s.store))).Methods("POST")
+
 	//Device Handler
-	deviceHandlers := &handlers.DeviceHandlers{}
+	deviceHandlers := &handlers.DeviceHandlers{CallOptions: &s.callOptions, Bus: s.bus, Rules: s.rules}
 	subrouter := r.PathPrefix("/device").Subrouter()
-	subrouter.HandleFunc("/", deviceHandlers.HandleGetAllDevice(s.store)).Methods("GET")
-	subrouter.HandleFunc("/{id}", deviceHandlers.HandleGetDevice(s.store)).Methods("GET")
-	subrouter.HandleFunc("/{id}/service", deviceHandlers.HandleGetDeviceService(s.store)).Methods("GET")
-	subrouter.HandleFunc("/{id}/service/{service}", deviceHandlers.HandleDeviceServiceCall(s.store)).Methods("GET")
-	subrouter.HandleFunc("/{id}/message", deviceHandlers.HandleGetDeviceMessage(s.store)).Methods("GET")
-
-	//Connect Handler
-	connectHandlers := &handlers.ConnectionHandlers{}
-	r.HandleFunc("/connect", connectHandlers.HandleConnect(s.store)).Methods("POST")
+	subrouter.Use(s.timeoutMiddleware)
+	subrouter.Handle("/", viewer(deviceHandlers.HandleGetAllDevice(s.store))).Methods("GET")
+	subrouter.Handle("/{id}", viewer(deviceHandlers.HandleGetDevice(s.store))).Methods("GET")
+	subrouter.Handle("/{id}", admin(deviceHandlers.HandlePatchDevice(s.store))).Methods("PATCH")
+	subrouter.Handle("/{id}", admin(deviceHandlers.HandleDeleteDevice(s.store))).Methods("DELETE")
+	subrouter.Handle("/{id}/secret", admin(deviceHandlers.HandleResetDeviceSecret(s.store))).Methods("POST")
+	subrouter.Handle("/{id}/service", viewer(deviceHandlers.HandleGetDeviceService(s.store))).Methods("GET")
+	subrouter.Handle("/{id}/service/{service}", operator(deviceHandlers.HandleDeviceServiceCall(s.store))).Methods("GET")
+	subrouter.Handle("/{id}/service/{service}", operator(deviceHandlers.HandleDeviceServiceCallJSON(s.store))).Methods("POST")
+	subrouter.Handle("/{id}/message", viewer(deviceHandlers.HandleGetDeviceMessage(s.store))).Methods("GET")
+	subrouter.Handle("/{id}/events", viewer(eventHandlers.HandleGetDeviceEvents(s.store))).Methods("GET")
+
+	//Connect Handler, devices connect with the hub code, and reconnect with their secret or certificate
+	connectHandlers := &handlers.ConnectionHandlers{Bus: s.bus, CallOptions: &s.callOptions, CA: s.ca, RequireCert: s.config.TLS.DeviceCertRequired, HubCodes: s.hubCodes}
+	r.Handle("/connect", s.timeoutMiddleware(connectHandlers.HandleConnect(s.store))).Methods("POST")
+
+	//Hub Code Handler, an admin issues a new hub code
+	hubCodeHandlers := &handlers.HubCodeHandlers{Issuer: s.hubCodes}
+	r.Handle("/hubcode", s.timeoutMiddleware(admin(hubCodeHandlers.HandleRotateHubCode(s.store)))).Methods("POST")
+
+	//Rule Handler
+	ruleHandlers := &handlers.RuleHandlers{Rules: s.rules}
+	ruleRouter := r.PathPrefix("/rule").Subrouter()
+	ruleRouter.Use(s.timeoutMiddleware)
+	ruleRouter.Handle("/", viewer(ruleHandlers.HandleGetAllRule(s.store))).Methods("GET")
+	ruleRouter.Handle("/", admin(ruleHandlers.HandleCreateRule(s.store))).Methods("POST")
+	ruleRouter.Handle("/{id}", viewer(ruleHandlers.HandleGetRule(s.store))).Methods("GET")
+	ruleRouter.Handle("/{id}", admin(ruleHandlers.HandleUpdateRule(s.store))).Methods("PUT")
+	ruleRouter.Handle("/{id}", admin(ruleHandlers.HandleDeleteRule(s.store))).Methods("DELETE")
+
+	//Schedule Handler
+	scheduleHandlers := &handlers.ScheduleHandlers{}
+	scheduleRouter := r.PathPrefix("/schedule").Subrouter()
+	scheduleRouter.Use(s.timeoutMiddleware)
+	scheduleRouter.Handle("/", viewer(scheduleHandlers.HandleGetAllSchedule(s.store))).Methods("GET")
+	scheduleRouter.Handle("/", admin(scheduleHandlers.HandleCreateSchedule(s.store))).Methods("POST")
+	scheduleRouter.Handle("/dry-run", viewer(scheduleHandlers.HandleDryRunSchedule())).Methods("POST")
+	scheduleRouter.Handle("/{id}", viewer(scheduleHandlers.HandleGetSchedule(s.store))).Methods("GET")
+	scheduleRouter.Handle("/{id}", admin(scheduleHandlers.HandleUpdateSchedule(s.store))).Methods("PUT")
+	scheduleRouter.Handle("/{id}", admin(scheduleHandlers.HandleDeleteSchedule(s.store))).Methods("DELETE")
+
+	//Scene Handler
+	sceneHandlers := &handlers.SceneHandlers{CallOptions: &s.callOptions}
+	sceneRouter := r.PathPrefix("/scene").Subrouter()
+	sceneRouter.Use(s.timeoutMiddleware)
+	sceneRouter.Handle("/", viewer(sceneHandlers.HandleGetAllScene(s.store))).Methods("GET")
+	sceneRouter.Handle("/", admin(sceneHandlers.HandleCreateScene(s.store))).Methods("POST")
+	sceneRouter.Handle("/{id}", viewer(sceneHandlers.HandleGetScene(s.store))).Methods("GET")
+	sceneRouter.Handle("/{id}", admin(sceneHandlers.HandleUpdateScene(s.store))).Methods("PUT")
+	sceneRouter.Handle("/{id}", admin(sceneHandlers.HandleDeleteScene(s.store))).Methods("DELETE")
+	sceneRouter.Handle("/{id}/activate", operator(sceneHandlers.HandleActivateScene(s.store))).Methods("POST")
+
+	//Group Handler
+	groupHandlers := &handlers.GroupHandlers{CallOptions: &s.callOptions}
+	groupRouter := r.PathPrefix("/group").Subrouter()
+	groupRouter.Use(s.timeoutMiddleware)
+	groupRouter.Handle("/", viewer(groupHandlers.HandleGetAllGroup(s.store))).Methods("GET")
+	groupRouter.Handle("/", admin(groupHandlers.HandleCreateGroup(s.store))).Methods("POST")
+	groupRouter.Handle("/{id}", viewer(groupHandlers.HandleGetGroup(s.store))).Methods("GET")
+	groupRouter.Handle("/{id}", admin(groupHandlers.HandleUpdateGroup(s.store))).Methods("PUT")
+	groupRouter.Handle("/{id}", admin(groupHandlers.HandleDeleteGroup(s.store))).Methods("DELETE")
+	groupRouter.Handle("/{id}/device/{device}", admin(groupHandlers.HandleAddGroupDevice(s.store))).Methods("PUT")
+	groupRouter.Handle("/{id}/device/{device}", admin(groupHandlers.HandleRemoveGroupDevice(s.store))).Methods("DELETE")
+	groupRouter.Handle("/{id}/service/{service}", operator(groupHandlers.HandleGroupServiceCall(s.store))).Methods("POST")
+
+	//Stream Handler, streams are long lived so they have no request timeout
+	streamHandlers := &handlers.StreamHandlers{Bus: s.bus}
+	r.Handle("/events/stream", viewer(streamHandlers.HandleSSE())).Methods("GET")
+	r.Handle("/events/ws", viewer(streamHandlers.HandleWebSocket())).Methods("GET")
 
 	return r
 }
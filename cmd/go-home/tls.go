@@ -0,0 +1,75 @@
+package main
+
+import (
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+
+	"github.com/IktaS/go-home/internal/app/config"
+	"github.com/IktaS/go-home/internal/pkg/pki"
+)
+
+// hubTLS defines how the hub uses TLS, the listener serves TLS when config is set,
+// and devices enroll with ca and are called with client when it is set
+type hubTLS struct {
+	config *tls.Config
+	ca     *pki.CA
+	client *http.Client
+}
+
+// hubHosts returns the names and IPs the hub is reached on, hosts when it is set,
+// by default its host name, localhost and the IPs of its interfaces
+func hubHosts(hosts []string) []string {
+	if len(hosts) > 0 {
+		return hosts
+	}
+	hosts = []string{"localhost"}
+	if name, err := os.Hostname(); err == nil {
+		hosts = append(hosts, name)
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return hosts
+	}
+	for _, address := range addrs {
+		if ipnet, ok := address.(*net.IPNet); ok {
+			hosts = append(hosts, ipnet.IP.String())
+		}
+	}
+	return hosts
+}
+
+// loadTLS loads the TLS of the hub, the certificate file is served by the listener, the CA of the CA directory,
+// made on first use, enrolls devices and issues the certificate the listener serves when no certificate file is set,
+// the config is validated beforehand
+func loadTLS(c config.TLS) (*hubTLS, error) {
+	h := &hubTLS{}
+	var cert tls.Certificate
+	var err error
+	if c.CertFile != "" {
+		cert, err = tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		h.config = &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12}
+	}
+	if c.CADir == "" {
+		return h, nil
+	}
+	h.ca, err = pki.LoadCA(c.CADir)
+	if err != nil {
+		return nil, err
+	}
+	// the hub presents a certificate of its CA to the devices it calls
+	hubCert, err := h.ca.IssueHub(hubHosts(c.Hosts))
+	if err != nil {
+		return nil, err
+	}
+	if c.CertFile == "" {
+		cert = hubCert
+	}
+	h.config = h.ca.ServerTLSConfig(cert)
+	h.client = &http.Client{Transport: &http.Transport{TLSClientConfig: h.ca.ClientTLSConfig(hubCert)}}
+	return h, nil
+}
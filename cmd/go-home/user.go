@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/IktaS/go-home/internal/app/config"
+	"github.com/IktaS/go-home/internal/app/store"
+	"github.com/IktaS/go-home/internal/pkg/user"
+)
+
+// userUsage is how `go-home user` is used
+const userUsage = "usage : go-home user [-sqlite path | -postgres dsn] add [-role role] name | list | delete name"
+
+// runUser implements `go-home user`, it manages users of the store of the flags, or else of defaults,
+// from the command line, a new password is read from the first line of in
+func runUser(args []string, defaults config.Store, in io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("user", flag.ContinueOnError)
+	fs.SetOutput(out)
+	selectStore := storeFlags(fs)
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New(userUsage)
+	}
+
+	c := selectStore(defaults)
+	if c.Backend == config.StoreMemory {
+		return errors.New("the users of a memory store are lost when the command exits")
+	}
+	repo, closer, err := openStore(c)
+	if err != nil {
+		return err
+	}
+	defer closer.Close()
+
+	switch fs.Arg(0) {
+	case "add":
+		return addUser(repo, fs.Args()[1:], in, out)
+	case "list":
+		users, err := repo.GetUsers()
+		if err != nil {
+			return err
+		}
+		for _, u := range users {
+			fmt.Fprintf(out, "%v\t%v\t%v\n", u.ID, u.Role, u.Name)
+		}
+		return nil
+	case "delete":
+		if fs.NArg() != 2 {
+			return errors.New(userUsage)
+		}
+		u, err := repo.GetUserByName(fs.Arg(1))
+		if err != nil {
+			return fmt.Errorf("cannot get user %v : %w", fs.Arg(1), err)
+		}
+		err = repo.DeleteUser(u.ID.String())
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Deleted user\t: %v\n", u.Name)
+		return nil
+	default:
+		return errors.New(userUsage)
+	}
+}
+
+// addUser implements `go-home user add`
+func addUser(repo store.Repo, args []string, in io.Reader, out io.Writer) error {
+	fs := flag.NewFlagSet("user add", flag.ContinueOnError)
+	fs.SetOutput(out)
+	role := fs.String("role", string(user.RoleViewer), "role of the user, viewer, operator or admin")
+	err := fs.Parse(args)
+	if err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New(userUsage)
+	}
+	fmt.Fprint(out, "Password\t: ")
+	password, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return err
+	}
+	fmt.Fprintln(out)
+	u, err := user.New(fs.Arg(0), strings.TrimRight(password, "\r\n"), user.Role(*role))
+	if err != nil {
+		return err
+	}
+	_, err = repo.GetUserByName(u.Name)
+	if err == nil {
+		return fmt.Errorf("user %v already exists", u.Name)
+	}
+	err = repo.SaveUser(u)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Added user\t: %v (%v)\n", u.Name, u.Role)
+	return nil
+}
@@ -5,12 +5,17 @@ go 1.15
 require (
 	github.com/DATA-DOG/go-sqlmock v1.5.0
 	github.com/IktaS/go-serv v0.3.1
+	github.com/eclipse/paho.mqtt.golang v1.3.5
 	github.com/google/uuid v1.2.0
 	github.com/gorilla/mux v1.8.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/joho/godotenv v1.3.0
 	github.com/kr/pretty v0.1.0 // indirect
 	github.com/lib/pq v1.9.0
 	github.com/mattn/go-sqlite3 v1.14.6
+	github.com/plgd-dev/go-coap/v2 v2.4.0
+	github.com/robfig/cron/v3 v3.0.1
 	github.com/stretchr/testify v1.5.1
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
 	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127 // indirect
 )
@@ -0,0 +1,492 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/event"
+	"github.com/IktaS/go-home/internal/pkg/logging"
+	"github.com/IktaS/go-home/internal/pkg/schedule"
+	"github.com/IktaS/go-home/internal/pkg/user"
+)
+
+// Store backends
+const (
+	StoreSQLite   = "sqlite"
+	StorePostgres = "postgres"
+	StoreMemory   = "memory"
+)
+
+// DefaultSQLiteFile is the database file of the sqlite backend when no DSN is set
+const DefaultSQLiteFile = "sqlite.db"
+
+// LogLevel is how much the hub logs, from the most verbose LogDebug to LogError
+type LogLevel = logging.Level
+
+// Log levels
+const (
+	LogDebug = logging.Debug
+	LogInfo  = logging.Info
+	LogWarn  = logging.Warn
+	LogError = logging.Error
+)
+
+// Duration is a time.Duration written as a string in the config file, e.g. "15s"
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+/*
+Store defines the JSON schema of the store of the hub :
+	Backend	`backend`	: sqlite (the default), postgres or memory, a memory store is lost when the hub stops
+	DSN		`dsn`		: Database file of sqlite, sqlite.db by default, or DSN of postgres
+*/
+type Store struct {
+	Backend string `json:"backend"`
+	DSN     string `json:"dsn"`
+}
+
+/*
+Timeouts defines the JSON schema of the timeouts of the hub listener :
+	Read	`read`		: How long reading a request may take
+	Request	`request`	: How long a request may take to be answered, streams are not bounded
+*/
+type Timeouts struct {
+	Read    Duration `json:"read"`
+	Request Duration `json:"request"`
+}
+
+/*
+HubCode defines the JSON schema of the hub code devices connect with :
+	TTL			`ttl`		: How long a hub code is valid before it rotates, 0 never rotates it
+	SingleUse	`singleUse`	: Makes each hub code valid for a single connection
+*/
+type HubCode struct {
+	TTL       Duration `json:"ttl"`
+	SingleUse bool     `json:"singleUse"`
+}
+
+/*
+DeviceCall defines the JSON schema of how the hub calls devices :
+	Timeout	`timeout`	: How long each attempt of a call may take
+	Retries	`retries`	: How many times a failed GET call is retried
+	Backoff	`backoff`	: The wait before the first retry, doubling on each retry
+*/
+type DeviceCall struct {
+	Timeout Duration `json:"timeout"`
+	Retries int      `json:"retries"`
+	Backoff Duration `json:"backoff"`
+}
+
+/*
+Health defines the JSON schema of the health checks of devices :
+	Interval	`interval`	: How often devices are checked, 0 disables health checks
+	Timeout		`timeout`	: How long a check may take
+*/
+type Health struct {
+	Interval Duration `json:"interval"`
+	Timeout  Duration `json:"timeout"`
+}
+
+/*
+Events defines the JSON schema of how long events are kept, forever by default :
+	Retention			`retention`				: Deletes events older than it when set
+	RetentionPerDevice	`retentionPerDevice`	: Keeps only the newest events of each device when set
+*/
+type Events struct {
+	Retention          Duration `json:"retention"`
+	RetentionPerDevice int      `json:"retentionPerDevice"`
+}
+
+/*
+Schedules defines the JSON schema of how schedules run :
+	MissedGrace	`missedGrace`	: How late a schedule missed while the hub was down still runs when the hub starts, 0 never runs them
+*/
+type Schedules struct {
+	MissedGrace Duration `json:"missedGrace"`
+}
+
+/*
+Auth defines the JSON schema of user authentication :
+	TokenTTL		`tokenTTL`		: How long a login session lasts
+	AdminName		`adminName`		: Name of the admin made when the hub has no user
+	AdminPassword	`adminPassword`	: Password of that admin, no admin is made without one
+*/
+type Auth struct {
+	TokenTTL      Duration `json:"tokenTTL"`
+	AdminName     string   `json:"adminName"`
+	AdminPassword string   `json:"adminPassword"`
+}
+
+/*
+MQTT defines the JSON schema of the MQTT broker devices may be called through :
+	Broker		`broker`		: Broker URL, e.g. tcp://localhost:1883, devices cannot select mqtt without it
+	ClientID	`clientID`		: Client ID of the hub
+	Username	`username`		: Username of the hub
+	Password	`password`		: Password of the hub
+	TopicPrefix	`topicPrefix`	: Prefix of the topics, go-home when empty
+*/
+type MQTT struct {
+	Broker      string `json:"broker"`
+	ClientID    string `json:"clientID"`
+	Username    string `json:"username"`
+	Password    string `json:"password"`
+	TopicPrefix string `json:"topicPrefix"`
+}
+
+/*
+TLS defines the JSON schema of the TLS of the hub, it serves plain HTTP without a certificate or CA :
+	CertFile			`certFile`				: Certificate the hub serves, set together with KeyFile
+	KeyFile				`keyFile`				: Key of that certificate
+	CADir				`caDir`					: Directory of the local CA of the hub, devices enroll with it
+	Hosts				`hosts`					: Names and IPs the certificate the CA issues to the hub is valid for
+	DeviceCertRequired	`deviceCertRequired`	: Makes devices reconnect and push events with their certificate, and present it when called
+*/
+type TLS struct {
+	CertFile           string   `json:"certFile"`
+	KeyFile            string   `json:"keyFile"`
+	CADir              string   `json:"caDir"`
+	Hosts              []string `json:"hosts"`
+	DeviceCertRequired bool     `json:"deviceCertRequired"`
+}
+
+/*
+Features defines the JSON schema of the features of the hub that can be turned off, all are on by default :
+	CoAP		`coap`		: Lets devices select the coap transport
+	Rules		`rules`		: Runs the rules
+	Schedules	`schedules`	: Runs the schedules
+*/
+type Features struct {
+	CoAP      bool `json:"coap"`
+	Rules     bool `json:"rules"`
+	Schedules bool `json:"schedules"`
+}
+
+/*
+Config defines the JSON schema of the config file of the hub :
+	Listen		`listen`		: Address the hub listens on
+	LogLevel	`logLevel`		: debug, info (the default), warn or error
+	Store		`store`			: See Store
+	Timeouts	`timeouts`		: See Timeouts
+	HubCode		`hubCode`		: See HubCode
+	DeviceCall	`deviceCall`	: See DeviceCall
+	Health		`health`		: See Health
+	Events		`events`		: See Events
+	Schedules	`schedules`		: See Schedules
+	Auth		`auth`			: See Auth
+	MQTT		`mqtt`			: See MQTT
+	TLS			`tls`			: See TLS
+	Features	`features`		: See Features
+*/
+type Config struct {
+	Listen     string     `json:"listen"`
+	LogLevel   LogLevel   `json:"logLevel"`
+	Store      Store      `json:"store"`
+	Timeouts   Timeouts   `json:"timeouts"`
+	HubCode    HubCode    `json:"hubCode"`
+	DeviceCall DeviceCall `json:"deviceCall"`
+	Health     Health     `json:"health"`
+	Events     Events     `json:"events"`
+	Schedules  Schedules  `json:"schedules"`
+	Auth       Auth       `json:"auth"`
+	MQTT       MQTT       `json:"mqtt"`
+	TLS        TLS        `json:"tls"`
+	Features   Features   `json:"features"`
+}
+
+// Default returns the config of a hub that configures nothing
+func Default() *Config {
+	return &Config{
+		Listen:   ":5575",
+		LogLevel: LogInfo,
+		Store:    Store{Backend: StoreSQLite},
+		Timeouts: Timeouts{
+			Read:    Duration(15 * time.Second),
+			Request: Duration(15 * time.Second),
+		},
+		DeviceCall: DeviceCall{
+			Timeout: Duration(device.DefaultCallOptions.Timeout),
+			Retries: device.DefaultCallOptions.Retries,
+			Backoff: Duration(device.DefaultCallOptions.Backoff),
+		},
+		Health: Health{
+			Interval: Duration(30 * time.Second),
+			Timeout:  Duration(2 * time.Second),
+		},
+		Schedules: Schedules{MissedGrace: Duration(schedule.DefaultMissedGrace)},
+		Auth:      Auth{TokenTTL: Duration(user.DefaultTokenTTL), AdminName: "admin"},
+		MQTT:      MQTT{ClientID: "go-home"},
+		Features:  Features{CoAP: true, Rules: true, Schedules: true},
+	}
+}
+
+// Errors are the errors of a config, reported together
+type Errors []error
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// flags are the flags of the hub, a flag only overrides the config when it is given
+type flags struct {
+	file     string
+	listen   string
+	logLevel string
+	backend  string
+	dsn      string
+}
+
+// Load reads the config of the hub, from its defaults, the JSON file of the -config flag or CONFIG_FILE,
+// env, then the flags of args parsed with fs, each overriding the ones before, and validates it
+func Load(fs *flag.FlagSet, args []string) (*Config, error) {
+	var f flags
+	fs.StringVar(&f.file, "config", "", "path of the JSON config file, CONFIG_FILE by default")
+	fs.StringVar(&f.listen, "listen", "", "address the hub listens on")
+	fs.StringVar(&f.logLevel, "log-level", "", "debug, info, warn or error")
+	fs.StringVar(&f.backend, "store", "", "store backend, sqlite, postgres or memory")
+	fs.StringVar(&f.dsn, "store-dsn", "", "database file of sqlite or DSN of postgres")
+	err := fs.Parse(args)
+	if err != nil {
+		return nil, err
+	}
+
+	c := Default()
+	file := f.file
+	if file == "" {
+		file = os.Getenv("CONFIG_FILE")
+	}
+	if file != "" {
+		err = c.readFile(file)
+		if err != nil {
+			return nil, err
+		}
+	}
+	errs := c.readEnv()
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "listen":
+			c.Listen = f.listen
+		case "log-level":
+			c.LogLevel = LogLevel(f.logLevel)
+		case "store":
+			c.Store.Backend = f.backend
+		case "store-dsn":
+			c.Store.DSN = f.dsn
+		}
+	})
+	errs = append(errs, c.Validate()...)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if c.Store.Backend == StoreSQLite && c.Store.DSN == "" {
+		c.Store.DSN = DefaultSQLiteFile
+	}
+	return c, nil
+}
+
+// readFile reads the JSON config file, the fields it leaves out are kept, and unknown fields are errors
+func (c *Config) readFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	err = dec.Decode(c)
+	if err != nil {
+		return fmt.Errorf("config file %v : %w", file, err)
+	}
+	return nil
+}
+
+// env reads the config from env, a variable that is not set keeps the value it had
+type env struct {
+	errs Errors
+}
+
+func (e *env) stringVar(key string, v *string) {
+	if s := os.Getenv(key); s != "" {
+		*v = s
+	}
+}
+
+func (e *env) durationVar(key string, v *Duration) {
+	s := os.Getenv(key)
+	if s == "" {
+		return
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%v : %w", key, err))
+		return
+	}
+	*v = Duration(d)
+}
+
+func (e *env) intVar(key string, v *int) {
+	s := os.Getenv(key)
+	if s == "" {
+		return
+	}
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%v : %w", key, err))
+		return
+	}
+	*v = i
+}
+
+func (e *env) boolVar(key string, v *bool) {
+	s := os.Getenv(key)
+	if s == "" {
+		return
+	}
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		e.errs = append(e.errs, fmt.Errorf("%v : %w", key, err))
+		return
+	}
+	*v = b
+}
+
+// readEnv reads the config from env, URL and PORT make the listen address when LISTEN_ADDR is not set
+func (c *Config) readEnv() Errors {
+	e := &env{}
+	if url, port := os.Getenv("URL"), os.Getenv("PORT"); url != "" || port != "" {
+		c.Listen = url
+		if port != "" {
+			c.Listen += ":" + port
+		}
+	}
+	e.stringVar("LISTEN_ADDR", &c.Listen)
+	level := string(c.LogLevel)
+	e.stringVar("LOG_LEVEL", &level)
+	c.LogLevel = LogLevel(level)
+	e.stringVar("STORE_BACKEND", &c.Store.Backend)
+	e.stringVar("STORE_DSN", &c.Store.DSN)
+	e.durationVar("READ_TIMEOUT", &c.Timeouts.Read)
+	e.durationVar("REQUEST_TIMEOUT", &c.Timeouts.Request)
+	e.durationVar("HUB_CODE_TTL", &c.HubCode.TTL)
+	e.boolVar("HUB_CODE_SINGLE_USE", &c.HubCode.SingleUse)
+	e.durationVar("DEVICE_CALL_TIMEOUT", &c.DeviceCall.Timeout)
+	e.intVar("DEVICE_CALL_RETRIES", &c.DeviceCall.Retries)
+	e.durationVar("DEVICE_CALL_BACKOFF", &c.DeviceCall.Backoff)
+	e.durationVar("HEALTH_CHECK_INTERVAL", &c.Health.Interval)
+	e.durationVar("HEALTH_CHECK_TIMEOUT", &c.Health.Timeout)
+	e.durationVar("EVENT_RETENTION", &c.Events.Retention)
+	e.intVar("EVENT_RETENTION_PER_DEVICE", &c.Events.RetentionPerDevice)
+	e.durationVar("SCHEDULE_MISSED_GRACE", &c.Schedules.MissedGrace)
+	e.durationVar("AUTH_TOKEN_TTL", &c.Auth.TokenTTL)
+	e.stringVar("ADMIN_NAME", &c.Auth.AdminName)
+	e.stringVar("ADMIN_PASSWORD", &c.Auth.AdminPassword)
+	e.stringVar("MQTT_BROKER", &c.MQTT.Broker)
+	e.stringVar("MQTT_CLIENT_ID", &c.MQTT.ClientID)
+	e.stringVar("MQTT_USERNAME", &c.MQTT.Username)
+	e.stringVar("MQTT_PASSWORD", &c.MQTT.Password)
+	e.stringVar("MQTT_TOPIC_PREFIX", &c.MQTT.TopicPrefix)
+	e.stringVar("TLS_CERT_FILE", &c.TLS.CertFile)
+	e.stringVar("TLS_KEY_FILE", &c.TLS.KeyFile)
+	e.stringVar("TLS_CA_DIR", &c.TLS.CADir)
+	if hosts := os.Getenv("TLS_HOSTS"); hosts != "" {
+		c.TLS.Hosts = strings.Split(hosts, ",")
+	}
+	e.boolVar("DEVICE_CERT_REQUIRED", &c.TLS.DeviceCertRequired)
+	e.boolVar("FEATURE_COAP", &c.Features.CoAP)
+	e.boolVar("FEATURE_RULES", &c.Features.Rules)
+	e.boolVar("FEATURE_SCHEDULES", &c.Features.Schedules)
+	// COAP_DISABLED predates the feature toggles
+	coapDisabled := false
+	e.boolVar("COAP_DISABLED", &coapDisabled)
+	if coapDisabled {
+		c.Features.CoAP = false
+	}
+	return e.errs
+}
+
+// Validate returns every error of the config
+func (c *Config) Validate() Errors {
+	var errs Errors
+	check := func(ok bool, msg string) {
+		if !ok {
+			errs = append(errs, errors.New(msg))
+		}
+	}
+	check(c.Listen != "", "listen address is not set")
+	check(c.LogLevel.Valid(), fmt.Sprintf("unknown log level %q, expected debug, info, warn or error", c.LogLevel))
+	switch c.Store.Backend {
+	case StoreSQLite:
+	case StorePostgres:
+		check(c.Store.DSN != "", "the postgres store needs a DSN")
+	case StoreMemory:
+		check(c.Store.DSN == "", "the memory store has no DSN")
+	default:
+		check(false, fmt.Sprintf("unknown store backend %q, expected sqlite, postgres or memory", c.Store.Backend))
+	}
+	check(c.Timeouts.Read > 0, "read timeout must be positive")
+	check(time.Duration(c.Timeouts.Request) > callDeadlineMargin, "request timeout must be longer than "+callDeadlineMargin.String())
+	check(c.HubCode.TTL >= 0, "hub code TTL cannot be negative")
+	check(c.DeviceCall.Timeout > 0, "device call timeout must be positive")
+	check(c.DeviceCall.Retries >= 0, "device call retries cannot be negative")
+	check(c.DeviceCall.Backoff >= 0, "device call backoff cannot be negative")
+	check(c.Health.Interval >= 0, "health check interval cannot be negative")
+	check(c.Health.Timeout > 0, "health check timeout must be positive")
+	check(c.Events.Retention >= 0, "event retention cannot be negative")
+	check(c.Events.RetentionPerDevice >= 0, "event retention per device cannot be negative")
+	check(c.Schedules.MissedGrace >= 0, "schedule missed grace cannot be negative")
+	check(c.Auth.TokenTTL > 0, "auth token TTL must be positive")
+	check((c.TLS.CertFile == "") == (c.TLS.KeyFile == ""), "TLS certificate and key files are set together")
+	check(!c.TLS.DeviceCertRequired || c.TLS.CADir != "", "device certificates need the CA of the TLS CA directory")
+	return errs
+}
+
+// callDeadlineMargin is how long before the request timeout a device call ends, to answer that the device timed out
+const callDeadlineMargin = time.Second
+
+// CallOptions returns the options the hub calls devices with, without transports,
+// a call with its retries ends before the request that made it times out
+func (c *Config) CallOptions() device.CallOptions {
+	return device.CallOptions{
+		Timeout:  time.Duration(c.DeviceCall.Timeout),
+		Retries:  c.DeviceCall.Retries,
+		Backoff:  time.Duration(c.DeviceCall.Backoff),
+		Deadline: time.Duration(c.Timeouts.Request) - callDeadlineMargin,
+	}
+}
+
+// Retention returns how long events are kept
+func (c *Config) Retention() event.Retention {
+	return event.Retention{
+		MaxAge:       time.Duration(c.Events.Retention),
+		MaxPerDevice: c.Events.RetentionPerDevice,
+	}
+}
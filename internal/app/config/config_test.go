@@ -0,0 +1,159 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoad(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hub.json")
+	err := ioutil.WriteFile(file, []byte(`{
+		"listen": ":8080",
+		"logLevel": "warn",
+		"store": {"backend": "postgres", "dsn": "postgres://hub@localhost/hub"},
+		"timeouts": {"request": "30s"},
+		"features": {"rules": false}
+	}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		args    []string
+		env     map[string]string
+		want    func(c *Config)
+		wantErr bool
+	}{
+		{
+			name: "Defaults",
+			want: func(c *Config) {
+				c.Store.DSN = DefaultSQLiteFile
+			},
+		},
+		{
+			name: "File",
+			args: []string{"-config", file},
+			want: func(c *Config) {
+				c.Listen = ":8080"
+				c.LogLevel = LogWarn
+				c.Store = Store{Backend: StorePostgres, DSN: "postgres://hub@localhost/hub"}
+				c.Timeouts.Request = Duration(30 * time.Second)
+				c.Features.Rules = false
+			},
+		},
+		{
+			name: "Env overrides the file",
+			env:  map[string]string{"CONFIG_FILE": file, "URL": "127.0.0.1", "PORT": "5575", "REQUEST_TIMEOUT": "1m", "COAP_DISABLED": "true", "SCHEDULE_MISSED_GRACE": "0"},
+			want: func(c *Config) {
+				c.Listen = "127.0.0.1:5575"
+				c.LogLevel = LogWarn
+				c.Store = Store{Backend: StorePostgres, DSN: "postgres://hub@localhost/hub"}
+				c.Timeouts.Request = Duration(time.Minute)
+				c.Schedules.MissedGrace = 0
+				c.Features.Rules = false
+				c.Features.CoAP = false
+			},
+		},
+		{
+			name: "Flags override env",
+			args: []string{"-listen", ":9090", "-store", "memory", "-log-level", "debug"},
+			env:  map[string]string{"LISTEN_ADDR": ":8080", "STORE_BACKEND": "sqlite", "DEVICE_CALL_RETRIES": "0"},
+			want: func(c *Config) {
+				c.Listen = ":9090"
+				c.LogLevel = LogDebug
+				c.Store.Backend = StoreMemory
+				c.DeviceCall.Retries = 0
+			},
+		},
+		{
+			name:    "Missing file",
+			args:    []string{"-config", filepath.Join(t.TempDir(), "missing.json")},
+			wantErr: true,
+		},
+		{
+			name:    "Invalid env",
+			env:     map[string]string{"HEALTH_CHECK_INTERVAL": "often"},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown backend",
+			args:    []string{"-store", "mysql"},
+			wantErr: true,
+		},
+		{
+			name:    "Postgres without DSN",
+			args:    []string{"-store", "postgres"},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown flag",
+			args:    []string{"-port", "80"},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for k, v := range tt.env {
+				os.Setenv(k, v)
+				defer os.Unsetenv(k)
+			}
+			fs := flag.NewFlagSet("go-home", flag.ContinueOnError)
+			fs.SetOutput(ioutil.Discard)
+			got, err := Load(fs, tt.args)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			want := Default()
+			tt.want(want)
+			assert.Equal(t, want, got)
+		})
+	}
+}
+
+func TestLoad_FileErrors(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "hub.json")
+	err := ioutil.WriteFile(file, []byte(`{"listen": ":8080", "port": 80}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Load(flag.NewFlagSet("go-home", flag.ContinueOnError), []string{"-config", file})
+	assert.Error(t, err, "unknown fields are errors")
+
+	err = ioutil.WriteFile(file, []byte(`{"timeouts": {"read": "soon"}}`), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = Load(flag.NewFlagSet("go-home", flag.ContinueOnError), []string{"-config", file})
+	assert.Error(t, err, "durations are parsed")
+}
+
+func TestConfig_Validate(t *testing.T) {
+	c := Default()
+	assert.Empty(t, c.Validate())
+
+	c.LogLevel = "verbose"
+	c.Timeouts.Read = 0
+	c.DeviceCall.Retries = -1
+	c.TLS.CertFile = "hub.crt"
+	c.TLS.DeviceCertRequired = true
+	c.Schedules.MissedGrace = Duration(-time.Minute)
+	errs := c.Validate()
+	assert.Len(t, errs, 6, "every error is reported")
+	assert.Contains(t, errs.Error(), "unknown log level \"verbose\"")
+}
+
+func TestLogLevel_Enabled(t *testing.T) {
+	assert.True(t, LogInfo.Enabled(LogInfo))
+	assert.True(t, LogInfo.Enabled(LogError))
+	assert.False(t, LogInfo.Enabled(LogDebug))
+	assert.True(t, LogDebug.Enabled(LogDebug))
+	assert.False(t, LogError.Enabled(LogWarn))
+}
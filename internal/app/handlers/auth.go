@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/IktaS/go-home/internal/app/store"
+	"github.com/IktaS/go-home/internal/pkg/user"
+	"github.com/gorilla/mux"
+)
+
+// AccessTokenParam is the query parameter a token can be given with, for clients that cannot set headers such as browser WebSockets
+const AccessTokenParam = "access_token"
+
+// AuthHandlers is exported handlers for user authentication, sessions last TokenTTL, or user.DefaultTokenTTL if 0
+type AuthHandlers struct {
+	TokenTTL time.Duration
+}
+
+/*
+LoginRequest defines the JSON schema of a login :
+	Name		`name`		: User name
+	Password	`password`	: User password
+*/
+type LoginRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+/*
+TokenResponse defines the JSON schema of an issued token, the token itself is only given once :
+	Token		`token`		: Bearer token to send as `Authorization: Bearer [token]`
+	ID			`id`		: Token ID, to revoke it
+	Kind		`kind`		: session or key
+	Name		`name`		: Name of an API key, omitted for a session
+	ExpiresAt	`expiresAt`	: Time the token expires, null if it never does
+	User		`user`		: User the token authenticates
+*/
+type TokenResponse struct {
+	Token     string         `json:"token"`
+	ID        string         `json:"id"`
+	Kind      user.TokenKind `json:"kind"`
+	Name      string         `json:"name,omitempty"`
+	ExpiresAt *time.Time     `json:"expiresAt"`
+	User      *user.User     `json:"user"`
+}
+
+func (h *AuthHandlers) tokenTTL() time.Duration {
+	if h.TokenTTL == 0 {
+		return user.DefaultTokenTTL
+	}
+	return h.TokenTTL
+}
+
+// bearerToken gets the token of a request, from the Authorization header or else the access_token query parameter
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if header != "" {
+		parts := strings.SplitN(header, " ", 2)
+		if len(parts) == 2 && strings.EqualFold(parts[0], "Bearer") {
+			return strings.TrimSpace(parts[1])
+		}
+		return ""
+	}
+	return r.URL.Query().Get(AccessTokenParam)
+}
+
+// Require is a middleware that answers 401 to a request without a valid token, and 403 to a user whose role is not allowed role
+func (*AuthHandlers) Require(repo store.Repo, role user.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			u, t, err := user.Authenticate(repo, bearerToken(r))
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if u == nil {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="go-home"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if !u.Role.Allows(role) {
+				http.Error(w, "Forbidden, "+string(role)+" role required", http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(user.NewContext(r.Context(), u, t)))
+		})
+	}
+}
+
+// HandleLogin handles logging in with a name and password, it is answered with a session token
+func (h *AuthHandlers) HandleLogin(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req LoginRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, "Invalid Login \n"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		token, t, u, err := user.Login(repo, req.Name, req.Password, h.tokenTTL())
+		if err == user.ErrCredentials {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, &TokenResponse{Token: token, ID: t.Hash, Kind: t.Kind, ExpiresAt: t.ExpiresAt, User: u})
+	}
+}
+
+// HandleLogout handles revoking the token the request is authenticated with
+func (*AuthHandlers) HandleLogout(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		_, t := user.FromContext(r.Context())
+		err := repo.DeleteToken(t.Hash)
+		if err != nil && err != sql.ErrNoRows {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleGetMe handles getting the authenticated user
+func (*AuthHandlers) HandleGetMe() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := user.FromContext(r.Context())
+		writeJSON(w, http.StatusOK, u)
+	}
+}
+
+// HandleGetKeys handles getting the API keys and sessions of the authenticated user
+func (*AuthHandlers) HandleGetKeys(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := user.FromContext(r.Context())
+		tokens, err := repo.GetUserTokens(u.ID.String())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, tokens)
+	}
+}
+
+// HandleCreateKey handles issuing an API key that never expires to the authenticated user
+func (*AuthHandlers) HandleCreateKey(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Name string `json:"name"`
+		}
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, "Invalid Key \n"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if strings.TrimSpace(req.Name) == "" {
+			http.Error(w, "An API key has a name", http.StatusBadRequest)
+			return
+		}
+		u, _ := user.FromContext(r.Context())
+		token, t, err := user.NewToken(repo, u, user.TokenKey, strings.TrimSpace(req.Name), 0)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, &TokenResponse{Token: token, ID: t.Hash, Kind: t.Kind, Name: t.Name, User: u})
+	}
+}
+
+// HandleDeleteKey handles revoking an API key or session of the authenticated user
+func (*AuthHandlers) HandleDeleteKey(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, _ := user.FromContext(r.Context())
+		t, err := repo.GetToken(mux.Vars(r)["id"])
+		if err == sql.ErrNoRows || (err == nil && t.User != u.ID) {
+			http.Error(w, "Key Not Found", http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = repo.DeleteToken(t.Hash)
+		if err != nil && err != sql.ErrNoRows {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
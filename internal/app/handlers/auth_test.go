@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IktaS/go-home/internal/app/store/sqlite"
+	"github.com/IktaS/go-home/internal/pkg/user"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestUser(t *testing.T, repo *sqlite.Store, name string, role user.Role) string {
+	u, err := user.New(name, "correct horse", role)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, repo.SaveUser(u))
+	token, _, err := user.NewToken(repo, u, user.TokenSession, "", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestAuthHandlers_Require(t *testing.T) {
+	repo := newTestStore(t)
+	viewer := newTestUser(t, repo, "viewer", user.RoleViewer)
+	operator := newTestUser(t, repo, "operator", user.RoleOperator)
+	h := &AuthHandlers{}
+	called := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		u, _ := user.FromContext(r.Context())
+		w.Write([]byte(u.Name))
+	})
+	handler := h.Require(repo, user.RoleOperator)(called)
+	tests := []struct {
+		name       string
+		header     string
+		url        string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "No token",
+			url:        "/",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "Unknown token",
+			header:     "Bearer unknown",
+			url:        "/",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "Not a bearer token",
+			header:     "Basic " + operator,
+			url:        "/",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "Role not allowed",
+			header:     "Bearer " + viewer,
+			url:        "/",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:       "Role allowed",
+			header:     "bearer " + operator,
+			url:        "/",
+			wantStatus: http.StatusOK,
+			wantBody:   "operator",
+		},
+		{
+			name:       "Token in query",
+			url:        "/?access_token=" + operator,
+			wantStatus: http.StatusOK,
+			wantBody:   "operator",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", tt.url, nil)
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			assert.Contains(t, w.Body.String(), tt.wantBody)
+			if tt.wantStatus == http.StatusUnauthorized {
+				assert.NotEmpty(t, w.Header().Get("WWW-Authenticate"))
+			}
+		})
+	}
+}
+
+func TestAuthHandlers_Tokens(t *testing.T) {
+	repo := newTestStore(t)
+	newTestUser(t, repo, "alice", user.RoleViewer)
+	other := newTestUser(t, repo, "bob", user.RoleViewer)
+	h := &AuthHandlers{TokenTTL: time.Hour}
+	auth := h.Require(repo, user.RoleViewer)
+	r := mux.NewRouter()
+	r.HandleFunc("/auth/login", h.HandleLogin(repo)).Methods("POST")
+	r.Handle("/auth/logout", auth(h.HandleLogout(repo))).Methods("POST")
+	r.Handle("/auth/me", auth(h.HandleGetMe())).Methods("GET")
+	r.Handle("/auth/key/", auth(h.HandleGetKeys(repo))).Methods("GET")
+	r.Handle("/auth/key/", auth(h.HandleCreateKey(repo))).Methods("POST")
+	r.Handle("/auth/key/{id}", auth(h.HandleDeleteKey(repo))).Methods("DELETE")
+	do := func(method string, url string, token string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, url, strings.NewReader(body))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("POST", "/auth/login", "", `{"name":"alice","password":"wrong horse"}`)
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = do("POST", "/auth/login", "", `{"name":"alice","password":"correct horse"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var login TokenResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &login))
+	assert.Equal(t, "alice", login.User.Name)
+	assert.Equal(t, user.TokenSession, login.Kind)
+	assert.NotNil(t, login.ExpiresAt)
+	assert.NotContains(t, w.Body.String(), "hash", "the password hash is never given")
+
+	w = do("GET", "/auth/me", login.Token, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"name":"alice"`)
+
+	w = do("POST", "/auth/key/", login.Token, `{"name":"dashboard"}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var key TokenResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &key))
+	assert.Nil(t, key.ExpiresAt)
+	w = do("POST", "/auth/key/", login.Token, `{"name":" "}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do("GET", "/auth/key/", key.Token, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), `"name":"dashboard"`)
+	assert.NotContains(t, w.Body.String(), key.Token, "a token is only given once")
+
+	w = do("DELETE", "/auth/key/"+key.ID, other, "")
+	assert.Equal(t, http.StatusNotFound, w.Code, "the key of another user cannot be revoked")
+	w = do("POST", "/auth/logout", login.Token, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = do("GET", "/auth/me", login.Token, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+	w = do("DELETE", "/auth/key/"+key.ID, key.Token, "")
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	w = do("GET", "/auth/me", key.Token, "")
+	assert.Equal(t, http.StatusUnauthorized, w.Code)
+}
@@ -1,27 +1,52 @@
 package handlers
 
 import (
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net"
 	"net/http"
-	"strings"
+	"time"
 
 	"github.com/IktaS/go-home/internal/app/store"
 	"github.com/IktaS/go-home/internal/pkg/auth"
+	"github.com/IktaS/go-home/internal/pkg/bus"
+	"github.com/IktaS/go-home/internal/pkg/decompress"
 	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/logging"
+	"github.com/IktaS/go-home/internal/pkg/pki"
 )
 
-//ConnectionHandlers is handlers for connection
-type ConnectionHandlers struct{}
+//ConnectionHandlers is handlers for connection, devices connecting are published on Bus,
+//a device may select a transport of CallOptions, or device.DefaultCallOptions if nil,
+//and enroll with a certificate signed by CA when it is set, a reconnect needs the certificate when RequireCert is set,
+//hub codes are checked by HubCodes when it is set, so a single use code is replaced once used
+type ConnectionHandlers struct {
+	Bus         *bus.Bus
+	CallOptions *device.CallOptions
+	CA          *pki.CA
+	RequireCert bool
+	HubCodes    *auth.Issuer
+}
+
+func (h *ConnectionHandlers) callOptions() device.CallOptions {
+	if h.CallOptions == nil {
+		return device.DefaultCallOptions
+	}
+	return *h.CallOptions
+}
 
 /*
 newConnection defines a device connect JSON payload :
 	HubCode 	`hub-code`	: To authenticate that device is an authenticated device that user actually want to connect to hub
 	Name		`name`		: Device Name
-	Serv 		`serv`		: An compressed text message of the device respective .serv definition
-	Algorithm 	`algo`		: Defines what algorithm they use to compress said Serv file
+	Addr		`addr`		: Endpoint the hub calls the device on, [scheme://][host][:port][/path], the host it connected from when it has no host
+	Serv 		`serv`		: An compressed text message of the device respective .serv definition, optional on reconnect
+	Algorithm 	`algo`		: Defines what algorithm they use to compress said Serv file, see decompress.Algorithms
+	Secret		`secret`	: Device secret given on its first connection, required to reconnect with its id
+	Transport	`transport`	: Transport the hub calls the device through, http (the default), mqtt or coap, kept on reconnect when left out
+	CSR			`csr`		: PEM encoded certificate signing request, to enroll the device with a certificate signed by the hub CA
 */
 type newConnection struct {
 	ID        interface{} `json:"id,omitempty"`
@@ -30,10 +55,82 @@ type newConnection struct {
 	Name      string      `json:"name"`
 	Serv      string      `json:"serv"`
 	Algorithm string      `json:"algo"`
+	Secret    string      `json:"secret,omitempty"`
+	Transport string      `json:"transport,omitempty"`
+	CSR       string      `json:"csr,omitempty"`
+}
+
+/*
+ConnectResponse defines the JSON schema of a device connecting, the secret is only given once :
+	ID			`id`			: Device UUID, to reconnect with
+	Secret		`secret`		: Device secret, to reconnect and push events with, omitted when the device already has one
+	Certificate	`certificate`	: PEM encoded certificate of the device signed by the hub CA, when it enrolled with a csr
+	CA			`ca`			: PEM encoded certificate of the hub CA, to verify the hub with, when it enrolled with a csr
+*/
+type ConnectResponse struct {
+	ID          string `json:"id"`
+	Secret      string `json:"secret,omitempty"`
+	Certificate string `json:"certificate,omitempty"`
+	CA          string `json:"ca,omitempty"`
+}
+
+// reconnectResponse defines the response of a reconnect that sent a new .serv definition
+type reconnectResponse struct {
+	ConnectResponse
+	Changes *device.Changes `json:"changes"`
+}
+
+// connectEndpoint makes the endpoint a connecting device is called on, the address it sent or else the endpoint it had before,
+// with the host it connected from when the endpoint has no host, e.g. for a device that only sent ":8080"
+func connectEndpoint(r *http.Request, addr string, prev *device.Endpoint) (*device.Endpoint, error) {
+	e := &device.Endpoint{}
+	if addr != "" {
+		parsed, err := device.ParseEndpoint(addr)
+		if err != nil {
+			return nil, err
+		}
+		e = parsed
+	} else if prev != nil {
+		// the device moved to the host it connected from
+		*e = *prev
+		e.Host = ""
+	}
+	if e.Host == "" {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		e.Host = host
+	}
+	return e, nil
 }
 
-// HandleConnect handles connecting a device to the hub
-func (*ConnectionHandlers) HandleConnect(repo store.Repo) http.HandlerFunc {
+// authenticate checks the hub code a device connects with, single use codes are consumed,
+// so it is only checked once the rest of the connection is known to be valid, false is returned once answered
+func (h *ConnectionHandlers) authenticate(w http.ResponseWriter, repo store.Repo, code string) bool {
+	var ok bool
+	var err error
+	if h.HubCodes != nil {
+		ok, err = h.HubCodes.Authenticate(repo, code)
+	} else {
+		ok, err = auth.Authenticate(repo, code)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return false
+	}
+	if !ok {
+		http.Error(w, "Wrong Hub Code", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// HandleConnect handles connecting a device to the hub, a new device needs the hub code and is given a secret it has to reconnect with,
+// a device that reconnects with its id may send a new serv to replace its stored definition,
+// a device registered before secrets existed needs the hub code on its next reconnect and is given a secret,
+// a device may enroll with a csr, and reconnect with the certificate it was given instead of its secret
+func (h *ConnectionHandlers) HandleConnect(repo store.Repo) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var newconn newConnection
 		err := json.NewDecoder(r.Body).Decode(&newconn)
@@ -41,49 +138,190 @@ func (*ConnectionHandlers) HandleConnect(repo store.Repo) http.HandlerFunc {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		if !auth.Authenticate(newconn.HubCode) {
-			http.Error(w, "Wrong Hub Code", http.StatusBadRequest)
+		if newconn.Transport != "" && !h.callOptions().HasTransport(newconn.Transport) {
+			http.Error(w, "Unknown Transport "+newconn.Transport, http.StatusBadRequest)
 			return
 		}
-		log.Println("New connection from :\t" + r.RemoteAddr)
-		var addr net.Addr
-		if newconn.Addr == "" {
-			ipStr := strings.Split(r.RemoteAddr, ":")
-			ip := net.ParseIP(ipStr[0])
-			addr = &net.IPAddr{IP: ip, Zone: ""}
-		} else {
-			ipStr := strings.Split(newconn.Addr, ":")
-			ip := net.ParseIP(ipStr[0])
-			addr = &net.IPAddr{IP: ip, Zone: ""}
+		if newconn.CSR != "" && h.CA == nil {
+			http.Error(w, "The hub has no CA to enroll with", http.StatusBadRequest)
+			return
 		}
+		logging.Infof("New connection from :\t%v\n", r.RemoteAddr)
 		if newconn.ID != nil {
 			dev, err := repo.Get(newconn.ID)
 			if err != nil {
+				if err == sql.ErrNoRows {
+					http.Error(w, "Device Not Found", http.StatusNotFound)
+					return
+				}
 				http.Error(w, err.Error(), http.StatusInternalServerError)
 				return
 			}
+			// a device certificate authenticates the device instead of its secret
+			certID := pki.ClientID(r.TLS)
+			if certID != "" && certID != dev.ID.String() {
+				http.Error(w, "Certificate of Another Device", http.StatusForbidden)
+				return
+			}
+			if certID == "" && h.RequireCert && newconn.CSR == "" {
+				http.Error(w, "Device Certificate Required", http.StatusUnauthorized)
+				return
+			}
+			if certID == "" && dev.HasSecret() && !dev.CheckSecret(newconn.Secret) {
+				http.Error(w, "Wrong Device Secret", http.StatusUnauthorized)
+				return
+			}
+			prev, _ := dev.Endpoint()
+			addr, err := connectEndpoint(r, newconn.Addr, prev)
+			if err != nil {
+				http.Error(w, "Invalid Address \n"+err.Error(), http.StatusBadRequest)
+				return
+			}
+			var changes *device.Changes
+			if newconn.Serv != "" {
+				decompServ, err := decompress.Decompress(newconn.Algorithm, newconn.Serv)
+				if err != nil {
+					http.Error(w, "Cannot Decompress Serv \n"+err.Error(), http.StatusBadRequest)
+					return
+				}
+				changes, err = dev.SetDefinition(decompServ)
+				if err != nil {
+					http.Error(w, "Invalid Serv \n"+err.Error(), http.StatusBadRequest)
+					return
+				}
+			}
+			res := ConnectResponse{ID: dev.ID.String()}
+			err = h.enroll(&res, newconn.CSR)
+			if err != nil {
+				http.Error(w, err.Error(), enrollStatus(err))
+				return
+			}
+			if !dev.HasSecret() {
+				// anyone may know the id of a device without a secret, only the hub code gets it one
+				if certID == "" && !h.authenticate(w, repo, newconn.HubCode) {
+					return
+				}
+				res.Secret, err = dev.NewSecret()
+				if err != nil {
+					http.Error(w, err.Error(), http.StatusInternalServerError)
+					return
+				}
+			}
 			dev.Addr = addr
-			repo.Save(dev)
-			w.WriteHeader(http.StatusOK)
-			fmt.Fprintf(w, "Device Reconnected to Hub!")
+			if newconn.Transport != "" {
+				dev.Transport = newconn.Transport
+			}
+			dev.MarkSeen(time.Now())
+			if changes == nil {
+				err = repo.Save(dev)
+				if err != nil {
+					http.Error(w, "Error Saving Device \n"+err.Error(), http.StatusInternalServerError)
+					return
+				}
+				h.publish(bus.DeviceReconnected, dev)
+				h.observe(dev)
+				if res.Secret != "" || res.Certificate != "" {
+					writeJSON(w, http.StatusOK, &res)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+				fmt.Fprintf(w, "Device Reconnected to Hub!")
+				return
+			}
+			err = repo.Update(dev)
+			if err != nil {
+				http.Error(w, "Error Saving Device \n"+err.Error(), http.StatusInternalServerError)
+				return
+			}
+			h.publish(bus.DeviceReconnected, dev)
+			h.observe(dev)
+			writeJSON(w, http.StatusOK, &reconnectResponse{
+				ConnectResponse: res,
+				Changes:         changes,
+			})
 			return
 		}
-		var DecompServ []byte
-		switch algo := newconn.Algorithm; algo {
-		case "none":
-			DecompServ = []byte(newconn.Serv)
+		addr, err := connectEndpoint(r, newconn.Addr, nil)
+		if err != nil {
+			http.Error(w, "Invalid Address \n"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		DecompServ, err := decompress.Decompress(newconn.Algorithm, newconn.Serv)
+		if err != nil {
+			http.Error(w, "Cannot Decompress Serv \n"+err.Error(), http.StatusBadRequest)
+			return
 		}
 		dev, err := device.NewDevice(newconn.Name, addr, DecompServ)
 		if err != nil {
-			http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+			http.Error(w, "Invalid Serv \n"+err.Error(), http.StatusBadRequest)
 			return
 		}
+		dev.Transport = newconn.Transport
+		res := ConnectResponse{ID: dev.ID.String()}
+		err = h.enroll(&res, newconn.CSR)
+		if err != nil {
+			http.Error(w, err.Error(), enrollStatus(err))
+			return
+		}
+		if !h.authenticate(w, repo, newconn.HubCode) {
+			return
+		}
+		res.Secret, err = dev.NewSecret()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		dev.MarkSeen(time.Now())
 		err = repo.Save(dev)
 		if err != nil {
 			http.Error(w, "Error Saving New Device \n"+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, dev.ID.String())
+		h.publish(bus.DeviceConnected, dev)
+		h.observe(dev)
+		writeJSON(w, http.StatusOK, &res)
 	}
 }
+
+// enroll signs the certificate signing request of a connecting device into its response, nothing is signed without one
+func (h *ConnectionHandlers) enroll(res *ConnectResponse, csr string) error {
+	if csr == "" {
+		return nil
+	}
+	cert, err := h.CA.SignDevice([]byte(csr), res.ID)
+	if err != nil {
+		return err
+	}
+	res.Certificate = string(cert)
+	res.CA = string(h.CA.CertPEM())
+	return nil
+}
+
+// enrollStatus returns the status a failed enrollment is answered with
+func enrollStatus(err error) int {
+	if errors.Is(err, pki.ErrInvalidCSR) {
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// observe makes the transport of a device watch its inbound services when it observes devices, in the background
+// as a device may not answer while it waits for its connection to be answered
+func (h *ConnectionHandlers) observe(dev *device.Device) {
+	opts := h.callOptions()
+	go func() {
+		err := opts.Observe(dev)
+		if err != nil {
+			logging.Warnf("Cannot observe device %v : %v\n", dev.ID, err)
+		}
+	}()
+}
+
+// publish publishes a device connecting, the data of the message is the device
+func (h *ConnectionHandlers) publish(t bus.Type, dev *device.Device) {
+	h.Bus.Publish(&bus.Message{
+		Type:   t,
+		Device: dev.ID.String(),
+		Data:   NewDeviceResponse(dev),
+	})
+}
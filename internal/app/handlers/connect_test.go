@@ -0,0 +1,296 @@
+package handlers
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/IktaS/go-home/internal/app/store"
+	"github.com/IktaS/go-home/internal/pkg/auth"
+	"github.com/IktaS/go-home/internal/pkg/bus"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/pki"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnectionHandlers_HandleConnect(t *testing.T) {
+	repo := newTestStore(t)
+	code, err := auth.NewHubCode(repo, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &ConnectionHandlers{Bus: bus.New()}
+	remoteAddr := "192.0.2.1:1234"
+	var peer *tls.ConnectionState
+	connect := func(t *testing.T, body map[string]interface{}) *httptest.ResponseRecorder {
+		if _, ok := body["hub-code"]; !ok {
+			body["hub-code"] = code
+		}
+		raw, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest("POST", "/connect", strings.NewReader(string(raw)))
+		req.RemoteAddr = remoteAddr
+		req.TLS = peer
+		h.HandleConnect(repo).ServeHTTP(w, req)
+		return w
+	}
+
+	w := connect(t, map[string]interface{}{"name": "Lamp", "addr": "10.0.0.2:80", "serv": "def outbound on();"})
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var res ConnectResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.NotEmpty(t, res.Secret)
+	dev, err := repo.Get(res.ID)
+	assert.NoError(t, err)
+	assert.True(t, dev.CheckSecret(res.Secret))
+
+	tests := []struct {
+		name       string
+		secret     string
+		wantStatus int
+		wantAddr   string
+	}{
+		{
+			name:       "Reconnect without a secret",
+			wantStatus: http.StatusUnauthorized,
+			wantAddr:   "10.0.0.2:80",
+		},
+		{
+			name:       "Reconnect with a wrong secret",
+			secret:     strings.Repeat("0", 64),
+			wantStatus: http.StatusUnauthorized,
+			wantAddr:   "10.0.0.2:80",
+		},
+		{
+			name:       "Reconnect with the secret",
+			secret:     res.Secret,
+			wantStatus: http.StatusOK,
+			wantAddr:   "10.0.0.3:80",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// the secret authenticates a reconnect instead of the hub code
+			w := connect(t, map[string]interface{}{"id": res.ID, "addr": "10.0.0.3:80", "secret": tt.secret, "hub-code": ""})
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			dev, err := repo.Get(res.ID)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantAddr, dev.Addr.String(), "a rejected reconnect keeps the device address")
+		})
+	}
+
+	t.Run("Device without a secret", func(t *testing.T) {
+		legacy := newTestDevice(t, repo)
+		w := connect(t, map[string]interface{}{"id": legacy.ID.String(), "hub-code": ""})
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "knowing its id is not enough to be given a secret")
+		dev, err := repo.Get(legacy.ID.String())
+		assert.NoError(t, err)
+		assert.False(t, dev.HasSecret())
+
+		w = connect(t, map[string]interface{}{"id": legacy.ID.String()})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var res ConnectResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		assert.Equal(t, legacy.ID.String(), res.ID)
+		dev, err = repo.Get(res.ID)
+		assert.NoError(t, err)
+		assert.True(t, dev.CheckSecret(res.Secret), "it is given a secret on its next reconnect with the hub code")
+
+		w = connect(t, map[string]interface{}{"id": legacy.ID.String()})
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "and needs it from then on")
+	})
+
+	t.Run("Single use hub code", func(t *testing.T) {
+		once, err := auth.NewHubCode(repo, 0, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := connect(t, map[string]interface{}{"name": "Lamp", "serv": "def outbound on(;", "hub-code": once})
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+		w = connect(t, map[string]interface{}{"name": "Lamp", "serv": "def outbound on();", "hub-code": once})
+		assert.Equal(t, http.StatusOK, w.Code, "a rejected connection does not use the code")
+		w = connect(t, map[string]interface{}{"name": "Lamp", "serv": "def outbound on();", "hub-code": once})
+		assert.Equal(t, http.StatusUnauthorized, w.Code, w.Body.String())
+	})
+
+	t.Run("Transport", func(t *testing.T) {
+		w := connect(t, map[string]interface{}{"name": "Sensor", "serv": "def inbound pressed();", "transport": "mqtt"})
+		assert.Equal(t, http.StatusBadRequest, w.Code, "the hub has no MQTT transport")
+
+		h.CallOptions = &device.CallOptions{Transports: map[string]device.Transport{device.TransportMQTT: &device.HTTPTransport{}}}
+		defer func() { h.CallOptions = nil }()
+		w = connect(t, map[string]interface{}{"name": "Sensor", "serv": "def inbound pressed();", "transport": "mqtt"})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var res ConnectResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		dev, err := repo.Get(res.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, device.TransportMQTT, dev.TransportName())
+
+		w = connect(t, map[string]interface{}{"id": res.ID, "secret": res.Secret})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		dev, err = repo.Get(res.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, device.TransportMQTT, dev.TransportName(), "a reconnect keeps the transport")
+	})
+
+	t.Run("Enrollment", func(t *testing.T) {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+		w := connect(t, map[string]interface{}{"name": "Lock", "serv": "def outbound open();", "csr": csr})
+		assert.Equal(t, http.StatusBadRequest, w.Code, "the hub has no CA")
+
+		h.CA, err = pki.NewCA("test CA")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() { h.CA, h.RequireCert = nil, false }()
+		w = connect(t, map[string]interface{}{"name": "Lock", "serv": "def outbound open();", "csr": "csr"})
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+		w = connect(t, map[string]interface{}{"name": "Lock", "serv": "def outbound open();", "csr": csr})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var res ConnectResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		assert.Equal(t, string(h.CA.CertPEM()), res.CA)
+		block, _ := pem.Decode([]byte(res.Certificate))
+		if block == nil {
+			t.Fatal("no certificate")
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.Equal(t, res.ID, cert.Subject.CommonName)
+
+		// the certificate authenticates a reconnect instead of the secret
+		h.RequireCert = true
+		w = connect(t, map[string]interface{}{"id": res.ID, "secret": res.Secret})
+		assert.Equal(t, http.StatusUnauthorized, w.Code, "the device has to use its certificate")
+		peer = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		defer func() { peer = nil }()
+		w = connect(t, map[string]interface{}{"id": res.ID})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		other := newTestDevice(t, repo)
+		w = connect(t, map[string]interface{}{"id": other.ID.String()})
+		assert.Equal(t, http.StatusForbidden, w.Code, "the certificate is of another device")
+	})
+
+	t.Run("Endpoint", func(t *testing.T) {
+		remoteAddr = "[fe80::1]:51234"
+		defer func() { remoteAddr = "192.0.2.1:1234" }()
+		w := connect(t, map[string]interface{}{"name": "Plug", "addr": ":8080/api", "serv": "def outbound on();"})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		var res ConnectResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		dev, err := repo.Get(res.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "[fe80::1]:8080/api", dev.Addr.String(), "the host it connected from fills the endpoint")
+
+		remoteAddr = "[fe80::2]:40000"
+		w = connect(t, map[string]interface{}{"id": res.ID, "secret": res.Secret})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		dev, err = repo.Get(res.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "[fe80::2]:8080/api", dev.Addr.String(), "a reconnect keeps the port and path")
+
+		w = connect(t, map[string]interface{}{"name": "Plug", "addr": "10.0.0.2:http", "serv": "def outbound on();"})
+		assert.Equal(t, http.StatusBadRequest, w.Code, w.Body.String())
+
+		w = connect(t, map[string]interface{}{"name": "Plug", "serv": "def outbound on();"})
+		assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		dev, err = repo.Get(res.ID)
+		assert.NoError(t, err)
+		assert.Equal(t, "[fe80::2]", dev.Addr.String(), "the port the device connected from is not the one it listens on")
+	})
+}
+
+func TestConnectionHandlers_HubCodes(t *testing.T) {
+	repo := newTestStore(t)
+	var announced []string
+	issuer := &auth.Issuer{SingleUse: true, Announce: func(code string) { announced = append(announced, code) }}
+	code, err := issuer.Rotate(repo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &ConnectionHandlers{Bus: bus.New(), HubCodes: issuer}
+	connect := func(code string) int {
+		w := httptest.NewRecorder()
+		body := `{"name":"Lamp","addr":"10.0.0.2:80","serv":"def outbound on();","hub-code":"` + code + `"}`
+		h.HandleConnect(repo).ServeHTTP(w, httptest.NewRequest("POST", "/connect", strings.NewReader(body)))
+		return w.Code
+	}
+	assert.Equal(t, http.StatusOK, connect(code))
+	assert.Equal(t, http.StatusUnauthorized, connect(code), "a single use code is consumed")
+	if assert.Len(t, announced, 2, "a fresh code is issued once the code is used") {
+		assert.Equal(t, http.StatusOK, connect(announced[1]), "so the next device can connect")
+	}
+}
+
+func TestDeviceHandlers_HandleResetDeviceSecret(t *testing.T) {
+	repo := newTestStore(t)
+	dev := newTestDevice(t, repo)
+	old, err := dev.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, repo.Save(dev))
+	h := &DeviceHandlers{}
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{id}/secret", h.HandleResetDeviceSecret(repo)).Methods("POST")
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/device/"+dev.ID.String()+"/secret", nil))
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	var res ConnectResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	ret, err := repo.Get(dev.ID.String())
+	assert.NoError(t, err)
+	assert.True(t, ret.CheckSecret(res.Secret))
+	assert.False(t, ret.CheckSecret(old), "the old secret stops working")
+
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/device/unknown/secret", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	r.HandleFunc("/deleted/{id}/secret", h.HandleResetDeviceSecret(deletingRepo{repo})).Methods("POST")
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/deleted/"+dev.ID.String()+"/secret", nil))
+	assert.Equal(t, http.StatusNotFound, w.Code, "a device deleted during the reset")
+	_, err = repo.Get(dev.ID.String())
+	assert.Equal(t, sql.ErrNoRows, err, "is not brought back")
+}
+
+// deletingRepo deletes a device as soon as it is read, as if it was deleted by another request
+type deletingRepo struct {
+	store.Repo
+}
+
+func (r deletingRepo) Get(id interface{}) (*device.Device, error) {
+	dev, err := r.Repo.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	return dev, r.Repo.Delete(id)
+}
@@ -1,39 +1,78 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
-	"os"
+	"strings"
+	"time"
 
 	"github.com/IktaS/go-home/internal/app/store"
+	"github.com/IktaS/go-home/internal/pkg/bus"
 	"github.com/IktaS/go-home/internal/pkg/device"
-	"github.com/IktaS/go-serv/pkg/serv"
+	"github.com/IktaS/go-home/internal/pkg/rule"
 	"github.com/gorilla/mux"
 )
 
-// DeviceHandlers is exported handlers for device
-type DeviceHandlers struct{}
+// DeviceHandlers is exported handlers for device, devices are called with CallOptions, or device.DefaultCallOptions if nil,
+// Rules is invalidated when a device is deleted, as the store removes the device from its rules
+type DeviceHandlers struct {
+	CallOptions *device.CallOptions
+	Bus         *bus.Bus
+	Rules       *rule.Cache
+}
+
+func (h *DeviceHandlers) callOptions() device.CallOptions {
+	if h.CallOptions == nil {
+		return device.DefaultCallOptions
+	}
+	return *h.CallOptions
+}
+
+// writeCallError answers a failed device call, 504 when the device did not respond in time and 502 when it failed
+func writeCallError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		// the client is gone, nobody reads the answer
+		return
+	case errors.Is(err, device.ErrTimeout):
+		http.Error(w, "Device Timed Out \n"+err.Error(), http.StatusGatewayTimeout)
+	case errors.Is(err, device.ErrUnreachable), errors.Is(err, device.ErrBadStatus), errors.Is(err, device.ErrWrongIdentity):
+		http.Error(w, "Cannot Call Device \n"+err.Error(), http.StatusBadGateway)
+	default:
+		http.Error(w, "Cannot Call Device \n"+err.Error(), http.StatusInternalServerError)
+	}
+}
 
-// HandleGetAllDevice handles getting all device
+// HandleGetAllDevice handles getting all device, or only the devices of the group query parameter
 func (*DeviceHandlers) HandleGetAllDevice(repo store.Repo) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		devs, err := repo.GetAll()
+		var devs []*device.Device
+		var err error
+		if id := r.URL.Query().Get("group"); id != "" {
+			g, ok := getGroup(w, repo, id)
+			if !ok {
+				return
+			}
+			devs, err = groupMembers(repo, g)
+		} else {
+			devs, err = repo.GetAll()
+		}
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		jsonString := "["
-		notFirst := false
+		res := make([]*DeviceResponse, 0, len(devs))
 		for _, dev := range devs {
-			if notFirst {
-				jsonString += ","
-			}
-			notFirst = true
-			jsonString += DeviceToJSON(dev)
+			res = append(res, NewDeviceResponse(dev))
 		}
-		jsonString += "]"
-		fmt.Fprintf(w, jsonString)
+		writeJSON(w, http.StatusOK, res)
 	}
 }
 
@@ -49,18 +88,48 @@ func (*DeviceHandlers) HandleGetDevice(repo store.Repo) http.HandlerFunc {
 		dev, err := repo.Get(val)
 		if err != nil {
 			if err == sql.ErrNoRows {
-				http.Error(w, err.Error(), http.StatusNoContent)
+				http.Error(w, "Device Not Found", http.StatusNotFound)
 				return
 			}
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		fmt.Fprintf(w, DeviceToJSON(dev))
+		writeJSON(w, http.StatusOK, NewDeviceResponse(dev))
 	}
 }
 
-// HandleGetDeviceService handles getting device service
-func (*DeviceHandlers) HandleGetDeviceService(repo store.Repo) http.HandlerFunc {
+/*
+devicePatch defines a device PATCH JSON payload, a field that is left out is not changed :
+	Name			`name`				: Device Name
+	Addr			`addr`				: Endpoint override, for a device the hub cannot reach through the address it connected from, e.g. "[fe80::1]:8080/api"
+	Timeout			`timeout`			: Call timeout of the device as a duration, e.g. "2s", "0" uses the hub default
+	ServiceTimeouts	`serviceTimeouts`	: Call timeout by service name, "0" uses the device timeout
+*/
+type devicePatch struct {
+	Name            *string           `json:"name"`
+	Addr            *string           `json:"addr"`
+	Timeout         *string           `json:"timeout"`
+	ServiceTimeouts map[string]string `json:"serviceTimeouts"`
+}
+
+// parseTimeout parses a call timeout, a negative duration is invalid, and so is one longer than the deadline of calls
+// as the call would always end before it
+func parseTimeout(s string, deadline time.Duration) (time.Duration, error) {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%v is negative", s)
+	}
+	if deadline > 0 && d > deadline {
+		return 0, fmt.Errorf("%v is longer than the %v calls are bounded by", s, deadline)
+	}
+	return d, nil
+}
+
+// HandlePatchDevice handles renaming a device, overriding its address and its call timeouts
+func (h *DeviceHandlers) HandlePatchDevice(repo store.Repo) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		val, ok := vars["id"]
@@ -68,21 +137,78 @@ func (*DeviceHandlers) HandleGetDeviceService(repo store.Repo) http.HandlerFunc
 			http.Error(w, "No id", http.StatusBadRequest)
 			return
 		}
+		var patch devicePatch
+		err := json.NewDecoder(r.Body).Decode(&patch)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 		dev, err := repo.Get(val)
 		if err != nil {
 			if err == sql.ErrNoRows {
-				http.Error(w, err.Error(), http.StatusNoContent)
+				http.Error(w, "Device Not Found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if patch.Name != nil {
+			if strings.TrimSpace(*patch.Name) == "" {
+				http.Error(w, "Name cannot be empty", http.StatusBadRequest)
+				return
+			}
+			dev.Name = *patch.Name
+		}
+		if patch.Addr != nil {
+			addr, err := parseAddr(*patch.Addr)
+			if err != nil {
+				http.Error(w, "Invalid Address \n"+err.Error(), http.StatusBadRequest)
+				return
+			}
+			dev.Addr = addr
+		}
+		if patch.Timeout != nil {
+			timeout, err := parseTimeout(*patch.Timeout, h.callOptions().Deadline)
+			if err != nil {
+				http.Error(w, "Invalid Timeout \n"+err.Error(), http.StatusBadRequest)
+				return
+			}
+			dev.Timeout = timeout
+		}
+		for service, value := range patch.ServiceTimeouts {
+			if dev.Service(service) == nil {
+				http.Error(w, "Service Not Found \n"+service, http.StatusBadRequest)
+				return
+			}
+			timeout, err := parseTimeout(value, h.callOptions().Deadline)
+			if err != nil {
+				http.Error(w, "Invalid Timeout \n"+err.Error(), http.StatusBadRequest)
+				return
+			}
+			if dev.ServiceTimeouts == nil {
+				dev.ServiceTimeouts = make(map[string]time.Duration)
+			}
+			if timeout == 0 {
+				delete(dev.ServiceTimeouts, service)
+				continue
+			}
+			dev.ServiceTimeouts[service] = timeout
+		}
+		err = repo.Update(dev)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Device Not Found", http.StatusNotFound)
 				return
 			}
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		fmt.Fprintf(w, ServiceToJSON(dev))
+		writeJSON(w, http.StatusOK, NewDeviceResponse(dev))
 	}
 }
 
-// HandleGetDeviceMessage handles getting device message
-func (*DeviceHandlers) HandleGetDeviceMessage(repo store.Repo) http.HandlerFunc {
+// HandleDeleteDevice handles deleting a device
+func (h *DeviceHandlers) HandleDeleteDevice(repo store.Repo) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		val, ok := vars["id"]
@@ -90,172 +216,248 @@ func (*DeviceHandlers) HandleGetDeviceMessage(repo store.Repo) http.HandlerFunc
 			http.Error(w, "No id", http.StatusBadRequest)
 			return
 		}
-		dev, err := repo.Get(val)
+		err := repo.Delete(val)
 		if err != nil {
 			if err == sql.ErrNoRows {
-				http.Error(w, err.Error(), http.StatusNoContent)
+				http.Error(w, "Device Not Found", http.StatusNotFound)
 				return
 			}
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		fmt.Fprintf(w, MessagesToJSON(dev))
+		h.callOptions().Forget(val)
+		if h.Rules != nil {
+			h.Rules.Invalidate()
+		}
+		h.Bus.Publish(&bus.Message{Type: bus.DeviceDeleted, Device: val})
+		w.WriteHeader(http.StatusNoContent)
 	}
 }
 
-// HandleDeviceServiceCall handles callign a device service
-func (*DeviceHandlers) HandleDeviceServiceCall(repo store.Repo) http.HandlerFunc {
+// HandleResetDeviceSecret handles giving a device a new secret, e.g. when it lost its secret, the old secret stops working
+func (*DeviceHandlers) HandleResetDeviceSecret(repo store.Repo) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
-		id, ok := vars["id"]
+		val, ok := vars["id"]
 		if !ok {
 			http.Error(w, "No id", http.StatusBadRequest)
 			return
 		}
-		dev, err := repo.Get(id)
+		dev, err := repo.Get(val)
 		if err != nil {
 			if err == sql.ErrNoRows {
-				http.Error(w, err.Error(), http.StatusNoContent)
+				http.Error(w, "Device Not Found", http.StatusNotFound)
 				return
 			}
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		service, ok := vars["service"]
-		if !ok {
-			http.Error(w, "No service", http.StatusBadRequest)
+		secret, err := dev.NewSecret()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
-		body, err := dev.Call(service, r.URL.RawQuery)
+		// updated rather than saved, so a device deleted meanwhile is not brought back
+		err = repo.Update(dev)
 		if err != nil {
-			http.Error(w, "Cannot Call Device", http.StatusBadRequest)
+			if err == sql.ErrNoRows {
+				http.Error(w, "Device Not Found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, "Error Saving Device \n"+err.Error(), http.StatusInternalServerError)
 			return
 		}
-		w.WriteHeader(http.StatusOK)
-		fmt.Fprintf(w, string(body))
+		writeJSON(w, http.StatusOK, &ConnectResponse{ID: dev.ID.String(), Secret: secret})
 	}
 }
 
-//DeviceToJSON returns a json string that represent the device
-func DeviceToJSON(d *device.Device) string {
-	ret := fmt.Sprintf("{\"id\":\"%v\",\"addr\":\"%v\",\"name\":\"%v\",\"services\":\"%v\",\"messages\":\"%v\"}",
-		d.ID.String(),
-		d.Addr.String(),
-		d.Name,
-		fmt.Sprintf("%v/device/%v/services", os.Getenv("APP_URL"), d.ID.String()),
-		fmt.Sprintf("%v/device/%v/messages", os.Getenv("APP_URL"), d.ID.String()),
-	)
-	return ret
-}
-
-//ServiceToJSON returns a json string that represent the device
-func ServiceToJSON(d *device.Device) string {
-	return serviceArrayToJSON(d.Services)
-}
-
-//MessagesToJSON returns a json string that represent the device
-func MessagesToJSON(d *device.Device) string {
-	return messageArrayToJSON(d.Messages)
-}
-
-func messageArrayToJSON(messages []*serv.Message) string {
-	ret := "["
-	notfirst := false
-	for _, m := range messages {
-		if notfirst {
-			ret += ","
-		}
-		notfirst = true
-		ret += messageToJSON(m)
+// parseAddr parses a device address override, a full endpoint with a host, see device.ParseEndpoint
+func parseAddr(s string) (net.Addr, error) {
+	e, err := device.ParseEndpoint(s)
+	if err != nil {
+		return nil, err
 	}
-	ret += "]"
-	return ret
-}
-
-func messageToJSON(m *serv.Message) string {
-	ret := fmt.Sprintf("{\"name\":\"%v\",\"definitions\":%v}",
-		m.Name,
-		mesDefinitionArrayToJSON(m.Definitions),
-	)
-	return ret
-}
-
-func mesDefinitionArrayToJSON(mesDefs []*serv.MessageDefinition) string {
-	ret := "["
-	notfirst := false
-	for _, m := range mesDefs {
-		if notfirst {
-			ret += ","
-		}
-		notfirst = true
-		ret += mesDefToJSON(m)
-	}
-	ret += "]"
-	return ret
-}
-
-func mesDefToJSON(m *serv.MessageDefinition) string {
-	if m.Field != nil {
-		f := m.Field
-		isOptional := f.Optional && !f.Required
-		return fmt.Sprintf("{\"name\":\"%v\",\"isOptional\":\"%v\",\"value\":%v}",
-			f.Name,
-			isOptional,
-			typeToJSON(f.Type),
-		)
+	if e.Host == "" {
+		return nil, fmt.Errorf("%v has no host", s)
 	}
-	return "\"None\""
+	return e, nil
 }
 
-func serviceArrayToJSON(services []*serv.Service) string {
-	ret := "["
-	notfirst := false
-	for _, s := range services {
-		if notfirst {
-			ret += ","
-		}
-		notfirst = true
-		ret += serviceToJSON(s)
+// HandleGetDeviceService handles getting device service
+func (*DeviceHandlers) HandleGetDeviceService(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		val, ok := vars["id"]
+		if !ok {
+			http.Error(w, "No id", http.StatusBadRequest)
+			return
+		}
+		dev, err := repo.Get(val)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Device Not Found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, NewServiceResponses(dev.Services))
 	}
-	ret += "]"
-	return ret
 }
 
-func serviceToJSON(s *serv.Service) string {
-	if s.Response == nil {
-		return fmt.Sprintf("{\"name\":\"%v\",\"request\":%v,\"Inbound\":%v,\"Outbound\":%v}",
-			s.Name,
-			typeArrayToJSON(s.Request),
-			s.Inbound,
-			s.Outbound,
-		)
+// HandleGetDeviceMessage handles getting device message
+func (*DeviceHandlers) HandleGetDeviceMessage(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		val, ok := vars["id"]
+		if !ok {
+			http.Error(w, "No id", http.StatusBadRequest)
+			return
+		}
+		dev, err := repo.Get(val)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Device Not Found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, NewMessageResponses(dev.Messages))
 	}
-	return fmt.Sprintf("{\"name\":\"%v\",\"Inbound\":%v,\"Outbound\":%v,\"response\":%v,\"request\":%v}",
-		s.Name,
-		s.Inbound,
-		s.Outbound,
-		typeToJSON(s.Response),
-		typeArrayToJSON(s.Request),
-	)
 }
 
-func typeArrayToJSON(types []*serv.Type) string {
-	ret := "["
-	notfirst := false
-	for _, t := range types {
-		if notfirst {
-			ret += ","
-		}
-		notfirst = true
-		ret += typeToJSON(t)
+// HandleDeviceServiceCall handles calling a device service, parameters are validated against the service request before the device is called
+func (h *DeviceHandlers) HandleDeviceServiceCall(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, ok := vars["id"]
+		if !ok {
+			http.Error(w, "No id", http.StatusBadRequest)
+			return
+		}
+		dev, err := repo.Get(id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Device Not Found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		service, ok := vars["service"]
+		if !ok {
+			http.Error(w, "No service", http.StatusBadRequest)
+			return
+		}
+		s := dev.Service(service)
+		if s == nil {
+			http.Error(w, "Service Not Found", http.StatusNotFound)
+			return
+		}
+		if !s.Outbound {
+			http.Error(w, "Service is not outbound", http.StatusBadRequest)
+			return
+		}
+		violations, err := dev.ValidateQuery(s, r.URL.Query())
+		if err != nil {
+			http.Error(w, "Invalid Service Definition \n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(violations) > 0 {
+			writeJSON(w, http.StatusUnprocessableEntity, &ViolationsResponse{
+				Service:    service,
+				Violations: violations,
+			})
+			return
+		}
+		body, err := dev.Call(r.Context(), service, r.URL.RawQuery, h.callOptions())
+		if err != nil {
+			writeCallError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(body)
 	}
-	ret += "]"
-	return ret
 }
 
-func typeToJSON(t *serv.Type) string {
-	if t.Reference == "" {
-		return fmt.Sprintf("{\"isScalar\":\"true\",\"value\":\"%v\"}", t.Scalar.String())
+// HandleDeviceServiceCallJSON handles calling a device service with a JSON body, the body is validated against the service request,
+// forwarded to the device as JSON, and the device response is re-encoded according to the service response
+func (h *DeviceHandlers) HandleDeviceServiceCallJSON(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, ok := vars["id"]
+		if !ok {
+			http.Error(w, "No id", http.StatusBadRequest)
+			return
+		}
+		dev, err := repo.Get(id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Device Not Found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		service, ok := vars["service"]
+		if !ok {
+			http.Error(w, "No service", http.StatusBadRequest)
+			return
+		}
+		s := dev.Service(service)
+		if s == nil {
+			http.Error(w, "Service Not Found", http.StatusNotFound)
+			return
+		}
+		if !s.Outbound {
+			http.Error(w, "Service is not outbound", http.StatusBadRequest)
+			return
+		}
+		raw, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body := make(map[string]interface{})
+		if len(bytes.TrimSpace(raw)) > 0 {
+			err = device.DecodeJSON(raw, &body)
+			if err != nil {
+				http.Error(w, "Body must be a JSON object \n"+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		violations, err := dev.ValidateBody(s, body)
+		if err != nil {
+			http.Error(w, "Invalid Service Definition \n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if len(violations) > 0 {
+			writeJSON(w, http.StatusUnprocessableEntity, &ViolationsResponse{
+				Service:    service,
+				Violations: violations,
+			})
+			return
+		}
+		payload, err := json.Marshal(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res, err := dev.CallJSON(r.Context(), service, payload, h.callOptions())
+		if err != nil {
+			writeCallError(w, err)
+			return
+		}
+		decoded, err := dev.DecodeResponse(s, res)
+		if err != nil {
+			http.Error(w, "Invalid Device Response \n"+err.Error(), http.StatusBadGateway)
+			return
+		}
+		if s.Response == nil {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, decoded)
 	}
-	return fmt.Sprintf("{\"isScalar\":\"false\",\"value\":\"%v\"}", t.Reference)
 }
@@ -0,0 +1,389 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IktaS/go-home/internal/app/store/sqlite"
+	"github.com/IktaS/go-home/internal/pkg/action"
+	"github.com/IktaS/go-home/internal/pkg/bus"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/rule"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestStore(t *testing.T) *sqlite.Store {
+	repo, err := sqlite.NewSQLiteStore(filepath.Join(t.TempDir(), "handlers.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { repo.DB.Close() })
+	return repo
+}
+
+func newTestDevice(t *testing.T, repo *sqlite.Store) *device.Device {
+	addr := &net.TCPAddr{
+		IP:   net.IPv4(127, 0, 0, 1),
+		Port: 80,
+	}
+	dev, err := device.NewDevice("Device1", addr, []byte(`def outbound click();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Save(dev)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dev
+}
+
+func TestDeviceHandlers_PatchDelete(t *testing.T) {
+	repo := newTestStore(t)
+	dev := newTestDevice(t, repo)
+	h := &DeviceHandlers{Bus: bus.New(), Rules: rule.NewCache(repo)}
+	sub := h.Bus.Subscribe(bus.Filter{Types: []bus.Type{bus.DeviceDeleted}})
+	click := &action.Action{Device: dev.ID.String(), Service: "click"}
+	ru := &rule.Rule{ID: uuid.New(), Name: "night", Definition: rule.Definition{
+		Trigger: &rule.Trigger{Type: rule.TriggerSchedule, At: "23:00"},
+		Actions: []*action.Action{click},
+	}}
+	assert.NoError(t, h.Rules.SaveRule(ru))
+	cached, err := h.Rules.GetRules()
+	assert.NoError(t, err)
+	assert.Equal(t, []*rule.Rule{ru}, cached)
+	defer sub.Close()
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{id}", h.HandleGetDevice(repo)).Methods("GET")
+	r.HandleFunc("/device/{id}", h.HandlePatchDevice(repo)).Methods("PATCH")
+	r.HandleFunc("/device/{id}", h.HandleDeleteDevice(repo)).Methods("DELETE")
+
+	tests := []struct {
+		name       string
+		method     string
+		id         string
+		body       string
+		wantStatus int
+		check      func(t *testing.T)
+	}{
+		{
+			name:       "Rename",
+			method:     "PATCH",
+			id:         dev.ID.String(),
+			body:       `{"name":"Kitchen Plug"}`,
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T) {
+				ret, err := repo.Get(dev.ID.String())
+				assert.NoError(t, err)
+				assert.Equal(t, "Kitchen Plug", ret.Name)
+				assert.Equal(t, "127.0.0.1:80", ret.Addr.String())
+				assert.Len(t, ret.Services, 1)
+			},
+		},
+		{
+			name:       "Override address",
+			method:     "PATCH",
+			id:         dev.ID.String(),
+			body:       `{"addr":"192.168.1.20:8080"}`,
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T) {
+				ret, err := repo.Get(dev.ID.String())
+				assert.NoError(t, err)
+				assert.Equal(t, "Kitchen Plug", ret.Name)
+				assert.Equal(t, "192.168.1.20:8080", ret.Addr.String())
+			},
+		},
+		{
+			name:       "Set call timeouts",
+			method:     "PATCH",
+			id:         dev.ID.String(),
+			body:       `{"timeout":"2s","serviceTimeouts":{"click":"500ms"}}`,
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T) {
+				ret, err := repo.Get(dev.ID.String())
+				assert.NoError(t, err)
+				assert.Equal(t, 2*time.Second, ret.Timeout)
+				assert.Equal(t, map[string]time.Duration{"click": 500 * time.Millisecond}, ret.ServiceTimeouts)
+			},
+		},
+		{
+			name:       "Clear service timeout",
+			method:     "PATCH",
+			id:         dev.ID.String(),
+			body:       `{"serviceTimeouts":{"click":"0"}}`,
+			wantStatus: http.StatusOK,
+			check: func(t *testing.T) {
+				ret, err := repo.Get(dev.ID.String())
+				assert.NoError(t, err)
+				assert.Equal(t, 2*time.Second, ret.Timeout)
+				assert.Empty(t, ret.ServiceTimeouts)
+			},
+		},
+		{
+			name:       "Invalid timeout",
+			method:     "PATCH",
+			id:         dev.ID.String(),
+			body:       `{"timeout":"-1s"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Timeout longer than a call",
+			method:     "PATCH",
+			id:         dev.ID.String(),
+			body:       `{"serviceTimeouts":{"click":"30s"}}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Timeout of unknown service",
+			method:     "PATCH",
+			id:         dev.ID.String(),
+			body:       `{"serviceTimeouts":{"missing":"1s"}}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Invalid address",
+			method:     "PATCH",
+			id:         dev.ID.String(),
+			body:       `{"addr":"not an address"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Empty name",
+			method:     "PATCH",
+			id:         dev.ID.String(),
+			body:       `{"name":" "}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Patch unknown device",
+			method:     "PATCH",
+			id:         "unknown",
+			body:       `{"name":"x"}`,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Delete",
+			method:     "DELETE",
+			id:         dev.ID.String(),
+			wantStatus: http.StatusNoContent,
+			check: func(t *testing.T) {
+				assert.Len(t, sub.C(), 1)
+				assert.Equal(t, dev.ID.String(), (<-sub.C()).Device)
+				rules, err := h.Rules.GetRules()
+				assert.NoError(t, err)
+				assert.Len(t, rules, 1)
+				assert.Empty(t, rules[0].Actions, "the cached rules are read again once the device is removed from them")
+				assert.True(t, rules[0].Disabled)
+			},
+		},
+		{
+			name:       "Get deleted device",
+			method:     "GET",
+			id:         dev.ID.String(),
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Delete unknown device",
+			method:     "DELETE",
+			id:         dev.ID.String(),
+			wantStatus: http.StatusNotFound,
+			check: func(t *testing.T) {
+				assert.Len(t, sub.C(), 0)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/device/"+tt.id, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.check != nil {
+				tt.check(t)
+			}
+		})
+	}
+}
+
+func TestDeviceHandlers_ServiceCallJSON(t *testing.T) {
+	var received map[string]interface{}
+	deviceServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		received = nil
+		json.NewDecoder(r.Body).Decode(&received)
+		switch r.URL.Path {
+		case "/set":
+			fmt.Fprint(w, `{"On":true,"Level":"3","Debug":"dropped"}`)
+		case "/broken":
+			fmt.Fprint(w, `not json`)
+		}
+	}))
+	defer deviceServer.Close()
+	deviceAddr, err := net.ResolveTCPAddr("tcp", deviceServer.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := newTestStore(t)
+	dev, err := device.NewDevice("Device1", deviceAddr, []byte(`
+		message Light{bool On; optional int32 Level;};
+		def outbound set(Light):Light;
+		def outbound broken(Light):Light;
+		def inbound pressed();
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, repo.Save(dev))
+
+	h := &DeviceHandlers{}
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{id}/service/{service}", h.HandleDeviceServiceCallJSON(repo)).Methods("POST")
+
+	tests := []struct {
+		name       string
+		service    string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Valid call",
+			service:    "set",
+			body:       `{"On":true,"Level":3}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `{"On":true,"Level":3}`,
+		},
+		{
+			name:       "Invalid body",
+			service:    "set",
+			body:       `{"On":"yes"}`,
+			wantStatus: http.StatusUnprocessableEntity,
+			wantBody:   `{"service":"set","violations":[{"param":"On","expected":"bool","reason":"invalid"}]}`,
+		},
+		{
+			name:       "Body is not an object",
+			service:    "set",
+			body:       `[1,2]`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Unknown service",
+			service:    "missing",
+			body:       `{}`,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Inbound service",
+			service:    "pressed",
+			body:       `{}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Device answers garbage",
+			service:    "broken",
+			body:       `{"On":false}`,
+			wantStatus: http.StatusBadGateway,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/device/"+dev.ID.String()+"/service/"+tt.service, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantBody != "" {
+				assert.JSONEq(t, tt.wantBody, w.Body.String())
+			}
+		})
+	}
+	assert.Equal(t, map[string]interface{}{"On": false}, received)
+}
+
+func TestDeviceHandlers_ServiceCallErrors(t *testing.T) {
+	deviceServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			fmt.Fprint(w, "done")
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(time.Second):
+			}
+		case "/broken":
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer deviceServer.Close()
+	deviceAddr, err := net.ResolveTCPAddr("tcp", deviceServer.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := newTestStore(t)
+	dev, err := device.NewDevice("Device1", deviceAddr, []byte(`def outbound ok(); def outbound slow(); def outbound broken();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, repo.Save(dev))
+
+	h := &DeviceHandlers{CallOptions: &device.CallOptions{Timeout: 50 * time.Millisecond, Retries: 1, Backoff: time.Millisecond}}
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{id}/service/{service}", h.HandleDeviceServiceCall(repo)).Methods("GET")
+	r.HandleFunc("/device/{id}/service/{service}", h.HandleDeviceServiceCallJSON(repo)).Methods("POST")
+
+	tests := []struct {
+		name       string
+		method     string
+		service    string
+		wantStatus int
+	}{
+		{
+			name:       "Success",
+			method:     "GET",
+			service:    "ok",
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Device times out",
+			method:     "GET",
+			service:    "slow",
+			wantStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name:       "Device fails",
+			method:     "GET",
+			service:    "broken",
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:       "Device times out on POST",
+			method:     "POST",
+			service:    "slow",
+			wantStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name:       "Device fails on POST",
+			method:     "POST",
+			service:    "broken",
+			wantStatus: http.StatusBadGateway,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/device/"+dev.ID.String()+"/service/"+tt.service, nil)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/IktaS/go-home/internal/app/store"
+	"github.com/IktaS/go-home/internal/pkg/bus"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/event"
+	"github.com/IktaS/go-home/internal/pkg/health"
+	"github.com/IktaS/go-home/internal/pkg/logging"
+	"github.com/IktaS/go-home/internal/pkg/pki"
+	"github.com/gorilla/mux"
+)
+
+// DeviceSecretHeader is the header a device sends its secret with when it pushes an event
+const DeviceSecretHeader = "X-Device-Secret"
+
+// EventHandlers is exported handlers for device events, pushed events are published on Bus,
+// a device pushing an event needs its certificate when RequireCert is set, so only HTTP events are accepted then
+type EventHandlers struct {
+	Bus         *bus.Bus
+	RequireCert bool
+}
+
+/*
+EventResponse defines the JSON schema of an event :
+	ID			`id`		: Event ID
+	Device		`device`	: Device UUID
+	Service		`service`	: Inbound service the event was pushed through
+	Payload		`payload`	: Payload of the event, as validated against the service request
+	CreatedAt	`createdAt`	: Time the hub received the event
+*/
+type EventResponse struct {
+	ID        int64           `json:"id"`
+	Device    string          `json:"device"`
+	Service   string          `json:"service"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// NewEventResponse makes the response of an event
+func NewEventResponse(e *event.Event) *EventResponse {
+	return &EventResponse{
+		ID:        e.ID,
+		Device:    e.DeviceID.String(),
+		Service:   e.Service,
+		Payload:   e.Payload,
+		CreatedAt: e.CreatedAt.UTC(),
+	}
+}
+
+// PushError is an event a device pushed that is rejected, it is answered with Status,
+// and with the Violations of the payload when there are any
+type PushError struct {
+	Status     int
+	Message    string
+	Violations []*device.Violation
+}
+
+func (e *PushError) Error() string {
+	if len(e.Violations) > 0 {
+		return fmt.Sprintf("%v violations of the service request", len(e.Violations))
+	}
+	return e.Message
+}
+
+// PushEvent handles a device pushing an event through one of its inbound services whatever transport it came through,
+// the payload is validated against the service request the same way as a JSON service call,
+// a device has to send its secret, and is rejected when RequireCert is set as only HTTP carries its certificate,
+// a rejected event is returned as a *PushError
+func (h *EventHandlers) PushEvent(repo store.Repo, id string, service string, secret string, raw []byte) (*event.Event, error) {
+	if h.RequireCert {
+		return nil, &PushError{Status: http.StatusUnauthorized, Message: "Device Certificate Required"}
+	}
+	return h.pushEvent(repo, id, service, secret, "", raw)
+}
+
+// pushEvent pushes an event of a device that is authenticated by the ID of its certificate when certID is set, or else by its secret
+func (h *EventHandlers) pushEvent(repo store.Repo, id string, service string, secret string, certID string, raw []byte) (*event.Event, error) {
+	dev, err := repo.Get(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &PushError{Status: http.StatusNotFound, Message: "Device Not Found"}
+		}
+		return nil, err
+	}
+	if certID != "" {
+		if certID != dev.ID.String() {
+			return nil, &PushError{Status: http.StatusForbidden, Message: "Certificate of Another Device"}
+		}
+	} else if !dev.HasSecret() {
+		// a device registered before secrets existed gets one by reconnecting with the hub code
+		return nil, &PushError{Status: http.StatusUnauthorized, Message: "Device Has No Secret, Reconnect With The Hub Code"}
+	} else if !dev.CheckSecret(secret) {
+		return nil, &PushError{Status: http.StatusUnauthorized, Message: "Wrong Device Secret"}
+	}
+	s := dev.Service(service)
+	if s == nil {
+		return nil, &PushError{Status: http.StatusNotFound, Message: "Service Not Found"}
+	}
+	if !s.Inbound {
+		return nil, &PushError{Status: http.StatusBadRequest, Message: "Service is not inbound"}
+	}
+	body := make(map[string]interface{})
+	if len(bytes.TrimSpace(raw)) > 0 {
+		err = device.DecodeJSON(raw, &body)
+		if err != nil {
+			return nil, &PushError{Status: http.StatusBadRequest, Message: "Body must be a JSON object \n" + err.Error()}
+		}
+	}
+	violations, err := dev.ValidateBody(s, body)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Service Definition \n%v", err)
+	}
+	if len(violations) > 0 {
+		return nil, &PushError{Status: http.StatusUnprocessableEntity, Violations: violations}
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	e := event.New(dev.ID, service, payload)
+	err = repo.SaveEvent(e)
+	if err != nil {
+		return nil, fmt.Errorf("Error Saving Event \n%v", err)
+	}
+	// a device that pushes an event is reachable
+	err = repo.SetStatus(dev.ID.String(), device.StatusOnline, e.CreatedAt)
+	if err != nil {
+		logging.Errorf("Cannot set device status : %v\n", err)
+	} else if dev.Status != device.StatusOnline {
+		h.Bus.Publish(health.NewStatusChange(dev.ID.String(), device.StatusOnline, e.CreatedAt))
+	}
+	h.Bus.Publish(&bus.Message{Type: bus.DeviceEvent, Device: dev.ID.String(), Data: e})
+	return e, nil
+}
+
+// HandleDeviceEvent handles a device pushing an event over HTTP, with its secret in the DeviceSecretHeader
+// or with its certificate, which takes precedence over the secret
+func (h *EventHandlers) HandleDeviceEvent(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, ok := vars["id"]
+		if !ok {
+			http.Error(w, "No id", http.StatusBadRequest)
+			return
+		}
+		service, ok := vars["service"]
+		if !ok {
+			http.Error(w, "No service", http.StatusBadRequest)
+			return
+		}
+		raw, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		certID := pki.ClientID(r.TLS)
+		if certID == "" && h.RequireCert {
+			http.Error(w, "Device Certificate Required", http.StatusUnauthorized)
+			return
+		}
+		e, err := h.pushEvent(repo, id, service, r.Header.Get(DeviceSecretHeader), certID, raw)
+		var pushErr *PushError
+		if errors.As(err, &pushErr) {
+			if len(pushErr.Violations) > 0 {
+				writeJSON(w, pushErr.Status, &ViolationsResponse{
+					Service:    service,
+					Violations: pushErr.Violations,
+				})
+				return
+			}
+			http.Error(w, pushErr.Message, pushErr.Status)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, NewEventResponse(e))
+	}
+}
+
+/*
+EventsResponse defines the JSON schema of a page of events in `/device/{id}/events` :
+	Events	`events`	: Events of the page, newest first
+	Next	`next`		: Cursor of the next page, null on the last page
+*/
+type EventsResponse struct {
+	Events []*EventResponse `json:"events"`
+	Next   *string          `json:"next"`
+}
+
+// parseEventQuery parses the query parameters of an event history request
+func parseEventQuery(values url.Values) (*event.Query, error) {
+	q := &event.Query{
+		Service: values.Get("service"),
+		Limit:   event.DefaultLimit,
+	}
+	var err error
+	if since := values.Get("since"); since != "" {
+		q.Since, err = time.Parse(time.RFC3339, since)
+		if err != nil {
+			return nil, fmt.Errorf("since must be a RFC 3339 time : %v", err)
+		}
+	}
+	if until := values.Get("until"); until != "" {
+		q.Until, err = time.Parse(time.RFC3339, until)
+		if err != nil {
+			return nil, fmt.Errorf("until must be a RFC 3339 time : %v", err)
+		}
+	}
+	if limit := values.Get("limit"); limit != "" {
+		q.Limit, err = strconv.Atoi(limit)
+		if err != nil || q.Limit <= 0 || q.Limit > event.MaxLimit {
+			return nil, fmt.Errorf("limit must be between 1 and %v", event.MaxLimit)
+		}
+	}
+	if cursor := values.Get("cursor"); cursor != "" {
+		q.Before, err = event.DecodeCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return q, nil
+}
+
+// HandleGetDeviceEvents handles getting the event history of a device, newest first, a page at a time
+func (*EventHandlers) HandleGetDeviceEvents(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, ok := vars["id"]
+		if !ok {
+			http.Error(w, "No id", http.StatusBadRequest)
+			return
+		}
+		dev, err := repo.Get(id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Device Not Found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		q, err := parseEventQuery(r.URL.Query())
+		if err != nil {
+			http.Error(w, "Invalid Query \n"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		q.DeviceID = dev.ID.String()
+		limit := q.Limit
+		// one more event than asked tells whether there is a next page
+		q.Limit++
+		events, err := repo.GetEvents(q)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res := &EventsResponse{Events: make([]*EventResponse, 0, len(events))}
+		if len(events) > limit {
+			events = events[:limit]
+			next := event.EncodeCursor(events[limit-1].ID)
+			res.Next = &next
+		}
+		for _, e := range events {
+			res.Events = append(res.Events, NewEventResponse(e))
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
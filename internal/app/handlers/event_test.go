@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/bus"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/event"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventHandlers_HandleDeviceEvent(t *testing.T) {
+	repo := newTestStore(t)
+	addr := &net.TCPAddr{
+		IP:   net.IPv4(127, 0, 0, 1),
+		Port: 80,
+	}
+	dev, err := device.NewDevice("Door", addr, []byte(`
+		message DoorState{bool Open; optional int32 Battery;};
+		def inbound opened(DoorState);
+		def inbound pressed();
+		def outbound lock();
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := dev.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, repo.Save(dev))
+
+	h := &EventHandlers{Bus: bus.New()}
+	sub := h.Bus.Subscribe(bus.Filter{})
+	defer sub.Close()
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{id}/event/{service}", h.HandleDeviceEvent(repo)).Methods("POST")
+
+	tests := []struct {
+		name        string
+		id          string
+		service     string
+		body        string
+		wantStatus  int
+		wantPayload string
+	}{
+		{
+			name:        "Valid event",
+			id:          dev.ID.String(),
+			service:     "opened",
+			body:        `{"Open":true,"Battery":80}`,
+			wantStatus:  http.StatusCreated,
+			wantPayload: `{"Battery":80,"Open":true}`,
+		},
+		{
+			name:        "Event without payload",
+			id:          dev.ID.String(),
+			service:     "pressed",
+			wantStatus:  http.StatusCreated,
+			wantPayload: `{}`,
+		},
+		{
+			name:       "Invalid payload",
+			id:         dev.ID.String(),
+			service:    "opened",
+			body:       `{"Open":"yes","Extra":1}`,
+			wantStatus: http.StatusUnprocessableEntity,
+		},
+		{
+			name:       "Outbound service",
+			id:         dev.ID.String(),
+			service:    "lock",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Unknown service",
+			id:         dev.ID.String(),
+			service:    "closed",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Unknown device",
+			id:         "unknown",
+			service:    "opened",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/device/"+tt.id+"/event/"+tt.service, strings.NewReader(tt.body))
+			req.Header.Set(DeviceSecretHeader, secret)
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			if tt.wantPayload != "" {
+				var payload string
+				err := repo.DB.QueryRow("SELECT payload FROM events ORDER BY id DESC LIMIT 1").Scan(&payload)
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantPayload, payload)
+				assert.Contains(t, w.Body.String(), `"service":"`+tt.service+`"`)
+			}
+		})
+	}
+
+	var count int
+	assert.NoError(t, repo.DB.QueryRow("SELECT COUNT(*) FROM events").Scan(&count))
+	assert.Equal(t, 2, count, "rejected events must not be stored")
+	ret, err := repo.Get(dev.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, device.StatusOnline, ret.Status)
+
+	var types []bus.Type
+	for len(sub.C()) > 0 {
+		m := <-sub.C()
+		assert.Equal(t, dev.ID.String(), m.Device)
+		types = append(types, m.Type)
+	}
+	assert.Equal(t, []bus.Type{bus.DeviceStatus, bus.DeviceEvent, bus.DeviceEvent}, types,
+		"the device going online is published once, then every stored event")
+}
+
+func TestEventHandlers_HandleDeviceEventSecret(t *testing.T) {
+	repo := newTestStore(t)
+	addr := &net.TCPAddr{
+		IP:   net.IPv4(127, 0, 0, 1),
+		Port: 80,
+	}
+	dev, err := device.NewDevice("Button", addr, []byte(`def inbound pressed();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := dev.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, repo.Save(dev))
+	legacy, err := device.NewDevice("Old Button", addr, []byte(`def inbound pressed();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, repo.Save(legacy))
+
+	h := &EventHandlers{Bus: bus.New()}
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{id}/event/{service}", h.HandleDeviceEvent(repo)).Methods("POST")
+	tests := []struct {
+		name        string
+		device      string
+		secret      string
+		certID      string
+		requireCert bool
+		wantStatus  int
+	}{
+		{
+			name:       "Without a secret",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "With a wrong secret",
+			secret:     "wrong",
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "With the secret",
+			secret:     secret,
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "With its certificate",
+			certID:     dev.ID.String(),
+			wantStatus: http.StatusCreated,
+		},
+		{
+			name:       "With the certificate of another device",
+			secret:     secret,
+			certID:     "00000000-0000-0000-0000-000000000000",
+			wantStatus: http.StatusForbidden,
+		},
+		{
+			name:        "With the secret when the certificate is required",
+			secret:      secret,
+			requireCert: true,
+			wantStatus:  http.StatusUnauthorized,
+		},
+		{
+			name:       "Device without a secret",
+			device:     legacy.ID.String(),
+			wantStatus: http.StatusUnauthorized,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id := dev.ID.String()
+			if tt.device != "" {
+				id = tt.device
+			}
+			req := httptest.NewRequest("POST", "/device/"+id+"/event/pressed", nil)
+			if tt.secret != "" {
+				req.Header.Set(DeviceSecretHeader, tt.secret)
+			}
+			if tt.certID != "" {
+				cert := &x509.Certificate{Subject: pkix.Name{CommonName: tt.certID}}
+				req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+			}
+			h.RequireCert = tt.requireCert
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+		})
+	}
+}
+
+func TestEventHandlers_PushEvent(t *testing.T) {
+	repo := newTestStore(t)
+	addr := &net.TCPAddr{
+		IP:   net.IPv4(127, 0, 0, 1),
+		Port: 80,
+	}
+	dev, err := device.NewDevice("Button", addr, []byte(`def inbound pressed();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := dev.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, repo.Save(dev))
+
+	h := &EventHandlers{Bus: bus.New()}
+	_, err = h.PushEvent(repo, dev.ID.String(), "pressed", secret, nil)
+	assert.NoError(t, err)
+
+	// events of MQTT and CoAP carry no certificate
+	h.RequireCert = true
+	_, err = h.PushEvent(repo, dev.ID.String(), "pressed", secret, nil)
+	var pushErr *PushError
+	if assert.True(t, errors.As(err, &pushErr)) {
+		assert.Equal(t, http.StatusUnauthorized, pushErr.Status)
+	}
+}
+
+func TestEventHandlers_HandleGetDeviceEvents(t *testing.T) {
+	repo := newTestStore(t)
+	dev := newTestDevice(t, repo)
+	start := time.Unix(1700000000, 0)
+	for i := 0; i < 5; i++ {
+		e := event.New(dev.ID, "click", json.RawMessage(fmt.Sprintf(`{"n":%v}`, i)))
+		e.CreatedAt = start.Add(time.Duration(i) * time.Minute)
+		assert.NoError(t, repo.SaveEvent(e))
+	}
+
+	h := &EventHandlers{}
+	r := mux.NewRouter()
+	r.HandleFunc("/device/{id}/events", h.HandleGetDeviceEvents(repo)).Methods("GET")
+	get := func(t *testing.T, url string) (int, *EventsResponse) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		if w.Code != http.StatusOK {
+			return w.Code, nil
+		}
+		var res EventsResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+		return w.Code, &res
+	}
+	payloads := func(res *EventsResponse) []string {
+		var p []string
+		for _, e := range res.Events {
+			p = append(p, string(e.Payload))
+		}
+		return p
+	}
+
+	t.Run("Pages", func(t *testing.T) {
+		var got []string
+		url := "/device/" + dev.ID.String() + "/events?limit=2"
+		for pages := 0; ; pages++ {
+			assert.Less(t, pages, 3)
+			code, res := get(t, url)
+			assert.Equal(t, http.StatusOK, code)
+			got = append(got, payloads(res)...)
+			if res.Next == nil {
+				break
+			}
+			url = "/device/" + dev.ID.String() + "/events?limit=2&cursor=" + *res.Next
+		}
+		assert.Equal(t, []string{`{"n":4}`, `{"n":3}`, `{"n":2}`, `{"n":1}`, `{"n":0}`}, got)
+	})
+	t.Run("Time range", func(t *testing.T) {
+		code, res := get(t, "/device/"+dev.ID.String()+"/events?since="+start.Add(time.Minute).UTC().Format(time.RFC3339)+
+			"&until="+start.Add(3*time.Minute).UTC().Format(time.RFC3339))
+		assert.Equal(t, http.StatusOK, code)
+		assert.Equal(t, []string{`{"n":2}`, `{"n":1}`}, payloads(res))
+		assert.Nil(t, res.Next)
+	})
+	t.Run("Other service", func(t *testing.T) {
+		code, res := get(t, "/device/"+dev.ID.String()+"/events?service=other")
+		assert.Equal(t, http.StatusOK, code)
+		assert.Empty(t, res.Events)
+	})
+	for _, query := range []string{"limit=0", "limit=1000", "since=yesterday", "cursor=x"} {
+		t.Run("Invalid "+query, func(t *testing.T) {
+			code, _ := get(t, "/device/"+dev.ID.String()+"/events?"+query)
+			assert.Equal(t, http.StatusBadRequest, code)
+		})
+	}
+	t.Run("Unknown device", func(t *testing.T) {
+		code, _ := get(t, "/device/unknown/events")
+		assert.Equal(t, http.StatusNotFound, code)
+	})
+}
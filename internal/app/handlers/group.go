@@ -0,0 +1,343 @@
+package handlers
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/IktaS/go-home/internal/app/store"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/group"
+	"github.com/gorilla/mux"
+)
+
+// GroupHandlers is exported handlers for device groups, devices are called with CallOptions, or device.DefaultCallOptions if nil
+type GroupHandlers struct {
+	CallOptions *device.CallOptions
+}
+
+func (h *GroupHandlers) callOptions() device.CallOptions {
+	if h.CallOptions == nil {
+		return device.DefaultCallOptions
+	}
+	return *h.CallOptions
+}
+
+/*
+GroupCallResult defines the JSON schema of the call of a group member :
+	Device		`device`		: Device UUID
+	Name		`name`			: Device Name
+	OK			`ok`			: Whether the device answered the call successfully
+	Response	`response`		: Device response decoded as the service response type, omitted if none
+	Error		`error`			: Why the call failed, omitted if it succeeded
+	Violations	`violations`	: Parameters the device service does not accept, omitted if none
+*/
+type GroupCallResult struct {
+	Device     string              `json:"device"`
+	Name       string              `json:"name"`
+	OK         bool                `json:"ok"`
+	Response   interface{}         `json:"response,omitempty"`
+	Error      string              `json:"error,omitempty"`
+	Violations []*device.Violation `json:"violations,omitempty"`
+}
+
+/*
+GroupCallResponse defines the JSON schema of a service called on a group :
+	Group	`group`		: Group ID
+	Service	`service`	: Service called
+	OK		`ok`		: Whether every called device succeeded
+	Devices	`devices`	: Result of every device that declares the service as outbound
+	Skipped	`skipped`	: UUID of the devices of the group that do not declare it
+*/
+type GroupCallResponse struct {
+	Group   string             `json:"group"`
+	Service string             `json:"service"`
+	OK      bool               `json:"ok"`
+	Devices []*GroupCallResult `json:"devices"`
+	Skipped []string           `json:"skipped"`
+}
+
+// getGroup gets the group of the request, it answers the request on error
+func getGroup(w http.ResponseWriter, repo store.Repo, id string) (*group.Group, bool) {
+	g, err := repo.GetGroup(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Group Not Found", http.StatusNotFound)
+			return nil, false
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return g, true
+}
+
+// writeGroupError answers a group that cannot be saved, 400 when it is invalid
+func writeGroupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, group.ErrInvalid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// readGroup reads a group from a request body and validates it against its devices, it answers the request on error
+func readGroup(w http.ResponseWriter, r *http.Request, repo store.Repo, id string) (*group.Group, bool) {
+	g := &group.Group{}
+	err := json.NewDecoder(r.Body).Decode(g)
+	if err != nil {
+		http.Error(w, "Invalid Group \n"+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if id != "" {
+		g.ID = id
+	}
+	err = g.Validate(repo)
+	if err != nil {
+		writeGroupError(w, err)
+		return nil, false
+	}
+	return g, true
+}
+
+// HandleGetAllGroup handles getting every group
+func (*GroupHandlers) HandleGetAllGroup(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		groups, err := repo.GetGroups()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, groups)
+	}
+}
+
+// HandleGetGroup handles getting a group
+func (*GroupHandlers) HandleGetGroup(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g, ok := getGroup(w, repo, mux.Vars(r)["id"])
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, g)
+	}
+}
+
+// HandleCreateGroup handles creating a group, answered with 409 when the ID is taken
+func (*GroupHandlers) HandleCreateGroup(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		g, ok := readGroup(w, r, repo, "")
+		if !ok {
+			return
+		}
+		_, err := repo.GetGroup(g.ID)
+		if err == nil {
+			http.Error(w, "Group "+g.ID+" already exists", http.StatusConflict)
+			return
+		}
+		if err != sql.ErrNoRows {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = repo.SaveGroup(g)
+		if err != nil {
+			http.Error(w, "Error Saving Group \n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, g)
+	}
+}
+
+// HandleUpdateGroup handles replacing the name and devices of a group
+func (*GroupHandlers) HandleUpdateGroup(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		_, ok := getGroup(w, repo, id)
+		if !ok {
+			return
+		}
+		g, ok := readGroup(w, r, repo, id)
+		if !ok {
+			return
+		}
+		err := repo.SaveGroup(g)
+		if err != nil {
+			http.Error(w, "Error Saving Group \n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, g)
+	}
+}
+
+// HandleDeleteGroup handles deleting a group, its devices are kept
+func (*GroupHandlers) HandleDeleteGroup(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		err := repo.DeleteGroup(mux.Vars(r)["id"])
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Group Not Found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleAddGroupDevice handles adding a device to a group
+func (*GroupHandlers) HandleAddGroupDevice(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		deviceID, err := group.ValidateDevice(repo, vars["device"])
+		if err != nil {
+			if errors.Is(err, group.ErrInvalid) {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		err = repo.AddGroupDevice(vars["id"], deviceID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Group Not Found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleRemoveGroupDevice handles removing a device from a group
+func (*GroupHandlers) HandleRemoveGroupDevice(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		err := repo.RemoveGroupDevice(vars["id"], vars["device"])
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Device Not In Group", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// groupMembers gets the devices of a group
+func groupMembers(repo store.Repo, g *group.Group) ([]*device.Device, error) {
+	devs := make([]*device.Device, 0, len(g.Devices))
+	for _, id := range g.Devices {
+		dev, err := repo.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		devs = append(devs, dev)
+	}
+	return devs, nil
+}
+
+// callMember calls a service of a group member with a JSON body, and decodes its response
+func (h *GroupHandlers) callMember(r *http.Request, dev *device.Device, service string, body map[string]interface{}) *GroupCallResult {
+	res := &GroupCallResult{Device: dev.ID.String(), Name: dev.Name}
+	s := dev.Service(service)
+	violations, err := dev.ValidateBody(s, body)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	if len(violations) > 0 {
+		res.Error = "invalid parameters"
+		res.Violations = violations
+		return res
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	answer, err := dev.CallJSON(r.Context(), service, payload, h.callOptions())
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	decoded, err := dev.DecodeResponse(s, answer)
+	if err != nil {
+		res.Error = "invalid device response : " + err.Error()
+		return res
+	}
+	res.OK = true
+	if s.Response != nil {
+		res.Response = decoded
+	}
+	return res
+}
+
+// HandleGroupServiceCall handles calling a service with a JSON body on every device of a group that declares it as outbound,
+// the devices are called at the same time, the body is checked against each of them, and it is answered with 502 when a device failed
+func (h *GroupHandlers) HandleGroupServiceCall(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		g, ok := getGroup(w, repo, vars["id"])
+		if !ok {
+			return
+		}
+		service := vars["service"]
+		raw, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body := make(map[string]interface{})
+		if len(bytes.TrimSpace(raw)) > 0 {
+			err = device.DecodeJSON(raw, &body)
+			if err != nil {
+				http.Error(w, "Body must be a JSON object \n"+err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+		devs, err := groupMembers(repo, g)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res := &GroupCallResponse{Group: g.ID, Service: service, OK: true, Devices: []*GroupCallResult{}, Skipped: []string{}}
+		var called []*device.Device
+		for _, dev := range devs {
+			if s := dev.Service(service); s == nil || !s.Outbound {
+				res.Skipped = append(res.Skipped, dev.ID.String())
+				continue
+			}
+			called = append(called, dev)
+		}
+		if len(called) == 0 {
+			http.Error(w, "No device of the group has outbound service "+service, http.StatusNotFound)
+			return
+		}
+		res.Devices = make([]*GroupCallResult, len(called))
+		var wg sync.WaitGroup
+		for i, dev := range called {
+			wg.Add(1)
+			go func(i int, dev *device.Device) {
+				defer wg.Done()
+				res.Devices[i] = h.callMember(r, dev, service, body)
+			}(i, dev)
+		}
+		wg.Wait()
+		for _, d := range res.Devices {
+			res.OK = res.OK && d.OK
+		}
+		if !res.OK {
+			writeJSON(w, http.StatusBadGateway, res)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGroupHandlers(t *testing.T) {
+	deviceServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/off" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, `{"On":true}`)
+	}))
+	defer deviceServer.Close()
+	deviceAddr, err := net.ResolveTCPAddr("tcp", deviceServer.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := newTestStore(t)
+	newDevice := func(name string, s string) *device.Device {
+		dev, err := device.NewDevice(name, deviceAddr, []byte(s))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NoError(t, repo.Save(dev))
+		return dev
+	}
+	lamp := newDevice("Lamp", `message Light{bool On;}; def outbound on(Light):Light; def outbound off();`)
+	kettle := newDevice("Kettle", `def outbound boil();`)
+	other := newTestDevice(t, repo)
+
+	h := &GroupHandlers{CallOptions: &device.CallOptions{Timeout: time.Second}}
+	d := &DeviceHandlers{}
+	r := mux.NewRouter()
+	r.HandleFunc("/device/", d.HandleGetAllDevice(repo)).Methods("GET")
+	r.HandleFunc("/group/", h.HandleGetAllGroup(repo)).Methods("GET")
+	r.HandleFunc("/group/", h.HandleCreateGroup(repo)).Methods("POST")
+	r.HandleFunc("/group/{id}", h.HandleGetGroup(repo)).Methods("GET")
+	r.HandleFunc("/group/{id}", h.HandleUpdateGroup(repo)).Methods("PUT")
+	r.HandleFunc("/group/{id}", h.HandleDeleteGroup(repo)).Methods("DELETE")
+	r.HandleFunc("/group/{id}/device/{device}", h.HandleAddGroupDevice(repo)).Methods("PUT")
+	r.HandleFunc("/group/{id}/device/{device}", h.HandleRemoveGroupDevice(repo)).Methods("DELETE")
+	r.HandleFunc("/group/{id}/service/{service}", h.HandleGroupServiceCall(repo)).Methods("POST")
+	do := func(method string, url string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return w
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		url         string
+		body        string
+		wantStatus  int
+		wantBody    string
+		notWantBody string
+	}{
+		{
+			name:       "Create",
+			method:     "POST",
+			url:        "/group/",
+			body:       `{"id":"kitchen","devices":["` + lamp.ID.String() + `"]}`,
+			wantStatus: http.StatusCreated,
+			wantBody:   `"name":"kitchen"`,
+		},
+		{
+			name:       "Create taken ID",
+			method:     "POST",
+			url:        "/group/",
+			body:       `{"id":"kitchen"}`,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "Create invalid ID",
+			method:     "POST",
+			url:        "/group/",
+			body:       `{"id":"the kitchen"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Add device",
+			method:     "PUT",
+			url:        "/group/kitchen/device/" + kettle.ID.String(),
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "Add unknown device",
+			method:     "PUT",
+			url:        "/group/kitchen/device/00000000-0000-0000-0000-000000000000",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:        "Devices of the group",
+			method:      "GET",
+			url:         "/device/?group=kitchen",
+			wantStatus:  http.StatusOK,
+			wantBody:    `"name":"Kettle"`,
+			notWantBody: other.ID.String(),
+		},
+		{
+			name:       "Devices of an unknown group",
+			method:     "GET",
+			url:        "/device/?group=garage",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Call",
+			method:     "POST",
+			url:        "/group/kitchen/service/on",
+			body:       `{"On":true}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `"devices":[{"device":"` + lamp.ID.String() + `","name":"Lamp","ok":true,"response":{"On":true}}],"skipped":["` + kettle.ID.String() + `"]`,
+		},
+		{
+			name:       "Call with invalid parameters",
+			method:     "POST",
+			url:        "/group/kitchen/service/on",
+			body:       `{"On":"yes"}`,
+			wantStatus: http.StatusBadGateway,
+			wantBody:   `"violations":[`,
+		},
+		{
+			name:       "Call failing",
+			method:     "POST",
+			url:        "/group/kitchen/service/off",
+			wantStatus: http.StatusBadGateway,
+			wantBody:   `"ok":false`,
+		},
+		{
+			name:       "Call service no device has",
+			method:     "POST",
+			url:        "/group/kitchen/service/click",
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Remove device",
+			method:     "DELETE",
+			url:        "/group/kitchen/device/" + lamp.ID.String(),
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "Remove device not in group",
+			method:     "DELETE",
+			url:        "/group/kitchen/device/" + lamp.ID.String(),
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:        "Update",
+			method:      "PUT",
+			url:         "/group/kitchen",
+			body:        `{"name":"Kitchen","devices":["` + other.ID.String() + `"]}`,
+			wantStatus:  http.StatusOK,
+			wantBody:    `{"id":"kitchen","name":"Kitchen","devices":["` + other.ID.String() + `"]}`,
+			notWantBody: kettle.ID.String(),
+		},
+		{
+			name:       "Get all",
+			method:     "GET",
+			url:        "/group/",
+			wantStatus: http.StatusOK,
+			wantBody:   `"id":"kitchen"`,
+		},
+		{
+			name:       "Delete",
+			method:     "DELETE",
+			url:        "/group/kitchen",
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "Get deleted group",
+			method:     "GET",
+			url:        "/group/kitchen",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.method, tt.url, tt.body)
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			assert.Contains(t, w.Body.String(), tt.wantBody)
+			if tt.notWantBody != "" {
+				assert.NotContains(t, w.Body.String(), tt.notWantBody)
+			}
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/IktaS/go-home/internal/app/store"
+	"github.com/IktaS/go-home/internal/pkg/auth"
+)
+
+// HubCodeHandlers is exported handlers for the hub code devices connect with, codes are issued by Issuer
+type HubCodeHandlers struct {
+	Issuer *auth.Issuer
+}
+
+/*
+HubCodeResponse defines the JSON schema of an issued hub code :
+	Code		`code`		: Hub code, only shown once as only its hash is kept
+	SingleUse	`singleUse`	: Whether the first device connecting with the code consumes it
+	ExpiresAt	`expiresAt`	: Time the code expires at, omitted when it never expires
+*/
+type HubCodeResponse struct {
+	Code      string     `json:"code"`
+	SingleUse bool       `json:"singleUse"`
+	ExpiresAt *time.Time `json:"expiresAt,omitempty"`
+}
+
+// HandleRotateHubCode handles issuing a new hub code, the codes issued before stop working
+func (h *HubCodeHandlers) HandleRotateHubCode(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		code, err := h.Issuer.Rotate(repo)
+		if err != nil {
+			http.Error(w, "Error Issuing Hub Code \n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		hubCode, err := repo.GetHubCode(auth.HashCode(code))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		res := &HubCodeResponse{Code: code, SingleUse: hubCode.SingleUse}
+		if !hubCode.ExpiresAt.IsZero() {
+			expiresAt := hubCode.ExpiresAt.UTC()
+			res.ExpiresAt = &expiresAt
+		}
+		writeJSON(w, http.StatusCreated, res)
+	}
+}
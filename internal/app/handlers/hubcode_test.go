@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/auth"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHubCodeHandlers_HandleRotateHubCode(t *testing.T) {
+	repo := newTestStore(t)
+	old, err := auth.NewHubCode(repo, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &HubCodeHandlers{Issuer: &auth.Issuer{TTL: time.Hour, SingleUse: true}}
+	w := httptest.NewRecorder()
+	h.HandleRotateHubCode(repo)(w, httptest.NewRequest("POST", "/hubcode", nil))
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var res HubCodeResponse
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &res))
+	assert.True(t, res.SingleUse)
+	if assert.NotNil(t, res.ExpiresAt) {
+		assert.WithinDuration(t, time.Now().Add(time.Hour), *res.ExpiresAt, time.Minute)
+	}
+
+	ok, err := auth.Authenticate(repo, old)
+	assert.NoError(t, err)
+	assert.False(t, ok, "the codes issued before stop working")
+	ok, err = auth.Authenticate(repo, res.Code)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
@@ -0,0 +1,203 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-serv/pkg/serv"
+)
+
+/*
+DeviceResponse defines the JSON schema of a device in `/device` and `/device/{id}` :
+	ID				`id`				: Device UUID
+	Name			`name`				: Device Name
+	Addr			`addr`				: Address the hub uses to call the device
+	Transport		`transport`			: Transport the hub calls the device through, http or mqtt
+	Services		`services`			: URL of the device services
+	Messages		`messages`			: URL of the device messages
+	Timeout			`timeout`			: Call timeout of the device, left out when the hub default is used
+	ServiceTimeouts	`serviceTimeouts`	: Call timeout by service name, left out when there is none
+	Status			`status`			: Whether the hub can reach the device, online, offline or unknown
+	LastSeen		`lastSeen`			: Last time the device was reached, null if it never was
+*/
+type DeviceResponse struct {
+	ID              string            `json:"id"`
+	Name            string            `json:"name"`
+	Addr            string            `json:"addr"`
+	Transport       string            `json:"transport"`
+	Services        string            `json:"services"`
+	Messages        string            `json:"messages"`
+	Timeout         string            `json:"timeout,omitempty"`
+	ServiceTimeouts map[string]string `json:"serviceTimeouts,omitempty"`
+	Status          string            `json:"status"`
+	LastSeen        *time.Time        `json:"lastSeen"`
+}
+
+/*
+ServiceResponse defines the JSON schema of a service in `/device/{id}/service` :
+	Name		`name`		: Service Name
+	Inbound		`inbound`	: Whether the device calls the hub with this service
+	Outbound	`outbound`	: Whether the hub calls the device with this service
+	Request		`request`	: Parameter types of the service, in order
+	Response	`response`	: Response type of the service, null if the service has no response
+*/
+type ServiceResponse struct {
+	Name     string          `json:"name"`
+	Inbound  bool            `json:"inbound"`
+	Outbound bool            `json:"outbound"`
+	Request  []*TypeResponse `json:"request"`
+	Response *TypeResponse   `json:"response"`
+}
+
+/*
+MessageResponse defines the JSON schema of a message in `/device/{id}/message` :
+	Name		`name`			: Message Name
+	Definitions	`definitions`	: Fields of the message, in order
+*/
+type MessageResponse struct {
+	Name        string           `json:"name"`
+	Definitions []*FieldResponse `json:"definitions"`
+}
+
+/*
+FieldResponse defines the JSON schema of a message field :
+	Name		`name`			: Field Name
+	IsOptional	`isOptional`	: Whether the field may be left out
+	Value		`value`			: Type of the field
+*/
+type FieldResponse struct {
+	Name       string        `json:"name"`
+	IsOptional bool          `json:"isOptional"`
+	Value      *TypeResponse `json:"value"`
+}
+
+/*
+TypeResponse defines the JSON schema of a .serv type :
+	IsScalar	`isScalar`	: Whether the type is a scalar, otherwise it references a message
+	Value		`value`		: Scalar name or referenced message name
+*/
+type TypeResponse struct {
+	IsScalar bool   `json:"isScalar"`
+	Value    string `json:"value"`
+}
+
+/*
+ViolationsResponse defines the JSON schema of a `422 Unprocessable Entity` service call :
+	Service		`service`		: Service Name
+	Violations	`violations`	: Every parameter that does not match the service request
+*/
+type ViolationsResponse struct {
+	Service    string              `json:"service"`
+	Violations []*device.Violation `json:"violations"`
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// NewDeviceResponse makes the response of a device
+func NewDeviceResponse(d *device.Device) *DeviceResponse {
+	res := &DeviceResponse{
+		ID:        d.ID.String(),
+		Name:      d.Name,
+		Addr:      d.Addr.String(),
+		Transport: d.TransportName(),
+		Services:  fmt.Sprintf("%v/device/%v/service", os.Getenv("APP_URL"), d.ID.String()),
+		Messages:  fmt.Sprintf("%v/device/%v/message", os.Getenv("APP_URL"), d.ID.String()),
+		Status:    d.Status.String(),
+	}
+	if !d.LastSeen.IsZero() {
+		lastSeen := d.LastSeen.UTC()
+		res.LastSeen = &lastSeen
+	}
+	if d.Timeout > 0 {
+		res.Timeout = d.Timeout.String()
+	}
+	if len(d.ServiceTimeouts) > 0 {
+		res.ServiceTimeouts = make(map[string]string, len(d.ServiceTimeouts))
+		for service, timeout := range d.ServiceTimeouts {
+			res.ServiceTimeouts[service] = timeout.String()
+		}
+	}
+	return res
+}
+
+// NewServiceResponses makes the response of a list of service
+func NewServiceResponses(services []*serv.Service) []*ServiceResponse {
+	res := make([]*ServiceResponse, 0, len(services))
+	for _, s := range services {
+		if s == nil {
+			continue
+		}
+		res = append(res, NewServiceResponse(s))
+	}
+	return res
+}
+
+// NewServiceResponse makes the response of a service
+func NewServiceResponse(s *serv.Service) *ServiceResponse {
+	request := make([]*TypeResponse, 0, len(s.Request))
+	for _, t := range s.Request {
+		if t == nil {
+			continue
+		}
+		request = append(request, NewTypeResponse(t))
+	}
+	return &ServiceResponse{
+		Name:     s.Name,
+		Inbound:  s.Inbound,
+		Outbound: s.Outbound,
+		Request:  request,
+		Response: NewTypeResponse(s.Response),
+	}
+}
+
+// NewMessageResponses makes the response of a list of message
+func NewMessageResponses(messages []*serv.Message) []*MessageResponse {
+	res := make([]*MessageResponse, 0, len(messages))
+	for _, m := range messages {
+		if m == nil {
+			continue
+		}
+		res = append(res, NewMessageResponse(m))
+	}
+	return res
+}
+
+// NewMessageResponse makes the response of a message, definitions that are not a field are left out
+func NewMessageResponse(m *serv.Message) *MessageResponse {
+	defs := make([]*FieldResponse, 0, len(m.Definitions))
+	for _, md := range m.Definitions {
+		if md == nil || md.Field == nil {
+			continue
+		}
+		f := md.Field
+		defs = append(defs, &FieldResponse{
+			Name:       f.Name,
+			IsOptional: f.Optional && !f.Required,
+			Value:      NewTypeResponse(f.Type),
+		})
+	}
+	return &MessageResponse{
+		Name:        m.Name,
+		Definitions: defs,
+	}
+}
+
+// NewTypeResponse makes the response of a type, returns nil for a nil type
+func NewTypeResponse(t *serv.Type) *TypeResponse {
+	if t == nil {
+		return nil
+	}
+	if t.Reference == "" {
+		return &TypeResponse{IsScalar: true, Value: t.Scalar.String()}
+	}
+	return &TypeResponse{IsScalar: false, Value: t.Reference}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResponses(t *testing.T) {
+	os.Setenv("APP_URL", "localhost:5575")
+	addr := &net.TCPAddr{
+		IP:   net.IPv4(127, 0, 0, 1),
+		Port: 80,
+	}
+	dev, err := device.NewDevice(`Living "Room"`, addr, []byte(`
+		message TestMessage{string TestString; optional int32 Level;};
+		def outbound click(TestMessage, bool):string;
+		def inbound pressed();
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := *dev
+	seen.Timeout = 2 * time.Second
+	seen.ServiceTimeouts = map[string]time.Duration{"click": 500 * time.Millisecond}
+	seen.MarkSeen(time.Unix(1700000000, 0))
+
+	tests := []struct {
+		name     string
+		input    interface{}
+		expected string
+	}{
+		{
+			name:  "Device",
+			input: NewDeviceResponse(dev),
+			expected: `{"id":"` + dev.ID.String() + `","name":"Living \"Room\"","addr":"127.0.0.1:80","transport":"http",` +
+				`"services":"localhost:5575/device/` + dev.ID.String() + `/service",` +
+				`"messages":"localhost:5575/device/` + dev.ID.String() + `/message",` +
+				`"status":"unknown","lastSeen":null}`,
+		},
+		{
+			name:  "Device seen",
+			input: NewDeviceResponse(&seen),
+			expected: `{"id":"` + seen.ID.String() + `","name":"Living \"Room\"","addr":"127.0.0.1:80","transport":"http",` +
+				`"services":"localhost:5575/device/` + seen.ID.String() + `/service",` +
+				`"messages":"localhost:5575/device/` + seen.ID.String() + `/message",` +
+				`"timeout":"2s","serviceTimeouts":{"click":"500ms"},` +
+				`"status":"online","lastSeen":"2023-11-14T22:13:20Z"}`,
+		},
+		{
+			name:  "Services",
+			input: NewServiceResponses(dev.Services),
+			expected: `[{"name":"click","inbound":false,"outbound":true,` +
+				`"request":[{"isScalar":false,"value":"TestMessage"},{"isScalar":true,"value":"bool"}],` +
+				`"response":{"isScalar":true,"value":"string"}},` +
+				`{"name":"pressed","inbound":true,"outbound":false,"request":[],"response":null}]`,
+		},
+		{
+			name:  "Messages",
+			input: NewMessageResponses(dev.Messages),
+			expected: `[{"name":"TestMessage","definitions":[` +
+				`{"name":"TestString","isOptional":false,"value":{"isScalar":true,"value":"string"}},` +
+				`{"name":"Level","isOptional":true,"value":{"isScalar":true,"value":"int32"}}]}]`,
+		},
+		{
+			name:     "No messages",
+			input:    NewMessageResponses(nil),
+			expected: `[]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			writeJSON(w, 200, tt.input)
+			assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+			assert.True(t, json.Valid(w.Body.Bytes()))
+			assert.JSONEq(t, tt.expected, w.Body.String())
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/IktaS/go-home/internal/app/store"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/rule"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// RuleHandlers is exported handlers for automation rules, rules are written through Rules when it is set,
+// e.g. a rule.Cache the rule engine reads, or else through the repo of the handler
+type RuleHandlers struct {
+	Rules rule.Repo
+}
+
+func (h *RuleHandlers) rules(repo store.Repo) rule.Repo {
+	if h.Rules == nil {
+		return repo
+	}
+	return h.Rules
+}
+
+// HandleGetAllRule handles getting every rule
+func (*RuleHandlers) HandleGetAllRule(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rules, err := repo.GetRules()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, rules)
+	}
+}
+
+// HandleGetRule handles getting a rule
+func (*RuleHandlers) HandleGetRule(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, ok := vars["id"]
+		if !ok {
+			http.Error(w, "No id", http.StatusBadRequest)
+			return
+		}
+		ru, err := repo.GetRule(id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Rule Not Found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, ru)
+	}
+}
+
+// readRule reads a rule from a request body and validates it against the devices it uses, it answers the request on error
+func readRule(w http.ResponseWriter, r *http.Request, repo store.Repo) (*rule.Rule, bool) {
+	raw, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	var ru rule.Rule
+	// numbers are kept as json.Number to be checked against the field types
+	err = device.DecodeJSON(raw, &ru)
+	if err != nil {
+		http.Error(w, "Invalid Rule \n"+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	err = ru.Validate(repo)
+	if err != nil {
+		if errors.Is(err, rule.ErrInvalid) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return &ru, true
+}
+
+// HandleCreateRule handles creating a rule
+func (h *RuleHandlers) HandleCreateRule(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ru, ok := readRule(w, r, repo)
+		if !ok {
+			return
+		}
+		ru.ID = uuid.New()
+		err := h.rules(repo).SaveRule(ru)
+		if err != nil {
+			http.Error(w, "Error Saving Rule \n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, ru)
+	}
+}
+
+// HandleUpdateRule handles replacing a rule
+func (h *RuleHandlers) HandleUpdateRule(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, ok := vars["id"]
+		if !ok {
+			http.Error(w, "No id", http.StatusBadRequest)
+			return
+		}
+		old, err := repo.GetRule(id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Rule Not Found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		ru, ok := readRule(w, r, repo)
+		if !ok {
+			return
+		}
+		ru.ID = old.ID
+		err = h.rules(repo).SaveRule(ru)
+		if err != nil {
+			http.Error(w, "Error Saving Rule \n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, ru)
+	}
+}
+
+// HandleDeleteRule handles deleting a rule
+func (h *RuleHandlers) HandleDeleteRule(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, ok := vars["id"]
+		if !ok {
+			http.Error(w, "No id", http.StatusBadRequest)
+			return
+		}
+		err := h.rules(repo).DeleteRule(id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Rule Not Found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
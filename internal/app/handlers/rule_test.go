@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/IktaS/go-home/internal/pkg/rule"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRuleHandlers(t *testing.T) {
+	repo := newTestStore(t)
+	dev := newTestDevice(t, repo)
+	h := &RuleHandlers{}
+	r := mux.NewRouter()
+	r.HandleFunc("/rule/", h.HandleGetAllRule(repo)).Methods("GET")
+	r.HandleFunc("/rule/", h.HandleCreateRule(repo)).Methods("POST")
+	r.HandleFunc("/rule/{id}", h.HandleGetRule(repo)).Methods("GET")
+	r.HandleFunc("/rule/{id}", h.HandleUpdateRule(repo)).Methods("PUT")
+	r.HandleFunc("/rule/{id}", h.HandleDeleteRule(repo)).Methods("DELETE")
+	do := func(method string, url string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return w
+	}
+
+	valid := `{"name":"click","trigger":{"type":"schedule","every":"1h"},"actions":[{"device":"` + dev.ID.String() + `","service":"click"}]}`
+	w := do("POST", "/rule/", valid)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created rule.Rule
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "click", created.Name)
+	id := created.ID.String()
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Get",
+			method:     "GET",
+			url:        "/rule/" + id,
+			wantStatus: http.StatusOK,
+			wantBody:   `"every":"1h"`,
+		},
+		{
+			name:       "Get all",
+			method:     "GET",
+			url:        "/rule/",
+			wantStatus: http.StatusOK,
+			wantBody:   `"id":"` + id + `"`,
+		},
+		{
+			name:       "Create invalid rule",
+			method:     "POST",
+			url:        "/rule/",
+			body:       `{"name":"click","trigger":{"type":"schedule","every":"1h"},"actions":[{"device":"` + dev.ID.String() + `","service":"unknown"}]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Create malformed rule",
+			method:     "POST",
+			url:        "/rule/",
+			body:       `{"name":`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Update",
+			method:     "PUT",
+			url:        "/rule/" + id,
+			body:       `{"name":"clicker","disabled":true,"trigger":{"type":"schedule","at":"08:00"},"actions":[{"device":"` + dev.ID.String() + `","service":"click"}]}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `"name":"clicker"`,
+		},
+		{
+			name:       "Update unknown rule",
+			method:     "PUT",
+			url:        "/rule/unknown",
+			body:       valid,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Delete",
+			method:     "DELETE",
+			url:        "/rule/" + id,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "Get deleted rule",
+			method:     "GET",
+			url:        "/rule/" + id,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.method, tt.url, tt.body)
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			assert.Contains(t, w.Body.String(), tt.wantBody)
+		})
+	}
+}
@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/IktaS/go-home/internal/app/store"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/scene"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// SceneHandlers is exported handlers for scenes, devices are called with CallOptions, or device.DefaultCallOptions if nil
+type SceneHandlers struct {
+	CallOptions *device.CallOptions
+}
+
+func (h *SceneHandlers) callOptions() device.CallOptions {
+	if h.CallOptions == nil {
+		return device.DefaultCallOptions
+	}
+	return *h.CallOptions
+}
+
+/*
+ActivateResponse defines the JSON schema of an activated scene :
+	Scene	`scene`	: Scene UUID
+	Mode	`mode`	: Mode the scene was activated with
+	OK		`ok`	: Whether every step succeeded
+	Steps	`steps`	: Result of every step, in the scene order
+*/
+type ActivateResponse struct {
+	Scene uuid.UUID           `json:"scene"`
+	Mode  scene.Mode          `json:"mode"`
+	OK    bool                `json:"ok"`
+	Steps []*scene.StepResult `json:"steps"`
+}
+
+// HandleGetAllScene handles getting every scene
+func (*SceneHandlers) HandleGetAllScene(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		scenes, err := repo.GetScenes()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, scenes)
+	}
+}
+
+// getScene gets the scene of the request, it answers the request on error
+func getScene(w http.ResponseWriter, r *http.Request, repo store.Repo) (*scene.Scene, bool) {
+	vars := mux.Vars(r)
+	id, ok := vars["id"]
+	if !ok {
+		http.Error(w, "No id", http.StatusBadRequest)
+		return nil, false
+	}
+	s, err := repo.GetScene(id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "Scene Not Found", http.StatusNotFound)
+			return nil, false
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return s, true
+}
+
+// HandleGetScene handles getting a scene
+func (*SceneHandlers) HandleGetScene(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := getScene(w, r, repo)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, s)
+	}
+}
+
+// readScene reads a scene from a request body and validates it against the devices it calls, it answers the request on error
+func readScene(w http.ResponseWriter, r *http.Request, repo store.Repo) (*scene.Scene, bool) {
+	var s scene.Scene
+	err := json.NewDecoder(r.Body).Decode(&s)
+	if err != nil {
+		http.Error(w, "Invalid Scene \n"+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	err = s.Validate(repo)
+	if err != nil {
+		if errors.Is(err, scene.ErrInvalid) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil, false
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return &s, true
+}
+
+// HandleCreateScene handles creating a scene
+func (*SceneHandlers) HandleCreateScene(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := readScene(w, r, repo)
+		if !ok {
+			return
+		}
+		s.ID = uuid.New()
+		err := repo.SaveScene(s)
+		if err != nil {
+			http.Error(w, "Error Saving Scene \n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, s)
+	}
+}
+
+// HandleUpdateScene handles replacing a scene
+func (*SceneHandlers) HandleUpdateScene(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		old, ok := getScene(w, r, repo)
+		if !ok {
+			return
+		}
+		s, ok := readScene(w, r, repo)
+		if !ok {
+			return
+		}
+		s.ID = old.ID
+		err := repo.SaveScene(s)
+		if err != nil {
+			http.Error(w, "Error Saving Scene \n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, s)
+	}
+}
+
+// HandleDeleteScene handles deleting a scene
+func (*SceneHandlers) HandleDeleteScene(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, ok := vars["id"]
+		if !ok {
+			http.Error(w, "No id", http.StatusBadRequest)
+			return
+		}
+		err := repo.DeleteScene(id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Scene Not Found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleActivateScene handles calling every step of a scene, the mode query parameter overrides the scene mode,
+// it is answered with 502 when a step failed
+func (h *SceneHandlers) HandleActivateScene(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := getScene(w, r, repo)
+		if !ok {
+			return
+		}
+		if mode := r.URL.Query().Get("mode"); mode != "" {
+			s.Mode = scene.Mode(mode)
+			if s.Mode != scene.ModeBestEffort && s.Mode != scene.ModeStopOnFailure {
+				http.Error(w, "Unknown mode "+mode, http.StatusBadRequest)
+				return
+			}
+		}
+		if len(s.Steps) == 0 {
+			http.Error(w, "Scene "+s.Name+" has no steps left, their devices were deleted", http.StatusConflict)
+			return
+		}
+		results := s.Activate(r.Context(), repo, h.callOptions())
+		res := &ActivateResponse{
+			Scene: s.ID,
+			Mode:  s.Mode,
+			OK:    scene.Succeeded(results),
+			Steps: results,
+		}
+		if !res.OK {
+			writeJSON(w, http.StatusBadGateway, res)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	}
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/action"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/scene"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSceneHandlers(t *testing.T) {
+	deviceServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer deviceServer.Close()
+	deviceAddr, err := net.ResolveTCPAddr("tcp", deviceServer.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := newTestStore(t)
+	dev, err := device.NewDevice("Device1", deviceAddr, []byte(`def outbound click(); def outbound fail();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, repo.Save(dev))
+
+	h := &SceneHandlers{CallOptions: &device.CallOptions{Timeout: time.Second}}
+	r := mux.NewRouter()
+	r.HandleFunc("/scene/", h.HandleGetAllScene(repo)).Methods("GET")
+	r.HandleFunc("/scene/", h.HandleCreateScene(repo)).Methods("POST")
+	r.HandleFunc("/scene/{id}", h.HandleGetScene(repo)).Methods("GET")
+	r.HandleFunc("/scene/{id}", h.HandleUpdateScene(repo)).Methods("PUT")
+	r.HandleFunc("/scene/{id}", h.HandleDeleteScene(repo)).Methods("DELETE")
+	r.HandleFunc("/scene/{id}/activate", h.HandleActivateScene(repo)).Methods("POST")
+	do := func(method string, url string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return w
+	}
+
+	click := `{"device":"` + dev.ID.String() + `","service":"click"}`
+	fail := `{"device":"` + dev.ID.String() + `","service":"fail"}`
+	w := do("POST", "/scene/", `{"name":"movie","steps":[`+click+`,`+click+`]}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created scene.Scene
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, scene.ModeBestEffort, created.Mode)
+	id := created.ID.String()
+	empty := &scene.Scene{ID: uuid.New(), Name: "reading", Mode: scene.ModeBestEffort, Steps: []*action.Action{}}
+	assert.NoError(t, repo.SaveScene(empty))
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Get",
+			method:     "GET",
+			url:        "/scene/" + id,
+			wantStatus: http.StatusOK,
+			wantBody:   `"mode":"best-effort"`,
+		},
+		{
+			name:       "Get all",
+			method:     "GET",
+			url:        "/scene/",
+			wantStatus: http.StatusOK,
+			wantBody:   `"id":"` + id + `"`,
+		},
+		{
+			name:       "Activate",
+			method:     "POST",
+			url:        "/scene/" + id + "/activate",
+			wantStatus: http.StatusOK,
+			wantBody:   `"ok":true`,
+		},
+		{
+			name:       "Activate without steps",
+			method:     "POST",
+			url:        "/scene/" + empty.ID.String() + "/activate",
+			wantStatus: http.StatusConflict,
+			wantBody:   "no steps left",
+		},
+		{
+			name:       "Create with unknown service",
+			method:     "POST",
+			url:        "/scene/",
+			body:       `{"name":"movie","steps":[{"device":"` + dev.ID.String() + `","service":"unknown"}]}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Update",
+			method:     "PUT",
+			url:        "/scene/" + id,
+			body:       `{"name":"movie","mode":"stop-on-failure","steps":[` + click + `,` + fail + `]}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `"mode":"stop-on-failure"`,
+		},
+		{
+			name:       "Activate with a failed step",
+			method:     "POST",
+			url:        "/scene/" + id + "/activate?mode=best-effort",
+			wantStatus: http.StatusBadGateway,
+			wantBody:   `{"step":0,"device":"` + dev.ID.String() + `","service":"click","ok":true}`,
+		},
+		{
+			name:       "Activate with an unknown mode",
+			method:     "POST",
+			url:        "/scene/" + id + "/activate?mode=sometimes",
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Delete",
+			method:     "DELETE",
+			url:        "/scene/" + id,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "Activate deleted scene",
+			method:     "POST",
+			url:        "/scene/" + id + "/activate",
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.method, tt.url, tt.body)
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			assert.Contains(t, w.Body.String(), tt.wantBody)
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/IktaS/go-home/internal/app/store"
+	"github.com/IktaS/go-home/internal/pkg/schedule"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// defaultNextCount is how many run times a dry run gives when no count is asked
+const defaultNextCount = 5
+
+// maxNextCount bounds how many run times a dry run gives
+const maxNextCount = 100
+
+// ScheduleHandlers is exported handlers for scheduled service calls
+type ScheduleHandlers struct{}
+
+/*
+NextResponse defines the JSON schema of a schedule dry run :
+	Next	`next`	: Next times the schedule runs, empty when it never runs again
+*/
+type NextResponse struct {
+	Next []time.Time `json:"next"`
+}
+
+// HandleGetAllSchedule handles getting every schedule
+func (*ScheduleHandlers) HandleGetAllSchedule(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		schedules, err := repo.GetSchedules()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, schedules)
+	}
+}
+
+// HandleGetSchedule handles getting a schedule
+func (*ScheduleHandlers) HandleGetSchedule(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, ok := vars["id"]
+		if !ok {
+			http.Error(w, "No id", http.StatusBadRequest)
+			return
+		}
+		s, err := repo.GetSchedule(id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Schedule Not Found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, s)
+	}
+}
+
+// writeScheduleError answers a schedule that cannot be saved, 400 when it is invalid
+func writeScheduleError(w http.ResponseWriter, err error) {
+	if errors.Is(err, schedule.ErrInvalid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// readSchedule reads a schedule from a request body and validates it against the device it calls, it answers the request on error,
+// old is the schedule it replaces, nil for a new schedule
+func readSchedule(w http.ResponseWriter, r *http.Request, repo store.Repo, old *schedule.Schedule) (*schedule.Schedule, bool) {
+	var s schedule.Schedule
+	err := json.NewDecoder(r.Body).Decode(&s)
+	if err != nil {
+		http.Error(w, "Invalid Schedule \n"+err.Error(), http.StatusBadRequest)
+		return nil, false
+	}
+	if old == nil {
+		err = s.Validate(repo)
+	} else {
+		err = s.ValidateChange(old, repo)
+	}
+	if err != nil {
+		writeScheduleError(w, err)
+		return nil, false
+	}
+	// the last run is kept by the hub
+	s.LastRun = nil
+	return &s, true
+}
+
+// HandleCreateSchedule handles creating a schedule
+func (*ScheduleHandlers) HandleCreateSchedule(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s, ok := readSchedule(w, r, repo, nil)
+		if !ok {
+			return
+		}
+		s.ID = uuid.New()
+		err := repo.SaveSchedule(s)
+		if err != nil {
+			http.Error(w, "Error Saving Schedule \n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, s)
+	}
+}
+
+// HandleUpdateSchedule handles replacing a schedule, its last run is kept unless its cron or at changed
+func (*ScheduleHandlers) HandleUpdateSchedule(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, ok := vars["id"]
+		if !ok {
+			http.Error(w, "No id", http.StatusBadRequest)
+			return
+		}
+		old, err := repo.GetSchedule(id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Schedule Not Found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		s, ok := readSchedule(w, r, repo, old)
+		if !ok {
+			return
+		}
+		s.ID = old.ID
+		if s.SameTiming(old) {
+			s.LastRun = old.LastRun
+		}
+		err = repo.SaveSchedule(s)
+		if err != nil {
+			http.Error(w, "Error Saving Schedule \n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, s)
+	}
+}
+
+// HandleDeleteSchedule handles deleting a schedule
+func (*ScheduleHandlers) HandleDeleteSchedule(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id, ok := vars["id"]
+		if !ok {
+			http.Error(w, "No id", http.StatusBadRequest)
+			return
+		}
+		err := repo.DeleteSchedule(id)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "Schedule Not Found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// HandleDryRunSchedule handles getting the next times a schedule would run without saving it,
+// only its cron or at is needed, count asks for more or less than 5 times
+func (*ScheduleHandlers) HandleDryRunSchedule() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		count := defaultNextCount
+		if c := r.URL.Query().Get("count"); c != "" {
+			var err error
+			count, err = strconv.Atoi(c)
+			if err != nil || count <= 0 || count > maxNextCount {
+				http.Error(w, fmt.Sprintf("count must be between 1 and %v", maxNextCount), http.StatusBadRequest)
+				return
+			}
+		}
+		var s schedule.Schedule
+		err := json.NewDecoder(r.Body).Decode(&s)
+		if err != nil {
+			http.Error(w, "Invalid Schedule \n"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		err = s.ValidateTiming()
+		if err != nil {
+			writeScheduleError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, &NextResponse{Next: s.Next(time.Now(), count)})
+	}
+}
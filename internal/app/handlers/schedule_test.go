@@ -0,0 +1,179 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/action"
+	"github.com/IktaS/go-home/internal/pkg/schedule"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScheduleHandlers(t *testing.T) {
+	repo := newTestStore(t)
+	dev := newTestDevice(t, repo)
+	h := &ScheduleHandlers{}
+	r := mux.NewRouter()
+	r.HandleFunc("/schedule/", h.HandleGetAllSchedule(repo)).Methods("GET")
+	r.HandleFunc("/schedule/", h.HandleCreateSchedule(repo)).Methods("POST")
+	r.HandleFunc("/schedule/dry-run", h.HandleDryRunSchedule()).Methods("POST")
+	r.HandleFunc("/schedule/{id}", h.HandleGetSchedule(repo)).Methods("GET")
+	r.HandleFunc("/schedule/{id}", h.HandleUpdateSchedule(repo)).Methods("PUT")
+	r.HandleFunc("/schedule/{id}", h.HandleDeleteSchedule(repo)).Methods("DELETE")
+	do := func(method string, url string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return w
+	}
+
+	valid := `{"name":"click","cron":"0 23 * * *","device":"` + dev.ID.String() + `","service":"click"}`
+	w := do("POST", "/schedule/", valid)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var created schedule.Schedule
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, "click", created.Name)
+	id := created.ID.String()
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Get",
+			method:     "GET",
+			url:        "/schedule/" + id,
+			wantStatus: http.StatusOK,
+			wantBody:   `"cron":"0 23 * * *"`,
+		},
+		{
+			name:       "Get all",
+			method:     "GET",
+			url:        "/schedule/",
+			wantStatus: http.StatusOK,
+			wantBody:   `"id":"` + id + `"`,
+		},
+		{
+			name:       "Create with both cron and at",
+			method:     "POST",
+			url:        "/schedule/",
+			body:       `{"name":"click","cron":"0 23 * * *","at":"2030-01-01T08:00:00Z","device":"` + dev.ID.String() + `","service":"click"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Create with unknown service",
+			method:     "POST",
+			url:        "/schedule/",
+			body:       `{"name":"click","cron":"0 23 * * *","device":"` + dev.ID.String() + `","service":"unknown"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Update",
+			method:     "PUT",
+			url:        "/schedule/" + id,
+			body:       `{"name":"once","at":"2030-01-01T08:00:00Z","device":"` + dev.ID.String() + `","service":"click"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `"at":"2030-01-01T08:00:00Z"`,
+		},
+		{
+			name:       "Update unknown schedule",
+			method:     "PUT",
+			url:        "/schedule/unknown",
+			body:       valid,
+			wantStatus: http.StatusNotFound,
+		},
+		{
+			name:       "Dry run",
+			method:     "POST",
+			url:        "/schedule/dry-run?count=2",
+			body:       `{"cron":"@yearly"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `-01-01T00:00:00`,
+		},
+		{
+			name:       "Dry run of a past time",
+			method:     "POST",
+			url:        "/schedule/dry-run",
+			body:       `{"at":"2000-01-01T08:00:00Z"}`,
+			wantStatus: http.StatusBadRequest,
+			wantBody:   `has passed`,
+		},
+		{
+			name:       "Dry run of an invalid cron",
+			method:     "POST",
+			url:        "/schedule/dry-run",
+			body:       `{"cron":"every day"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Dry run with an invalid count",
+			method:     "POST",
+			url:        "/schedule/dry-run?count=0",
+			body:       `{"cron":"@yearly"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Delete",
+			method:     "DELETE",
+			url:        "/schedule/" + id,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "Get deleted schedule",
+			method:     "GET",
+			url:        "/schedule/" + id,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.method, tt.url, tt.body)
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			assert.Contains(t, w.Body.String(), tt.wantBody)
+		})
+	}
+}
+
+func TestScheduleHandlers_HandleUpdateSchedule(t *testing.T) {
+	repo := newTestStore(t)
+	dev := newTestDevice(t, repo)
+	h := &ScheduleHandlers{}
+	r := mux.NewRouter()
+	r.HandleFunc("/schedule/{id}", h.HandleUpdateSchedule(repo)).Methods("PUT")
+	ran := time.Date(2021, 1, 1, 8, 0, 0, 0, time.UTC)
+	once := &schedule.Schedule{ID: uuid.New(), Name: "once", At: &ran, Action: action.Action{Device: dev.ID.String(), Service: "click"}}
+	assert.NoError(t, repo.SaveSchedule(once))
+	assert.NoError(t, repo.SetScheduleResult(once.ID.String(), &schedule.Result{At: ran, OK: true}))
+	update := func(body string) (*httptest.ResponseRecorder, *schedule.Schedule) {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("PUT", "/schedule/"+once.ID.String(), strings.NewReader(body)))
+		var s schedule.Schedule
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &s))
+		}
+		return w, &s
+	}
+
+	w, s := update(`{"name":"ran","disabled":true,"at":"2021-01-01T08:00:00Z","device":"` + dev.ID.String() + `","service":"click"}`)
+	assert.Equal(t, http.StatusOK, w.Code, "a schedule whose at passed can be changed, "+w.Body.String())
+	assert.Equal(t, "ran", s.Name)
+	if assert.NotNil(t, s.LastRun, "the last run is kept when the timing did not change") {
+		assert.True(t, s.LastRun.At.Equal(ran))
+	}
+
+	w, _ = update(`{"name":"ran","at":"2021-01-02T08:00:00Z","device":"` + dev.ID.String() + `","service":"click"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code, "a new at time that has passed is refused")
+
+	w, s = update(`{"name":"nightly","cron":"0 23 * * *","device":"` + dev.ID.String() + `","service":"click"}`)
+	assert.Equal(t, http.StatusOK, w.Code, w.Body.String())
+	assert.Nil(t, s.LastRun, "the last run is reset when the timing changed")
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/bus"
+	"github.com/IktaS/go-home/internal/pkg/logging"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+)
+
+// defaultKeepAlive is how often an idle stream is pinged when StreamHandlers.KeepAlive is not set
+const defaultKeepAlive = 15 * time.Second
+
+// webSocketWriteTimeout bounds how long writing a WebSocket frame may take
+const webSocketWriteTimeout = 10 * time.Second
+
+// maxCloseReason is the longest reason a WebSocket close frame can carry
+const maxCloseReason = 123
+
+// maxSubscribeSize bounds the size of a WebSocket subscribe message
+const maxSubscribeSize = 4096
+
+// StreamHandlers is exported handlers for streaming the messages of Bus to clients
+type StreamHandlers struct {
+	Bus       *bus.Bus
+	KeepAlive time.Duration
+}
+
+func (h *StreamHandlers) keepAlive() time.Duration {
+	if h.KeepAlive <= 0 {
+		return defaultKeepAlive
+	}
+	return h.KeepAlive
+}
+
+/*
+subscribeRequest defines the JSON schema of a WebSocket subscribe message, it replaces the filter of the stream :
+	Devices	`devices`	: UUIDs of the devices to receive messages of, empty for every device
+	Types	`types`		: Types of messages to receive, empty for every type
+*/
+type subscribeRequest struct {
+	Devices []string `json:"devices"`
+	Types   []string `json:"types"`
+}
+
+// newFilter makes a bus filter of device UUIDs and message type names
+func newFilter(devices []string, types []string) (bus.Filter, error) {
+	var f bus.Filter
+	for _, d := range devices {
+		id, err := uuid.Parse(d)
+		if err != nil {
+			return bus.Filter{}, fmt.Errorf("%v is not a device UUID", d)
+		}
+		f.Devices = append(f.Devices, id.String())
+	}
+	for _, t := range types {
+		typ, err := bus.ParseType(t)
+		if err != nil {
+			return bus.Filter{}, err
+		}
+		f.Types = append(f.Types, typ)
+	}
+	return f, nil
+}
+
+// splitValues splits query values that may be repeated or comma separated
+func splitValues(values []string) []string {
+	var res []string
+	for _, v := range values {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				res = append(res, s)
+			}
+		}
+	}
+	return res
+}
+
+// parseFilter parses the device and type query parameters of a stream request
+func parseFilter(values url.Values) (bus.Filter, error) {
+	return newFilter(splitValues(values["device"]), splitValues(values["type"]))
+}
+
+// HandleSSE handles streaming the messages of the bus as Server-Sent Events, the event name is the message type
+func (h *StreamHandlers) HandleSSE() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			http.Error(w, "Streaming Not Supported", http.StatusInternalServerError)
+			return
+		}
+		filter, err := parseFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, "Invalid Filter \n"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		sub := h.Bus.Subscribe(filter)
+		defer sub.Close()
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		flusher.Flush()
+		ticker := time.NewTicker(h.keepAlive())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.Context().Done():
+				return
+			case m, ok := <-sub.C():
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(m)
+				if err != nil {
+					logging.Errorf("Cannot encode message : %v\n", err)
+					continue
+				}
+				fmt.Fprintf(w, "event: %v\ndata: %s\n\n", m.Type, data)
+			case <-ticker.C:
+				// a comment keeps proxies from closing an idle stream
+				fmt.Fprint(w, ": keep-alive\n\n")
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// errInvalidSubscribe is the error of a subscribe message that cannot be applied
+var errInvalidSubscribe = errors.New("invalid subscribe message")
+
+// readSubscribes applies the subscribe messages of a WebSocket client to its subscription until reading fails
+func readSubscribes(conn *websocket.Conn, sub *bus.Subscription, keepAlive time.Duration) error {
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return err
+		}
+		conn.SetReadDeadline(time.Now().Add(2 * keepAlive))
+		var req subscribeRequest
+		err = json.Unmarshal(data, &req)
+		if err != nil {
+			return fmt.Errorf("%w : %v", errInvalidSubscribe, err)
+		}
+		filter, err := newFilter(req.Devices, req.Types)
+		if err != nil {
+			return fmt.Errorf("%w : %v", errInvalidSubscribe, err)
+		}
+		sub.SetFilter(filter)
+	}
+}
+
+// HandleWebSocket handles streaming the messages of the bus as JSON over a WebSocket,
+// the client may send subscribe messages to replace the filter given in the query
+func (h *StreamHandlers) HandleWebSocket() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, err := parseFilter(r.URL.Query())
+		if err != nil {
+			http.Error(w, "Invalid Filter \n"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		sub := h.Bus.Subscribe(filter)
+		defer sub.Close()
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			// the upgrader already answered the client
+			return
+		}
+		defer conn.Close()
+		keepAlive := h.keepAlive()
+		conn.SetReadLimit(maxSubscribeSize)
+		conn.SetReadDeadline(time.Now().Add(2 * keepAlive))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * keepAlive))
+		})
+		readErr := make(chan error, 1)
+		go func() {
+			readErr <- readSubscribes(conn, sub, keepAlive)
+		}()
+		ticker := time.NewTicker(keepAlive)
+		defer ticker.Stop()
+		for {
+			select {
+			case err := <-readErr:
+				if errors.Is(err, errInvalidSubscribe) {
+					reason := err.Error()
+					if len(reason) > maxCloseReason {
+						reason = reason[:maxCloseReason]
+					}
+					msg := websocket.FormatCloseMessage(websocket.CloseUnsupportedData, reason)
+					conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(webSocketWriteTimeout))
+				}
+				return
+			case m, ok := <-sub.C():
+				if !ok {
+					return
+				}
+				conn.SetWriteDeadline(time.Now().Add(webSocketWriteTimeout))
+				err := conn.WriteJSON(m)
+				if err != nil {
+					return
+				}
+			case <-ticker.C:
+				err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(webSocketWriteTimeout))
+				if err != nil {
+					return
+				}
+			}
+		}
+	}
+}
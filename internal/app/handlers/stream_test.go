@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/bus"
+	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_parseFilter(t *testing.T) {
+	id1, id2 := uuid.New(), uuid.New()
+	tests := []struct {
+		name    string
+		query   string
+		want    bus.Filter
+		wantErr bool
+	}{
+		{
+			name:  "No filter",
+			query: "",
+		},
+		{
+			name:  "Repeated and comma separated",
+			query: "device=" + id1.String() + "," + strings.ToUpper(id2.String()) + "&type=device.status&type=device.event",
+			want: bus.Filter{
+				Devices: []string{id1.String(), id2.String()},
+				Types:   []bus.Type{bus.DeviceStatus, bus.DeviceEvent},
+			},
+		},
+		{
+			name:    "Invalid device",
+			query:   "device=door",
+			wantErr: true,
+		},
+		{
+			name:    "Unknown type",
+			query:   "type=device.moved",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := parseFilter(values)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestStreamHandlers_HandleSSE(t *testing.T) {
+	h := &StreamHandlers{Bus: bus.New()}
+	server := httptest.NewServer(h.HandleSSE())
+	defer server.Close()
+	id := uuid.New().String()
+
+	res, err := http.Get(server.URL + "?type=device.status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "text/event-stream", res.Header.Get("Content-Type"))
+
+	h.Bus.Publish(&bus.Message{Type: bus.DeviceEvent, Device: id})
+	h.Bus.Publish(&bus.Message{Type: bus.DeviceStatus, Device: id, Data: map[string]string{"status": "online"}})
+	reader := bufio.NewReader(res.Body)
+	line, err := reader.ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "event: device.status\n", line)
+	line, err = reader.ReadString('\n')
+	assert.NoError(t, err)
+	var m bus.Message
+	assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &m))
+	assert.Equal(t, bus.DeviceStatus, m.Type)
+	assert.Equal(t, id, m.Device)
+
+	res, err = http.Get(server.URL + "?device=door")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestStreamHandlers_HandleWebSocket(t *testing.T) {
+	h := &StreamHandlers{Bus: bus.New()}
+	server := httptest.NewServer(h.HandleWebSocket())
+	defer server.Close()
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	id1, id2 := uuid.New().String(), uuid.New().String()
+
+	conn, _, err := websocket.DefaultDialer.Dial(wsURL+"?device="+id1, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	h.Bus.Publish(&bus.Message{Type: bus.DeviceEvent, Device: id2})
+	h.Bus.Publish(&bus.Message{Type: bus.DeviceEvent, Device: id1})
+	var m bus.Message
+	assert.NoError(t, conn.ReadJSON(&m))
+	assert.Equal(t, id1, m.Device)
+
+	// a subscribe message replaces the filter, it applies once the server read it
+	assert.NoError(t, conn.WriteJSON(map[string][]string{"devices": {id2}, "types": {"device.deleted"}}))
+	received := make(chan bus.Message)
+	go func() {
+		var m bus.Message
+		if conn.ReadJSON(&m) == nil {
+			received <- m
+		}
+		close(received)
+	}()
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	for m = (bus.Message{}); m.Device == ""; {
+		select {
+		case m = <-received:
+		case <-ticker.C:
+			h.Bus.Publish(&bus.Message{Type: bus.DeviceDeleted, Device: id2})
+		}
+	}
+	assert.Equal(t, bus.DeviceDeleted, m.Type)
+	assert.Equal(t, id2, m.Device)
+
+	// an invalid subscribe message closes the stream
+	conn, _, err = websocket.DefaultDialer.Dial(wsURL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	assert.NoError(t, conn.WriteJSON(map[string][]string{"types": {"device.moved"}}))
+	_, _, err = conn.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseUnsupportedData), err)
+
+	_, res, err := websocket.DefaultDialer.Dial(wsURL+"?type=device.moved", nil)
+	assert.Error(t, err)
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
@@ -0,0 +1,234 @@
+package handlers
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/IktaS/go-home/internal/app/store"
+	"github.com/IktaS/go-home/internal/pkg/user"
+	"github.com/gorilla/mux"
+)
+
+// UserHandlers is exported handlers for managing users
+type UserHandlers struct{}
+
+/*
+UserRequest defines the JSON schema of a created or patched user, a patch leaves out what it keeps :
+	Name		`name`		: User name
+	Password	`password`	: User password, at least 8 characters
+	Role		`role`		: viewer, operator or admin
+*/
+type UserRequest struct {
+	Name     *string    `json:"name"`
+	Password *string    `json:"password"`
+	Role     *user.Role `json:"role"`
+}
+
+// writeUserError answers a user that cannot be saved, 400 when it is invalid
+func writeUserError(w http.ResponseWriter, err error) {
+	if errors.Is(err, user.ErrInvalid) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// nameTaken tells whether another user than id has name, it answers the request when it has
+func nameTaken(w http.ResponseWriter, repo store.Repo, name string, id string) bool {
+	other, err := repo.GetUserByName(name)
+	if err == sql.ErrNoRows {
+		return false
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	if other.ID.String() == id {
+		return false
+	}
+	http.Error(w, "User "+name+" already exists", http.StatusConflict)
+	return true
+}
+
+// lastAdmin tells whether u is the only admin, it answers the request when it is
+func lastAdmin(w http.ResponseWriter, repo store.Repo, u *user.User) bool {
+	if u.Role != user.RoleAdmin {
+		return false
+	}
+	users, err := repo.GetUsers()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return true
+	}
+	for _, other := range users {
+		if other.Role == user.RoleAdmin && other.ID != u.ID {
+			return false
+		}
+	}
+	http.Error(w, "The last admin cannot be removed", http.StatusConflict)
+	return true
+}
+
+// getUser gets the user of the request, it answers the request on error
+func getUser(w http.ResponseWriter, r *http.Request, repo store.Repo) (*user.User, bool) {
+	u, err := repo.GetUser(mux.Vars(r)["id"])
+	if err != nil {
+		if err == sql.ErrNoRows {
+			http.Error(w, "User Not Found", http.StatusNotFound)
+			return nil, false
+		}
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return nil, false
+	}
+	return u, true
+}
+
+// HandleGetAllUser handles getting every user
+func (*UserHandlers) HandleGetAllUser(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		users, err := repo.GetUsers()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, users)
+	}
+}
+
+// HandleGetUser handles getting a user
+func (*UserHandlers) HandleGetUser(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := getUser(w, r, repo)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, u)
+	}
+}
+
+// HandleCreateUser handles creating a user, every field is required
+func (*UserHandlers) HandleCreateUser(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req UserRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, "Invalid User \n"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Name == nil || req.Password == nil || req.Role == nil {
+			http.Error(w, "A user has a name, password and role", http.StatusBadRequest)
+			return
+		}
+		u, err := user.New(*req.Name, *req.Password, *req.Role)
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		if nameTaken(w, repo, u.Name, u.ID.String()) {
+			return
+		}
+		err = repo.SaveUser(u)
+		if err != nil {
+			http.Error(w, "Error Saving User \n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, u)
+	}
+}
+
+// HandlePatchUser handles changing the name, password or role of a user, a new password revokes the user sessions
+func (*UserHandlers) HandlePatchUser(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := getUser(w, r, repo)
+		if !ok {
+			return
+		}
+		var req UserRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, "Invalid User \n"+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Name != nil {
+			u.Name = strings.TrimSpace(*req.Name)
+		}
+		if req.Role != nil && *req.Role != u.Role {
+			if lastAdmin(w, repo, u) {
+				return
+			}
+			u.Role = *req.Role
+		}
+		err = u.Validate()
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		if req.Password != nil {
+			u.PasswordHash, err = user.HashPassword(*req.Password)
+			if err != nil {
+				writeUserError(w, err)
+				return
+			}
+		}
+		if nameTaken(w, repo, u.Name, u.ID.String()) {
+			return
+		}
+		err = repo.SaveUser(u)
+		if err != nil {
+			http.Error(w, "Error Saving User \n"+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if req.Password != nil {
+			err = revokeSessions(repo, u)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		writeJSON(w, http.StatusOK, u)
+	}
+}
+
+// revokeSessions deletes the session tokens of a user, its API keys are kept
+func revokeSessions(repo store.Repo, u *user.User) error {
+	tokens, err := repo.GetUserTokens(u.ID.String())
+	if err != nil {
+		return err
+	}
+	for _, t := range tokens {
+		if t.Kind != user.TokenSession {
+			continue
+		}
+		err = repo.DeleteToken(t.Hash)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+	}
+	return nil
+}
+
+// HandleDeleteUser handles deleting a user and its tokens, the last admin cannot be deleted
+func (*UserHandlers) HandleDeleteUser(repo store.Repo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		u, ok := getUser(w, r, repo)
+		if !ok {
+			return
+		}
+		if lastAdmin(w, repo, u) {
+			return
+		}
+		err := repo.DeleteUser(u.ID.String())
+		if err != nil {
+			if err == sql.ErrNoRows {
+				http.Error(w, "User Not Found", http.StatusNotFound)
+				return
+			}
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
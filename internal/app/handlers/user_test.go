@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/IktaS/go-home/internal/pkg/user"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserHandlers(t *testing.T) {
+	repo := newTestStore(t)
+	newTestUser(t, repo, "root", user.RoleAdmin)
+	admin, err := repo.GetUserByName("root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := &UserHandlers{}
+	r := mux.NewRouter()
+	r.HandleFunc("/user/", h.HandleGetAllUser(repo)).Methods("GET")
+	r.HandleFunc("/user/", h.HandleCreateUser(repo)).Methods("POST")
+	r.HandleFunc("/user/{id}", h.HandleGetUser(repo)).Methods("GET")
+	r.HandleFunc("/user/{id}", h.HandlePatchUser(repo)).Methods("PATCH")
+	r.HandleFunc("/user/{id}", h.HandleDeleteUser(repo)).Methods("DELETE")
+	do := func(method string, url string, body string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(method, url, strings.NewReader(body)))
+		return w
+	}
+
+	w := do("POST", "/user/", `{"name":"alice","password":"correct horse","role":"operator"}`)
+	assert.Equal(t, http.StatusCreated, w.Code, w.Body.String())
+	var alice user.User
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &alice))
+	id := alice.ID.String()
+	_, session, _, err := user.Login(repo, "alice", "correct horse", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		method     string
+		url        string
+		body       string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			name:       "Create taken name",
+			method:     "POST",
+			url:        "/user/",
+			body:       `{"name":"alice","password":"correct horse","role":"viewer"}`,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "Create without role",
+			method:     "POST",
+			url:        "/user/",
+			body:       `{"name":"bob","password":"correct horse"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Create with short password",
+			method:     "POST",
+			url:        "/user/",
+			body:       `{"name":"bob","password":"horse","role":"viewer"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Get",
+			method:     "GET",
+			url:        "/user/" + id,
+			wantStatus: http.StatusOK,
+			wantBody:   `"role":"operator"`,
+		},
+		{
+			name:       "Patch role and password",
+			method:     "PATCH",
+			url:        "/user/" + id,
+			body:       `{"role":"viewer","password":"battery staple"}`,
+			wantStatus: http.StatusOK,
+			wantBody:   `"role":"viewer"`,
+		},
+		{
+			name:       "Patch to unknown role",
+			method:     "PATCH",
+			url:        "/user/" + id,
+			body:       `{"role":"root"}`,
+			wantStatus: http.StatusBadRequest,
+		},
+		{
+			name:       "Patch to taken name",
+			method:     "PATCH",
+			url:        "/user/" + id,
+			body:       `{"name":"root"}`,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "Demote last admin",
+			method:     "PATCH",
+			url:        "/user/" + admin.ID.String(),
+			body:       `{"role":"viewer"}`,
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "Delete last admin",
+			method:     "DELETE",
+			url:        "/user/" + admin.ID.String(),
+			wantStatus: http.StatusConflict,
+		},
+		{
+			name:       "Get all",
+			method:     "GET",
+			url:        "/user/",
+			wantStatus: http.StatusOK,
+			wantBody:   `"name":"root"`,
+		},
+		{
+			name:       "Delete",
+			method:     "DELETE",
+			url:        "/user/" + id,
+			wantStatus: http.StatusNoContent,
+		},
+		{
+			name:       "Get deleted user",
+			method:     "GET",
+			url:        "/user/" + id,
+			wantStatus: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := do(tt.method, tt.url, tt.body)
+			assert.Equal(t, tt.wantStatus, w.Code, w.Body.String())
+			assert.Contains(t, w.Body.String(), tt.wantBody)
+			if w.Code < http.StatusBadRequest {
+				assert.NotContains(t, w.Body.String(), "password")
+			}
+			if tt.name == "Patch role and password" {
+				_, err := repo.GetToken(session.Hash)
+				assert.Error(t, err, "a new password revokes the sessions")
+			}
+		})
+	}
+}
@@ -0,0 +1,159 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Dialect defines which SQL dialect a migration is written in
+type Dialect int
+
+const (
+	// SQLite is the dialect used by the sqlite store
+	SQLite Dialect = iota
+	// Postgres is the dialect used by the postgres store
+	Postgres
+)
+
+// Migration defines a single schema version, with the statements needed to reach it for each dialect
+type Migration struct {
+	Version     int
+	Description string
+	SQLite      []string
+	Postgres    []string
+}
+
+// Statements returns the statements of the migration for a dialect
+func (m *Migration) Statements(d Dialect) []string {
+	if d == Postgres {
+		return m.Postgres
+	}
+	return m.SQLite
+}
+
+// Migrator applies migrations to a database
+type Migrator struct {
+	DB         *sql.DB
+	Dialect    Dialect
+	Migrations []*Migration
+}
+
+// NewMigrator makes a new Migrator with every known migration
+func NewMigrator(db *sql.DB, d Dialect) *Migrator {
+	return &Migrator{
+		DB:         db,
+		Dialect:    d,
+		Migrations: All(),
+	}
+}
+
+// All returns every known migration ordered by version
+func All() []*Migration {
+	all := make([]*Migration, len(migrations))
+	copy(all, migrations)
+	sort.Slice(all, func(i, j int) bool {
+		return all[i].Version < all[j].Version
+	})
+	return all
+}
+
+// Latest returns the latest known schema version
+func Latest() int {
+	all := All()
+	if len(all) == 0 {
+		return 0
+	}
+	return all[len(all)-1].Version
+}
+
+func (m *Migrator) placeholder(i int) string {
+	if m.Dialect == Postgres {
+		return fmt.Sprintf("$%d", i)
+	}
+	return "?"
+}
+
+func (m *Migrator) createVersionTable() error {
+	createVersionTableSQL := `CREATE TABLE IF NOT EXISTS schema_version(
+		version INTEGER NOT NULL PRIMARY KEY,
+		description TEXT,
+		applied_at BIGINT
+	);`
+	_, err := m.DB.Exec(createVersionTableSQL)
+	return err
+}
+
+// Current returns the current schema version of the database, 0 means no migration is applied
+func (m *Migrator) Current() (int, error) {
+	err := m.createVersionTable()
+	if err != nil {
+		return 0, err
+	}
+	var version sql.NullInt64
+	err = m.DB.QueryRow("SELECT MAX(version) FROM schema_version").Scan(&version)
+	if err != nil {
+		return 0, err
+	}
+	if !version.Valid {
+		return 0, nil
+	}
+	return int(version.Int64), nil
+}
+
+// Pending returns the migrations that is not yet applied to the database
+func (m *Migrator) Pending() ([]*Migration, error) {
+	current, err := m.Current()
+	if err != nil {
+		return nil, err
+	}
+	var pending []*Migration
+	for _, mig := range m.Migrations {
+		if mig.Version > current {
+			pending = append(pending, mig)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration in order, each migration runs in its own transaction
+func (m *Migrator) Up() ([]*Migration, error) {
+	pending, err := m.Pending()
+	if err != nil {
+		return nil, err
+	}
+	var applied []*Migration
+	for _, mig := range pending {
+		err = m.apply(mig)
+		if err != nil {
+			return applied, fmt.Errorf("migration %d (%v): %v", mig.Version, mig.Description, err)
+		}
+		applied = append(applied, mig)
+	}
+	return applied, nil
+}
+
+func (m *Migrator) apply(mig *Migration) error {
+	ctx := context.Background()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, statement := range mig.Statements(m.Dialect) {
+		_, err = tx.ExecContext(ctx, statement)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	insertVersionSQL := fmt.Sprintf("INSERT INTO schema_version(version, description, applied_at) VALUES(%v,%v,%v);",
+		m.placeholder(1), m.placeholder(2), m.placeholder(3))
+	_, err = tx.ExecContext(ctx, insertVersionSQL, mig.Version, mig.Description, time.Now().Unix())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,102 @@
+package migrations
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/stretchr/testify/assert"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "migrations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func TestAll(t *testing.T) {
+	all := All()
+	for i, m := range all {
+		assert.Equal(t, i+1, m.Version, "versions must be consecutive")
+		assert.NotEmpty(t, m.SQLite, "version %v has no sqlite statement", m.Version)
+		assert.NotEmpty(t, m.Postgres, "version %v has no postgres statement", m.Version)
+	}
+	assert.Equal(t, len(all), Latest())
+}
+
+func TestMigrator_Up(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(t *testing.T, db *sql.DB)
+	}{
+		{
+			name:  "Fresh database",
+			setup: func(t *testing.T, db *sql.DB) {},
+		},
+		{
+			name: "Database made before schema_version",
+			setup: func(t *testing.T, db *sql.DB) {
+				for _, m := range All()[:2] {
+					for _, s := range m.SQLite {
+						_, err := db.Exec(s)
+						if err != nil {
+							t.Fatal(err)
+						}
+					}
+				}
+				_, err := db.Exec(`INSERT INTO devices(id, name, addr) VALUES('id','name','127.0.0.1')`)
+				if err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := openTestDB(t)
+			defer db.Close()
+			tt.setup(t, db)
+			m := NewMigrator(db, SQLite)
+
+			pending, err := m.Pending()
+			assert.NoError(t, err)
+			assert.Len(t, pending, Latest())
+
+			applied, err := m.Up()
+			assert.NoError(t, err)
+			assert.Equal(t, pending, applied)
+
+			current, err := m.Current()
+			assert.NoError(t, err)
+			assert.Equal(t, Latest(), current)
+
+			applied, err = m.Up()
+			assert.NoError(t, err)
+			assert.Empty(t, applied)
+		})
+	}
+}
+
+func TestMigrator_UpFailure(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+	m := NewMigrator(db, SQLite)
+	m.Migrations = []*Migration{
+		{Version: 1, Description: "ok", SQLite: []string{"CREATE TABLE a(id INTEGER);"}},
+		{Version: 2, Description: "broken", SQLite: []string{"CREATE TABLE b(id INTEGER);", "NOT SQL"}},
+	}
+	applied, err := m.Up()
+	assert.Error(t, err)
+	assert.Len(t, applied, 1)
+
+	current, err := m.Current()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, current)
+
+	// the failed migration is rolled back as a whole
+	_, err = db.Exec("SELECT * FROM b")
+	assert.Error(t, err)
+}
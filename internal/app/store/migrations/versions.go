@@ -0,0 +1,396 @@
+package migrations
+
+// migrations is every schema version of the store, new versions are appended at the end and never edited once released.
+// Version 1 uses CREATE TABLE IF NOT EXISTS so databases made before schema_version existed are adopted as is.
+var migrations = []*Migration{
+	{
+		Version:     1,
+		Description: "create device definition tables",
+		SQLite: []string{
+			`CREATE TABLE IF NOT EXISTS devices(
+				"id" TEXT NOT NULL PRIMARY KEY,
+				"name" TEXT,
+				"addr" TEXT
+			);`,
+			`CREATE TABLE IF NOT EXISTS service_response(
+				"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+				"is_scalar" INTEGER,
+				"value" TEXT
+			);`,
+			`CREATE TABLE IF NOT EXISTS services(
+				"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+				"device_id" TEXT NOT NULL,
+				"is_inbound" INTEGER,
+				"name" TEXT,
+				"response_id" INTEGER DEFAULT NULL,
+				FOREIGN KEY (device_id) REFERENCES devices (id) ON UPDATE CASCADE ON DELETE CASCADE,
+				FOREIGN KEY (response_id) REFERENCES service_response (id) ON UPDATE CASCADE ON DELETE CASCADE
+			);`,
+			`CREATE TABLE IF NOT EXISTS service_request(
+				"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+				"service_id" INTEGER NOT NULL,
+				"is_scalar" INTEGER,
+				"value" TEXT
+			);`,
+			`CREATE TABLE IF NOT EXISTS messages(
+				"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+				"device_id" TEXT NOT NULL,
+				"name" TEXT,
+				FOREIGN KEY (device_id) REFERENCES devices (id) ON UPDATE CASCADE ON DELETE CASCADE
+			);`,
+			`CREATE TABLE IF NOT EXISTS message_definition_fields(
+				"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+				"message_id" INTEGER NOT NULL,
+				"name" TEXT,
+				"is_optional" TEXT,
+				"is_required" TEXT,
+				"is_scalar" INTEGER,
+				"value" TEXT,
+				FOREIGN KEY (message_id) REFERENCES messages(id) ON UPDATE CASCADE ON DELETE CASCADE
+			);`,
+		},
+		Postgres: []string{
+			`CREATE TABLE IF NOT EXISTS devices(
+				id TEXT NOT NULL PRIMARY KEY,
+				name TEXT,
+				addr TEXT
+			);`,
+			`CREATE TABLE IF NOT EXISTS service_response(
+				id SERIAL PRIMARY KEY,
+				is_scalar INTEGER,
+				value TEXT
+			);`,
+			`CREATE TABLE IF NOT EXISTS services(
+				id SERIAL PRIMARY KEY,
+				device_id TEXT NOT NULL REFERENCES devices (id) ON UPDATE CASCADE ON DELETE CASCADE,
+				is_inbound INTEGER,
+				name TEXT,
+				response_id INTEGER DEFAULT NULL REFERENCES service_response (id) ON UPDATE CASCADE ON DELETE CASCADE
+			);`,
+			`CREATE TABLE IF NOT EXISTS service_request(
+				id SERIAL PRIMARY KEY,
+				service_id INTEGER NOT NULL REFERENCES services (id) ON UPDATE CASCADE ON DELETE CASCADE,
+				is_scalar INTEGER,
+				value TEXT
+			);`,
+			`CREATE TABLE IF NOT EXISTS messages(
+				id SERIAL PRIMARY KEY,
+				device_id TEXT NOT NULL REFERENCES devices (id) ON UPDATE CASCADE ON DELETE CASCADE,
+				name TEXT
+			);`,
+			`CREATE TABLE IF NOT EXISTS message_definition_fields(
+				id SERIAL PRIMARY KEY,
+				message_id INTEGER NOT NULL REFERENCES messages (id) ON UPDATE CASCADE ON DELETE CASCADE,
+				name TEXT,
+				is_optional INTEGER,
+				is_required INTEGER,
+				is_scalar INTEGER,
+				value TEXT
+			);`,
+		},
+	},
+	{
+		Version:     2,
+		Description: "create hub code table",
+		SQLite: []string{
+			`CREATE TABLE IF NOT EXISTS hub_codes(
+				"hash" TEXT NOT NULL PRIMARY KEY,
+				"single_use" INTEGER,
+				"expires_at" INTEGER,
+				"created_at" INTEGER
+			);`,
+		},
+		Postgres: []string{
+			`CREATE TABLE IF NOT EXISTS hub_codes(
+				hash TEXT NOT NULL PRIMARY KEY,
+				single_use INTEGER,
+				expires_at BIGINT,
+				created_at BIGINT
+			);`,
+		},
+	},
+	{
+		Version:     3,
+		Description: "keep service request parameter order",
+		SQLite: []string{
+			`ALTER TABLE service_request ADD COLUMN "position" INTEGER NOT NULL DEFAULT 0;`,
+		},
+		Postgres: []string{
+			`ALTER TABLE service_request ADD COLUMN IF NOT EXISTS position INTEGER NOT NULL DEFAULT 0;`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "keep device call timeouts",
+		SQLite: []string{
+			`ALTER TABLE devices ADD COLUMN "timeout_ms" INTEGER NOT NULL DEFAULT 0;`,
+			`CREATE TABLE IF NOT EXISTS service_timeouts(
+				"device_id" TEXT NOT NULL,
+				"service" TEXT NOT NULL,
+				"timeout_ms" INTEGER NOT NULL,
+				PRIMARY KEY (device_id, service),
+				FOREIGN KEY (device_id) REFERENCES devices (id) ON UPDATE CASCADE ON DELETE CASCADE
+			);`,
+		},
+		Postgres: []string{
+			`ALTER TABLE devices ADD COLUMN IF NOT EXISTS timeout_ms BIGINT NOT NULL DEFAULT 0;`,
+			`CREATE TABLE IF NOT EXISTS service_timeouts(
+				device_id TEXT NOT NULL REFERENCES devices (id) ON UPDATE CASCADE ON DELETE CASCADE,
+				service TEXT NOT NULL,
+				timeout_ms BIGINT NOT NULL,
+				PRIMARY KEY (device_id, service)
+			);`,
+		},
+	},
+	{
+		Version:     5,
+		Description: "keep device status",
+		SQLite: []string{
+			`ALTER TABLE devices ADD COLUMN "status" TEXT NOT NULL DEFAULT '';`,
+			`ALTER TABLE devices ADD COLUMN "last_seen" INTEGER NOT NULL DEFAULT 0;`,
+		},
+		Postgres: []string{
+			`ALTER TABLE devices ADD COLUMN IF NOT EXISTS status TEXT NOT NULL DEFAULT '';`,
+			`ALTER TABLE devices ADD COLUMN IF NOT EXISTS last_seen BIGINT NOT NULL DEFAULT 0;`,
+		},
+	},
+	{
+		Version:     6,
+		Description: "create event table",
+		SQLite: []string{
+			`CREATE TABLE IF NOT EXISTS events(
+				"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+				"device_id" TEXT NOT NULL,
+				"service" TEXT NOT NULL,
+				"payload" TEXT NOT NULL,
+				"created_at" INTEGER NOT NULL,
+				FOREIGN KEY (device_id) REFERENCES devices (id) ON UPDATE CASCADE ON DELETE CASCADE
+			);`,
+		},
+		Postgres: []string{
+			`CREATE TABLE IF NOT EXISTS events(
+				id BIGSERIAL PRIMARY KEY,
+				device_id TEXT NOT NULL REFERENCES devices (id) ON UPDATE CASCADE ON DELETE CASCADE,
+				service TEXT NOT NULL,
+				payload TEXT NOT NULL,
+				created_at BIGINT NOT NULL
+			);`,
+		},
+	},
+	{
+		Version:     7,
+		Description: "index events for history queries",
+		SQLite: []string{
+			`CREATE INDEX IF NOT EXISTS events_device_id ON events (device_id, id);`,
+			`CREATE INDEX IF NOT EXISTS events_created_at ON events (created_at);`,
+		},
+		Postgres: []string{
+			`CREATE INDEX IF NOT EXISTS events_device_id ON events (device_id, id);`,
+			`CREATE INDEX IF NOT EXISTS events_created_at ON events (created_at);`,
+		},
+	},
+	{
+		Version:     8,
+		Description: "create rule table",
+		SQLite: []string{
+			`CREATE TABLE IF NOT EXISTS rules(
+				"id" TEXT NOT NULL PRIMARY KEY,
+				"name" TEXT NOT NULL,
+				"disabled" INTEGER NOT NULL DEFAULT 0,
+				"definition" TEXT NOT NULL
+			);`,
+		},
+		Postgres: []string{
+			`CREATE TABLE IF NOT EXISTS rules(
+				id TEXT NOT NULL PRIMARY KEY,
+				name TEXT NOT NULL,
+				disabled INTEGER NOT NULL DEFAULT 0,
+				definition TEXT NOT NULL
+			);`,
+		},
+	},
+	{
+		Version:     9,
+		Description: "create schedule table",
+		SQLite: []string{
+			`CREATE TABLE IF NOT EXISTS schedules(
+				"id" TEXT NOT NULL PRIMARY KEY,
+				"name" TEXT NOT NULL,
+				"disabled" INTEGER NOT NULL DEFAULT 0,
+				"cron" TEXT NOT NULL DEFAULT '',
+				"at" INTEGER NOT NULL DEFAULT 0,
+				"device_id" TEXT NOT NULL,
+				"service" TEXT NOT NULL,
+				"params" TEXT NOT NULL,
+				"last_run_at" INTEGER NOT NULL DEFAULT 0,
+				"last_error" TEXT NOT NULL DEFAULT '',
+				FOREIGN KEY (device_id) REFERENCES devices (id) ON UPDATE CASCADE ON DELETE CASCADE
+			);`,
+		},
+		Postgres: []string{
+			`CREATE TABLE IF NOT EXISTS schedules(
+				id TEXT NOT NULL PRIMARY KEY,
+				name TEXT NOT NULL,
+				disabled INTEGER NOT NULL DEFAULT 0,
+				cron TEXT NOT NULL DEFAULT '',
+				at BIGINT NOT NULL DEFAULT 0,
+				device_id TEXT NOT NULL REFERENCES devices (id) ON UPDATE CASCADE ON DELETE CASCADE,
+				service TEXT NOT NULL,
+				params TEXT NOT NULL,
+				last_run_at BIGINT NOT NULL DEFAULT 0,
+				last_error TEXT NOT NULL DEFAULT ''
+			);`,
+		},
+	},
+	{
+		Version:     10,
+		Description: "create scene table",
+		SQLite: []string{
+			`CREATE TABLE IF NOT EXISTS scenes(
+				"id" TEXT NOT NULL PRIMARY KEY,
+				"name" TEXT NOT NULL,
+				"mode" TEXT NOT NULL,
+				"steps" TEXT NOT NULL
+			);`,
+		},
+		Postgres: []string{
+			`CREATE TABLE IF NOT EXISTS scenes(
+				id TEXT NOT NULL PRIMARY KEY,
+				name TEXT NOT NULL,
+				mode TEXT NOT NULL,
+				steps TEXT NOT NULL
+			);`,
+		},
+	},
+	{
+		Version:     11,
+		Description: "create device group tables",
+		SQLite: []string{
+			`CREATE TABLE IF NOT EXISTS device_groups(
+				"id" TEXT NOT NULL PRIMARY KEY,
+				"name" TEXT NOT NULL
+			);`,
+			`CREATE TABLE IF NOT EXISTS device_group_members(
+				"group_id" TEXT NOT NULL,
+				"device_id" TEXT NOT NULL,
+				PRIMARY KEY (group_id, device_id),
+				FOREIGN KEY (group_id) REFERENCES device_groups (id) ON UPDATE CASCADE ON DELETE CASCADE,
+				FOREIGN KEY (device_id) REFERENCES devices (id) ON UPDATE CASCADE ON DELETE CASCADE
+			);`,
+		},
+		Postgres: []string{
+			`CREATE TABLE IF NOT EXISTS device_groups(
+				id TEXT NOT NULL PRIMARY KEY,
+				name TEXT NOT NULL
+			);`,
+			`CREATE TABLE IF NOT EXISTS device_group_members(
+				group_id TEXT NOT NULL REFERENCES device_groups (id) ON UPDATE CASCADE ON DELETE CASCADE,
+				device_id TEXT NOT NULL REFERENCES devices (id) ON UPDATE CASCADE ON DELETE CASCADE,
+				PRIMARY KEY (group_id, device_id)
+			);`,
+		},
+	},
+	{
+		Version:     12,
+		Description: "create user tables",
+		SQLite: []string{
+			`CREATE TABLE IF NOT EXISTS users(
+				"id" TEXT NOT NULL PRIMARY KEY,
+				"name" TEXT NOT NULL UNIQUE,
+				"role" TEXT NOT NULL,
+				"password_hash" TEXT NOT NULL,
+				"created_at" INTEGER NOT NULL DEFAULT 0
+			);`,
+			`CREATE TABLE IF NOT EXISTS user_tokens(
+				"hash" TEXT NOT NULL PRIMARY KEY,
+				"user_id" TEXT NOT NULL,
+				"kind" TEXT NOT NULL,
+				"name" TEXT NOT NULL DEFAULT '',
+				"expires_at" INTEGER NOT NULL DEFAULT 0,
+				"created_at" INTEGER NOT NULL DEFAULT 0,
+				FOREIGN KEY (user_id) REFERENCES users (id) ON UPDATE CASCADE ON DELETE CASCADE
+			);`,
+		},
+		Postgres: []string{
+			`CREATE TABLE IF NOT EXISTS users(
+				id TEXT NOT NULL PRIMARY KEY,
+				name TEXT NOT NULL UNIQUE,
+				role TEXT NOT NULL,
+				password_hash TEXT NOT NULL,
+				created_at BIGINT NOT NULL DEFAULT 0
+			);`,
+			`CREATE TABLE IF NOT EXISTS user_tokens(
+				hash TEXT NOT NULL PRIMARY KEY,
+				user_id TEXT NOT NULL REFERENCES users (id) ON UPDATE CASCADE ON DELETE CASCADE,
+				kind TEXT NOT NULL,
+				name TEXT NOT NULL DEFAULT '',
+				expires_at BIGINT NOT NULL DEFAULT 0,
+				created_at BIGINT NOT NULL DEFAULT 0
+			);`,
+		},
+	},
+	{
+		Version:     13,
+		Description: "keep device secrets",
+		SQLite: []string{
+			`ALTER TABLE devices ADD COLUMN "secret_hash" TEXT NOT NULL DEFAULT '';`,
+		},
+		Postgres: []string{
+			`ALTER TABLE devices ADD COLUMN IF NOT EXISTS secret_hash TEXT NOT NULL DEFAULT '';`,
+		},
+	},
+	{
+		Version:     14,
+		Description: "keep device transports",
+		SQLite: []string{
+			`ALTER TABLE devices ADD COLUMN "transport" TEXT NOT NULL DEFAULT '';`,
+		},
+		Postgres: []string{
+			`ALTER TABLE devices ADD COLUMN IF NOT EXISTS transport TEXT NOT NULL DEFAULT '';`,
+		},
+	},
+	{
+		// rules and scenes keep their devices in JSON, the devices they already use are found by their quoted UUID,
+		// the store removes a deleted device from its rules and scenes
+		Version:     15,
+		Description: "keep the devices of rules and scenes",
+		SQLite: []string{
+			`CREATE TABLE IF NOT EXISTS rule_devices(
+				"rule_id" TEXT NOT NULL,
+				"device_id" TEXT NOT NULL,
+				PRIMARY KEY (rule_id, device_id),
+				FOREIGN KEY (rule_id) REFERENCES rules (id) ON UPDATE CASCADE ON DELETE CASCADE,
+				FOREIGN KEY (device_id) REFERENCES devices (id) ON UPDATE CASCADE ON DELETE CASCADE
+			);`,
+			`CREATE TABLE IF NOT EXISTS scene_devices(
+				"scene_id" TEXT NOT NULL,
+				"device_id" TEXT NOT NULL,
+				PRIMARY KEY (scene_id, device_id),
+				FOREIGN KEY (scene_id) REFERENCES scenes (id) ON UPDATE CASCADE ON DELETE CASCADE,
+				FOREIGN KEY (device_id) REFERENCES devices (id) ON UPDATE CASCADE ON DELETE CASCADE
+			);`,
+			`INSERT OR IGNORE INTO rule_devices(rule_id, device_id)
+				SELECT rules.id, devices.id FROM rules JOIN devices ON rules.definition LIKE '%"' || devices.id || '"%';`,
+			`INSERT OR IGNORE INTO scene_devices(scene_id, device_id)
+				SELECT scenes.id, devices.id FROM scenes JOIN devices ON scenes.steps LIKE '%"' || devices.id || '"%';`,
+		},
+		Postgres: []string{
+			`CREATE TABLE IF NOT EXISTS rule_devices(
+				rule_id TEXT NOT NULL REFERENCES rules (id) ON UPDATE CASCADE ON DELETE CASCADE,
+				device_id TEXT NOT NULL REFERENCES devices (id) ON UPDATE CASCADE ON DELETE CASCADE,
+				PRIMARY KEY (rule_id, device_id)
+			);`,
+			`CREATE TABLE IF NOT EXISTS scene_devices(
+				scene_id TEXT NOT NULL REFERENCES scenes (id) ON UPDATE CASCADE ON DELETE CASCADE,
+				device_id TEXT NOT NULL REFERENCES devices (id) ON UPDATE CASCADE ON DELETE CASCADE,
+				PRIMARY KEY (scene_id, device_id)
+			);`,
+			`INSERT INTO rule_devices(rule_id, device_id)
+				SELECT rules.id, devices.id FROM rules JOIN devices ON rules.definition LIKE '%"' || devices.id || '"%'
+				ON CONFLICT DO NOTHING;`,
+			`INSERT INTO scene_devices(scene_id, device_id)
+				SELECT scenes.id, devices.id FROM scenes JOIN devices ON scenes.steps LIKE '%"' || devices.id || '"%'
+				ON CONFLICT DO NOTHING;`,
+		},
+	},
+}
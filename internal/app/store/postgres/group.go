@@ -0,0 +1,126 @@
+package postgres
+
+import (
+	"context"
+
+	"github.com/IktaS/go-home/internal/pkg/group"
+)
+
+// SaveGroup saves a group and its devices to the postgreSQL store, replacing the group with the same ID
+func (p *Store) SaveGroup(g *group.Group) error {
+	ctx := context.Background()
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	saveGroupSQL := `INSERT INTO device_groups(id, name) VALUES($1,$2)
+					ON CONFLICT(id) DO UPDATE SET name = excluded.name;`
+	_, err = tx.ExecContext(ctx, saveGroupSQL, g.ID, g.Name)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "DELETE FROM device_group_members WHERE group_id = $1;", g.ID)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, d := range g.Devices {
+		_, err = tx.ExecContext(ctx, "INSERT INTO device_group_members(group_id, device_id) VALUES($1,$2);", g.ID, d)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// groupDevices gets the devices of every group, by group ID
+func (p *Store) groupDevices(query string, args ...interface{}) (map[string][]string, error) {
+	rows, err := p.DB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	devices := make(map[string][]string)
+	for rows.Next() {
+		var groupID string
+		var deviceID string
+		err := rows.Scan(&groupID, &deviceID)
+		if err != nil {
+			return nil, err
+		}
+		devices[groupID] = append(devices[groupID], deviceID)
+	}
+	return devices, rows.Err()
+}
+
+// GetGroup gets a group by its ID from the postgreSQL store
+func (p *Store) GetGroup(id string) (*group.Group, error) {
+	g := &group.Group{Devices: []string{}}
+	err := p.DB.QueryRow("SELECT id, name FROM device_groups WHERE id = $1", id).Scan(&g.ID, &g.Name)
+	if err != nil {
+		return nil, err
+	}
+	devices, err := p.groupDevices("SELECT group_id, device_id FROM device_group_members WHERE group_id = $1 ORDER BY device_id", id)
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := devices[g.ID]; ok {
+		g.Devices = d
+	}
+	return g, nil
+}
+
+// GetGroups gets every group from the postgreSQL store, by ID
+func (p *Store) GetGroups() ([]*group.Group, error) {
+	rows, err := p.DB.Query("SELECT id, name FROM device_groups ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	groups := []*group.Group{}
+	for rows.Next() {
+		g := &group.Group{Devices: []string{}}
+		err := rows.Scan(&g.ID, &g.Name)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	devices, err := p.groupDevices("SELECT group_id, device_id FROM device_group_members ORDER BY device_id")
+	if err != nil {
+		return nil, err
+	}
+	for _, g := range groups {
+		if d, ok := devices[g.ID]; ok {
+			g.Devices = d
+		}
+	}
+	return groups, nil
+}
+
+// DeleteGroup deletes a group by its ID from the postgreSQL store
+func (p *Store) DeleteGroup(id string) error {
+	return p.execAffectingOne("DELETE FROM device_groups WHERE id = $1;", id)
+}
+
+// AddGroupDevice adds a device to a group in the postgreSQL store, adding a device already in the group does nothing
+func (p *Store) AddGroupDevice(id string, deviceID string) error {
+	var exist int
+	err := p.DB.QueryRow("SELECT 1 FROM device_groups WHERE id = $1", id).Scan(&exist)
+	if err != nil {
+		return err
+	}
+	addDeviceSQL := "INSERT INTO device_group_members(group_id, device_id) VALUES($1,$2) ON CONFLICT DO NOTHING;"
+	_, err = p.DB.Exec(addDeviceSQL, id, deviceID)
+	return err
+}
+
+// RemoveGroupDevice removes a device from a group in the postgreSQL store
+func (p *Store) RemoveGroupDevice(id string, deviceID string) error {
+	return p.execAffectingOne("DELETE FROM device_group_members WHERE group_id = $1 AND device_id = $2;", id, deviceID)
+}
@@ -1,13 +1,54 @@
 package postgres
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 
+	"github.com/IktaS/go-home/internal/app/store/migrations"
 	"github.com/IktaS/go-home/internal/pkg/device"
-	_ "github.com/lib/pq"
+	"github.com/IktaS/go-home/internal/pkg/logging"
+	"github.com/IktaS/go-serv/pkg/serv"
+	"github.com/google/uuid"
+	_ "github.com/lib/pq" // import postgres driver
 )
 
+func booltoI(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func intToBool(i int) bool {
+	return i == 1
+}
+
+func typeToDBModel(t *serv.Type) (int, string) {
+	if t == nil {
+		return -1, ""
+	}
+	isScalar := (t.Reference == "")
+	var value string
+	if isScalar {
+		value = t.Scalar.String()
+	} else {
+		value = t.Reference
+	}
+	return booltoI(isScalar), value
+}
+
+func dbModelToType(isScalar int, value string) *serv.Type {
+	if isScalar == 1 {
+		return &serv.Type{
+			Scalar: serv.StringToScalar[value],
+		}
+	}
+	return &serv.Type{
+		Reference: value,
+	}
+}
+
 //Store defines what the Postgre SQL Store needs
 type Store struct {
 	DSN string
@@ -17,39 +58,578 @@ type Store struct {
 // NewPostgreSQLStore makes a new PostgreSQL Store
 func NewPostgreSQLStore(dsn string) (*Store, error) {
 	p := &Store{DSN: dsn}
-	err := p.Init()
+	err := p.Init(dsn)
 	if err != nil {
 		return nil, err
 	}
 	return p, nil
 }
 
-// Init initialize a postgreSQL
-func (p *Store) Init() error {
-	db, err := sql.Open("postgres", p.DSN)
+// Open opens a connection to the postgreSQL database without migrating it
+func Open(dsn string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	err = db.Ping()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+// Init initialize a postgreSQL, accepts the DSN as string, and applies every pending migration
+func (p *Store) Init(config interface{}) error {
+	dsn, ok := config.(string)
+	if !ok {
+		return errors.New("postgres config must be a DSN string")
+	}
+	p.DSN = dsn
+	db, err := Open(dsn)
+	if err != nil {
+		return err
+	}
+	applied, err := migrations.NewMigrator(db, migrations.Postgres).Up()
 	if err != nil {
 		return err
 	}
+	for _, m := range applied {
+		logging.Infof("Applied migration %v : %v\n", m.Version, m.Description)
+	}
 	p.DB = db
 	return nil
 }
 
+func idToString(id interface{}) (string, error) {
+	switch v := id.(type) {
+	case string:
+		return v, nil
+	case uuid.UUID:
+		return v.String(), nil
+	}
+	return "", errors.New("id must be a string")
+}
+
+func deviceExist(ctx context.Context, tx *sql.Tx, id string) (bool, error) {
+	checkExist := `SELECT id FROM devices WHERE id = $1`
+	err := tx.QueryRowContext(ctx, checkExist, id).Scan(&id)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			return false, err
+		}
+		return false, nil
+	}
+	return true, nil
+}
+
 // Save saves a device to the postgreSQL store
 func (p *Store) Save(d *device.Device) error {
-	return errors.New("Not Implemented")
+	ctx := context.Background()
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	isExist, err := deviceExist(ctx, tx, d.ID.String())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	insertDeviceSQL := `INSERT INTO devices(id, name, addr, timeout_ms, status, last_seen, secret_hash, transport) VALUES($1,$2,$3,$4,$5,$6,$7,$8)
+						ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name, addr = EXCLUDED.addr, timeout_ms = EXCLUDED.timeout_ms,
+						status = EXCLUDED.status, last_seen = EXCLUDED.last_seen, secret_hash = EXCLUDED.secret_hash, transport = EXCLUDED.transport;`
+	_, err = tx.ExecContext(ctx, insertDeviceSQL, d.ID.String(), d.Name, d.Addr.String(), durationToMillis(d.Timeout), string(d.Status), timeToUnix(d.LastSeen), d.SecretHash, d.Transport)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = saveServiceTimeouts(ctx, tx, d)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if isExist {
+		return tx.Commit()
+	}
+	for _, m := range d.Messages {
+		err := insertMessage(ctx, tx, d.ID, m)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, s := range d.Services {
+		err := insertService(ctx, tx, d.ID, s)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Update updates an existing device, replacing its services and messages, returns sql.ErrNoRows if the device does not exist
+func (p *Store) Update(d *device.Device) error {
+	ctx := context.Background()
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	updateDeviceSQL := "UPDATE devices SET name = $1, addr = $2, timeout_ms = $3, status = $4, last_seen = $5, secret_hash = $6, transport = $7 WHERE id = $8;"
+	res, err := tx.ExecContext(ctx, updateDeviceSQL, d.Name, d.Addr.String(), durationToMillis(d.Timeout), string(d.Status), timeToUnix(d.LastSeen), d.SecretHash, d.Transport, d.ID.String())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n == 0 {
+		tx.Rollback()
+		return sql.ErrNoRows
+	}
+	err = saveServiceTimeouts(ctx, tx, d)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = deleteDefinition(ctx, tx, d.ID.String())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, m := range d.Messages {
+		err := insertMessage(ctx, tx, d.ID, m)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, s := range d.Services {
+		err := insertService(ctx, tx, d.ID, s)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// deleteDefinition deletes every service and message of a device,
+// service_response is deleted first as it is referenced by services and not the other way around
+func deleteDefinition(ctx context.Context, tx *sql.Tx, id string) error {
+	deleteDefinitionSQL := []string{
+		"DELETE FROM service_response WHERE id IN (SELECT response_id FROM services WHERE device_id = $1);",
+		"DELETE FROM services WHERE device_id = $1;",
+		"DELETE FROM messages WHERE device_id = $1;",
+	}
+	for _, statement := range deleteDefinitionSQL {
+		_, err := tx.ExecContext(ctx, statement, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveReferences replaces the devices an item refers to in a table of references, the devices that do not exist are skipped
+func saveReferences(ctx context.Context, tx *sql.Tx, table string, column string, id string, devices []string) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE "+column+" = $1;", id)
+	if err != nil {
+		return err
+	}
+	for _, d := range devices {
+		insertReferenceSQL := "INSERT INTO " + table + "(" + column + ", device_id) SELECT $1, id FROM devices WHERE id = $2 ON CONFLICT DO NOTHING;"
+		_, err = tx.ExecContext(ctx, insertReferenceSQL, id, d)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertMessage(ctx context.Context, tx *sql.Tx, devID uuid.UUID, m *serv.Message) error {
+	if m == nil {
+		return nil
+	}
+	insertMessageSQL := "INSERT INTO messages(device_id, name) VALUES($1,$2) RETURNING id;"
+	var messageID int64
+	err := tx.QueryRowContext(ctx, insertMessageSQL, devID.String(), m.Name).Scan(&messageID)
+	if err != nil {
+		return err
+	}
+	for _, md := range m.Definitions {
+		if md.Field != nil {
+			err := insertMessageField(ctx, tx, messageID, md.Field)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func insertMessageField(ctx context.Context, tx *sql.Tx, mesID int64, f *serv.Field) error {
+	if f == nil {
+		return nil
+	}
+	isScalar, value := typeToDBModel(f.Type)
+	if isScalar == -1 {
+		return nil
+	}
+	insertMesDefSQL := `INSERT INTO message_definition_fields(message_id, name, is_optional, is_required, is_scalar, value)
+						VALUES($1,$2,$3,$4,$5,$6);`
+	_, err := tx.ExecContext(ctx, insertMesDefSQL, mesID, f.Name, booltoI(f.Optional), booltoI(f.Required), isScalar, value)
+	return err
 }
 
-// Get defines getting a device.Device
+func serviceDirectionToInt(s *serv.Service) int {
+	if s.Inbound {
+		return 1
+	}
+	if s.Outbound {
+		return 0
+	}
+	return -1
+}
+
+func insertService(ctx context.Context, tx *sql.Tx, devID uuid.UUID, s *serv.Service) error {
+	if s == nil {
+		return nil
+	}
+	var responseID sql.NullInt64
+	if s.Response != nil {
+		id, err := insertServiceResponse(ctx, tx, s.Response)
+		if err != nil {
+			return err
+		}
+		responseID = sql.NullInt64{Int64: id, Valid: true}
+	}
+	insertServiceSQL := "INSERT INTO services(device_id, name, is_inbound, response_id) VALUES($1,$2,$3,$4) RETURNING id;"
+	var serviceID int64
+	err := tx.QueryRowContext(ctx, insertServiceSQL, devID.String(), s.Name, serviceDirectionToInt(s), responseID).Scan(&serviceID)
+	if err != nil {
+		return err
+	}
+	for i, r := range s.Request {
+		err := insertServiceRequest(ctx, tx, serviceID, i, r)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func insertServiceResponse(ctx context.Context, tx *sql.Tx, t *serv.Type) (int64, error) {
+	isScalar, value := typeToDBModel(t)
+	insertServiceResponseSQL := "INSERT INTO service_response(is_scalar, value) VALUES($1,$2) RETURNING id;"
+	var id int64
+	err := tx.QueryRowContext(ctx, insertServiceResponseSQL, isScalar, value).Scan(&id)
+	if err != nil {
+		return -1, err
+	}
+	return id, nil
+}
+
+func insertServiceRequest(ctx context.Context, tx *sql.Tx, id int64, position int, t *serv.Type) error {
+	if t == nil {
+		return nil
+	}
+	isScalar, value := typeToDBModel(t)
+	insertServiceRequestSQL := "INSERT INTO service_request(service_id, is_scalar, value, position) VALUES($1,$2,$3,$4);"
+	_, err := tx.ExecContext(ctx, insertServiceRequestSQL, id, isScalar, value, position)
+	return err
+}
+
+// Get defines getting a device.Device, accept id as string
 func (p *Store) Get(id interface{}) (*device.Device, error) {
-	return nil, errors.New("Not Implemented")
+	idStr, err := idToString(id)
+	if err != nil {
+		return nil, err
+	}
+	deviceQuerySQL := "SELECT " + deviceColumns + " FROM devices WHERE id = $1"
+	d, err := scanDevice(p.DB.QueryRow(deviceQuerySQL, idStr))
+	if err != nil {
+		return nil, err
+	}
+	return dbDeviceToDevice(p.DB, d)
+}
+
+// deviceColumns are the columns of devices that scanDevice scans
+const deviceColumns = "id, name, addr, timeout_ms, status, last_seen, secret_hash, transport"
+
+// dbDevice defines a row of devices
+type dbDevice struct {
+	id        string
+	name      string
+	addr      string
+	timeoutMs int64
+	status    string
+	lastSeen  int64
+	secret    string
+	transport string
+}
+
+func scanDevice(row interface{ Scan(...interface{}) error }) (*dbDevice, error) {
+	d := &dbDevice{}
+	err := row.Scan(&d.id, &d.name, &d.addr, &d.timeoutMs, &d.status, &d.lastSeen, &d.secret, &d.transport)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func dbDeviceToDevice(db *sql.DB, d *dbDevice) (*device.Device, error) {
+	id := d.id
+	addr := d.addr
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := device.ParseEndpoint(addr)
+	if err != nil {
+		// an address stored before endpoints, e.g. of a device that connected over IPv6, is left empty
+		// until the device reconnects or its address is overridden
+		endpoint = &device.Endpoint{}
+	}
+	dev := &device.Device{
+		ID:         uid,
+		Name:       d.name,
+		Addr:       endpoint,
+		Timeout:    millisToDuration(d.timeoutMs),
+		Status:     device.Status(d.status),
+		LastSeen:   unixToTime(d.lastSeen),
+		SecretHash: d.secret,
+		Transport:  d.transport,
+	}
+	dev.ServiceTimeouts, err = getServiceTimeouts(db, id)
+	if err != nil {
+		return nil, err
+	}
+	dev.Messages, err = getMessages(db, id)
+	if err != nil {
+		return nil, err
+	}
+	dev.Services, err = getServices(db, id)
+	if err != nil {
+		return nil, err
+	}
+	return dev, nil
+}
+
+func getMessageDefinition(db *sql.DB, mesID int) ([]*serv.MessageDefinition, error) {
+	messageFieldSQL := `SELECT name, is_optional, is_required, is_scalar, value
+						FROM message_definition_fields WHERE message_id = $1 ORDER BY id`
+	rows, err := db.Query(messageFieldSQL, mesID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var mesDef []*serv.MessageDefinition
+	for rows.Next() {
+		var name string
+		var isOptional int
+		var isRequired int
+		var isScalar int
+		var value string
+		err = rows.Scan(&name, &isOptional, &isRequired, &isScalar, &value)
+		if err != nil {
+			return nil, err
+		}
+		mesDef = append(mesDef, &serv.MessageDefinition{
+			Field: &serv.Field{
+				Optional: intToBool(isOptional),
+				Required: intToBool(isRequired),
+				Type:     dbModelToType(isScalar, value),
+				Name:     name,
+			},
+		})
+	}
+	return mesDef, rows.Err()
+}
+
+func getMessages(db *sql.DB, deviceID string) ([]*serv.Message, error) {
+	messageQuerySQL := "SELECT id, name FROM messages WHERE device_id = $1 ORDER BY id"
+	rows, err := db.Query(messageQuerySQL, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	var messages []*serv.Message
+	for rows.Next() {
+		var id int
+		var name string
+		err := rows.Scan(&id, &name)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		messages = append(messages, &serv.Message{Name: name})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, m := range messages {
+		m.Definitions, err = getMessageDefinition(db, ids[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return messages, nil
+}
+
+func getServiceResponse(db *sql.DB, id int64) (*serv.Type, error) {
+	serviceResponseSQL := "SELECT is_scalar, value FROM service_response WHERE id = $1"
+	var isScalar int
+	var value string
+	err := db.QueryRow(serviceResponseSQL, id).Scan(&isScalar, &value)
+	if err != nil {
+		return nil, err
+	}
+	return dbModelToType(isScalar, value), nil
+}
+
+func getServiceRequest(db *sql.DB, serviceID int) ([]*serv.Type, error) {
+	serviceRequestSQL := "SELECT is_scalar, value FROM service_request WHERE service_id = $1 ORDER BY position, id"
+	rows, err := db.Query(serviceRequestSQL, serviceID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var requests []*serv.Type
+	for rows.Next() {
+		var isScalar int
+		var value string
+		err = rows.Scan(&isScalar, &value)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, dbModelToType(isScalar, value))
+	}
+	return requests, rows.Err()
+}
+
+func getServices(db *sql.DB, deviceID string) ([]*serv.Service, error) {
+	serviceQuerySQL := "SELECT id, name, is_inbound, response_id FROM services WHERE device_id = $1 ORDER BY id"
+	rows, err := db.Query(serviceQuerySQL, deviceID)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	var responseIDs []sql.NullInt64
+	var services []*serv.Service
+	for rows.Next() {
+		var id int
+		var name string
+		var isInbound int
+		var responseID sql.NullInt64
+		err := rows.Scan(&id, &name, &isInbound, &responseID)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		ids = append(ids, id)
+		responseIDs = append(responseIDs, responseID)
+		services = append(services, &serv.Service{
+			Name:     name,
+			Inbound:  (isInbound == 1),
+			Outbound: (isInbound == 0),
+		})
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i, s := range services {
+		if responseIDs[i].Valid {
+			s.Response, err = getServiceResponse(db, responseIDs[i].Int64)
+			if err != nil {
+				return nil, err
+			}
+		}
+		s.Request, err = getServiceRequest(db, ids[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return services, nil
 }
 
 // GetAll gets all device
 func (p *Store) GetAll() ([]*device.Device, error) {
-	return nil, errors.New("Not Implemented")
+	deviceQuerySQL := "SELECT " + deviceColumns + " FROM devices ORDER BY name, id"
+	rows, err := p.DB.Query(deviceQuerySQL)
+	if err != nil {
+		return nil, err
+	}
+	var dbDevices []*dbDevice
+	for rows.Next() {
+		d, err := scanDevice(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		dbDevices = append(dbDevices, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	var devices []*device.Device
+	for _, d := range dbDevices {
+		dev, err := dbDeviceToDevice(p.DB, d)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, dev)
+	}
+	return devices, nil
 }
 
-// Delete defines getting a device.Device
+// Delete defines deleting a device.Device and its definition, accepts a string as ID, returns sql.ErrNoRows if the device does not exist
 func (p *Store) Delete(id interface{}) error {
-	return errors.New("Not Implemented")
+	idStr, err := idToString(id)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	err = deleteDefinition(ctx, tx, idStr)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = removeRuleDevice(ctx, tx, idStr)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = removeSceneDevice(ctx, tx, idStr)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// timeouts, events, schedules and group memberships are deleted by their foreign keys
+	deleteDeviceSQL := "DELETE FROM devices WHERE id = $1"
+	res, err := tx.ExecContext(ctx, deleteDeviceSQL, idStr)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n == 0 {
+		tx.Rollback()
+		return sql.ErrNoRows
+	}
+	return tx.Commit()
 }
@@ -2,25 +2,167 @@ package postgres
 
 import (
 	"database/sql"
+	"encoding/json"
+	"os"
+	"regexp"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IktaS/go-home/internal/pkg/action"
 	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/event"
+	"github.com/IktaS/go-home/internal/pkg/group"
+	"github.com/IktaS/go-home/internal/pkg/rule"
+	"github.com/IktaS/go-home/internal/pkg/scene"
+	"github.com/IktaS/go-home/internal/pkg/schedule"
+	"github.com/IktaS/go-home/internal/pkg/user"
+	"github.com/IktaS/go-serv/pkg/serv"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
 )
 
+func newMockStore(t *testing.T) (*Store, sqlmock.Sqlmock, *sql.DB) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	return &Store{DSN: "mock", DB: db}, mock, db
+}
+
+func testDevice() *device.Device {
+	return &device.Device{
+		ID:   uuid.New(),
+		Name: "test-device",
+		Addr: &device.Endpoint{
+			Host: "127.0.0.1",
+			Port: "80",
+		},
+		Services: []*serv.Service{
+			{
+				Name:     "TestService",
+				Inbound:  true,
+				Outbound: false,
+				Request: []*serv.Type{
+					{
+						Reference: "TestMessage",
+					},
+				},
+				Response: &serv.Type{
+					Scalar: serv.String,
+				},
+			},
+		},
+		Messages: []*serv.Message{
+			{
+				Name: "TestMessage",
+				Definitions: []*serv.MessageDefinition{
+					{
+						Field: &serv.Field{
+							Name: "TestString",
+							Type: &serv.Type{
+								Scalar: serv.String,
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
 func TestPostgreSQLStore_Save(t *testing.T) {
 	tests := []struct {
 		name     string
 		setup    func(*testing.T) (*Store, sqlmock.Sqlmock, *sql.DB)
 		teardown func(*testing.T, *sql.DB)
 		input    *device.Device
-		expect   func(sqlmock.Sqlmock)
+		expect   func(sqlmock.Sqlmock, *device.Device)
 		wantErr  bool
-	}{}
+	}{
+		{
+			name:  "New device",
+			setup: newMockStore,
+			teardown: func(t *testing.T, db *sql.DB) {
+				db.Close()
+			},
+			input: testDevice(),
+			expect: func(mock sqlmock.Sqlmock, d *device.Device) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(
+					regexp.QuoteMeta("SELECT id FROM devices WHERE id = $1"),
+				).WithArgs(d.ID.String()).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectExec(
+					"INSERT INTO devices",
+				).WithArgs(d.ID.String(), d.Name, d.Addr.String(), 0, "", 0, "", "").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(
+					regexp.QuoteMeta("DELETE FROM service_timeouts WHERE device_id = $1"),
+				).WithArgs(d.ID.String()).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectQuery(
+					"INSERT INTO messages",
+				).WithArgs(d.ID.String(), "TestMessage").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectExec(
+					"INSERT INTO message_definition_fields",
+				).WithArgs(1, "TestString", 0, 0, 1, "string").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery(
+					"INSERT INTO service_response",
+				).WithArgs(1, "string").WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectQuery(
+					"INSERT INTO services",
+				).WithArgs(d.ID.String(), "TestService", 1, 1).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectExec(
+					"INSERT INTO service_request",
+				).WithArgs(1, 0, "TestMessage", 0).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			},
+			wantErr: false,
+		},
+		{
+			name:  "Existing device",
+			setup: newMockStore,
+			teardown: func(t *testing.T, db *sql.DB) {
+				db.Close()
+			},
+			input: testDevice(),
+			expect: func(mock sqlmock.Sqlmock, d *device.Device) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(
+					regexp.QuoteMeta("SELECT id FROM devices WHERE id = $1"),
+				).WithArgs(d.ID.String()).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(d.ID.String()))
+				mock.ExpectExec(
+					"INSERT INTO devices",
+				).WithArgs(d.ID.String(), d.Name, d.Addr.String(), 0, "", 0, "", "").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec(
+					regexp.QuoteMeta("DELETE FROM service_timeouts WHERE device_id = $1"),
+				).WithArgs(d.ID.String()).WillReturnResult(sqlmock.NewResult(0, 0))
+				mock.ExpectCommit()
+			},
+			wantErr: false,
+		},
+		{
+			name:  "Insert fails",
+			setup: newMockStore,
+			teardown: func(t *testing.T, db *sql.DB) {
+				db.Close()
+			},
+			input: testDevice(),
+			expect: func(mock sqlmock.Sqlmock, d *device.Device) {
+				mock.ExpectBegin()
+				mock.ExpectQuery(
+					regexp.QuoteMeta("SELECT id FROM devices WHERE id = $1"),
+				).WithArgs(d.ID.String()).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectExec(
+					"INSERT INTO devices",
+				).WillReturnError(sql.ErrConnDone)
+				mock.ExpectRollback()
+			},
+			wantErr: true,
+		},
+	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			p, mock, db := tt.setup(t)
+			tt.expect(mock, tt.input)
 
 			err := p.Save(tt.input)
 
@@ -30,16 +172,414 @@ func TestPostgreSQLStore_Save(t *testing.T) {
 				assert.NoError(t, err)
 			}
 
-			tt.expect(mock)
-			err = mock.ExpectationsWereMet()
-
-			if tt.wantErr {
-				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
-			}
+			assert.NoError(t, mock.ExpectationsWereMet())
 
 			tt.teardown(t, db)
 		})
 	}
 }
+
+func TestPostgreSQLStore_Get(t *testing.T) {
+	expected := testDevice()
+	p, mock, db := newMockStore(t)
+	defer db.Close()
+	id := expected.ID.String()
+
+	mock.ExpectQuery(
+		regexp.QuoteMeta("SELECT id, name, addr, timeout_ms, status, last_seen, secret_hash, transport FROM devices WHERE id = $1"),
+	).WithArgs(id).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "name", "addr", "timeout_ms", "status", "last_seen", "secret_hash", "transport"}).AddRow(id, "test-device", "127.0.0.1:80", 0, "", 0, "", ""),
+	)
+	mock.ExpectQuery(
+		regexp.QuoteMeta("SELECT service, timeout_ms FROM service_timeouts WHERE device_id = $1"),
+	).WithArgs(id).WillReturnRows(sqlmock.NewRows([]string{"service", "timeout_ms"}))
+	mock.ExpectQuery(
+		regexp.QuoteMeta("SELECT id, name FROM messages WHERE device_id = $1"),
+	).WithArgs(id).WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow(1, "TestMessage"))
+	mock.ExpectQuery(
+		regexp.QuoteMeta("FROM message_definition_fields WHERE message_id = $1"),
+	).WithArgs(1).WillReturnRows(
+		sqlmock.NewRows([]string{"name", "is_optional", "is_required", "is_scalar", "value"}).AddRow("TestString", 0, 0, 1, "string"),
+	)
+	mock.ExpectQuery(
+		regexp.QuoteMeta("SELECT id, name, is_inbound, response_id FROM services WHERE device_id = $1"),
+	).WithArgs(id).WillReturnRows(sqlmock.NewRows([]string{"id", "name", "is_inbound", "response_id"}).AddRow(1, "TestService", 1, 1))
+	mock.ExpectQuery(
+		regexp.QuoteMeta("SELECT is_scalar, value FROM service_response WHERE id = $1"),
+	).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"is_scalar", "value"}).AddRow(1, "string"))
+	mock.ExpectQuery(
+		regexp.QuoteMeta("SELECT is_scalar, value FROM service_request WHERE service_id = $1"),
+	).WithArgs(1).WillReturnRows(sqlmock.NewRows([]string{"is_scalar", "value"}).AddRow(0, "TestMessage"))
+
+	ret, err := p.Get(id)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Equal(t, expected, ret)
+}
+
+func TestPostgreSQLStore_Delete(t *testing.T) {
+	p, mock, db := newMockStore(t)
+	defer db.Close()
+	id := uuid.New().String()
+
+	expectDelete := func(affected int64) {
+		mock.ExpectBegin()
+		mock.ExpectExec("DELETE FROM service_response").WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("DELETE FROM services").WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec("DELETE FROM messages").WithArgs(id).WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectQuery("FROM rules WHERE id IN").WithArgs(id).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "disabled", "definition"}))
+		mock.ExpectQuery("FROM scenes WHERE id IN").WithArgs(id).
+			WillReturnRows(sqlmock.NewRows([]string{"id", "name", "mode", "steps"}))
+		mock.ExpectExec(
+			regexp.QuoteMeta("DELETE FROM devices WHERE id = $1"),
+		).WithArgs(id).WillReturnResult(sqlmock.NewResult(0, affected))
+	}
+
+	expectDelete(1)
+	mock.ExpectCommit()
+	assert.NoError(t, p.Delete(id))
+
+	expectDelete(0)
+	mock.ExpectRollback()
+	assert.Equal(t, sql.ErrNoRows, p.Delete(id))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+	assert.Error(t, p.Delete(42))
+}
+
+// TestPostgreSQLStore_Live runs a round trip against a local Postgres when POSTGRES_TEST_DSN is set
+func TestPostgreSQLStore_Live(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+	p, err := NewPostgreSQLStore(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.DB.Close()
+	d := testDevice()
+	assert.NoError(t, p.Save(d))
+	defer p.Delete(d.ID.String())
+	ret, err := p.Get(d.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, d, ret)
+	assert.NoError(t, p.Delete(d.ID.String()))
+	_, err = p.Get(d.ID.String())
+	assert.Equal(t, sql.ErrNoRows, err)
+}
+
+// TestPostgreSQLStore_LiveDeleteCascade checks against a local Postgres, when POSTGRES_TEST_DSN is set,
+// that the foreign keys delete what refers to a deleted device, and that its rules and scenes are kept without it
+func TestPostgreSQLStore_LiveDeleteCascade(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN is not set")
+	}
+	p, err := NewPostgreSQLStore(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.DB.Close()
+	d := testDevice()
+	assert.NoError(t, p.Save(d))
+	defer p.Delete(d.ID.String())
+	id := d.ID.String()
+	call := &action.Action{Device: id, Service: "TestService"}
+	g := &group.Group{ID: uuid.New().String(), Name: "Kitchen", Devices: []string{id}}
+	assert.NoError(t, p.SaveGroup(g))
+	defer p.DeleteGroup(g.ID)
+	assert.NoError(t, p.SaveEvent(&event.Event{DeviceID: d.ID, Service: "TestService", Payload: json.RawMessage(`{}`), CreatedAt: time.Now()}))
+	s := &schedule.Schedule{ID: uuid.New(), Name: "night", Cron: "0 23 * * *", Action: *call}
+	assert.NoError(t, p.SaveSchedule(s))
+	r := &rule.Rule{ID: uuid.New(), Name: "night", Definition: rule.Definition{
+		Trigger: &rule.Trigger{Type: rule.TriggerSchedule, At: "23:00"},
+		Actions: []*action.Action{call},
+	}}
+	assert.NoError(t, p.SaveRule(r))
+	defer p.DeleteRule(r.ID.String())
+	sc := &scene.Scene{ID: uuid.New(), Name: "movie", Mode: scene.ModeBestEffort, Steps: []*action.Action{call}}
+	assert.NoError(t, p.SaveScene(sc))
+	defer p.DeleteScene(sc.ID.String())
+	assert.NoError(t, p.Delete(id))
+
+	ret, err := p.GetGroup(g.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, ret.Devices, "a deleted device leaves its groups")
+	events, err := p.GetEvents(&event.Query{DeviceID: id})
+	assert.NoError(t, err)
+	assert.Empty(t, events)
+	_, err = p.GetSchedule(s.ID.String())
+	assert.Equal(t, sql.ErrNoRows, err)
+	keptRule, err := p.GetRule(r.ID.String())
+	assert.NoError(t, err)
+	assert.Empty(t, keptRule.Actions, "a deleted device is removed from the rules")
+	assert.True(t, keptRule.Disabled, "a rule left without actions is disabled")
+	keptScene, err := p.GetScene(sc.ID.String())
+	assert.NoError(t, err)
+	assert.Empty(t, keptScene.Steps, "a deleted device is removed from the scenes")
+}
+
+func TestPostgreSQLStore_Update(t *testing.T) {
+	p, mock, db := newMockStore(t)
+	defer db.Close()
+	d := testDevice()
+	d.Messages = nil
+	d.Services = d.Services[:0]
+	d.Timeout = 2 * time.Second
+	d.ServiceTimeouts = map[string]time.Duration{"TestService": 1500 * time.Millisecond}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(
+		regexp.QuoteMeta("UPDATE devices SET name = $1, addr = $2, timeout_ms = $3, status = $4, last_seen = $5, secret_hash = $6, transport = $7 WHERE id = $8"),
+	).WithArgs(d.Name, d.Addr.String(), 2000, "", 0, "", "", d.ID.String()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(
+		regexp.QuoteMeta("DELETE FROM service_timeouts WHERE device_id = $1"),
+	).WithArgs(d.ID.String()).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(
+		regexp.QuoteMeta("INSERT INTO service_timeouts(device_id, service, timeout_ms) VALUES($1,$2,$3)"),
+	).WithArgs(d.ID.String(), "TestService", 1500).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM service_response").WithArgs(d.ID.String()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM services").WithArgs(d.ID.String()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM messages").WithArgs(d.ID.String()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, p.Update(d))
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE devices").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	assert.Equal(t, sql.ErrNoRows, p.Update(d))
+
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgreSQLStore_GetEvents(t *testing.T) {
+	p, mock, db := newMockStore(t)
+	defer db.Close()
+	deviceID := uuid.New()
+	since := time.Unix(1700000000, 0)
+
+	mock.ExpectQuery(
+		regexp.QuoteMeta("SELECT id, device_id, service, payload, created_at FROM events WHERE device_id = $1 AND service = $2 AND created_at >= $3 AND id < $4 ORDER BY id DESC LIMIT $5"),
+	).WithArgs(deviceID.String(), "opened", 1700000000000, 10, 2).WillReturnRows(
+		sqlmock.NewRows([]string{"id", "device_id", "service", "payload", "created_at"}).
+			AddRow(9, deviceID.String(), "opened", `{"Open":true}`, 1700000060000),
+	)
+	events, err := p.GetEvents(&event.Query{DeviceID: deviceID.String(), Service: "opened", Since: since, Before: 10, Limit: 2})
+	assert.NoError(t, err)
+	assert.Equal(t, []*event.Event{
+		{
+			ID:        9,
+			DeviceID:  deviceID,
+			Service:   "opened",
+			Payload:   json.RawMessage(`{"Open":true}`),
+			CreatedAt: since.Add(time.Minute),
+		},
+	}, events)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgreSQLStore_Rules(t *testing.T) {
+	p, mock, db := newMockStore(t)
+	defer db.Close()
+	r := &rule.Rule{
+		ID:   uuid.New(),
+		Name: "night",
+		Definition: rule.Definition{
+			Trigger: &rule.Trigger{Type: rule.TriggerSchedule, At: "22:00"},
+			Actions: []*action.Action{{Device: uuid.New().String(), Service: "off"}},
+		},
+	}
+	definition, err := json.Marshal(&r.Definition)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO rules(id, name, disabled, definition) VALUES($1,$2,$3,$4)")).
+		WithArgs(r.ID.String(), "night", 0, string(definition)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM rule_devices WHERE rule_id = $1;")).
+		WithArgs(r.ID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO rule_devices(rule_id, device_id) SELECT $1, id FROM devices WHERE id = $2")).
+		WithArgs(r.ID.String(), r.Actions[0].Device).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, p.SaveRule(r))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, name, disabled, definition FROM rules WHERE id = $1")).
+		WithArgs(r.ID.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "disabled", "definition"}).
+			AddRow(r.ID.String(), "night", 0, string(definition)))
+	ret, err := p.GetRule(r.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, r, ret)
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM rules WHERE id = $1;")).
+		WithArgs(r.ID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.Equal(t, sql.ErrNoRows, p.DeleteRule(r.ID.String()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgreSQLStore_Schedules(t *testing.T) {
+	p, mock, db := newMockStore(t)
+	defer db.Close()
+	s := &schedule.Schedule{
+		ID:     uuid.New(),
+		Name:   "night",
+		Cron:   "0 23 * * *",
+		Action: action.Action{Device: uuid.New().String(), Service: "off"},
+	}
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO schedules(id, name, disabled, cron, at, device_id, service, params) VALUES($1,$2,$3,$4,$5,$6,$7,$8)")).
+		WithArgs(s.ID.String(), "night", 0, "0 23 * * *", 0, s.Device, "off", "null").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, p.SaveSchedule(s))
+
+	s.LastRun = &schedule.Result{At: time.Unix(1610000000, 0), OK: true}
+	mock.ExpectQuery(regexp.QuoteMeta("FROM schedules WHERE id = $1")).
+		WithArgs(s.ID.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "disabled", "cron", "at", "device_id", "service", "params", "last_run_at", "last_error"}).
+			AddRow(s.ID.String(), "night", 0, "0 23 * * *", 0, s.Device, "off", "null", 1610000000, ""))
+	ret, err := p.GetSchedule(s.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, s, ret)
+
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE schedules SET last_run_at = $1, last_error = $2 WHERE id = $3;")).
+		WithArgs(1610000000, "", s.ID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, p.SetScheduleResult(s.ID.String(), s.LastRun))
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM schedules WHERE id = $1;")).
+		WithArgs(s.ID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.Equal(t, sql.ErrNoRows, p.DeleteSchedule(s.ID.String()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgreSQLStore_Scenes(t *testing.T) {
+	p, mock, db := newMockStore(t)
+	defer db.Close()
+	s := &scene.Scene{
+		ID:    uuid.New(),
+		Name:  "movie",
+		Mode:  scene.ModeStopOnFailure,
+		Steps: []*action.Action{{Device: uuid.New().String(), Service: "off"}},
+	}
+	steps, err := json.Marshal(s.Steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO scenes(id, name, mode, steps) VALUES($1,$2,$3,$4)")).
+		WithArgs(s.ID.String(), "movie", "stop-on-failure", string(steps)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM scene_devices WHERE scene_id = $1;")).
+		WithArgs(s.ID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO scene_devices(scene_id, device_id) SELECT $1, id FROM devices WHERE id = $2")).
+		WithArgs(s.ID.String(), s.Steps[0].Device).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, p.SaveScene(s))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, name, mode, steps FROM scenes WHERE id = $1")).
+		WithArgs(s.ID.String()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "mode", "steps"}).
+			AddRow(s.ID.String(), "movie", "stop-on-failure", string(steps)))
+	ret, err := p.GetScene(s.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, s, ret)
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM scenes WHERE id = $1;")).
+		WithArgs(s.ID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.Equal(t, sql.ErrNoRows, p.DeleteScene(s.ID.String()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgreSQLStore_Groups(t *testing.T) {
+	p, mock, db := newMockStore(t)
+	defer db.Close()
+	g := &group.Group{ID: "kitchen", Name: "Kitchen", Devices: []string{uuid.New().String()}}
+
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO device_groups(id, name) VALUES($1,$2)")).
+		WithArgs("kitchen", "Kitchen").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM device_group_members WHERE group_id = $1;")).
+		WithArgs("kitchen").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO device_group_members(group_id, device_id) VALUES($1,$2);")).
+		WithArgs("kitchen", g.Devices[0]).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	assert.NoError(t, p.SaveGroup(g))
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT id, name FROM device_groups WHERE id = $1")).
+		WithArgs("kitchen").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name"}).AddRow("kitchen", "Kitchen"))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT group_id, device_id FROM device_group_members WHERE group_id = $1")).
+		WithArgs("kitchen").
+		WillReturnRows(sqlmock.NewRows([]string{"group_id", "device_id"}).AddRow("kitchen", g.Devices[0]))
+	ret, err := p.GetGroup("kitchen")
+	assert.NoError(t, err)
+	assert.Equal(t, g, ret)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT 1 FROM device_groups WHERE id = $1")).
+		WithArgs("garage").
+		WillReturnRows(sqlmock.NewRows([]string{"1"}))
+	assert.Equal(t, sql.ErrNoRows, p.AddGroupDevice("garage", g.Devices[0]))
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM device_groups WHERE id = $1;")).
+		WithArgs("kitchen").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, p.DeleteGroup("kitchen"))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestPostgreSQLStore_Users(t *testing.T) {
+	p, mock, db := newMockStore(t)
+	defer db.Close()
+	u := &user.User{ID: uuid.New(), Name: "alice", Role: user.RoleAdmin, PasswordHash: "hash", CreatedAt: time.Unix(1610000000, 0)}
+
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO users(id, name, role, password_hash, created_at) VALUES($1,$2,$3,$4,$5)")).
+		WithArgs(u.ID.String(), "alice", "admin", "hash", 1610000000).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, p.SaveUser(u))
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM users WHERE name = $1")).
+		WithArgs("alice").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "name", "role", "password_hash", "created_at"}).
+			AddRow(u.ID.String(), "alice", "admin", "hash", 1610000000))
+	ret, err := p.GetUserByName("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, u, ret)
+
+	key := &user.Token{Hash: "key", User: u.ID, Kind: user.TokenKey, Name: "dashboard", CreatedAt: time.Unix(1610000000, 0)}
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO user_tokens(hash, user_id, kind, name, expires_at, created_at) VALUES($1,$2,$3,$4,$5,$6);")).
+		WithArgs("key", u.ID.String(), "key", "dashboard", 0, 1610000000).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	assert.NoError(t, p.SaveToken(key))
+
+	mock.ExpectQuery(regexp.QuoteMeta("FROM user_tokens WHERE hash = $1")).
+		WithArgs("key").
+		WillReturnRows(sqlmock.NewRows([]string{"hash", "user_id", "kind", "name", "expires_at", "created_at"}).
+			AddRow("key", u.ID.String(), "key", "dashboard", 0, 1610000000))
+	tok, err := p.GetToken("key")
+	assert.NoError(t, err)
+	assert.Equal(t, key, tok)
+
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM user_tokens WHERE user_id = $1;")).
+		WithArgs(u.ID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM users WHERE id = $1;")).
+		WithArgs(u.ID.String()).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	assert.Equal(t, sql.ErrNoRows, p.DeleteUser(u.ID.String()))
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
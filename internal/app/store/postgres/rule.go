@@ -0,0 +1,140 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/rule"
+	"github.com/google/uuid"
+)
+
+// SaveRule saves a rule and the devices it uses to the postgreSQL store, replacing the rule with the same ID,
+// a device the rule uses is removed from it when the device is deleted
+func (p *Store) SaveRule(r *rule.Rule) error {
+	definition, err := json.Marshal(&r.Definition)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	saveRuleSQL := `INSERT INTO rules(id, name, disabled, definition) VALUES($1,$2,$3,$4)
+					ON CONFLICT(id) DO UPDATE SET name = excluded.name, disabled = excluded.disabled, definition = excluded.definition;`
+	_, err = tx.ExecContext(ctx, saveRuleSQL, r.ID.String(), r.Name, booltoI(r.Disabled), string(definition))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = saveReferences(ctx, tx, "rule_devices", "rule_id", r.ID.String(), r.Devices())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeRuleDevice removes a device about to be deleted from the rules using it, see rule.Rule.RemoveDevice
+func removeRuleDevice(ctx context.Context, tx *sql.Tx, deviceID string) error {
+	ruleQuerySQL := "SELECT id, name, disabled, definition FROM rules WHERE id IN (SELECT rule_id FROM rule_devices WHERE device_id = $1)"
+	rows, err := tx.QueryContext(ctx, ruleQuerySQL, deviceID)
+	if err != nil {
+		return err
+	}
+	var rules []*rule.Rule
+	for rows.Next() {
+		r, err := scanRule(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		rules = append(rules, r)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, r := range rules {
+		r.RemoveDevice(deviceID)
+		definition, err := json.Marshal(&r.Definition)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE rules SET disabled = $1, definition = $2 WHERE id = $3;", booltoI(r.Disabled), string(definition), r.ID.String())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanRule(row interface{ Scan(...interface{}) error }) (*rule.Rule, error) {
+	var id string
+	var name string
+	var disabled int
+	var definition string
+	err := row.Scan(&id, &name, &disabled, &definition)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	r := &rule.Rule{
+		ID:       uid,
+		Name:     name,
+		Disabled: intToBool(disabled),
+	}
+	// numbers of conditions are kept as json.Number, the same as when the rule was received
+	err = device.DecodeJSON([]byte(definition), &r.Definition)
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetRule gets a rule by its ID from the postgreSQL store
+func (p *Store) GetRule(id string) (*rule.Rule, error) {
+	ruleQuerySQL := "SELECT id, name, disabled, definition FROM rules WHERE id = $1"
+	return scanRule(p.DB.QueryRow(ruleQuerySQL, id))
+}
+
+// GetRules gets every rule from the postgreSQL store, by name
+func (p *Store) GetRules() ([]*rule.Rule, error) {
+	ruleQuerySQL := "SELECT id, name, disabled, definition FROM rules ORDER BY name, id"
+	rows, err := p.DB.Query(ruleQuerySQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rules := []*rule.Rule{}
+	for rows.Next() {
+		r, err := scanRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+// DeleteRule deletes a rule by its ID from the postgreSQL store
+func (p *Store) DeleteRule(id string) error {
+	deleteRuleSQL := "DELETE FROM rules WHERE id = $1;"
+	res, err := p.DB.Exec(deleteRuleSQL, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
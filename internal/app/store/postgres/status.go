@@ -0,0 +1,25 @@
+package postgres
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/device"
+)
+
+// SetStatus sets the status and last seen time of a device, returns sql.ErrNoRows if the device does not exist
+func (p *Store) SetStatus(id string, status device.Status, lastSeen time.Time) error {
+	setStatusSQL := "UPDATE devices SET status = $1, last_seen = $2 WHERE id = $3;"
+	res, err := p.DB.Exec(setStatusSQL, string(status), timeToUnix(lastSeen), id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/device"
+)
+
+func durationToMillis(d time.Duration) int64 {
+	return int64(d / time.Millisecond)
+}
+
+func millisToDuration(i int64) time.Duration {
+	return time.Duration(i) * time.Millisecond
+}
+
+// saveServiceTimeouts replaces the service timeouts of a device,
+// they are kept by service name apart from the definition so they survive a reconnect
+func saveServiceTimeouts(ctx context.Context, tx *sql.Tx, d *device.Device) error {
+	deleteServiceTimeoutsSQL := "DELETE FROM service_timeouts WHERE device_id = $1;"
+	_, err := tx.ExecContext(ctx, deleteServiceTimeoutsSQL, d.ID.String())
+	if err != nil {
+		return err
+	}
+	insertServiceTimeoutSQL := "INSERT INTO service_timeouts(device_id, service, timeout_ms) VALUES($1,$2,$3);"
+	for service, timeout := range d.ServiceTimeouts {
+		if timeout <= 0 {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, insertServiceTimeoutSQL, d.ID.String(), service, durationToMillis(timeout))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getServiceTimeouts(db *sql.DB, id string) (map[string]time.Duration, error) {
+	serviceTimeoutSQL := "SELECT service, timeout_ms FROM service_timeouts WHERE device_id = $1"
+	rows, err := db.Query(serviceTimeoutSQL, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var timeouts map[string]time.Duration
+	for rows.Next() {
+		var service string
+		var timeoutMs int64
+		err := rows.Scan(&service, &timeoutMs)
+		if err != nil {
+			return nil, err
+		}
+		if timeouts == nil {
+			timeouts = make(map[string]time.Duration)
+		}
+		timeouts[service] = millisToDuration(timeoutMs)
+	}
+	return timeouts, rows.Err()
+}
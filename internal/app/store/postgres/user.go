@@ -0,0 +1,147 @@
+package postgres
+
+import (
+	"github.com/IktaS/go-home/internal/pkg/user"
+	"github.com/google/uuid"
+)
+
+// SaveUser saves a user to the postgreSQL store, replacing the user with the same ID
+func (p *Store) SaveUser(u *user.User) error {
+	saveUserSQL := `INSERT INTO users(id, name, role, password_hash, created_at) VALUES($1,$2,$3,$4,$5)
+					ON CONFLICT(id) DO UPDATE SET name = excluded.name, role = excluded.role, password_hash = excluded.password_hash;`
+	_, err := p.DB.Exec(saveUserSQL, u.ID.String(), u.Name, string(u.Role), u.PasswordHash, timeToUnix(u.CreatedAt))
+	return err
+}
+
+const userColumns = "id, name, role, password_hash, created_at"
+
+func scanUser(row interface{ Scan(...interface{}) error }) (*user.User, error) {
+	var id string
+	var name string
+	var role string
+	var passwordHash string
+	var createdAt int64
+	err := row.Scan(&id, &name, &role, &passwordHash, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	return &user.User{
+		ID:           uid,
+		Name:         name,
+		Role:         user.Role(role),
+		PasswordHash: passwordHash,
+		CreatedAt:    unixToTime(createdAt),
+	}, nil
+}
+
+// GetUser gets a user by its ID from the postgreSQL store
+func (p *Store) GetUser(id string) (*user.User, error) {
+	userQuerySQL := "SELECT " + userColumns + " FROM users WHERE id = $1"
+	return scanUser(p.DB.QueryRow(userQuerySQL, id))
+}
+
+// GetUserByName gets a user by its name from the postgreSQL store
+func (p *Store) GetUserByName(name string) (*user.User, error) {
+	userQuerySQL := "SELECT " + userColumns + " FROM users WHERE name = $1"
+	return scanUser(p.DB.QueryRow(userQuerySQL, name))
+}
+
+// GetUsers gets every user from the postgreSQL store, by name
+func (p *Store) GetUsers() ([]*user.User, error) {
+	userQuerySQL := "SELECT " + userColumns + " FROM users ORDER BY name"
+	rows, err := p.DB.Query(userQuerySQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	users := []*user.User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, u)
+	}
+	return users, rows.Err()
+}
+
+// DeleteUser deletes a user and its tokens from the postgreSQL store
+func (p *Store) DeleteUser(id string) error {
+	_, err := p.DB.Exec("DELETE FROM user_tokens WHERE user_id = $1;", id)
+	if err != nil {
+		return err
+	}
+	return p.execAffectingOne("DELETE FROM users WHERE id = $1;", id)
+}
+
+// SaveToken saves a token to the postgreSQL store
+func (p *Store) SaveToken(t *user.Token) error {
+	saveTokenSQL := "INSERT INTO user_tokens(hash, user_id, kind, name, expires_at, created_at) VALUES($1,$2,$3,$4,$5,$6);"
+	_, err := p.DB.Exec(saveTokenSQL, t.Hash, t.User.String(), string(t.Kind), t.Name, timePtrToUnix(t.ExpiresAt), timeToUnix(t.CreatedAt))
+	return err
+}
+
+const tokenColumns = "hash, user_id, kind, name, expires_at, created_at"
+
+func scanToken(row interface{ Scan(...interface{}) error }) (*user.Token, error) {
+	var hash string
+	var userID string
+	var kind string
+	var name string
+	var expiresAt int64
+	var createdAt int64
+	err := row.Scan(&hash, &userID, &kind, &name, &expiresAt, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := uuid.Parse(userID)
+	if err != nil {
+		return nil, err
+	}
+	t := &user.Token{
+		Hash:      hash,
+		User:      uid,
+		Kind:      user.TokenKind(kind),
+		Name:      name,
+		CreatedAt: unixToTime(createdAt),
+	}
+	if expiresAt != 0 {
+		e := unixToTime(expiresAt)
+		t.ExpiresAt = &e
+	}
+	return t, nil
+}
+
+// GetToken gets a token by its hash from the postgreSQL store
+func (p *Store) GetToken(hash string) (*user.Token, error) {
+	tokenQuerySQL := "SELECT " + tokenColumns + " FROM user_tokens WHERE hash = $1"
+	return scanToken(p.DB.QueryRow(tokenQuerySQL, hash))
+}
+
+// GetUserTokens gets the tokens of a user from the postgreSQL store, newest first
+func (p *Store) GetUserTokens(id string) ([]*user.Token, error) {
+	tokenQuerySQL := "SELECT " + tokenColumns + " FROM user_tokens WHERE user_id = $1 ORDER BY created_at DESC, hash"
+	rows, err := p.DB.Query(tokenQuerySQL, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tokens := []*user.Token{}
+	for rows.Next() {
+		t, err := scanToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, t)
+	}
+	return tokens, rows.Err()
+}
+
+// DeleteToken deletes a token by its hash from the postgreSQL store
+func (p *Store) DeleteToken(hash string) error {
+	return p.execAffectingOne("DELETE FROM user_tokens WHERE hash = $1;", hash)
+}
@@ -0,0 +1,131 @@
+package sqlite
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/event"
+	"github.com/google/uuid"
+)
+
+// events are kept in unix milliseconds, as a device may push more than one event a second
+func timeToUnixMilli(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// SaveEvent saves an event to the SQLite store, and sets its ID
+func (p *Store) SaveEvent(e *event.Event) error {
+	insertEventSQL := "INSERT INTO events(device_id, service, payload, created_at) VALUES(?,?,?,?);"
+	res, err := p.DB.Exec(insertEventSQL, e.DeviceID.String(), e.Service, string(e.Payload), timeToUnixMilli(e.CreatedAt))
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	e.ID = id
+	return nil
+}
+
+func unixMilliToTime(i int64) time.Time {
+	return time.Unix(0, i*int64(time.Millisecond))
+}
+
+// GetEvents gets the events matching a query from the SQLite store, newest first
+func (p *Store) GetEvents(q *event.Query) ([]*event.Event, error) {
+	var conditions []string
+	var args []interface{}
+	if q.DeviceID != "" {
+		conditions = append(conditions, "device_id = ?")
+		args = append(args, q.DeviceID)
+	}
+	if q.Service != "" {
+		conditions = append(conditions, "service = ?")
+		args = append(args, q.Service)
+	}
+	if !q.Since.IsZero() {
+		conditions = append(conditions, "created_at >= ?")
+		args = append(args, timeToUnixMilli(q.Since))
+	}
+	if !q.Until.IsZero() {
+		conditions = append(conditions, "created_at < ?")
+		args = append(args, timeToUnixMilli(q.Until))
+	}
+	if q.Before > 0 {
+		conditions = append(conditions, "id < ?")
+		args = append(args, q.Before)
+	}
+	eventQuerySQL := "SELECT id, device_id, service, payload, created_at FROM events"
+	if len(conditions) > 0 {
+		eventQuerySQL += " WHERE " + strings.Join(conditions, " AND ")
+	}
+	// ids grow with every insert, so the newest event has the biggest id
+	eventQuerySQL += " ORDER BY id DESC"
+	if q.Limit > 0 {
+		eventQuerySQL += " LIMIT ?"
+		args = append(args, q.Limit)
+	}
+	rows, err := p.DB.Query(eventQuerySQL, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	events := []*event.Event{}
+	for rows.Next() {
+		e, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+func scanEvent(row interface{ Scan(...interface{}) error }) (*event.Event, error) {
+	var id int64
+	var deviceID string
+	var service string
+	var payload string
+	var createdAt int64
+	err := row.Scan(&id, &deviceID, &service, &payload, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := uuid.Parse(deviceID)
+	if err != nil {
+		return nil, err
+	}
+	return &event.Event{
+		ID:        id,
+		DeviceID:  uid,
+		Service:   service,
+		Payload:   json.RawMessage(payload),
+		CreatedAt: unixMilliToTime(createdAt),
+	}, nil
+}
+
+// DeleteEventsBefore deletes every event created before t from the SQLite store
+func (p *Store) DeleteEventsBefore(t time.Time) (int64, error) {
+	deleteEventsSQL := "DELETE FROM events WHERE created_at < ?;"
+	res, err := p.DB.Exec(deleteEventsSQL, timeToUnixMilli(t))
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+// TrimEvents deletes every event of a device but its newest max from the SQLite store
+func (p *Store) TrimEvents(max int) (int64, error) {
+	trimEventsSQL := `DELETE FROM events WHERE id IN (
+						SELECT id FROM (
+							SELECT id, ROW_NUMBER() OVER (PARTITION BY device_id ORDER BY id DESC) AS n FROM events
+						) WHERE n > ?
+					);`
+	res, err := p.DB.Exec(trimEventsSQL, max)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
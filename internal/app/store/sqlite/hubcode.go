@@ -0,0 +1,91 @@
+package sqlite
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/auth"
+)
+
+func timeToUnix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func unixToTime(i int64) time.Time {
+	if i == 0 {
+		return time.Time{}
+	}
+	return time.Unix(i, 0)
+}
+
+// SaveHubCode saves a hub code to the SQLite store
+func (p *Store) SaveHubCode(c *auth.HubCode) error {
+	insertHubCodeSQL := "INSERT OR REPLACE INTO hub_codes(hash, single_use, expires_at, created_at) VALUES(?,?,?,?);"
+	_, err := p.DB.Exec(insertHubCodeSQL, c.Hash, booltoI(c.SingleUse), timeToUnix(c.ExpiresAt), timeToUnix(c.CreatedAt))
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func scanHubCode(row interface{ Scan(...interface{}) error }) (*auth.HubCode, error) {
+	var hash string
+	var singleUse int
+	var expiresAt int64
+	var createdAt int64
+	err := row.Scan(&hash, &singleUse, &expiresAt, &createdAt)
+	if err != nil {
+		return nil, err
+	}
+	return &auth.HubCode{
+		Hash:      hash,
+		SingleUse: intToBool(singleUse),
+		ExpiresAt: unixToTime(expiresAt),
+		CreatedAt: unixToTime(createdAt),
+	}, nil
+}
+
+// GetHubCode gets a hub code by its hash
+func (p *Store) GetHubCode(hash string) (*auth.HubCode, error) {
+	hubCodeQuerySQL := "SELECT hash, single_use, expires_at, created_at FROM hub_codes WHERE hash = ?"
+	return scanHubCode(p.DB.QueryRow(hubCodeQuerySQL, hash))
+}
+
+// GetAllHubCodes gets all hub code
+func (p *Store) GetAllHubCodes() ([]*auth.HubCode, error) {
+	hubCodeQuerySQL := "SELECT hash, single_use, expires_at, created_at FROM hub_codes"
+	rows, err := p.DB.Query(hubCodeQuerySQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var codes []*auth.HubCode
+	for rows.Next() {
+		c, err := scanHubCode(rows)
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, c)
+	}
+	return codes, rows.Err()
+}
+
+// DeleteHubCode deletes a hub code by its hash, returns sql.ErrNoRows if there is no such code
+func (p *Store) DeleteHubCode(hash string) error {
+	deleteHubCodeSQL := "DELETE FROM hub_codes WHERE hash = ?"
+	res, err := p.DB.Exec(deleteHubCodeSQL, hash)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
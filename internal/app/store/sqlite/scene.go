@@ -0,0 +1,126 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+
+	"github.com/IktaS/go-home/internal/pkg/scene"
+	"github.com/google/uuid"
+)
+
+// SaveScene saves a scene and the devices it calls to the SQLite store, replacing the scene with the same ID,
+// a device the scene calls is removed from it when the device is deleted
+func (p *Store) SaveScene(s *scene.Scene) error {
+	steps, err := json.Marshal(s.Steps)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	saveSceneSQL := `INSERT INTO scenes(id, name, mode, steps) VALUES(?,?,?,?)
+					ON CONFLICT(id) DO UPDATE SET name = excluded.name, mode = excluded.mode, steps = excluded.steps;`
+	_, err = tx.ExecContext(ctx, saveSceneSQL, s.ID.String(), s.Name, string(s.Mode), string(steps))
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = saveReferences(ctx, tx, "scene_devices", "scene_id", s.ID.String(), s.Devices())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// removeSceneDevice removes a device about to be deleted from the scenes calling it, see scene.Scene.RemoveDevice
+func removeSceneDevice(ctx context.Context, tx *sql.Tx, deviceID string) error {
+	sceneQuerySQL := "SELECT id, name, mode, steps FROM scenes WHERE id IN (SELECT scene_id FROM scene_devices WHERE device_id = ?)"
+	rows, err := tx.QueryContext(ctx, sceneQuerySQL, deviceID)
+	if err != nil {
+		return err
+	}
+	var scenes []*scene.Scene
+	for rows.Next() {
+		s, err := scanScene(rows)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		scenes = append(scenes, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for _, s := range scenes {
+		s.RemoveDevice(deviceID)
+		steps, err := json.Marshal(s.Steps)
+		if err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "UPDATE scenes SET steps = ? WHERE id = ?;", string(steps), s.ID.String())
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func scanScene(row interface{ Scan(...interface{}) error }) (*scene.Scene, error) {
+	var id string
+	var name string
+	var mode string
+	var steps string
+	err := row.Scan(&id, &name, &mode, &steps)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	s := &scene.Scene{
+		ID:   uid,
+		Name: name,
+		Mode: scene.Mode(mode),
+	}
+	err = json.Unmarshal([]byte(steps), &s.Steps)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// GetScene gets a scene by its ID from the SQLite store
+func (p *Store) GetScene(id string) (*scene.Scene, error) {
+	sceneQuerySQL := "SELECT id, name, mode, steps FROM scenes WHERE id = ?"
+	return scanScene(p.DB.QueryRow(sceneQuerySQL, id))
+}
+
+// GetScenes gets every scene from the SQLite store, by name
+func (p *Store) GetScenes() ([]*scene.Scene, error) {
+	sceneQuerySQL := "SELECT id, name, mode, steps FROM scenes ORDER BY name, id"
+	rows, err := p.DB.Query(sceneQuerySQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	scenes := []*scene.Scene{}
+	for rows.Next() {
+		s, err := scanScene(rows)
+		if err != nil {
+			return nil, err
+		}
+		scenes = append(scenes, s)
+	}
+	return scenes, rows.Err()
+}
+
+// DeleteScene deletes a scene by its ID from the SQLite store
+func (p *Store) DeleteScene(id string) error {
+	return p.execAffectingOne("DELETE FROM scenes WHERE id = ?;", id)
+}
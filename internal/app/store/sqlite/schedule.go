@@ -0,0 +1,125 @@
+package sqlite
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/schedule"
+	"github.com/google/uuid"
+)
+
+func timePtrToUnix(t *time.Time) int64 {
+	if t == nil {
+		return 0
+	}
+	return timeToUnix(*t)
+}
+
+// SaveSchedule saves a schedule to the SQLite store, replacing the schedule with the same ID
+func (p *Store) SaveSchedule(s *schedule.Schedule) error {
+	params, err := json.Marshal(s.Params)
+	if err != nil {
+		return err
+	}
+	saveScheduleSQL := `INSERT INTO schedules(id, name, disabled, cron, at, device_id, service, params) VALUES(?,?,?,?,?,?,?,?)
+						ON CONFLICT(id) DO UPDATE SET name = excluded.name, disabled = excluded.disabled, cron = excluded.cron, at = excluded.at,
+						device_id = excluded.device_id, service = excluded.service, params = excluded.params;`
+	_, err = p.DB.Exec(saveScheduleSQL, s.ID.String(), s.Name, booltoI(s.Disabled), s.Cron, timePtrToUnix(s.At), s.Device, s.Service, string(params))
+	return err
+}
+
+const scheduleColumns = "id, name, disabled, cron, at, device_id, service, params, last_run_at, last_error"
+
+func scanSchedule(row interface{ Scan(...interface{}) error }) (*schedule.Schedule, error) {
+	var id string
+	var name string
+	var disabled int
+	var cron string
+	var at int64
+	var deviceID string
+	var service string
+	var params string
+	var lastRunAt int64
+	var lastError string
+	err := row.Scan(&id, &name, &disabled, &cron, &at, &deviceID, &service, &params, &lastRunAt, &lastError)
+	if err != nil {
+		return nil, err
+	}
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	s := &schedule.Schedule{
+		ID:       uid,
+		Name:     name,
+		Disabled: intToBool(disabled),
+		Cron:     cron,
+	}
+	s.Device = deviceID
+	s.Service = service
+	err = json.Unmarshal([]byte(params), &s.Params)
+	if err != nil {
+		return nil, err
+	}
+	if at != 0 {
+		t := unixToTime(at)
+		s.At = &t
+	}
+	if lastRunAt != 0 {
+		s.LastRun = &schedule.Result{At: unixToTime(lastRunAt), OK: lastError == "", Error: lastError}
+	}
+	return s, nil
+}
+
+// GetSchedule gets a schedule by its ID from the SQLite store
+func (p *Store) GetSchedule(id string) (*schedule.Schedule, error) {
+	scheduleQuerySQL := "SELECT " + scheduleColumns + " FROM schedules WHERE id = ?"
+	return scanSchedule(p.DB.QueryRow(scheduleQuerySQL, id))
+}
+
+// GetSchedules gets every schedule from the SQLite store, by name
+func (p *Store) GetSchedules() ([]*schedule.Schedule, error) {
+	scheduleQuerySQL := "SELECT " + scheduleColumns + " FROM schedules ORDER BY name, id"
+	rows, err := p.DB.Query(scheduleQuerySQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	schedules := []*schedule.Schedule{}
+	for rows.Next() {
+		s, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, s)
+	}
+	return schedules, rows.Err()
+}
+
+// execAffectingOne runs a statement that must affect a row, returns sql.ErrNoRows if it affected none
+func (p *Store) execAffectingOne(query string, args ...interface{}) error {
+	res, err := p.DB.Exec(query, args...)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// DeleteSchedule deletes a schedule by its ID from the SQLite store
+func (p *Store) DeleteSchedule(id string) error {
+	return p.execAffectingOne("DELETE FROM schedules WHERE id = ?;", id)
+}
+
+// SetScheduleResult sets the last run of a schedule in the SQLite store
+func (p *Store) SetScheduleResult(id string, r *schedule.Result) error {
+	setResultSQL := "UPDATE schedules SET last_run_at = ?, last_error = ? WHERE id = ?;"
+	return p.execAffectingOne(setResultSQL, timeToUnix(r.At), r.Error, id)
+}
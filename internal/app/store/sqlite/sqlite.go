@@ -3,13 +3,11 @@ package sqlite
 import (
 	"context"
 	"database/sql"
-	"log"
-	"net"
 	"os"
-	"strconv"
-	"strings"
 
+	"github.com/IktaS/go-home/internal/app/store/migrations"
 	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/logging"
 	"github.com/IktaS/go-serv/pkg/serv"
 	"github.com/google/uuid"
 	_ "github.com/mattn/go-sqlite3" // import sqlite3 driver
@@ -72,149 +70,71 @@ func NewSQLiteStore(filename string) (*Store, error) {
 	return p, nil
 }
 
-// Init initialize a SQLite
-func (p *Store) Init(config interface{}) error {
-	filename := config.(string)
+// Open opens the SQLite database file, creating it if it does not exist yet, or a database in memory for Memory, without migrating it
+func Open(filename string) (*sql.DB, error) {
+	if filename == Memory {
+		return openMemory()
+	}
 	if _, err := os.Stat(filename); err == nil {
 		// database exists
-		log.Println("Database exist, skipped making database file")
+		logging.Debugf("Database exist, skipped making database file\n")
 	} else if os.IsNotExist(err) {
 		// database does not exist
 		file, err := os.Create(filename)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		file.Close()
 	} else {
 		// Schrodinger: file may or may not exist. See err for details.
 
 		// Therefore, do *NOT* use !os.IsNotExist(err) to test for file existence
-		return err
+		return nil, err
 	}
-	db, err := sql.Open("sqlite3", "./"+filename)
+	// enable foreign key, through the DSN so every pooled connection has it and not only the first one
+	db, err := sql.Open("sqlite3", filename+"?_foreign_keys=on")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	err = db.Ping()
 	if err != nil {
-		return err
-	}
-
-	//enable foreign key
-	statement, err := db.Prepare(`PRAGMA foreign_keys = ON;`)
-	if err != nil {
-		return err
-	}
-	_, err = statement.Exec()
-	if err != nil {
-		return err
-	}
-
-	// Create Devices Table
-	createDevicesTableSQL := `CREATE TABLE IF NOT EXISTS devices(
-		"id" TEXT NOT NULL PRIMARY KEY,
-		"name" TEXT,
-		"addr" TEXT
-	);`
-
-	statement, err = db.Prepare(createDevicesTableSQL)
-	if err != nil {
-		return err
-	}
-	_, err = statement.Exec()
-	if err != nil {
-		return err
-	}
-
-	// Create ServiceResponse Table
-	createServiceResponseTableSQL := `CREATE TABLE IF NOT EXISTS service_response(
-		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
-		"is_scalar" INTEGER,
-		"value" TEXT
-	);`
-
-	statement, err = db.Prepare(createServiceResponseTableSQL)
-	if err != nil {
-		return err
-	}
-	_, err = statement.Exec()
-	if err != nil {
-		return err
-	}
-
-	// Create Services Table
-	createServicesTableSQL := `CREATE TABLE IF NOT EXISTS services(
-		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
-		"device_id" TEXT NOT NULL,
-		"is_inbound" INTEGER,
-		"name" TEXT,
-		"response_id" INTEGER DEFAULT NULL,
-		FOREIGN KEY (device_id) REFERENCES devices (id) ON UPDATE CASCADE ON DELETE CASCADE,
-		FOREIGN KEY (response_id) REFERENCES service_response (id) ON UPDATE CASCADE ON DELETE CASCADE
-	);`
-
-	statement, err = db.Prepare(createServicesTableSQL)
-	if err != nil {
-		return err
-	}
-	_, err = statement.Exec()
-	if err != nil {
-		return err
+		return nil, err
 	}
+	return db, nil
+}
 
-	// Create ServiceRequest Table
-	createServiceRequestTableSQL := `CREATE TABLE IF NOT EXISTS service_request(
-		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
-		"service_id" INTEGER NOT NULL,
-		"is_scalar" INTEGER,
-		"value" TEXT
-	);`
+// Memory is the file name of a database kept in memory, it is lost when the hub stops
+const Memory = ":memory:"
 
-	statement, err = db.Prepare(createServiceRequestTableSQL)
+// openMemory opens a new database kept in memory on a single connection, so queries wait for each other instead of locking,
+// the database is gone once the connection is closed
+func openMemory() (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", "file:"+uuid.New().String()+"?mode=memory&_foreign_keys=on")
 	if err != nil {
-		return err
+		return nil, err
 	}
-	_, err = statement.Exec()
+	db.SetConnMaxLifetime(0)
+	db.SetMaxOpenConns(1)
+	err = db.Ping()
 	if err != nil {
-		return err
+		return nil, err
 	}
+	return db, nil
+}
 
-	// Create Messages Table
-	createMessagesTableSQL := `CREATE TABLE IF NOT EXISTS messages(
-		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
-		"device_id" TEXT NOT NULL,
-		"name" TEXT,
-		FOREIGN KEY (device_id) REFERENCES devices (id) ON UPDATE CASCADE ON DELETE CASCADE
-	);`
-
-	statement, err = db.Prepare(createMessagesTableSQL)
-	if err != nil {
-		return err
-	}
-	_, err = statement.Exec()
+// Init initialize a SQLite, and applies every pending migration
+func (p *Store) Init(config interface{}) error {
+	filename := config.(string)
+	db, err := Open(filename)
 	if err != nil {
 		return err
 	}
-
-	// Create MessageDefinitionFields Table
-	createMessageDefinitionFieldsTableSQL := `CREATE TABLE IF NOT EXISTS message_definition_fields(
-		"id" INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
-		"message_id" INTEGER NOT NULL,
-		"name" TEXT,
-		"is_optional" TEXT,
-		"is_required" TEXT,
-		"is_scalar" INTEGER,
-		"value" TEXT,
-		FOREIGN KEY (message_id) REFERENCES messages(id) ON UPDATE CASCADE ON DELETE CASCADE
-	);`
-
-	statement, err = db.Prepare(createMessageDefinitionFieldsTableSQL)
+	applied, err := migrations.NewMigrator(db, migrations.SQLite).Up()
 	if err != nil {
 		return err
 	}
-	_, err = statement.Exec()
-	if err != nil {
-		return err
+	for _, m := range applied {
+		logging.Infof("Applied migration %v : %v\n", m.Version, m.Description)
 	}
 	p.DB = db
 	return nil
@@ -243,8 +163,16 @@ func (p *Store) Save(d *device.Device) error {
 	if err != nil {
 		return err
 	}
-	insertDeviceSQL := "INSERT OR REPLACE INTO devices(id, name, addr) VALUES(?,?,?);"
-	_, err = tx.ExecContext(ctx, insertDeviceSQL, d.ID.String(), d.Name, d.Addr.String())
+	// an upsert and not INSERT OR REPLACE, replacing the row would cascade the delete to the device definition
+	insertDeviceSQL := `INSERT INTO devices(id, name, addr, timeout_ms, status, last_seen, secret_hash, transport) VALUES(?,?,?,?,?,?,?,?)
+						ON CONFLICT(id) DO UPDATE SET name = excluded.name, addr = excluded.addr, timeout_ms = excluded.timeout_ms,
+						status = excluded.status, last_seen = excluded.last_seen, secret_hash = excluded.secret_hash, transport = excluded.transport;`
+	_, err = tx.ExecContext(ctx, insertDeviceSQL, d.ID.String(), d.Name, d.Addr.String(), durationToMillis(d.Timeout), string(d.Status), timeToUnix(d.LastSeen), d.SecretHash, d.Transport)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = saveServiceTimeouts(ctx, tx, d)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -283,6 +211,90 @@ func (p *Store) Save(d *device.Device) error {
 	return nil
 }
 
+// Update updates an existing device, replacing its services and messages, returns sql.ErrNoRows if the device does not exist
+func (p *Store) Update(d *device.Device) error {
+	ctx := context.Background()
+	tx, err := p.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	updateDeviceSQL := "UPDATE devices SET name = ?, addr = ?, timeout_ms = ?, status = ?, last_seen = ?, secret_hash = ?, transport = ? WHERE id = ?;"
+	res, err := tx.ExecContext(ctx, updateDeviceSQL, d.Name, d.Addr.String(), durationToMillis(d.Timeout), string(d.Status), timeToUnix(d.LastSeen), d.SecretHash, d.Transport, d.ID.String())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n == 0 {
+		tx.Rollback()
+		return sql.ErrNoRows
+	}
+	err = saveServiceTimeouts(ctx, tx, d)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = deleteDefinition(ctx, tx, d.ID.String())
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	for _, m := range d.Messages {
+		err := insertMessage(ctx, tx, d.ID, m)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, s := range d.Services {
+		err := insertService(ctx, tx, d.ID, s)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// deleteDefinition deletes every service and message of a device,
+// children rows are deleted explicitly as service_request and service_response have no cascade from the device
+func deleteDefinition(ctx context.Context, tx *sql.Tx, id string) error {
+	deleteDefinitionSQL := []string{
+		"DELETE FROM service_request WHERE service_id IN (SELECT id FROM services WHERE device_id = ?);",
+		"DELETE FROM message_definition_fields WHERE message_id IN (SELECT id FROM messages WHERE device_id = ?);",
+		"DELETE FROM service_response WHERE id IN (SELECT response_id FROM services WHERE device_id = ?);",
+		"DELETE FROM services WHERE device_id = ?;",
+		"DELETE FROM messages WHERE device_id = ?;",
+	}
+	for _, statement := range deleteDefinitionSQL {
+		_, err := tx.ExecContext(ctx, statement, id)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// saveReferences replaces the devices an item refers to in a table of references, the devices that do not exist are skipped
+func saveReferences(ctx context.Context, tx *sql.Tx, table string, column string, id string, devices []string) error {
+	_, err := tx.ExecContext(ctx, "DELETE FROM "+table+" WHERE "+column+" = ?;", id)
+	if err != nil {
+		return err
+	}
+	for _, d := range devices {
+		insertReferenceSQL := "INSERT OR IGNORE INTO " + table + "(" + column + ", device_id) SELECT ?, id FROM devices WHERE id = ?;"
+		_, err = tx.ExecContext(ctx, insertReferenceSQL, id, d)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func insertMessage(ctx context.Context, tx *sql.Tx, devID uuid.UUID, m *serv.Message) error {
 	if m == nil {
 		return nil
@@ -363,8 +375,8 @@ func insertService(ctx context.Context, tx *sql.Tx, devID uuid.UUID, s *serv.Ser
 			return err
 		}
 	}
-	for _, r := range s.Request {
-		err := insertServiceRequest(ctx, tx, serviceID, r)
+	for i, r := range s.Request {
+		err := insertServiceRequest(ctx, tx, serviceID, i, r)
 		if err != nil {
 			return err
 		}
@@ -389,13 +401,13 @@ func insertServiceResponse(ctx context.Context, tx *sql.Tx, t *serv.Type) (int64
 	return id, nil
 }
 
-func insertServiceRequest(ctx context.Context, tx *sql.Tx, id int64, t *serv.Type) error {
+func insertServiceRequest(ctx context.Context, tx *sql.Tx, id int64, position int, t *serv.Type) error {
 	if t == nil {
 		return nil
 	}
 	isScalar, value := typeToDBModel(t)
-	insertServiceRequestSQL := "INSERT OR IGNORE INTO service_request(service_id, is_scalar, value) VALUES(?,?,?);"
-	_, err := tx.ExecContext(ctx, insertServiceRequestSQL, id, isScalar, value)
+	insertServiceRequestSQL := "INSERT OR IGNORE INTO service_request(service_id, is_scalar, value, position) VALUES(?,?,?,?);"
+	_, err := tx.ExecContext(ctx, insertServiceRequestSQL, id, isScalar, value, position)
 	if err != nil {
 		return err
 	}
@@ -405,43 +417,68 @@ func insertServiceRequest(ctx context.Context, tx *sql.Tx, id int64, t *serv.Typ
 // Get defines getting a device.Device, accept id as string
 func (p *Store) Get(id interface{}) (*device.Device, error) {
 	id = id.(string)
-	deviceQuerySQL := "SELECT * FROM devices WHERE id = ?"
-	deviceRow := p.DB.QueryRow(deviceQuerySQL, id)
-	var uuID string
-	var name string
-	var addr string
-	err := deviceRow.Scan(&uuID, &name, &addr)
+	deviceQuerySQL := "SELECT " + deviceColumns + " FROM devices WHERE id = ?"
+	d, err := scanDevice(p.DB.QueryRow(deviceQuerySQL, id))
 	if err != nil {
 		return nil, err
 	}
-	dev, err := dbDeviceToDevice(p.DB, uuID, name, addr)
+	dev, err := dbDeviceToDevice(p.DB, d)
 	if err != nil {
 		return nil, err
 	}
 	return dev, nil
 }
 
-func dbDeviceToDevice(db *sql.DB, id string, name string, addr string) (*device.Device, error) {
+// deviceColumns are the columns of devices that scanDevice scans
+const deviceColumns = "id, name, addr, timeout_ms, status, last_seen, secret_hash, transport"
+
+// dbDevice defines a row of devices
+type dbDevice struct {
+	id        string
+	name      string
+	addr      string
+	timeoutMs int64
+	status    string
+	lastSeen  int64
+	secret    string
+	transport string
+}
+
+func scanDevice(row interface{ Scan(...interface{}) error }) (*dbDevice, error) {
+	d := &dbDevice{}
+	err := row.Scan(&d.id, &d.name, &d.addr, &d.timeoutMs, &d.status, &d.lastSeen, &d.secret, &d.transport)
+	if err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func dbDeviceToDevice(db *sql.DB, d *dbDevice) (*device.Device, error) {
+	id := d.id
+	addr := d.addr
 	uid, err := uuid.Parse(id)
 	if err != nil {
 		return nil, err
 	}
-	parsedAddr := strings.Split(addr, ":")
-	ip := net.ParseIP(parsedAddr[0])
-	port := 80
-	if len(parsedAddr) > 1 {
-		port, err = strconv.Atoi(parsedAddr[1])
-		if err != nil {
-			port = 80
-		}
+	endpoint, err := device.ParseEndpoint(addr)
+	if err != nil {
+		// an address stored before endpoints, e.g. of a device that connected over IPv6, is left empty
+		// until the device reconnects or its address is overridden
+		endpoint = &device.Endpoint{}
 	}
 	dev := &device.Device{
-		ID:   uid,
-		Name: name,
-		Addr: &net.TCPAddr{
-			IP:   ip,
-			Port: port,
-		},
+		ID:         uid,
+		Name:       d.name,
+		Addr:       endpoint,
+		Timeout:    millisToDuration(d.timeoutMs),
+		Status:     device.Status(d.status),
+		LastSeen:   unixToTime(d.lastSeen),
+		SecretHash: d.secret,
+		Transport:  d.transport,
+	}
+	dev.ServiceTimeouts, err = getServiceTimeouts(db, id)
+	if err != nil {
+		return nil, err
 	}
 	messageQuerySQL := "SELECT * FROM messages WHERE device_id = ?"
 	messageRows, err := db.Query(messageQuerySQL, id)
@@ -454,7 +491,7 @@ func dbDeviceToDevice(db *sql.DB, id string, name string, addr string) (*device.
 		return nil, err
 	}
 
-	serviceQuerySQL := "SELECT * FROM services WHERE device_id = ?"
+	serviceQuerySQL := "SELECT id, device_id, name, is_inbound, response_id FROM services WHERE device_id = ?"
 	serviceRows, err := db.Query(serviceQuerySQL, id)
 	defer serviceRows.Close()
 	if err != nil {
@@ -513,7 +550,10 @@ func getMessageDefinition(db *sql.DB, mesID int) ([]*serv.MessageDefinition, err
 	return messageDefinitions, nil
 }
 
+// messageRowsToMessages reads every message of rows and closes them before their definitions are queried,
+// as a database kept in memory has a single connection
 func messageRowsToMessages(db *sql.DB, rows *sql.Rows) ([]*serv.Message, error) {
+	var ids []int
 	var messages []*serv.Message
 	for rows.Next() {
 		var id int
@@ -523,16 +563,19 @@ func messageRowsToMessages(db *sql.DB, rows *sql.Rows) ([]*serv.Message, error)
 		if err != nil {
 			return nil, err
 		}
-		messageDefinitions, err := getMessageDefinition(db, id)
+		ids = append(ids, id)
+		messages = append(messages, &serv.Message{Name: name})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	for i, m := range messages {
+		messageDefinitions, err := getMessageDefinition(db, ids[i])
 		if err != nil {
 			return nil, err
 		}
-
-		message := &serv.Message{
-			Name:        name,
-			Definitions: messageDefinitions,
-		}
-		messages = append(messages, message)
+		m.Definitions = messageDefinitions
 	}
 	return messages, nil
 }
@@ -550,7 +593,7 @@ func getServiceResponse(db *sql.DB, id int) (*serv.Type, error) {
 }
 
 func getServiceRequest(db *sql.DB, serviceID int) ([]*serv.Type, error) {
-	serviceRequestSQL := "SELECT * FROM service_request WHERE service_id = ?"
+	serviceRequestSQL := "SELECT id, service_id, is_scalar, value FROM service_request WHERE service_id = ? ORDER BY position, id"
 	serviceRequestRows, err := db.Query(serviceRequestSQL, serviceID)
 	if err != nil {
 		return nil, err
@@ -571,7 +614,14 @@ func getServiceRequest(db *sql.DB, serviceID int) ([]*serv.Type, error) {
 	return requests, nil
 }
 
+// serviceRowsToServices reads every service of rows and closes them before their requests and responses are queried,
+// as a database kept in memory has a single connection
 func serviceRowsToServices(db *sql.DB, rows *sql.Rows) ([]*serv.Service, error) {
+	type serviceRow struct {
+		id         int
+		responseID sql.NullInt64
+	}
+	var serviceRows []serviceRow
 	var services []*serv.Service
 	for rows.Next() {
 		var id int
@@ -583,50 +633,58 @@ func serviceRowsToServices(db *sql.DB, rows *sql.Rows) ([]*serv.Service, error)
 		if err != nil {
 			return nil, err
 		}
-		var response *serv.Type
-		if responseID.Valid {
-			res, err := getServiceResponse(db, int(responseID.Int64))
-			response = res
+		serviceRows = append(serviceRows, serviceRow{id: id, responseID: responseID})
+		services = append(services, &serv.Service{
+			Name:     name,
+			Inbound:  (isInbound == 1),
+			Outbound: (isInbound == 0),
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+	for i, s := range services {
+		row := serviceRows[i]
+		if row.responseID.Valid {
+			res, err := getServiceResponse(db, int(row.responseID.Int64))
 			if err != nil {
 				return nil, err
 			}
-		} else {
-			response = nil
+			s.Response = res
 		}
-
-		requests, err := getServiceRequest(db, id)
+		requests, err := getServiceRequest(db, row.id)
 		if err != nil {
 			return nil, err
 		}
-		services = append(services, &serv.Service{
-			Name:     name,
-			Inbound:  (isInbound == 1),
-			Outbound: (isInbound == 0),
-			Request:  requests,
-			Response: response,
-		})
+		s.Request = requests
 	}
 	return services, nil
 }
 
 // GetAll gets all device
 func (p *Store) GetAll() ([]*device.Device, error) {
-	deviceQuerySQL := "SELECT * FROM devices"
+	deviceQuerySQL := "SELECT " + deviceColumns + " FROM devices"
 	deviceRows, err := p.DB.Query(deviceQuerySQL)
 	defer deviceRows.Close()
 	if err != nil {
 		return nil, err
 	}
-	var devices []*device.Device
+	var dbDevices []*dbDevice
 	for deviceRows.Next() {
-		var uuID string
-		var name string
-		var addr string
-		err := deviceRows.Scan(&uuID, &name, &addr)
+		d, err := scanDevice(deviceRows)
 		if err != nil {
 			return nil, err
 		}
-		device, err := dbDeviceToDevice(p.DB, uuID, name, addr)
+		dbDevices = append(dbDevices, d)
+	}
+	if err := deviceRows.Err(); err != nil {
+		return nil, err
+	}
+	deviceRows.Close()
+	var devices []*device.Device
+	for _, d := range dbDevices {
+		device, err := dbDeviceToDevice(p.DB, d)
 		if err != nil {
 			return nil, err
 		}
@@ -635,20 +693,45 @@ func (p *Store) GetAll() ([]*device.Device, error) {
 	return devices, nil
 }
 
-// Delete defines getting a device.Device, accepts a string as ID
+// Delete defines deleting a device.Device and its definition, accepts a string as ID, returns sql.ErrNoRows if the device does not exist
 func (p *Store) Delete(id interface{}) error {
-	id = id.(string)
+	idStr := id.(string)
 	ctx := context.Background()
 	tx, err := p.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	err = deleteDefinition(ctx, tx, idStr)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = removeRuleDevice(ctx, tx, idStr)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	err = removeSceneDevice(ctx, tx, idStr)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	// timeouts, events, schedules and group memberships are deleted by their foreign keys
 	deleteDeviceSQL := "DELETE FROM devices WHERE id = ?"
-	_, err = tx.ExecContext(ctx, deleteDeviceSQL, id)
+	res, err := tx.ExecContext(ctx, deleteDeviceSQL, idStr)
 	if err != nil {
 		tx.Rollback()
 		return err
 	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n == 0 {
+		tx.Rollback()
+		return sql.ErrNoRows
+	}
 	err = tx.Commit()
 	if err != nil {
 		return err
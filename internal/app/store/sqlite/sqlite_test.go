@@ -1,13 +1,24 @@
 package sqlite
 
 import (
-	"net"
+	"database/sql"
+	"encoding/json"
 	"os"
+	"path/filepath"
 	"regexp"
+	"sort"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/IktaS/go-home/internal/pkg/action"
 	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/event"
+	"github.com/IktaS/go-home/internal/pkg/group"
+	"github.com/IktaS/go-home/internal/pkg/rule"
+	"github.com/IktaS/go-home/internal/pkg/scene"
+	"github.com/IktaS/go-home/internal/pkg/schedule"
+	"github.com/IktaS/go-home/internal/pkg/user"
 	"github.com/IktaS/go-serv/pkg/serv"
 	"github.com/google/uuid"
 	_ "github.com/mattn/go-sqlite3"
@@ -34,6 +45,16 @@ func TestNewSQLiteStore(t *testing.T) {
 			},
 			wantErr: false,
 		},
+		{
+			name:     "Memory",
+			setup:    func(t *testing.T, filename string) {},
+			filename: Memory,
+			teardown: func(t *testing.T, filename string) {
+				_, err := os.Stat(filename)
+				assert.True(t, os.IsNotExist(err), "no file is made")
+			},
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -76,8 +97,12 @@ func TestStore_Save(t *testing.T) {
 				mock.ExpectBegin()
 
 				mock.ExpectExec(
-					"INSERT OR REPLACE INTO devices",
-				).WithArgs(d.ID.String(), d.Name, d.Addr.String()).WillReturnResult(sqlmock.NewResult(1, 1))
+					"INSERT INTO devices",
+				).WithArgs(d.ID.String(), d.Name, d.Addr.String(), 0, "", 0, "", "").WillReturnResult(sqlmock.NewResult(1, 1))
+
+				mock.ExpectExec(
+					regexp.QuoteMeta("DELETE FROM service_timeouts WHERE device_id = ?"),
+				).WithArgs(d.ID.String()).WillReturnResult(sqlmock.NewResult(0, 0))
 
 				mock.ExpectExec(
 					"INSERT OR IGNORE INTO messages",
@@ -97,7 +122,7 @@ func TestStore_Save(t *testing.T) {
 
 				mock.ExpectExec(
 					"INSERT OR IGNORE INTO service_request",
-				).WithArgs(1, 0, "TestMessage").WillReturnResult(sqlmock.NewResult(1, 1))
+				).WithArgs(1, 0, "TestMessage", 0).WillReturnResult(sqlmock.NewResult(1, 1))
 
 				mock.ExpectCommit()
 
@@ -109,9 +134,9 @@ func TestStore_Save(t *testing.T) {
 			input: &device.Device{
 				ID:   uuid.New(),
 				Name: "test-device",
-				Addr: &net.TCPAddr{
-					IP:   net.IPv4(127, 0, 0, 1),
-					Port: 80,
+				Addr: &device.Endpoint{
+					Host: "127.0.0.1",
+					Port: "80",
 				},
 				Services: []*serv.Service{
 					{
@@ -165,8 +190,12 @@ func TestStore_Save(t *testing.T) {
 				mock.ExpectBegin()
 
 				mock.ExpectExec(
-					"INSERT OR REPLACE INTO devices",
-				).WithArgs(d.ID.String(), d.Name, d.Addr.String()).WillReturnResult(sqlmock.NewResult(1, 1))
+					"INSERT INTO devices",
+				).WithArgs(d.ID.String(), d.Name, d.Addr.String(), 0, "", 0, "", "").WillReturnResult(sqlmock.NewResult(1, 1))
+
+				mock.ExpectExec(
+					regexp.QuoteMeta("DELETE FROM service_timeouts WHERE device_id = ?"),
+				).WithArgs(d.ID.String()).WillReturnResult(sqlmock.NewResult(0, 0))
 
 				mock.ExpectExec(
 					"INSERT OR IGNORE INTO services",
@@ -182,9 +211,9 @@ func TestStore_Save(t *testing.T) {
 			input: &device.Device{
 				ID:   uuid.New(),
 				Name: "Device1",
-				Addr: &net.TCPAddr{
-					IP:   net.IPv4(127, 0, 0, 1),
-					Port: 80,
+				Addr: &device.Endpoint{
+					Host: "127.0.0.1",
+					Port: "80",
 				},
 				Services: []*serv.Service{
 					{
@@ -217,8 +246,12 @@ func TestStore_Save(t *testing.T) {
 				mock.ExpectBegin()
 
 				mock.ExpectExec(
-					"INSERT OR REPLACE INTO devices",
-				).WithArgs(d.ID.String(), d.Name, d.Addr.String()).WillReturnResult(sqlmock.NewResult(1, 1))
+					"INSERT INTO devices",
+				).WithArgs(d.ID.String(), d.Name, d.Addr.String(), 0, "", 0, "", "").WillReturnResult(sqlmock.NewResult(1, 1))
+
+				mock.ExpectExec(
+					regexp.QuoteMeta("DELETE FROM service_timeouts WHERE device_id = ?"),
+				).WithArgs(d.ID.String()).WillReturnResult(sqlmock.NewResult(0, 0))
 
 				mock.ExpectCommit()
 
@@ -230,9 +263,9 @@ func TestStore_Save(t *testing.T) {
 			input: &device.Device{
 				ID:   uuid.New(),
 				Name: "Device1",
-				Addr: &net.TCPAddr{
-					IP:   net.IPv4(127, 0, 0, 1),
-					Port: 80,
+				Addr: &device.Endpoint{
+					Host: "127.0.0.1",
+					Port: "80",
 				},
 				Services: []*serv.Service{
 					{
@@ -288,11 +321,14 @@ func TestStore_Get(t *testing.T) {
 
 				// setup database filling
 				//device filling
-				deviceRows := sqlmock.NewRows([]string{"id", "name", "addr"}).
-					AddRow(deviceID, "test-device", "127.0.0.1:80")
+				deviceRows := sqlmock.NewRows([]string{"id", "name", "addr", "timeout_ms", "status", "last_seen", "secret_hash", "transport"}).
+					AddRow(deviceID, "test-device", "127.0.0.1:80", 0, "", 0, "", "")
 				mock.ExpectQuery(
-					regexp.QuoteMeta("SELECT * FROM devices WHERE id"),
+					regexp.QuoteMeta("SELECT id, name, addr, timeout_ms, status, last_seen, secret_hash, transport FROM devices WHERE id"),
 				).WithArgs(deviceID).WillReturnRows(deviceRows)
+				mock.ExpectQuery(
+					regexp.QuoteMeta("SELECT service, timeout_ms FROM service_timeouts WHERE device_id"),
+				).WithArgs(deviceID).WillReturnRows(sqlmock.NewRows([]string{"service", "timeout_ms"}))
 
 				//messages filling
 				messageID := 1
@@ -314,7 +350,7 @@ func TestStore_Get(t *testing.T) {
 				serviceRows := sqlmock.NewRows([]string{"id", "device_id", "name", "is_inbound", "response_id"}).
 					AddRow(serviceID, deviceID, "TestService", 1, responseID)
 				mock.ExpectQuery(
-					regexp.QuoteMeta("SELECT * FROM services WHERE device_id"),
+					regexp.QuoteMeta("SELECT id, device_id, name, is_inbound, response_id FROM services WHERE device_id"),
 				).WithArgs(deviceID).WillReturnRows(serviceRows)
 
 				responseRows := sqlmock.NewRows([]string{"id", "is_scalar", "value"}).
@@ -326,7 +362,7 @@ func TestStore_Get(t *testing.T) {
 				requestRows := sqlmock.NewRows([]string{"id", "service_id", "is_scalar", "value"}).
 					AddRow(1, serviceID, 0, "TestMessage")
 				mock.ExpectQuery(
-					regexp.QuoteMeta("SELECT * FROM service_request WHERE service_id"),
+					regexp.QuoteMeta("SELECT id, service_id, is_scalar, value FROM service_request WHERE service_id"),
 				).WithArgs(serviceID).WillReturnRows(requestRows)
 				return s, mock
 			},
@@ -336,9 +372,9 @@ func TestStore_Get(t *testing.T) {
 			expected: &device.Device{
 				ID:   uuid.New(),
 				Name: "test-device",
-				Addr: &net.TCPAddr{
-					IP:   net.IPv4(127, 0, 0, 1),
-					Port: 80,
+				Addr: &device.Endpoint{
+					Host: "127.0.0.1",
+					Port: "80",
 				},
 				Services: []*serv.Service{
 					{
@@ -403,7 +439,39 @@ func TestStore_Delete(t *testing.T) {
 		input    string
 		wantErr  bool
 	}{
-		// TODO: Add test cases.
+		{
+			name: "Delete existing device",
+			setup: func(t *testing.T) (*Store, sqlmock.Sqlmock) {
+				db, mock, err := sqlmock.New()
+				if err != nil {
+					t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+				}
+				s := &Store{
+					FileName: "test_sqlite.db",
+					DB:       db,
+				}
+				mock.ExpectBegin()
+				mock.ExpectExec("DELETE FROM service_request").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("DELETE FROM message_definition_fields").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("DELETE FROM service_response").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("DELETE FROM services").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectExec("DELETE FROM messages").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectQuery("FROM rules WHERE id IN").WithArgs("device-id").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "disabled", "definition"}))
+				mock.ExpectQuery("FROM scenes WHERE id IN").WithArgs("device-id").
+					WillReturnRows(sqlmock.NewRows([]string{"id", "name", "mode", "steps"}))
+				mock.ExpectExec(
+					regexp.QuoteMeta("DELETE FROM devices WHERE id = ?"),
+				).WithArgs("device-id").WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+				return s, mock
+			},
+			teardown: func(t *testing.T, s *Store) {
+				s.DB.Close()
+			},
+			input:   "device-id",
+			wantErr: false,
+		},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
@@ -424,3 +492,484 @@ func TestStore_Delete(t *testing.T) {
 		})
 	}
 }
+
+func TestStore_Update(t *testing.T) {
+	p, err := NewSQLiteStore(filepath.Join(t.TempDir(), "update.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.DB.Close()
+	addr := &device.Endpoint{
+		Host: "127.0.0.1",
+		Port: "80",
+	}
+	dev, err := device.NewDevice("Device1", addr, []byte(`message TestMessage{string TestString;};def outbound click(TestMessage, string):string;`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, p.Save(dev))
+
+	ret, err := p.Get(dev.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, dev, ret)
+
+	changes, err := ret.SetDefinition([]byte(`message Other{int32 A;};def outbound click(Other):string;def inbound pressed();`))
+	assert.NoError(t, err)
+	assert.False(t, changes.Empty())
+	ret.Name = "Device2"
+	ret.Timeout = 2 * time.Second
+	ret.ServiceTimeouts = map[string]time.Duration{"click": 1500 * time.Millisecond}
+	ret.MarkSeen(time.Unix(1700000000, 0))
+	_, err = ret.NewSecret()
+	assert.NoError(t, err)
+	ret.Transport = device.TransportMQTT
+	ret.Addr = &device.Endpoint{Scheme: "https", Host: "fe80::1", Port: "8443", Path: "/api"}
+	assert.NoError(t, p.Update(ret))
+
+	updated, err := p.Get(dev.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, ret, updated)
+
+	// saving an existing device again, as a reconnect without a definition does, keeps its definition
+	assert.NoError(t, p.Save(updated))
+	resaved, err := p.Get(dev.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, updated, resaved)
+
+	var orphans int
+	err = p.DB.QueryRow("SELECT COUNT(*) FROM service_request WHERE service_id NOT IN (SELECT id FROM services)").Scan(&orphans)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, orphans)
+
+	missing := *ret
+	missing.ID = uuid.New()
+	assert.Equal(t, sql.ErrNoRows, p.Update(&missing))
+}
+
+func TestStore_DeleteCascade(t *testing.T) {
+	p, err := NewSQLiteStore(filepath.Join(t.TempDir(), "delete.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.DB.Close()
+	addr := &device.Endpoint{
+		Host: "127.0.0.1",
+		Port: "80",
+	}
+	dev, err := device.NewDevice("Device1", addr, []byte(`message TestMessage{string TestString;};def outbound click(TestMessage):string;`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.ServiceTimeouts = map[string]time.Duration{"click": time.Second}
+	assert.NoError(t, p.Save(dev))
+	kept, err := device.NewDevice("Device2", addr, []byte(`def outbound click();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, p.Save(kept))
+	id := dev.ID.String()
+	click := &action.Action{Device: id, Service: "click"}
+	assert.NoError(t, p.SaveGroup(&group.Group{ID: "kitchen", Name: "Kitchen", Devices: []string{id, kept.ID.String()}}))
+	assert.NoError(t, p.SaveEvent(&event.Event{DeviceID: dev.ID, Service: "click", Payload: json.RawMessage(`{}`), CreatedAt: time.Now()}))
+	assert.NoError(t, p.SaveSchedule(&schedule.Schedule{ID: uuid.New(), Name: "night", Cron: "0 23 * * *", Action: *click}))
+	keptClick := &action.Action{Device: kept.ID.String(), Service: "click"}
+	night := &rule.Rule{ID: uuid.New(), Name: "night", Definition: rule.Definition{
+		Trigger: &rule.Trigger{Type: rule.TriggerSchedule, At: "23:00"},
+		Actions: []*action.Action{click, keptClick},
+	}}
+	assert.NoError(t, p.SaveRule(night))
+	online := &rule.Rule{ID: uuid.New(), Name: "online", Definition: rule.Definition{
+		Trigger: &rule.Trigger{Type: rule.TriggerStatus, Device: id},
+		Actions: []*action.Action{keptClick},
+	}}
+	assert.NoError(t, p.SaveRule(online))
+	movie := &scene.Scene{ID: uuid.New(), Name: "movie", Mode: scene.ModeBestEffort, Steps: []*action.Action{keptClick, click}}
+	assert.NoError(t, p.SaveScene(movie))
+	reading := &scene.Scene{ID: uuid.New(), Name: "reading", Mode: scene.ModeBestEffort, Steps: []*action.Action{click}}
+	assert.NoError(t, p.SaveScene(reading))
+	assert.NoError(t, p.Delete(id))
+
+	for _, table := range []string{"services", "messages", "service_timeouts", "events", "schedules", "rule_devices", "scene_devices"} {
+		var count int
+		err = p.DB.QueryRow("SELECT COUNT(*) FROM "+table+" WHERE device_id = ?", id).Scan(&count)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, count, table)
+	}
+	for _, table := range []string{"service_request", "service_response", "message_definition_fields"} {
+		var count int
+		err = p.DB.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count)
+		assert.NoError(t, err)
+		assert.Equal(t, 0, count, table)
+	}
+	g, err := p.GetGroup("kitchen")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{kept.ID.String()}, g.Devices, "a deleted device leaves its groups")
+	rules, err := p.GetRules()
+	assert.NoError(t, err)
+	night.Actions = []*action.Action{keptClick}
+	online.Disabled = true
+	assert.Equal(t, []*rule.Rule{night, online}, rules, "a deleted device is removed from the rules, which are disabled when it triggers them")
+	scenes, err := p.GetScenes()
+	assert.NoError(t, err)
+	movie.Steps = []*action.Action{keptClick}
+	reading.Steps = []*action.Action{}
+	assert.Equal(t, []*scene.Scene{movie, reading}, scenes, "a deleted device is removed from the scenes")
+	assert.Equal(t, sql.ErrNoRows, p.Delete(id))
+}
+
+func TestStore_SetStatus(t *testing.T) {
+	p, err := NewSQLiteStore(filepath.Join(t.TempDir(), "status.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.DB.Close()
+	addr := &device.Endpoint{
+		Host: "127.0.0.1",
+		Port: "80",
+	}
+	dev, err := device.NewDevice("Device1", addr, []byte(`def outbound click();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, p.Save(dev))
+
+	ret, err := p.Get(dev.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, device.StatusUnknown, ret.Status)
+	assert.True(t, ret.LastSeen.IsZero())
+
+	lastSeen := time.Unix(1700000000, 0)
+	assert.NoError(t, p.SetStatus(dev.ID.String(), device.StatusOffline, lastSeen))
+	ret, err = p.Get(dev.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, device.StatusOffline, ret.Status)
+	assert.Equal(t, lastSeen, ret.LastSeen)
+	assert.Len(t, ret.Services, 1)
+
+	assert.Equal(t, sql.ErrNoRows, p.SetStatus(uuid.New().String(), device.StatusOnline, lastSeen))
+}
+
+func TestStore_Events(t *testing.T) {
+	p, err := NewSQLiteStore(filepath.Join(t.TempDir(), "events.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.DB.Close()
+	addr := &device.Endpoint{
+		Host: "127.0.0.1",
+		Port: "80",
+	}
+	var devs []*device.Device
+	for _, name := range []string{"Door", "Window"} {
+		dev, err := device.NewDevice(name, addr, []byte(`def inbound opened(); def inbound closed();`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NoError(t, p.Save(dev))
+		devs = append(devs, dev)
+	}
+	start := time.Unix(1700000000, 0)
+	var saved []*event.Event
+	for i := 0; i < 6; i++ {
+		service := "opened"
+		if i%2 == 1 {
+			service = "closed"
+		}
+		e := &event.Event{
+			DeviceID:  devs[i%3/2].ID,
+			Service:   service,
+			Payload:   json.RawMessage(`{}`),
+			CreatedAt: start.Add(time.Duration(i) * time.Minute),
+		}
+		assert.NoError(t, p.SaveEvent(e))
+		assert.NotZero(t, e.ID)
+		saved = append(saved, e)
+	}
+	// saved events 0, 1, 3 and 4 are of devs[0], 2 and 5 of devs[1]
+	ids := func(events []*event.Event) []int64 {
+		res := []int64{}
+		for _, e := range events {
+			res = append(res, e.ID)
+		}
+		return res
+	}
+	tests := []struct {
+		name     string
+		query    *event.Query
+		expected []*event.Event
+	}{
+		{
+			name:     "Device",
+			query:    &event.Query{DeviceID: devs[0].ID.String()},
+			expected: []*event.Event{saved[4], saved[3], saved[1], saved[0]},
+		},
+		{
+			name:     "Service",
+			query:    &event.Query{DeviceID: devs[0].ID.String(), Service: "closed"},
+			expected: []*event.Event{saved[3], saved[1]},
+		},
+		{
+			name:     "Time range",
+			query:    &event.Query{Since: start.Add(time.Minute), Until: start.Add(3 * time.Minute)},
+			expected: []*event.Event{saved[2], saved[1]},
+		},
+		{
+			name:     "Page",
+			query:    &event.Query{DeviceID: devs[0].ID.String(), Before: saved[3].ID, Limit: 1},
+			expected: []*event.Event{saved[1]},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events, err := p.GetEvents(tt.query)
+			assert.NoError(t, err)
+			assert.Equal(t, ids(tt.expected), ids(events))
+			if len(events) > 0 {
+				assert.Equal(t, tt.expected[0].CreatedAt, events[0].CreatedAt)
+				assert.Equal(t, tt.expected[0].DeviceID, events[0].DeviceID)
+			}
+		})
+	}
+
+	n, err := p.TrimEvents(2)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	events, err := p.GetEvents(&event.Query{})
+	assert.NoError(t, err)
+	assert.Equal(t, ids([]*event.Event{saved[5], saved[4], saved[3], saved[2]}), ids(events))
+
+	n, err = p.DeleteEventsBefore(start.Add(4 * time.Minute))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), n)
+	events, err = p.GetEvents(&event.Query{})
+	assert.NoError(t, err)
+	assert.Equal(t, ids([]*event.Event{saved[5], saved[4]}), ids(events))
+}
+
+func TestStore_Rules(t *testing.T) {
+	p, err := NewSQLiteStore(filepath.Join(t.TempDir(), "rules.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.DB.Close()
+	deviceID := uuid.New().String()
+	r := &rule.Rule{
+		ID:   uuid.New(),
+		Name: "night",
+		Definition: rule.Definition{
+			Trigger:    &rule.Trigger{Type: rule.TriggerEvent, Device: deviceID, Service: "opened"},
+			Conditions: []*rule.Condition{{Field: "Level", Op: rule.OpLess, Value: json.Number("20")}},
+			Window:     &rule.Window{After: "22:00"},
+			Actions:    []*action.Action{{Device: deviceID, Service: "on", Params: map[string]string{"arg0": "1"}}},
+		},
+	}
+	assert.NoError(t, p.SaveRule(r))
+	ret, err := p.GetRule(r.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, r, ret)
+
+	r.Disabled = true
+	r.Name = "always"
+	r.Window = nil
+	assert.NoError(t, p.SaveRule(r))
+	rules, err := p.GetRules()
+	assert.NoError(t, err)
+	assert.Equal(t, []*rule.Rule{r}, rules)
+
+	assert.NoError(t, p.DeleteRule(r.ID.String()))
+	_, err = p.GetRule(r.ID.String())
+	assert.Equal(t, sql.ErrNoRows, err)
+	assert.Equal(t, sql.ErrNoRows, p.DeleteRule(r.ID.String()))
+}
+
+func TestStore_Schedules(t *testing.T) {
+	p, err := NewSQLiteStore(filepath.Join(t.TempDir(), "schedules.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.DB.Close()
+	addr := &device.Endpoint{
+		Host: "127.0.0.1",
+		Port: "80",
+	}
+	dev, err := device.NewDevice("Device1", addr, []byte(`def outbound on(int32);`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, p.Save(dev))
+	s := &schedule.Schedule{
+		ID:     uuid.New(),
+		Name:   "night",
+		Cron:   "0 23 * * *",
+		Action: action.Action{Device: dev.ID.String(), Service: "on", Params: map[string]string{"arg0": "1"}},
+	}
+	assert.NoError(t, p.SaveSchedule(s))
+	ret, err := p.GetSchedule(s.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, s, ret)
+
+	at := time.Unix(1610000000, 0)
+	s.Cron = ""
+	s.At = &at
+	s.Disabled = true
+	assert.NoError(t, p.SaveSchedule(s))
+	result := &schedule.Result{At: time.Unix(1610000100, 0), Error: "device offline"}
+	assert.NoError(t, p.SetScheduleResult(s.ID.String(), result))
+	s.LastRun = result
+	schedules, err := p.GetSchedules()
+	assert.NoError(t, err)
+	assert.Equal(t, []*schedule.Schedule{s}, schedules)
+
+	assert.NoError(t, p.Delete(dev.ID.String()))
+	_, err = p.GetSchedule(s.ID.String())
+	assert.Equal(t, sql.ErrNoRows, err, "schedules of a deleted device are deleted")
+	assert.Equal(t, sql.ErrNoRows, p.DeleteSchedule(s.ID.String()))
+	assert.Equal(t, sql.ErrNoRows, p.SetScheduleResult(s.ID.String(), result))
+}
+
+func TestStore_Scenes(t *testing.T) {
+	p, err := NewSQLiteStore(filepath.Join(t.TempDir(), "scenes.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.DB.Close()
+	s := &scene.Scene{
+		ID:   uuid.New(),
+		Name: "movie",
+		Mode: scene.ModeBestEffort,
+		Steps: []*action.Action{
+			{Device: uuid.New().String(), Service: "off"},
+			{Device: uuid.New().String(), Service: "on", Params: map[string]string{"arg0": "20"}},
+		},
+	}
+	assert.NoError(t, p.SaveScene(s))
+	ret, err := p.GetScene(s.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, s, ret)
+
+	s.Mode = scene.ModeStopOnFailure
+	s.Steps = s.Steps[1:]
+	assert.NoError(t, p.SaveScene(s))
+	scenes, err := p.GetScenes()
+	assert.NoError(t, err)
+	assert.Equal(t, []*scene.Scene{s}, scenes)
+
+	assert.NoError(t, p.DeleteScene(s.ID.String()))
+	_, err = p.GetScene(s.ID.String())
+	assert.Equal(t, sql.ErrNoRows, err)
+	assert.Equal(t, sql.ErrNoRows, p.DeleteScene(s.ID.String()))
+}
+
+func TestStore_Groups(t *testing.T) {
+	p, err := NewSQLiteStore(filepath.Join(t.TempDir(), "groups.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.DB.Close()
+	addr := &device.Endpoint{
+		Host: "127.0.0.1",
+		Port: "80",
+	}
+	var ids []string
+	for _, name := range []string{"Lamp", "Kettle"} {
+		dev, err := device.NewDevice(name, addr, []byte(`def outbound on();`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		assert.NoError(t, p.Save(dev))
+		ids = append(ids, dev.ID.String())
+	}
+	sort.Strings(ids)
+
+	kitchen := &group.Group{ID: "kitchen", Name: "Kitchen", Devices: ids}
+	assert.NoError(t, p.SaveGroup(kitchen))
+	assert.NoError(t, p.SaveGroup(&group.Group{ID: "bedroom", Name: "Bedroom", Devices: ids[:1]}))
+	ret, err := p.GetGroup("kitchen")
+	assert.NoError(t, err)
+	assert.Equal(t, kitchen, ret)
+
+	assert.NoError(t, p.RemoveGroupDevice("kitchen", ids[0]))
+	assert.Equal(t, sql.ErrNoRows, p.RemoveGroupDevice("kitchen", ids[0]))
+	assert.NoError(t, p.AddGroupDevice("bedroom", ids[1]))
+	assert.NoError(t, p.AddGroupDevice("bedroom", ids[1]), "adding a device twice does nothing")
+	assert.Equal(t, sql.ErrNoRows, p.AddGroupDevice("garage", ids[1]))
+
+	assert.NoError(t, p.Delete(ids[1]))
+	groups, err := p.GetGroups()
+	assert.NoError(t, err)
+	assert.Equal(t, []*group.Group{
+		{ID: "bedroom", Name: "Bedroom", Devices: ids[:1]},
+		{ID: "kitchen", Name: "Kitchen", Devices: []string{}},
+	}, groups, "a deleted device leaves its groups")
+
+	assert.NoError(t, p.DeleteGroup("bedroom"))
+	_, err = p.GetGroup("bedroom")
+	assert.Equal(t, sql.ErrNoRows, err)
+	assert.Equal(t, sql.ErrNoRows, p.DeleteGroup("bedroom"))
+	_, err = p.Get(ids[0])
+	assert.NoError(t, err, "a deleted group keeps its devices")
+}
+
+func TestStore_Users(t *testing.T) {
+	p, err := NewSQLiteStore(filepath.Join(t.TempDir(), "users.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.DB.Close()
+	u := &user.User{ID: uuid.New(), Name: "alice", Role: user.RoleAdmin, PasswordHash: "hash", CreatedAt: time.Unix(1610000000, 0)}
+	assert.NoError(t, p.SaveUser(u))
+	assert.Error(t, p.SaveUser(&user.User{ID: uuid.New(), Name: "alice", Role: user.RoleViewer}), "names are unique")
+	ret, err := p.GetUserByName("alice")
+	assert.NoError(t, err)
+	assert.Equal(t, u, ret)
+
+	u.Role = user.RoleViewer
+	assert.NoError(t, p.SaveUser(u))
+	users, err := p.GetUsers()
+	assert.NoError(t, err)
+	assert.Equal(t, []*user.User{u}, users)
+
+	expiresAt := time.Unix(1610003600, 0)
+	session := &user.Token{Hash: "session", User: u.ID, Kind: user.TokenSession, ExpiresAt: &expiresAt, CreatedAt: time.Unix(1610000000, 0)}
+	key := &user.Token{Hash: "key", User: u.ID, Kind: user.TokenKey, Name: "dashboard", CreatedAt: time.Unix(1610000100, 0)}
+	assert.NoError(t, p.SaveToken(session))
+	assert.NoError(t, p.SaveToken(key))
+	tok, err := p.GetToken("session")
+	assert.NoError(t, err)
+	assert.Equal(t, session, tok)
+	tokens, err := p.GetUserTokens(u.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, []*user.Token{key, session}, tokens)
+	assert.NoError(t, p.DeleteToken("session"))
+	assert.Equal(t, sql.ErrNoRows, p.DeleteToken("session"))
+
+	assert.NoError(t, p.DeleteUser(u.ID.String()))
+	_, err = p.GetUser(u.ID.String())
+	assert.Equal(t, sql.ErrNoRows, err)
+	_, err = p.GetToken("key")
+	assert.Equal(t, sql.ErrNoRows, err, "the tokens of a deleted user are deleted")
+	assert.Equal(t, sql.ErrNoRows, p.DeleteUser(u.ID.String()))
+}
+
+func TestStore_Memory(t *testing.T) {
+	p, err := NewSQLiteStore(Memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, err := device.NewDevice("Device1", &device.Endpoint{Host: "127.0.0.1", Port: "80"}, []byte(`def outbound on();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, p.Save(dev))
+	got, err := p.Get(dev.ID.String())
+	assert.NoError(t, err)
+	assert.Equal(t, dev.Name, got.Name)
+
+	other, err := NewSQLiteStore(Memory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, err := other.GetAll()
+	assert.NoError(t, err)
+	assert.Empty(t, devices, "every store in memory has a database of its own")
+}
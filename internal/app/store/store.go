@@ -1,12 +1,31 @@
 package store
 
-import "github.com/IktaS/go-home/internal/pkg/device"
+import (
+	"github.com/IktaS/go-home/internal/pkg/auth"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/event"
+	"github.com/IktaS/go-home/internal/pkg/group"
+	"github.com/IktaS/go-home/internal/pkg/health"
+	"github.com/IktaS/go-home/internal/pkg/rule"
+	"github.com/IktaS/go-home/internal/pkg/scene"
+	"github.com/IktaS/go-home/internal/pkg/schedule"
+	"github.com/IktaS/go-home/internal/pkg/user"
+)
 
 //Repo is an interface that defines what a repository should have
 type Repo interface {
 	Init(interface{}) error
 	Save(*device.Device) error
+	Update(*device.Device) error
 	Get(interface{}) (*device.Device, error)
 	GetAll() ([]*device.Device, error)
 	Delete(interface{}) error
+	auth.CodeRepo
+	health.StatusRepo
+	event.Repo
+	rule.Repo
+	scene.Repo
+	schedule.Repo
+	group.Repo
+	user.Repo
 }
@@ -0,0 +1,108 @@
+package action
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/google/uuid"
+)
+
+// ErrInvalid is the error of an action that does not match the device it calls
+var ErrInvalid = errors.New("invalid action")
+
+/*
+Action defines the JSON schema of an outbound service call the hub makes on its own :
+	Device	`device`	: UUID of the device
+	Service	`service`	: Outbound service of the device
+	Params	`params`	: Parameters of the call, the same as the query of a GET service call
+*/
+type Action struct {
+	Device  string            `json:"device"`
+	Service string            `json:"service"`
+	Params  map[string]string `json:"params,omitempty"`
+}
+
+// DeviceRepo defines what actions need from a device repository
+type DeviceRepo interface {
+	Get(interface{}) (*device.Device, error)
+}
+
+func invalid(format string, a ...interface{}) error {
+	return fmt.Errorf("%w : %v", ErrInvalid, fmt.Sprintf(format, a...))
+}
+
+// Query is the URL query of the call
+func (a *Action) Query() url.Values {
+	query := url.Values{}
+	for k, v := range a.Params {
+		query.Set(k, v)
+	}
+	return query
+}
+
+// Validate checks the action against the device it calls, and normalizes the device UUID,
+// an error wrapping ErrInvalid is returned when the action cannot be called
+func (a *Action) Validate(devices DeviceRepo) error {
+	id, err := uuid.Parse(a.Device)
+	if err != nil {
+		return invalid("%v is not a device UUID", a.Device)
+	}
+	dev, err := devices.Get(id.String())
+	if err == sql.ErrNoRows {
+		return invalid("device %v not found", a.Device)
+	}
+	if err != nil {
+		return err
+	}
+	a.Device = dev.ID.String()
+	s := dev.Service(a.Service)
+	if s == nil || !s.Outbound {
+		return invalid("device %v has no outbound service %v", dev.Name, a.Service)
+	}
+	violations, err := dev.ValidateQuery(s, a.Query())
+	if err != nil {
+		return invalid("service %v cannot be resolved : %v", a.Service, err)
+	}
+	if len(violations) > 0 {
+		v := violations[0]
+		return invalid("param %v of %v is %v", v.Param, a.Service, v.Reason)
+	}
+	return nil
+}
+
+// Devices gets the UUIDs of the devices the actions call, each once in order
+func Devices(actions []*Action) []string {
+	var res []string
+	seen := make(map[string]bool)
+	for _, a := range actions {
+		if !seen[a.Device] {
+			seen[a.Device] = true
+			res = append(res, a.Device)
+		}
+	}
+	return res
+}
+
+// Without gets the actions that do not call a device, in order
+func Without(actions []*Action, id string) []*Action {
+	res := []*Action{}
+	for _, a := range actions {
+		if a.Device != id {
+			res = append(res, a)
+		}
+	}
+	return res
+}
+
+// Call calls the service of the device, and returns the device answer
+func (a *Action) Call(ctx context.Context, devices DeviceRepo, opts device.CallOptions) ([]byte, error) {
+	dev, err := devices.Get(a.Device)
+	if err != nil {
+		return nil, err
+	}
+	return dev.Call(ctx, a.Service, a.Query().Encode(), opts)
+}
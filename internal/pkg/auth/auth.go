@@ -1,7 +1,163 @@
 package auth
 
-//Authenticate is used to authenticate a code
-func Authenticate(code string) bool {
-	return true
-	//TODO : Implement Auth
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+)
+
+// codeAlphabet leaves out characters that are easy to confuse when a code is typed into a device
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// codeLength is the length of a generated hub code
+const codeLength = 8
+
+// HubCode defines a stored hub code, only the hash of the code is kept
+type HubCode struct {
+	Hash      string
+	SingleUse bool
+	ExpiresAt time.Time
+	CreatedAt time.Time
+}
+
+// Expired reports whether the hub code is expired at t, a zero ExpiresAt never expires
+func (c *HubCode) Expired(t time.Time) bool {
+	return !c.ExpiresAt.IsZero() && !t.Before(c.ExpiresAt)
+}
+
+// CodeRepo is an interface that defines what a hub code repository should have,
+// GetHubCode and DeleteHubCode return sql.ErrNoRows when there is no such code
+type CodeRepo interface {
+	SaveHubCode(*HubCode) error
+	GetHubCode(hash string) (*HubCode, error)
+	GetAllHubCodes() ([]*HubCode, error)
+	DeleteHubCode(hash string) error
+}
+
+// HashCode returns the hash of a hub code as it is kept in the repository
+func HashCode(code string) string {
+	sum := sha256.Sum256([]byte(strings.ToUpper(strings.TrimSpace(code))))
+	return hex.EncodeToString(sum[:])
+}
+
+// GenerateCode generates a random hub code
+func GenerateCode() (string, error) {
+	buf := make([]byte, codeLength)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	code := make([]byte, codeLength)
+	for i, b := range buf {
+		code[i] = codeAlphabet[int(b)%len(codeAlphabet)]
+	}
+	return string(code), nil
+}
+
+// NewHubCode generates a new hub code and saves it to the repo, a ttl of 0 never expires
+func NewHubCode(repo CodeRepo, ttl time.Duration, singleUse bool) (string, error) {
+	code, err := GenerateCode()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	hubCode := &HubCode{
+		Hash:      HashCode(code),
+		SingleUse: singleUse,
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		hubCode.ExpiresAt = now.Add(ttl)
+	}
+	err = repo.SaveHubCode(hubCode)
+	if err != nil {
+		return "", err
+	}
+	return code, nil
+}
+
+// RotateHubCode revokes every stored hub code and generates a new one
+func RotateHubCode(repo CodeRepo, ttl time.Duration, singleUse bool) (string, error) {
+	codes, err := repo.GetAllHubCodes()
+	if err != nil {
+		return "", err
+	}
+	for _, c := range codes {
+		err = repo.DeleteHubCode(c.Hash)
+		if err != nil && err != sql.ErrNoRows {
+			return "", err
+		}
+	}
+	return NewHubCode(repo, ttl, singleUse)
+}
+
+// Authenticate is used to authenticate a code, single use codes are consumed and expired codes are removed
+func Authenticate(repo CodeRepo, code string) (bool, error) {
+	if strings.TrimSpace(code) == "" {
+		return false, nil
+	}
+	hash := HashCode(code)
+	hubCode, err := repo.GetHubCode(hash)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+	if hubCode.Expired(time.Now()) {
+		err = repo.DeleteHubCode(hash)
+		if err != nil && err != sql.ErrNoRows {
+			return false, err
+		}
+		return false, nil
+	}
+	if hubCode.SingleUse {
+		// another device might have used the code in the meantime
+		err = repo.DeleteHubCode(hash)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return false, nil
+			}
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// Issuer issues the hub codes devices connect with, each code lasts TTL, 0 for ever, and is consumed by its first use when SingleUse,
+// as only the hash of a code is kept every code issued is given to Announce when it is set
+type Issuer struct {
+	TTL       time.Duration
+	SingleUse bool
+	Announce  func(code string)
+
+	mu sync.Mutex
+}
+
+// Rotate revokes every stored hub code and issues a new one
+func (i *Issuer) Rotate(repo CodeRepo) (string, error) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	code, err := RotateHubCode(repo, i.TTL, i.SingleUse)
+	if err != nil {
+		return "", err
+	}
+	if i.Announce != nil {
+		i.Announce(code)
+	}
+	return code, nil
+}
+
+// Authenticate authenticates a code, a fresh code is issued once a single use code is consumed so the next device can still connect
+func (i *Issuer) Authenticate(repo CodeRepo, code string) (bool, error) {
+	ok, err := Authenticate(repo, code)
+	if err != nil || !ok || !i.SingleUse {
+		return ok, err
+	}
+	_, err = i.Rotate(repo)
+	return true, err
 }
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryCodeRepo struct {
+	codes map[string]*HubCode
+}
+
+func newMemoryCodeRepo() *memoryCodeRepo {
+	return &memoryCodeRepo{codes: make(map[string]*HubCode)}
+}
+
+func (m *memoryCodeRepo) SaveHubCode(c *HubCode) error {
+	m.codes[c.Hash] = c
+	return nil
+}
+
+func (m *memoryCodeRepo) GetHubCode(hash string) (*HubCode, error) {
+	c, ok := m.codes[hash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return c, nil
+}
+
+func (m *memoryCodeRepo) GetAllHubCodes() ([]*HubCode, error) {
+	var codes []*HubCode
+	for _, c := range m.codes {
+		codes = append(codes, c)
+	}
+	return codes, nil
+}
+
+func (m *memoryCodeRepo) DeleteHubCode(hash string) error {
+	if _, ok := m.codes[hash]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.codes, hash)
+	return nil
+}
+
+func TestAuthenticate(t *testing.T) {
+	tests := []struct {
+		name     string
+		setup    func(t *testing.T, repo *memoryCodeRepo) string
+		input    func(code string) string
+		expected []bool
+	}{
+		{
+			name: "Valid code",
+			setup: func(t *testing.T, repo *memoryCodeRepo) string {
+				code, err := NewHubCode(repo, 0, false)
+				assert.NoError(t, err)
+				return code
+			},
+			input:    func(code string) string { return code },
+			expected: []bool{true, true},
+		},
+		{
+			name: "Code is case insensitive",
+			setup: func(t *testing.T, repo *memoryCodeRepo) string {
+				code, err := NewHubCode(repo, 0, false)
+				assert.NoError(t, err)
+				return code
+			},
+			input: func(code string) string {
+				lower := []byte(code)
+				for i, c := range lower {
+					if c >= 'A' && c <= 'Z' {
+						lower[i] = c + ('a' - 'A')
+					}
+				}
+				return " " + string(lower) + " "
+			},
+			expected: []bool{true},
+		},
+		{
+			name: "Wrong code",
+			setup: func(t *testing.T, repo *memoryCodeRepo) string {
+				_, err := NewHubCode(repo, 0, false)
+				assert.NoError(t, err)
+				return ""
+			},
+			input:    func(code string) string { return "WRONGCODE" },
+			expected: []bool{false},
+		},
+		{
+			name: "Empty code",
+			setup: func(t *testing.T, repo *memoryCodeRepo) string {
+				return ""
+			},
+			input:    func(code string) string { return code },
+			expected: []bool{false},
+		},
+		{
+			name: "Single use code",
+			setup: func(t *testing.T, repo *memoryCodeRepo) string {
+				code, err := NewHubCode(repo, 0, true)
+				assert.NoError(t, err)
+				return code
+			},
+			input:    func(code string) string { return code },
+			expected: []bool{true, false},
+		},
+		{
+			name: "Expired code",
+			setup: func(t *testing.T, repo *memoryCodeRepo) string {
+				code, err := GenerateCode()
+				assert.NoError(t, err)
+				err = repo.SaveHubCode(&HubCode{
+					Hash:      HashCode(code),
+					CreatedAt: time.Now().Add(-2 * time.Hour),
+					ExpiresAt: time.Now().Add(-time.Hour),
+				})
+				assert.NoError(t, err)
+				return code
+			},
+			input:    func(code string) string { return code },
+			expected: []bool{false},
+		},
+		{
+			name: "Rotated code",
+			setup: func(t *testing.T, repo *memoryCodeRepo) string {
+				old, err := NewHubCode(repo, 0, false)
+				assert.NoError(t, err)
+				_, err = RotateHubCode(repo, 0, false)
+				assert.NoError(t, err)
+				return old
+			},
+			input:    func(code string) string { return code },
+			expected: []bool{false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := newMemoryCodeRepo()
+			code := tt.setup(t, repo)
+			for _, want := range tt.expected {
+				ok, err := Authenticate(repo, tt.input(code))
+				assert.NoError(t, err)
+				assert.Equal(t, want, ok)
+			}
+		})
+	}
+}
+
+func TestRotateHubCode(t *testing.T) {
+	repo := newMemoryCodeRepo()
+	for i := 0; i < 3; i++ {
+		_, err := NewHubCode(repo, 0, false)
+		assert.NoError(t, err)
+	}
+	code, err := RotateHubCode(repo, time.Hour, true)
+	assert.NoError(t, err)
+	assert.Len(t, repo.codes, 1)
+	c, err := repo.GetHubCode(HashCode(code))
+	assert.NoError(t, err)
+	assert.True(t, c.SingleUse)
+	assert.False(t, c.Expired(time.Now()))
+	assert.True(t, c.Expired(time.Now().Add(2*time.Hour)))
+}
+
+func TestIssuer(t *testing.T) {
+	repo := newMemoryCodeRepo()
+	var announced []string
+	i := &Issuer{SingleUse: true, Announce: func(code string) { announced = append(announced, code) }}
+	code, err := i.Rotate(repo)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{code}, announced)
+
+	ok, err := i.Authenticate(repo, code)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = i.Authenticate(repo, code)
+	assert.NoError(t, err)
+	assert.False(t, ok, "a single use code is consumed")
+	if assert.Len(t, announced, 2, "a fresh code is issued once a single use code is consumed") {
+		ok, err = i.Authenticate(repo, announced[1])
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+
+	i = &Issuer{}
+	code, err = i.Rotate(repo)
+	assert.NoError(t, err)
+	for n := 0; n < 2; n++ {
+		ok, err = i.Authenticate(repo, code)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+	assert.Len(t, repo.codes, 1, "codes that are not single use are not rotated when used")
+}
@@ -0,0 +1,292 @@
+package bus
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/logging"
+)
+
+// Type is the kind of a message
+type Type string
+
+// Types of messages published by the hub
+const (
+	// DeviceConnected is published when a new device connects to the hub
+	DeviceConnected Type = "device.connected"
+	// DeviceReconnected is published when a known device reconnects to the hub, possibly with a new definition
+	DeviceReconnected Type = "device.reconnected"
+	// DeviceDeleted is published when a device is deleted from the hub
+	DeviceDeleted Type = "device.deleted"
+	// DeviceStatus is published when a device goes online or offline
+	DeviceStatus Type = "device.status"
+	// DeviceEvent is published when a device pushes an event through one of its inbound services
+	DeviceEvent Type = "device.event"
+)
+
+// Types is every type of message, in the order they are documented
+var Types = []Type{DeviceConnected, DeviceReconnected, DeviceDeleted, DeviceStatus, DeviceEvent}
+
+// ParseType parses the name of a message type
+func ParseType(s string) (Type, error) {
+	for _, t := range Types {
+		if string(t) == s {
+			return t, nil
+		}
+	}
+	return "", fmt.Errorf("unknown message type %v", s)
+}
+
+/*
+Message defines the JSON schema of a message on the bus :
+	Type	`type`		: Kind of the message, see Types
+	Device	`device`	: UUID of the device the message is about
+	Data	`data`		: Content of the message, depends on its type
+	Time	`time`		: Time the message was published
+*/
+type Message struct {
+	Type   Type        `json:"type"`
+	Device string      `json:"device"`
+	Data   interface{} `json:"data,omitempty"`
+	Time   time.Time   `json:"time"`
+}
+
+// Filter selects messages by device and type, an empty list matches everything
+type Filter struct {
+	Devices []string `json:"devices,omitempty"`
+	Types   []Type   `json:"types,omitempty"`
+}
+
+// Match tells whether a message passes the filter
+func (f Filter) Match(m *Message) bool {
+	if len(f.Devices) > 0 && !containsDevice(f.Devices, m.Device) {
+		return false
+	}
+	if len(f.Types) > 0 && !containsType(f.Types, m.Type) {
+		return false
+	}
+	return true
+}
+
+func containsDevice(devices []string, device string) bool {
+	for _, d := range devices {
+		if d == device {
+			return true
+		}
+	}
+	return false
+}
+
+func containsType(types []Type, t Type) bool {
+	for _, typ := range types {
+		if typ == t {
+			return true
+		}
+	}
+	return false
+}
+
+// DefaultBuffer is how many messages a subscriber may fall behind before it misses messages
+const DefaultBuffer = 64
+
+// Bus delivers published messages to every subscriber whose filter matches,
+// a nil Bus drops every message and its subscriptions are already closed
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+}
+
+// New makes an empty Bus
+func New() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Publish sends a message to the subscribers, it never blocks : a subscriber with a full buffer misses the message,
+// and a subscriber of SubscribeAll queues it
+func (b *Bus) Publish(m *Message) {
+	if b == nil {
+		return
+	}
+	if m.Time.IsZero() {
+		m.Time = time.Now().UTC()
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if !s.Filter().Match(m) {
+			continue
+		}
+		s.deliver(m)
+	}
+}
+
+// Subscribe starts receiving the messages that match a filter, the subscription must be closed once it is not read anymore
+func (b *Bus) Subscribe(f Filter) *Subscription {
+	if b == nil {
+		return closedSubscription(f)
+	}
+	s := &Subscription{
+		bus:    b,
+		filter: f,
+		c:      make(chan *Message, DefaultBuffer),
+	}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// SubscribeAll starts receiving every message that matches a filter, however far behind the subscriber falls,
+// messages wait in a queue of the subscription instead of being missed, for subscribers that act on each message
+func (b *Bus) SubscribeAll(f Filter) *Subscription {
+	if b == nil {
+		return closedSubscription(f)
+	}
+	s := &Subscription{
+		bus:    b,
+		filter: f,
+		c:      make(chan *Message),
+		queue: &queue{
+			ready: make(chan struct{}, 1),
+			done:  make(chan struct{}),
+		},
+	}
+	go s.pump()
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+// closedSubscription makes a subscription of a nil Bus, its channel is closed and closing it does nothing
+func closedSubscription(f Filter) *Subscription {
+	s := &Subscription{
+		filter: f,
+		c:      make(chan *Message),
+	}
+	close(s.c)
+	s.once.Do(func() {})
+	return s
+}
+
+// queue keeps the messages of a subscription of SubscribeAll until they are received
+type queue struct {
+	mu    sync.Mutex
+	msgs  []*Message
+	ready chan struct{}
+	done  chan struct{}
+}
+
+func (q *queue) push(m *Message) {
+	q.mu.Lock()
+	q.msgs = append(q.msgs, m)
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+func (q *queue) pop() (*Message, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.msgs) == 0 {
+		return nil, false
+	}
+	m := q.msgs[0]
+	q.msgs[0] = nil
+	q.msgs = q.msgs[1:]
+	return m, true
+}
+
+// Subscription receives the messages of a Bus that match its filter
+type Subscription struct {
+	bus    *Bus
+	mu     sync.RWMutex
+	filter Filter
+	c      chan *Message
+	once   sync.Once
+	// queue is nil for a subscription that misses messages when its buffer is full
+	queue   *queue
+	dropped uint64
+}
+
+// deliver sends a message to the subscription without blocking
+func (s *Subscription) deliver(m *Message) {
+	if s.queue != nil {
+		s.queue.push(m)
+		return
+	}
+	select {
+	case s.c <- m:
+	default:
+		// logged on the first missed message and then less and less often
+		if n := atomic.AddUint64(&s.dropped, 1); n&(n-1) == 0 {
+			logging.Warnf("A subscriber of the bus is behind, it missed %v messages\n", n)
+		}
+	}
+}
+
+// pump sends the queued messages of a subscription of SubscribeAll in order, it closes the channel once the subscription is closed
+func (s *Subscription) pump() {
+	defer close(s.c)
+	for {
+		select {
+		case <-s.queue.done:
+			return
+		case <-s.queue.ready:
+		}
+		for {
+			m, ok := s.queue.pop()
+			if !ok {
+				break
+			}
+			select {
+			case s.c <- m:
+			case <-s.queue.done:
+				return
+			}
+		}
+	}
+}
+
+// Dropped is how many messages the subscription missed as its buffer was full
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// C is the channel messages are received on, it is closed when the subscription is closed
+func (s *Subscription) C() <-chan *Message {
+	return s.c
+}
+
+// Filter is the current filter of the subscription
+func (s *Subscription) Filter() Filter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.filter
+}
+
+// SetFilter replaces the filter of the subscription
+func (s *Subscription) SetFilter(f Filter) {
+	s.mu.Lock()
+	s.filter = f
+	s.mu.Unlock()
+}
+
+// Close stops the subscription and closes its channel
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		s.bus.mu.Unlock()
+		if s.queue != nil {
+			// the channel is closed by pump, which may be sending on it
+			close(s.queue.done)
+			return
+		}
+		close(s.c)
+	})
+}
@@ -0,0 +1,122 @@
+package bus
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilter_Match(t *testing.T) {
+	m := &Message{Type: DeviceEvent, Device: "device1"}
+	tests := []struct {
+		name   string
+		filter Filter
+		want   bool
+	}{
+		{
+			name: "Empty filter",
+			want: true,
+		},
+		{
+			name:   "Device matches",
+			filter: Filter{Devices: []string{"device2", "device1"}},
+			want:   true,
+		},
+		{
+			name:   "Device does not match",
+			filter: Filter{Devices: []string{"device2"}},
+		},
+		{
+			name:   "Type matches",
+			filter: Filter{Types: []Type{DeviceEvent}},
+			want:   true,
+		},
+		{
+			name:   "Type does not match",
+			filter: Filter{Types: []Type{DeviceStatus}},
+		},
+		{
+			name:   "Device matches but type does not",
+			filter: Filter{Devices: []string{"device1"}, Types: []Type{DeviceDeleted}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.filter.Match(m))
+		})
+	}
+}
+
+func TestBus(t *testing.T) {
+	b := New()
+	all := b.Subscribe(Filter{})
+	status := b.Subscribe(Filter{Types: []Type{DeviceStatus}})
+
+	b.Publish(&Message{Type: DeviceEvent, Device: "device1"})
+	b.Publish(&Message{Type: DeviceStatus, Device: "device1"})
+
+	assert.Equal(t, DeviceEvent, (<-all.C()).Type)
+	m := <-all.C()
+	assert.Equal(t, DeviceStatus, m.Type)
+	assert.False(t, m.Time.IsZero(), "publishing sets the time")
+	assert.Equal(t, m, <-status.C())
+	assert.Len(t, status.C(), 0)
+
+	status.SetFilter(Filter{Devices: []string{"device2"}})
+	b.Publish(&Message{Type: DeviceStatus, Device: "device1"})
+	assert.Len(t, status.C(), 0)
+
+	status.Close()
+	status.Close()
+	_, ok := <-status.C()
+	assert.False(t, ok, "closing closes the channel")
+	b.Publish(&Message{Type: DeviceStatus, Device: "device2"})
+	assert.Len(t, all.C(), 2)
+
+	// a subscriber that falls behind misses messages instead of blocking the publisher
+	for i := 0; i < DefaultBuffer; i++ {
+		b.Publish(&Message{Type: DeviceEvent, Device: "device1"})
+	}
+	assert.Len(t, all.C(), DefaultBuffer)
+	assert.Equal(t, uint64(2), all.Dropped(), "missed messages are counted")
+	all.Close()
+
+	var nilBus *Bus
+	nilBus.Publish(&Message{Type: DeviceEvent})
+	for _, sub := range []*Subscription{nilBus.Subscribe(Filter{}), nilBus.SubscribeAll(Filter{})} {
+		_, ok := <-sub.C()
+		assert.False(t, ok, "a subscription of a nil Bus is already closed")
+		sub.Close()
+	}
+}
+
+func TestBus_SubscribeAll(t *testing.T) {
+	b := New()
+	sub := b.SubscribeAll(Filter{Types: []Type{DeviceEvent}})
+	n := DefaultBuffer * 4
+	for i := 0; i < n; i++ {
+		b.Publish(&Message{Type: DeviceEvent, Device: fmt.Sprint(i)})
+		b.Publish(&Message{Type: DeviceStatus})
+	}
+	for i := 0; i < n; i++ {
+		m := <-sub.C()
+		assert.Equal(t, fmt.Sprint(i), m.Device, "no message is missed and they keep their order")
+	}
+	assert.Zero(t, sub.Dropped())
+
+	b.Publish(&Message{Type: DeviceEvent})
+	sub.Close()
+	sub.Close()
+	for range sub.C() {
+	}
+	b.Publish(&Message{Type: DeviceEvent})
+}
+
+func TestParseType(t *testing.T) {
+	typ, err := ParseType("device.status")
+	assert.NoError(t, err)
+	assert.Equal(t, DeviceStatus, typ)
+	_, err = ParseType("device.unknown")
+	assert.Error(t, err)
+}
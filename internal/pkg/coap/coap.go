@@ -0,0 +1,237 @@
+package coap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/logging"
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/udp"
+	"github.com/plgd-dev/go-coap/v2/udp/client"
+	"github.com/plgd-dev/go-coap/v2/udp/message/pool"
+)
+
+// DefaultPort is the port a device is called on when its address has none
+const DefaultPort = "5683"
+
+// observeTimeout bounds how long a device may take to accept an observation
+const observeTimeout = 5 * time.Second
+
+// EventHandler handles an event a device sent through one of its inbound services, with the secret it was sent with
+type EventHandler func(id string, service string, secret string, body []byte) error
+
+/*
+Event defines the JSON schema of a notification a device sends on an observed inbound service :
+	Secret	`secret`	: Device secret given on its first connection
+	Body	`body`		: JSON body of the event, keyed the same way as an event pushed over HTTP
+*/
+type Event struct {
+	Secret string          `json:"secret"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// observation is a connection to a device that observes its inbound services
+type observation struct {
+	conn         *client.ClientConn
+	observations []*client.Observation
+}
+
+// cancel cancels the observations and closes the connection
+func (o *observation) cancel() {
+	for _, obs := range o.observations {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		obs.Cancel(ctx)
+		cancel()
+	}
+	o.conn.Close()
+}
+
+// Transport carries service calls to devices as confirmable CoAP requests to coap://[endpoint]/[service],
+// a GET with the query as URI queries or a POST with the JSON body, and observes the inbound services of devices
+type Transport struct {
+	onEvent EventHandler
+
+	mu           sync.Mutex
+	observations map[string]*observation
+}
+
+// NewTransport makes a Transport, a nil onEvent ignores events
+func NewTransport(onEvent EventHandler) *Transport {
+	return &Transport{
+		onEvent:      onEvent,
+		observations: make(map[string]*observation),
+	}
+}
+
+// endpoint returns the endpoint of a device, which has the coap scheme or none
+func endpoint(d *device.Device) (*device.Endpoint, error) {
+	e, err := d.Endpoint()
+	if err != nil {
+		return nil, err
+	}
+	if e.Scheme != "" && e.Scheme != "coap" {
+		return nil, fmt.Errorf("%v cannot be called over CoAP", e)
+	}
+	return e, nil
+}
+
+// dial connects to a device endpoint, on DefaultPort when it has no port, errors of the connection are logged
+func dial(d *device.Device, e *device.Endpoint) (*client.ClientConn, error) {
+	return udp.Dial(e.HostPortOr(DefaultPort), udp.WithErrors(func(err error) {
+		logging.Warnf("CoAP error of device %v : %v\n", d.ID, err)
+	}))
+}
+
+// queryOptions makes the URI query options of a URL query, e.g. arg0=1&arg1=2
+func queryOptions(query string) message.Options {
+	var opts message.Options
+	for _, q := range strings.Split(query, "&") {
+		if q != "" {
+			opts = append(opts, message.Option{ID: message.URIQuery, Value: []byte(q)})
+		}
+	}
+	return opts
+}
+
+// statusError makes the error of a device answering with a code that is not a success, server errors are temporary
+func statusError(code codes.Code) *device.StatusError {
+	return &device.StatusError{
+		Status:    fmt.Sprintf("%d.%02d %v", code>>5, code&0x1f, code),
+		Temporary: code>>5 == 5,
+	}
+}
+
+// RoundTrip calls a device service over CoAP
+func (t *Transport) RoundTrip(ctx context.Context, d *device.Device, req *device.Request) ([]byte, error) {
+	e, err := endpoint(d)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := dial(d, e)
+	if err != nil {
+		return nil, fmt.Errorf("%w : %v", device.ErrUnreachable, err)
+	}
+	defer conn.Close()
+
+	path := e.Path + "/" + req.Service
+	var resp *pool.Message
+	if req.Body == nil {
+		logging.Debugf("calling to coap://%v%v\n", e.HostPortOr(DefaultPort), path)
+		resp, err = conn.Get(ctx, path, queryOptions(req.Query)...)
+	} else {
+		logging.Debugf("posting to coap://%v%v\n", e.HostPortOr(DefaultPort), path)
+		resp, err = conn.Post(ctx, path, message.AppJSON, bytes.NewReader(req.Body))
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w : %v", device.ErrUnreachable, err)
+	}
+	defer pool.ReleaseMessage(resp)
+	if resp.Code()>>5 != 2 {
+		return nil, statusError(resp.Code())
+	}
+	return resp.ReadBody()
+}
+
+// Observe observes the inbound services of a device, replacing what was observed of it before,
+// a notification is given to the EventHandler but the answer to the observation is the current state and is not an event
+func (t *Transport) Observe(d *device.Device) error {
+	t.Forget(d.ID.String())
+	var services []string
+	for _, s := range d.Services {
+		if s.Inbound {
+			services = append(services, s.Name)
+		}
+	}
+	if len(services) == 0 {
+		return nil
+	}
+	e, err := endpoint(d)
+	if err != nil {
+		return err
+	}
+	conn, err := dial(d, e)
+	if err != nil {
+		return err
+	}
+	o := &observation{conn: conn}
+	for _, service := range services {
+		ctx, cancel := context.WithTimeout(context.Background(), observeTimeout)
+		obs, err := conn.Observe(ctx, e.Path+"/"+service, t.notify(d.ID.String(), service))
+		cancel()
+		if err != nil {
+			o.cancel()
+			return fmt.Errorf("cannot observe service %v : %w", service, err)
+		}
+		o.observations = append(o.observations, obs)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if old, ok := t.observations[d.ID.String()]; ok {
+		// the device was observed again while its services were being observed
+		old.cancel()
+	}
+	t.observations[d.ID.String()] = o
+	return nil
+}
+
+// Forget stops observing a device
+func (t *Transport) Forget(id string) {
+	t.mu.Lock()
+	o, ok := t.observations[id]
+	delete(t.observations, id)
+	t.mu.Unlock()
+	if ok {
+		o.cancel()
+	}
+}
+
+// Close stops observing every device
+func (t *Transport) Close() {
+	t.mu.Lock()
+	observations := t.observations
+	t.observations = make(map[string]*observation)
+	t.mu.Unlock()
+	for _, o := range observations {
+		o.cancel()
+	}
+}
+
+// notify makes the handler of the notifications of an observed service
+func (t *Transport) notify(id string, service string) func(*pool.Message) {
+	var observed uint32
+	return func(msg *pool.Message) {
+		if atomic.CompareAndSwapUint32(&observed, 0, 1) || t.onEvent == nil {
+			return
+		}
+		if msg.Code()>>5 != 2 {
+			logging.Infof("Observation of service %v of device %v ended : %v\n", service, id, statusError(msg.Code()).Status)
+			return
+		}
+		payload, err := msg.ReadBody()
+		if err != nil {
+			logging.Warnf("Invalid event from device %v : %v\n", id, err)
+			return
+		}
+		var e Event
+		err = json.Unmarshal(payload, &e)
+		if err != nil {
+			logging.Warnf("Invalid event from device %v : %v\n", id, err)
+			return
+		}
+		err = t.onEvent(id, service, e.Secret, e.Body)
+		if err != nil {
+			logging.Errorf("Cannot handle event %v of device %v : %v\n", service, id, err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package coap
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/plgd-dev/go-coap/v2/message"
+	"github.com/plgd-dev/go-coap/v2/message/codes"
+	"github.com/plgd-dev/go-coap/v2/mux"
+	coapNet "github.com/plgd-dev/go-coap/v2/net"
+	"github.com/plgd-dev/go-coap/v2/udp"
+	"github.com/stretchr/testify/assert"
+)
+
+// notification is the event the test server notifies observers of pressed with
+const notification = `{"secret":"s3cret","body":{"Count":2}}`
+
+// observeOptions makes the options of an observe notification
+func observeOptions(seq uint32) message.Options {
+	buf := make([]byte, 16)
+	opts, _, _ := message.Options{}.SetObserve(buf, seq)
+	opts, _, _ = opts.SetContentFormat(buf[4:], message.AppJSON)
+	return opts
+}
+
+// newTestServer starts a local CoAP server that answers on with what it was called with,
+// off with a server error, and notifies observers of pressed once
+func newTestServer(t *testing.T) net.Addr {
+	r := mux.NewRouter()
+	r.HandleFunc("/on", func(w mux.ResponseWriter, req *mux.Message) {
+		assert.True(t, req.IsConfirmable, "calls are confirmable")
+		if req.Code == codes.POST {
+			body, _ := ioutil.ReadAll(req.Body)
+			w.SetResponse(codes.Changed, message.AppJSON, bytes.NewReader(body))
+			return
+		}
+		queries, _ := req.Options.Queries()
+		w.SetResponse(codes.Content, message.TextPlain, strings.NewReader(strings.Join(queries, "&")))
+	})
+	r.HandleFunc("/off", func(w mux.ResponseWriter, req *mux.Message) {
+		w.SetResponse(codes.ServiceUnavailable, message.TextPlain, nil)
+	})
+	r.HandleFunc("/pressed", func(w mux.ResponseWriter, req *mux.Message) {
+		if obs, err := req.Options.Observe(); err != nil || obs != 0 {
+			w.SetResponse(codes.Content, message.AppJSON, strings.NewReader(`{}`))
+			return
+		}
+		cc := w.Client()
+		token := req.Token
+		w.SetResponse(codes.Content, message.AppJSON, strings.NewReader(`{}`), observeOptions(1)...)
+		go func() {
+			time.Sleep(50 * time.Millisecond)
+			cc.WriteMessage(&message.Message{
+				Context: cc.Context(),
+				Token:   token,
+				Code:    codes.Content,
+				Options: observeOptions(2),
+				Body:    strings.NewReader(notification),
+			})
+		}()
+	})
+
+	l, err := coapNet.NewListenUDP("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := udp.NewServer(udp.WithMux(r))
+	go s.Serve(l)
+	t.Cleanup(func() {
+		s.Stop()
+		l.Close()
+	})
+	return l.LocalAddr()
+}
+
+func TestTransport(t *testing.T) {
+	addr := newTestServer(t)
+	type pushedEvent struct {
+		id      string
+		service string
+		secret  string
+		body    string
+	}
+	events := make(chan pushedEvent, 1)
+	tr := NewTransport(func(id string, service string, secret string, body []byte) error {
+		events <- pushedEvent{id: id, service: service, secret: secret, body: string(body)}
+		return nil
+	})
+	defer tr.Close()
+	dev, err := device.NewDevice("Button", addr, []byte(`def outbound on(int32);def outbound off();def outbound missing();message Press{int32 Count;}def inbound pressed(Press);`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.Transport = device.TransportCoAP
+	opts := device.CallOptions{
+		Timeout:    time.Second,
+		Transports: map[string]device.Transport{device.TransportCoAP: tr},
+	}
+
+	body, err := dev.Call(context.Background(), "on", "arg0=80&arg1=1", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "arg0=80&arg1=1", string(body))
+
+	body, err = dev.CallJSON(context.Background(), "on", []byte(`{"arg0":80}`), opts)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"arg0":80}`, string(body))
+
+	_, err = dev.Call(context.Background(), "off", "", opts)
+	assert.True(t, errors.Is(err, device.ErrBadStatus), err)
+	assert.Contains(t, err.Error(), "5.03 ServiceUnavailable")
+
+	_, err = dev.Call(context.Background(), "missing", "", opts)
+	assert.True(t, errors.Is(err, device.ErrBadStatus), err)
+	assert.Contains(t, err.Error(), "4.04 NotFound")
+
+	other := *dev
+	other.Addr = &device.Endpoint{Scheme: "http", Host: "127.0.0.1"}
+	_, err = other.Call(context.Background(), "on", "", opts)
+	assert.Error(t, err, "a http endpoint is not called over CoAP")
+
+	t.Run("Observe", func(t *testing.T) {
+		assert.NoError(t, opts.Observe(dev))
+		select {
+		case e := <-events:
+			assert.Equal(t, pushedEvent{id: dev.ID.String(), service: "pressed", secret: "s3cret", body: `{"Count":2}`}, e)
+		case <-time.After(2 * time.Second):
+			t.Fatal("event was not handled")
+		}
+		select {
+		case e := <-events:
+			t.Fatalf("the answer to the observation is not an event : %v", e)
+		case <-time.After(100 * time.Millisecond):
+		}
+		opts.Forget(dev.ID.String())
+		assert.Empty(t, tr.observations)
+	})
+}
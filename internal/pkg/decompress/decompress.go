@@ -0,0 +1,114 @@
+package decompress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+)
+
+// MaxSize is the maximum size of a decompressed payload, to keep a small payload from expanding without bound
+const MaxSize = 1 << 20
+
+// ErrUnknownAlgorithm is returned when no decompressor is registered for an algorithm
+var ErrUnknownAlgorithm = errors.New("unknown decompression algorithm")
+
+// ErrTooLarge is returned when a decompressed payload is bigger than MaxSize
+var ErrTooLarge = errors.New("decompressed payload is too large")
+
+// Decompressor decompresses a payload as it is received in the connect JSON
+type Decompressor func(payload string) ([]byte, error)
+
+var (
+	mu            sync.RWMutex
+	decompressors = make(map[string]Decompressor)
+)
+
+func init() {
+	Register("none", func(payload string) ([]byte, error) {
+		return []byte(payload), nil
+	})
+	Register("base64", decodeBase64)
+	Register("gzip", withBase64(func(r io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(r)
+	}))
+	Register("zlib", withBase64(zlib.NewReader))
+	Register("deflate", withBase64(func(r io.Reader) (io.ReadCloser, error) {
+		return flate.NewReader(r), nil
+	}))
+}
+
+// Register registers a decompressor for an algorithm, replacing any registered one
+func Register(algo string, d Decompressor) {
+	mu.Lock()
+	defer mu.Unlock()
+	decompressors[strings.ToLower(algo)] = d
+}
+
+// Algorithms returns every registered algorithm
+func Algorithms() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	var algos []string
+	for a := range decompressors {
+		algos = append(algos, a)
+	}
+	return algos
+}
+
+// Decompress decompresses a payload with a registered algorithm, an empty algorithm means none
+func Decompress(algo string, payload string) ([]byte, error) {
+	if algo == "" {
+		algo = "none"
+	}
+	mu.RLock()
+	d, ok := decompressors[strings.ToLower(algo)]
+	mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w : %v", ErrUnknownAlgorithm, algo)
+	}
+	return d(payload)
+}
+
+func decodeBase64(payload string) ([]byte, error) {
+	payload = strings.TrimSpace(payload)
+	data, err := base64.StdEncoding.DecodeString(payload)
+	if err != nil {
+		// devices may leave out the padding
+		data, err = base64.RawStdEncoding.DecodeString(strings.TrimRight(payload, "="))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// withBase64 makes a Decompressor of a compressed stream that is transported as base64
+func withBase64(newReader func(io.Reader) (io.ReadCloser, error)) Decompressor {
+	return func(payload string) ([]byte, error) {
+		data, err := decodeBase64(payload)
+		if err != nil {
+			return nil, err
+		}
+		r, err := newReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		out, err := ioutil.ReadAll(io.LimitReader(r, MaxSize+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(out) > MaxSize {
+			return nil, ErrTooLarge
+		}
+		return out, nil
+	}
+}
@@ -0,0 +1,137 @@
+package decompress
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/base64"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testServ = `message TestMessage{string TestString;};def outbound click(TestMessage):string;`
+
+func compress(t *testing.T, newWriter func(io.Writer) io.WriteCloser, data string) string {
+	var buf bytes.Buffer
+	w := newWriter(&buf)
+	_, err := w.Write([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = w.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+func gzipWriter(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }
+
+func zlibWriter(w io.Writer) io.WriteCloser { return zlib.NewWriter(w) }
+
+func flateWriter(w io.Writer) io.WriteCloser {
+	fw, _ := flate.NewWriter(w, flate.BestCompression)
+	return fw
+}
+
+func TestDecompress(t *testing.T) {
+	tests := []struct {
+		name    string
+		algo    string
+		payload func(t *testing.T) string
+		want    string
+		wantErr error
+	}{
+		{
+			name:    "None",
+			algo:    "none",
+			payload: func(t *testing.T) string { return testServ },
+			want:    testServ,
+		},
+		{
+			name:    "Empty algorithm",
+			algo:    "",
+			payload: func(t *testing.T) string { return testServ },
+			want:    testServ,
+		},
+		{
+			name:    "Base64",
+			algo:    "base64",
+			payload: func(t *testing.T) string { return base64.StdEncoding.EncodeToString([]byte(testServ)) },
+			want:    testServ,
+		},
+		{
+			name:    "Base64 without padding",
+			algo:    "base64",
+			payload: func(t *testing.T) string { return base64.RawStdEncoding.EncodeToString([]byte(testServ + "x")) },
+			want:    testServ + "x",
+		},
+		{
+			name:    "Gzip",
+			algo:    "gzip",
+			payload: func(t *testing.T) string { return compress(t, gzipWriter, testServ) },
+			want:    testServ,
+		},
+		{
+			name:    "Zlib",
+			algo:    "ZLIB",
+			payload: func(t *testing.T) string { return compress(t, zlibWriter, testServ) },
+			want:    testServ,
+		},
+		{
+			name:    "Deflate",
+			algo:    "deflate",
+			payload: func(t *testing.T) string { return compress(t, flateWriter, testServ) },
+			want:    testServ,
+		},
+		{
+			name:    "Unknown algorithm",
+			algo:    "lz4",
+			payload: func(t *testing.T) string { return testServ },
+			wantErr: ErrUnknownAlgorithm,
+		},
+		{
+			name:    "Too large",
+			algo:    "gzip",
+			payload: func(t *testing.T) string { return compress(t, gzipWriter, strings.Repeat("a", MaxSize+1)) },
+			wantErr: ErrTooLarge,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Decompress(tt.algo, tt.payload(t))
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "got error %v", err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, string(got))
+		})
+	}
+}
+
+func TestDecompress_Corrupt(t *testing.T) {
+	_, err := Decompress("gzip", "not base64!")
+	assert.Error(t, err)
+	_, err = Decompress("gzip", base64.StdEncoding.EncodeToString([]byte("not gzip")))
+	assert.Error(t, err)
+}
+
+func TestRegister(t *testing.T) {
+	Register("Reverse", func(payload string) ([]byte, error) {
+		b := []byte(payload)
+		for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+			b[i], b[j] = b[j], b[i]
+		}
+		return b, nil
+	})
+	assert.Contains(t, Algorithms(), "reverse")
+	got, err := Decompress("reverse", "cba")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc", string(got))
+}
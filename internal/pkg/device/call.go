@@ -0,0 +1,207 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/logging"
+)
+
+// ErrTimeout is returned when a device does not respond to a call in time
+var ErrTimeout = errors.New("device did not respond in time")
+
+// ErrUnreachable is returned when the hub cannot connect to a device
+var ErrUnreachable = errors.New("device is unreachable")
+
+// ErrBadStatus is returned when a device responds to a call with a non 2xx status
+var ErrBadStatus = errors.New("device responded with an error")
+
+// ErrWrongIdentity is returned when a device that has to prove it is the device cannot, it is not retried
+var ErrWrongIdentity = errors.New("device cannot be verified")
+
+// CallOptions defines how the hub calls a device
+type CallOptions struct {
+	// Timeout bounds a single attempt, the device and service timeouts take precedence over it
+	Timeout time.Duration
+	// Retries is how many times an idempotent call is retried after a failed attempt
+	Retries int
+	// Backoff is the wait before the first retry, doubled after every retry
+	Backoff time.Duration
+	// Deadline bounds a whole call with its retries, it is kept below the request timeout of the hub
+	// so a device that does not answer is answered as timed out instead of the request being cut
+	Deadline time.Duration
+	// Client calls the device, http.DefaultClient if nil
+	Client *http.Client
+	// Transports carries calls to devices by transport name, a device on HTTP is called through Client unless it has one
+	Transports map[string]Transport
+}
+
+// transport returns the transport a device is called through
+func (o CallOptions) transport(name string) (Transport, error) {
+	if t, ok := o.Transports[name]; ok {
+		return t, nil
+	}
+	if name == TransportHTTP {
+		return &HTTPTransport{Client: o.Client}, nil
+	}
+	return nil, fmt.Errorf("%w : the hub has no %v transport", ErrUnreachable, name)
+}
+
+// HasTransport reports whether devices on a transport can be called
+func (o CallOptions) HasTransport(name string) bool {
+	_, err := o.transport(name)
+	return err == nil
+}
+
+// Observe makes the Observer of the device transport watch the device, and every other Observer forget it
+func (o CallOptions) Observe(d *Device) error {
+	var err error
+	for name, t := range o.Transports {
+		obs, ok := t.(Observer)
+		if !ok {
+			continue
+		}
+		if name == d.TransportName() {
+			err = obs.Observe(d)
+		} else {
+			obs.Forget(d.ID.String())
+		}
+	}
+	return err
+}
+
+// Forget makes every Observer stop watching the device of id
+func (o CallOptions) Forget(id string) {
+	for _, t := range o.Transports {
+		if obs, ok := t.(Observer); ok {
+			obs.Forget(id)
+		}
+	}
+}
+
+// DefaultCallOptions is used by a hub that does not configure its calls
+var DefaultCallOptions = CallOptions{
+	Timeout:  5 * time.Second,
+	Retries:  2,
+	Backoff:  200 * time.Millisecond,
+	Deadline: 12 * time.Second,
+}
+
+// CallTimeout returns the timeout of a service call, the service timeout takes precedence over the device timeout, then over def
+func (d *Device) CallTimeout(service string, def time.Duration) time.Duration {
+	if t, ok := d.ServiceTimeouts[service]; ok && t > 0 {
+		return t
+	}
+	if d.Timeout > 0 {
+		return d.Timeout
+	}
+	return def
+}
+
+// Call calls a service with a URL query, a failed attempt is retried as the call is idempotent
+func (d *Device) Call(ctx context.Context, service string, query string, opts CallOptions) ([]byte, error) {
+	return d.do(ctx, &Request{Service: service, Query: query}, opts, opts.Retries)
+}
+
+// CallJSON calls a service with a JSON body, it is never retried as the device may have acted on a failed attempt
+func (d *Device) CallJSON(ctx context.Context, service string, body []byte, opts CallOptions) ([]byte, error) {
+	return d.do(ctx, &Request{Service: service, Body: body}, opts, 0)
+}
+
+// do runs a call through the device transport, retrying a failed attempt up to retries times with an exponential backoff,
+// every attempt ends by the deadline of the call
+func (d *Device) do(ctx context.Context, req *Request, opts CallOptions, retries int) ([]byte, error) {
+	t, err := opts.transport(d.TransportName())
+	if err != nil {
+		return nil, err
+	}
+	if opts.Deadline > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Deadline)
+		defer cancel()
+	}
+	timeout := d.CallTimeout(req.Service, opts.Timeout)
+	backoff := opts.Backoff
+	for attempt := 0; ; attempt++ {
+		body, retry, err := callOnce(ctx, t, d, req, timeout)
+		if err == nil || !retry || attempt >= retries {
+			return body, err
+		}
+		logging.Infof("retrying %v of device %v : %v\n", req.Service, d.ID, err)
+		t := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, fmt.Errorf("%w : %v", ErrTimeout, err)
+			}
+			return nil, ctx.Err()
+		case <-t.C:
+		}
+		backoff *= 2
+	}
+}
+
+// callOnce runs a single attempt, and reports whether a failed attempt may be retried
+func callOnce(ctx context.Context, t Transport, d *Device, req *Request, timeout time.Duration) ([]byte, bool, error) {
+	attemptCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		attemptCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	body, err := t.RoundTrip(attemptCtx, d, req)
+	if err == nil {
+		return body, false, nil
+	}
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		// a device error is only worth retrying when the device says it is temporary
+		return nil, statusErr.Temporary, err
+	}
+	if errors.Is(err, ErrWrongIdentity) {
+		return nil, false, err
+	}
+	return nil, ctx.Err() == nil && networkError(attemptCtx, err), callError(ctx, attemptCtx, err)
+}
+
+// networkError reports whether an attempt failed on the way to the device, a timeout or a network error,
+// which another attempt may not fail on, unlike e.g. an endpoint the transport cannot call
+func networkError(attemptCtx context.Context, err error) bool {
+	if attemptCtx.Err() == context.DeadlineExceeded {
+		return true
+	}
+	var netErr interface{ Timeout() bool }
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return true
+	}
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+	// the device closed the connection before answering, or the transport could not reach it
+	return errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrUnreachable)
+}
+
+// maxResponseSize bounds how much of a device response is read
+const maxResponseSize = 1 << 20
+
+// callError maps a transport error to ErrTimeout or ErrUnreachable, a canceled call keeps the context error
+func callError(ctx context.Context, attemptCtx context.Context, err error) error {
+	if ctx.Err() == context.Canceled {
+		return ctx.Err()
+	}
+	if attemptCtx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("%w : %v", ErrTimeout, err)
+	}
+	var netErr interface{ Timeout() bool }
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return fmt.Errorf("%w : %v", ErrTimeout, err)
+	}
+	return fmt.Errorf("%w : %v", ErrUnreachable, err)
+}
@@ -0,0 +1,185 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestCallDevice(t *testing.T, handler http.HandlerFunc) *Device {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	addr, err := net.ResolveTCPAddr("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, err := NewDevice("Device1", addr, []byte(`def outbound click();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dev
+}
+
+func TestDevice_CallTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		device   *Device
+		expected time.Duration
+	}{
+		{
+			name:     "Hub default",
+			device:   &Device{},
+			expected: time.Second,
+		},
+		{
+			name:     "Device timeout",
+			device:   &Device{Timeout: 2 * time.Second},
+			expected: 2 * time.Second,
+		},
+		{
+			name: "Service timeout",
+			device: &Device{
+				Timeout:         2 * time.Second,
+				ServiceTimeouts: map[string]time.Duration{"click": 3 * time.Second, "other": 4 * time.Second},
+			},
+			expected: 3 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, tt.device.CallTimeout("click", time.Second))
+		})
+	}
+}
+
+func TestDevice_Call(t *testing.T) {
+	opts := CallOptions{Timeout: 100 * time.Millisecond, Retries: 2, Backoff: time.Millisecond}
+	tests := []struct {
+		name         string
+		handler      func(attempt int32, w http.ResponseWriter, r *http.Request)
+		expected     string
+		wantErr      error
+		wantAttempts int32
+	}{
+		{
+			name: "Success",
+			handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, r.URL.RawQuery)
+			},
+			expected:     "a=1",
+			wantAttempts: 1,
+		},
+		{
+			name: "Retried until success",
+			handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				if attempt < 3 {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				fmt.Fprint(w, "ok")
+			},
+			expected:     "ok",
+			wantAttempts: 3,
+		},
+		{
+			name: "Timeout",
+			handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-r.Context().Done():
+				case <-time.After(time.Second):
+				}
+			},
+			wantErr:      ErrTimeout,
+			wantAttempts: 3,
+		},
+		{
+			name: "Client error is not retried",
+			handler: func(attempt int32, w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+			},
+			wantErr:      ErrBadStatus,
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var attempts int32
+			dev := newTestCallDevice(t, func(w http.ResponseWriter, r *http.Request) {
+				tt.handler(atomic.AddInt32(&attempts, 1), w, r)
+			})
+			body, err := dev.Call(context.Background(), "click", "a=1", opts)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), "expected %v, got %v", tt.wantErr, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.expected, string(body))
+			}
+			assert.Equal(t, tt.wantAttempts, atomic.LoadInt32(&attempts))
+		})
+	}
+}
+
+func TestDevice_CallJSON(t *testing.T) {
+	var attempts int32
+	dev := newTestCallDevice(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&attempts, 1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	_, err := dev.CallJSON(context.Background(), "click", []byte(`{}`), CallOptions{Retries: 2})
+	assert.True(t, errors.Is(err, ErrBadStatus))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&attempts), "a POST call must not be retried")
+}
+
+func TestDevice_CallUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr()
+	listener.Close()
+	dev, err := NewDevice("Device1", addr, []byte(`def outbound click();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dev.Call(context.Background(), "click", "", CallOptions{Timeout: time.Second})
+	assert.True(t, errors.Is(err, ErrUnreachable), "expected %v, got %v", ErrUnreachable, err)
+}
+
+func TestDevice_CallCanceled(t *testing.T) {
+	dev := newTestCallDevice(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err := dev.Call(ctx, "click", "", CallOptions{Retries: 5, Backoff: time.Second})
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestDevice_CallDeadline(t *testing.T) {
+	dev := newTestCallDevice(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	})
+	start := time.Now()
+	_, err := dev.Call(context.Background(), "click", "", CallOptions{Timeout: 100 * time.Millisecond, Retries: 5, Backoff: 10 * time.Millisecond, Deadline: 150 * time.Millisecond})
+	assert.True(t, errors.Is(err, ErrTimeout), "expected %v, got %v", ErrTimeout, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second), "the retries end with the deadline of the call")
+}
+
+func TestDevice_CallNotRetried(t *testing.T) {
+	dev, err := NewDevice("Device1", &Endpoint{Scheme: "coap", Host: "127.0.0.1"}, []byte(`def outbound click();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	_, err = dev.Call(context.Background(), "click", "", CallOptions{Retries: 2, Backoff: time.Second})
+	assert.True(t, errors.Is(err, ErrUnreachable), "expected %v, got %v", ErrUnreachable, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second), "an endpoint the transport cannot call is not retried")
+}
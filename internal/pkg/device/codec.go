@@ -0,0 +1,236 @@
+package device
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/IktaS/go-serv/pkg/serv"
+)
+
+// DecodeJSON decodes a JSON document keeping numbers as json.Number, so integers are not rounded through float64
+func DecodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	err := dec.Decode(v)
+	if err != nil {
+		return err
+	}
+	if dec.More() {
+		return fmt.Errorf("unexpected data after JSON value")
+	}
+	return nil
+}
+
+// ValidateScalarJSON checks whether a JSON value decoded with DecodeJSON matches a .serv scalar
+func ValidateScalarJSON(scalar string, v interface{}) bool {
+	switch scalar {
+	case "bool":
+		_, ok := v.(bool)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "bytes":
+		str, ok := v.(string)
+		if !ok {
+			return false
+		}
+		_, err := base64.StdEncoding.DecodeString(str)
+		return err == nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return false
+	}
+	return ValidateScalar(scalar, n.String())
+}
+
+func typeName(t *serv.Type) string {
+	if t.Reference == "" {
+		return t.Scalar.String()
+	}
+	return t.Reference
+}
+
+// ValidateBody validates a JSON body of a service call against the service request,
+// message request fields are keys of the body, nested messages are nested objects,
+// and scalar request parameters are keyed by ScalarParam
+func (d *Device) ValidateBody(s *serv.Service, body map[string]interface{}) ([]*Violation, error) {
+	violations := []*Violation{}
+	known := make(map[string]bool)
+	for i, t := range s.Request {
+		if t == nil {
+			continue
+		}
+		if t.Reference == "" {
+			name := ScalarParam(i)
+			known[name] = true
+			v, ok := body[name]
+			if !ok {
+				violations = append(violations, &Violation{Param: name, Expected: typeName(t), Reason: ReasonMissing})
+				continue
+			}
+			if !ValidateScalarJSON(t.Scalar.String(), v) {
+				violations = append(violations, &Violation{Param: name, Expected: typeName(t), Reason: ReasonInvalid})
+			}
+			continue
+		}
+		v, err := d.validateMessage("", t.Reference, body, true, known, map[string]bool{})
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, v...)
+	}
+	violations = append(violations, unknownKeys("", body, known)...)
+	return violations, nil
+}
+
+func unknownKeys(prefix string, obj map[string]interface{}, known map[string]bool) []*Violation {
+	var unknown []string
+	for k := range obj {
+		if !known[k] {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	var violations []*Violation
+	for _, k := range unknown {
+		violations = append(violations, &Violation{Param: prefix + k, Reason: ReasonUnknown})
+	}
+	return violations
+}
+
+// validateMessage validates obj against the fields of a message, and marks each field name as known
+func (d *Device) validateMessage(prefix string, name string, obj map[string]interface{}, required bool, known map[string]bool, seen map[string]bool) ([]*Violation, error) {
+	if seen[name] {
+		return nil, fmt.Errorf("message %v references itself", name)
+	}
+	m := d.Message(name)
+	if m == nil {
+		return nil, fmt.Errorf("message %v is not defined", name)
+	}
+	seen[name] = true
+	defer delete(seen, name)
+	var violations []*Violation
+	for _, md := range m.Definitions {
+		if md == nil || md.Field == nil || md.Field.Type == nil {
+			continue
+		}
+		f := md.Field
+		known[f.Name] = true
+		fieldRequired := required && !(f.Optional && !f.Required)
+		v, ok := obj[f.Name]
+		if !ok || v == nil {
+			if fieldRequired {
+				violations = append(violations, &Violation{Param: prefix + f.Name, Expected: typeName(f.Type), Reason: ReasonMissing})
+			}
+			continue
+		}
+		if f.Type.Reference == "" {
+			if !ValidateScalarJSON(f.Type.Scalar.String(), v) {
+				violations = append(violations, &Violation{Param: prefix + f.Name, Expected: typeName(f.Type), Reason: ReasonInvalid})
+			}
+			continue
+		}
+		sub, ok := v.(map[string]interface{})
+		if !ok {
+			violations = append(violations, &Violation{Param: prefix + f.Name, Expected: typeName(f.Type), Reason: ReasonInvalid})
+			continue
+		}
+		subKnown := make(map[string]bool)
+		subViolations, err := d.validateMessage(prefix+f.Name+".", f.Type.Reference, sub, true, subKnown, seen)
+		if err != nil {
+			return nil, err
+		}
+		violations = append(violations, subViolations...)
+		violations = append(violations, unknownKeys(prefix+f.Name+".", sub, subKnown)...)
+	}
+	return violations, nil
+}
+
+// DecodeResponse decodes a device response according to the service response type,
+// a message response keeps only the declared fields, returns nil for a service without response
+func (d *Device) DecodeResponse(s *serv.Service, raw []byte) (interface{}, error) {
+	if s.Response == nil {
+		return nil, nil
+	}
+	var v interface{}
+	err := DecodeJSON(raw, &v)
+	if err != nil {
+		if s.Response.Reference == "" {
+			// scalar responses may come as plain text, decodeValue parses it as the scalar
+			v = strings.TrimSpace(string(raw))
+		} else {
+			return nil, fmt.Errorf("response is not JSON : %v", err)
+		}
+	}
+	if _, ok := v.(string); !ok && s.Response.Reference == "" && s.Response.Scalar.String() == "string" {
+		// a plain text string response that happens to be valid JSON, e.g. 42
+		v = strings.TrimSpace(string(raw))
+	}
+	return d.decodeValue(s.Response, v, map[string]bool{})
+}
+
+func (d *Device) decodeValue(t *serv.Type, v interface{}, seen map[string]bool) (interface{}, error) {
+	if t.Reference == "" {
+		scalar := t.Scalar.String()
+		if n, ok := v.(json.Number); ok && scalar == "bool" {
+			// devices may answer a bool as 1 or 0
+			v = n.String()
+		}
+		if str, ok := v.(string); ok && scalar != "string" && scalar != "bytes" {
+			// a scalar sent as a JSON string, e.g. "true" or "42"
+			if scalar == "bool" {
+				b, err := strconv.ParseBool(str)
+				if err != nil {
+					return nil, fmt.Errorf("%q is not a %v", str, scalar)
+				}
+				v = b
+			} else {
+				v = json.Number(str)
+			}
+		}
+		if !ValidateScalarJSON(scalar, v) {
+			return nil, fmt.Errorf("%v is not a %v", v, scalar)
+		}
+		return v, nil
+	}
+	if seen[t.Reference] {
+		return nil, fmt.Errorf("message %v references itself", t.Reference)
+	}
+	m := d.Message(t.Reference)
+	if m == nil {
+		return nil, fmt.Errorf("message %v is not defined", t.Reference)
+	}
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%v is not a %v message", v, t.Reference)
+	}
+	seen[t.Reference] = true
+	defer delete(seen, t.Reference)
+	out := make(map[string]interface{})
+	for _, md := range m.Definitions {
+		if md == nil || md.Field == nil || md.Field.Type == nil {
+			continue
+		}
+		f := md.Field
+		fv, ok := obj[f.Name]
+		if !ok || fv == nil {
+			if !(f.Optional && !f.Required) {
+				return nil, fmt.Errorf("%v.%v is missing", t.Reference, f.Name)
+			}
+			continue
+		}
+		decoded, err := d.decodeValue(f.Type, fv, seen)
+		if err != nil {
+			return nil, fmt.Errorf("%v.%v : %v", t.Reference, f.Name, err)
+		}
+		out[f.Name] = decoded
+	}
+	return out, nil
+}
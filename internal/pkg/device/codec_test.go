@@ -0,0 +1,174 @@
+package device
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newCodecDevice(t *testing.T) *Device {
+	addr := &net.TCPAddr{
+		IP:   net.IPv4(127, 0, 0, 1),
+		Port: 80,
+	}
+	dev, err := NewDevice("Device1", addr, []byte(`
+		message Color{uint32 R; uint32 G; uint32 B;};
+		message Light{bool On; optional Color Color; optional string Label;};
+		def outbound set(Light, int64):Light;
+		def outbound level():double;
+		def outbound name():string;
+		def outbound on():bool;
+		def outbound click();
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dev
+}
+
+func TestDevice_ValidateBody(t *testing.T) {
+	dev := newCodecDevice(t)
+	tests := []struct {
+		name     string
+		body     string
+		expected []*Violation
+	}{
+		{
+			name:     "Valid",
+			body:     `{"On":true,"Color":{"R":1,"G":2,"B":3},"arg1":9007199254740993}`,
+			expected: []*Violation{},
+		},
+		{
+			name:     "Optional field left out",
+			body:     `{"On":false,"arg1":1}`,
+			expected: []*Violation{},
+		},
+		{
+			name: "Wrong types",
+			body: `{"On":"yes","Color":{"R":1.5,"G":2},"Label":3,"arg1":"1"}`,
+			expected: []*Violation{
+				{Param: "On", Expected: "bool", Reason: ReasonInvalid},
+				{Param: "Color.R", Expected: "uint32", Reason: ReasonInvalid},
+				{Param: "Color.B", Expected: "uint32", Reason: ReasonMissing},
+				{Param: "Label", Expected: "string", Reason: ReasonInvalid},
+				{Param: "arg1", Expected: "int64", Reason: ReasonInvalid},
+			},
+		},
+		{
+			name: "Missing and unknown",
+			body: `{"Color":{"R":1,"G":2,"B":3,"A":4},"Brightness":1}`,
+			expected: []*Violation{
+				{Param: "arg1", Expected: "int64", Reason: ReasonMissing},
+				{Param: "On", Expected: "bool", Reason: ReasonMissing},
+				{Param: "Color.A", Reason: ReasonUnknown},
+				{Param: "Brightness", Reason: ReasonUnknown},
+			},
+		},
+		{
+			name: "Nested message is not an object",
+			body: `{"On":true,"Color":"red","arg1":1}`,
+			expected: []*Violation{
+				{Param: "Color", Expected: "Color", Reason: ReasonInvalid},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body map[string]interface{}
+			err := DecodeJSON([]byte(tt.body), &body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			violations, err := dev.ValidateBody(dev.Service("set"), body)
+			assert.NoError(t, err)
+			assert.ElementsMatch(t, tt.expected, violations)
+		})
+	}
+}
+
+func TestDevice_DecodeResponse(t *testing.T) {
+	dev := newCodecDevice(t)
+	tests := []struct {
+		name     string
+		service  string
+		raw      string
+		expected string
+		wantErr  bool
+	}{
+		{
+			name:     "Message keeps declared fields",
+			service:  "set",
+			raw:      `{"On":true,"Color":{"R":1,"G":2,"B":3},"Debug":"x"}`,
+			expected: `{"On":true,"Color":{"R":1,"G":2,"B":3}}`,
+		},
+		{
+			name:     "Message with scalar as string",
+			service:  "set",
+			raw:      `{"On":"true"}`,
+			expected: `{"On":true}`,
+		},
+		{
+			name:    "Message missing a required field",
+			service: "set",
+			raw:     `{"Color":{"R":1,"G":2,"B":3}}`,
+			wantErr: true,
+		},
+		{
+			name:    "Message that is not JSON",
+			service: "set",
+			raw:     `on`,
+			wantErr: true,
+		},
+		{
+			name:     "Plain text double",
+			service:  "level",
+			raw:      "0.75\n",
+			expected: `0.75`,
+		},
+		{
+			name:    "Invalid double",
+			service: "level",
+			raw:     "high",
+			wantErr: true,
+		},
+		{
+			name:     "Plain text string",
+			service:  "name",
+			raw:      "Living Room",
+			expected: `"Living Room"`,
+		},
+		{
+			name:     "Plain text string that looks like a number",
+			service:  "name",
+			raw:      "42",
+			expected: `"42"`,
+		},
+		{
+			name:     "Plain text bool",
+			service:  "on",
+			raw:      "1",
+			expected: `true`,
+		},
+		{
+			name:     "No response",
+			service:  "click",
+			raw:      "ok",
+			expected: `null`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v, err := dev.DecodeResponse(dev.Service(tt.service), []byte(tt.raw))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			out, err := json.Marshal(v)
+			assert.NoError(t, err)
+			assert.JSONEq(t, tt.expected, string(out))
+		})
+	}
+}
@@ -1,12 +1,8 @@
 package device
 
 import (
-	"fmt"
-	"io/ioutil"
-	"log"
 	"net"
-	"net/http"
-	"net/url"
+	"time"
 
 	"github.com/IktaS/go-serv/pkg/serv"
 	"github.com/google/uuid"
@@ -24,20 +20,30 @@ func readService(input []byte) (*serv.Gserv, error) {
 	return srv, err
 }
 
-// Device defines a device id, and it's respective message and services
+// Device defines a device id, and it's respective message and services,
+// Timeout and ServiceTimeouts override the hub call timeout for the device and for a service by name,
+// Status and LastSeen are kept by the health monitor, a zero LastSeen means the device was never seen,
+// SecretHash is the hash of the secret the device authenticates with, empty for a device that has none yet,
+// Transport is the transport the device is called through, empty for HTTP
 type Device struct {
-	ID       uuid.UUID
-	Name     string
-	Addr     net.Addr
-	Services []*serv.Service
-	Messages []*serv.Message
+	ID              uuid.UUID
+	Name            string
+	Addr            net.Addr
+	Services        []*serv.Service
+	Messages        []*serv.Message
+	Timeout         time.Duration
+	ServiceTimeouts map[string]time.Duration
+	Status          Status
+	LastSeen        time.Time
+	SecretHash      string
+	Transport       string
 }
 
-// NewDevice creates a new device by accepting a service definiton
-func NewDevice(name string, address net.Addr, s []byte) (*Device, error) {
+// ParseDefinition parses a service definition into its services and messages
+func ParseDefinition(s []byte) ([]*serv.Service, []*serv.Message, error) {
 	srv, err := readService(s)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	var services []*serv.Service
 	var messages []*serv.Message
@@ -48,6 +54,15 @@ func NewDevice(name string, address net.Addr, s []byte) (*Device, error) {
 			messages = append(messages, def.Message)
 		}
 	}
+	return services, messages, nil
+}
+
+// NewDevice creates a new device by accepting a service definiton
+func NewDevice(name string, address net.Addr, s []byte) (*Device, error) {
+	services, messages, err := ParseDefinition(s)
+	if err != nil {
+		return nil, err
+	}
 	dev := &Device{
 		ID:       uuid.New(),
 		Name:     name,
@@ -58,26 +73,14 @@ func NewDevice(name string, address net.Addr, s []byte) (*Device, error) {
 	return dev, nil
 }
 
-// Call calls a service with a data
-func (d *Device) Call(service string, query string) ([]byte, error) {
-	connectionString := fmt.Sprintf("http://%v/%v?%v", d.Addr.String(), service, query)
-	u, err := url.Parse(connectionString)
-	if err != nil {
-		return nil, err
-	}
-
-	if u.Scheme == "" || u.Host == "" || u.Path == "" {
-		return nil, fmt.Errorf("Invalid URL")
-	}
-	log.Println("calling to " + connectionString)
-	resp, err := http.Get(connectionString)
-	if err != nil {
-		return nil, err
-	}
-	defer resp.Body.Close()
-	body, err := ioutil.ReadAll(resp.Body)
+// SetDefinition replaces the device services and messages with a new service definition, and returns what changed
+func (d *Device) SetDefinition(s []byte) (*Changes, error) {
+	services, messages, err := ParseDefinition(s)
 	if err != nil {
 		return nil, err
 	}
-	return body, nil
+	changes := Diff(d, services, messages)
+	d.Services = services
+	d.Messages = messages
+	return changes, nil
 }
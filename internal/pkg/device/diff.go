@@ -0,0 +1,131 @@
+package device
+
+import "github.com/IktaS/go-serv/pkg/serv"
+
+// Changes defines what changed between two service definitions of a device, by service and message name
+type Changes struct {
+	AddedServices   []string `json:"addedServices"`
+	RemovedServices []string `json:"removedServices"`
+	ChangedServices []string `json:"changedServices"`
+	AddedMessages   []string `json:"addedMessages"`
+	RemovedMessages []string `json:"removedMessages"`
+	ChangedMessages []string `json:"changedMessages"`
+}
+
+// Empty reports whether nothing changed
+func (c *Changes) Empty() bool {
+	return len(c.AddedServices) == 0 && len(c.RemovedServices) == 0 && len(c.ChangedServices) == 0 &&
+		len(c.AddedMessages) == 0 && len(c.RemovedMessages) == 0 && len(c.ChangedMessages) == 0
+}
+
+// Diff compares the device current services and messages against new ones
+func Diff(d *Device, services []*serv.Service, messages []*serv.Message) *Changes {
+	c := &Changes{
+		AddedServices:   []string{},
+		RemovedServices: []string{},
+		ChangedServices: []string{},
+		AddedMessages:   []string{},
+		RemovedMessages: []string{},
+		ChangedMessages: []string{},
+	}
+
+	oldServices := make(map[string]*serv.Service)
+	for _, s := range d.Services {
+		if s != nil {
+			oldServices[s.Name] = s
+		}
+	}
+	for _, s := range services {
+		if s == nil {
+			continue
+		}
+		old, ok := oldServices[s.Name]
+		if !ok {
+			c.AddedServices = append(c.AddedServices, s.Name)
+			continue
+		}
+		if !equalService(old, s) {
+			c.ChangedServices = append(c.ChangedServices, s.Name)
+		}
+		delete(oldServices, s.Name)
+	}
+	for _, s := range d.Services {
+		if s == nil {
+			continue
+		}
+		if _, ok := oldServices[s.Name]; ok {
+			c.RemovedServices = append(c.RemovedServices, s.Name)
+		}
+	}
+
+	oldMessages := make(map[string]*serv.Message)
+	for _, m := range d.Messages {
+		if m != nil {
+			oldMessages[m.Name] = m
+		}
+	}
+	for _, m := range messages {
+		if m == nil {
+			continue
+		}
+		old, ok := oldMessages[m.Name]
+		if !ok {
+			c.AddedMessages = append(c.AddedMessages, m.Name)
+			continue
+		}
+		if !equalMessage(old, m) {
+			c.ChangedMessages = append(c.ChangedMessages, m.Name)
+		}
+		delete(oldMessages, m.Name)
+	}
+	for _, m := range d.Messages {
+		if m == nil {
+			continue
+		}
+		if _, ok := oldMessages[m.Name]; ok {
+			c.RemovedMessages = append(c.RemovedMessages, m.Name)
+		}
+	}
+	return c
+}
+
+func equalType(a, b *serv.Type) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Reference == b.Reference && (a.Reference != "" || a.Scalar == b.Scalar)
+}
+
+func equalService(a, b *serv.Service) bool {
+	if a.Inbound != b.Inbound || a.Outbound != b.Outbound || !equalType(a.Response, b.Response) {
+		return false
+	}
+	if len(a.Request) != len(b.Request) {
+		return false
+	}
+	for i := range a.Request {
+		if !equalType(a.Request[i], b.Request[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func equalField(a, b *serv.Field) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Name == b.Name && a.Optional == b.Optional && a.Required == b.Required && equalType(a.Type, b.Type)
+}
+
+func equalMessage(a, b *serv.Message) bool {
+	if len(a.Definitions) != len(b.Definitions) {
+		return false
+	}
+	for i := range a.Definitions {
+		if !equalField(a.Definitions[i].Field, b.Definitions[i].Field) {
+			return false
+		}
+	}
+	return true
+}
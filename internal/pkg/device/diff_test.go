@@ -0,0 +1,89 @@
+package device
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDevice_SetDefinition(t *testing.T) {
+	tests := []struct {
+		name     string
+		old      string
+		new      string
+		expected *Changes
+		wantErr  bool
+	}{
+		{
+			name: "Nothing changed",
+			old:  `message TestMessage{string TestString;};def outbound click(TestMessage):string;`,
+			new:  `message TestMessage{string TestString;};def outbound click(TestMessage):string;`,
+			expected: &Changes{
+				AddedServices:   []string{},
+				RemovedServices: []string{},
+				ChangedServices: []string{},
+				AddedMessages:   []string{},
+				RemovedMessages: []string{},
+				ChangedMessages: []string{},
+			},
+		},
+		{
+			name: "Firmware update",
+			old:  `message TestMessage{string TestString;};message Gone{string A;};def outbound click(TestMessage):string;def outbound reset();`,
+			new:  `message TestMessage{int32 TestString;};def outbound click(TestMessage):string;def outbound on();def inbound reset();`,
+			expected: &Changes{
+				AddedServices:   []string{"on"},
+				RemovedServices: []string{},
+				ChangedServices: []string{"reset"},
+				AddedMessages:   []string{},
+				RemovedMessages: []string{"Gone"},
+				ChangedMessages: []string{"TestMessage"},
+			},
+		},
+		{
+			name: "Service removed",
+			old:  `def outbound click();def outbound on(string);`,
+			new:  `def outbound on(string, int32);`,
+			expected: &Changes{
+				AddedServices:   []string{},
+				RemovedServices: []string{"click"},
+				ChangedServices: []string{"on"},
+				AddedMessages:   []string{},
+				RemovedMessages: []string{},
+				ChangedMessages: []string{},
+			},
+		},
+		{
+			name:    "Invalid definition",
+			old:     `def outbound click();`,
+			new:     `this is not serv`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr := &net.TCPAddr{
+				IP:   net.IPv4(127, 0, 0, 1),
+				Port: 80,
+			}
+			dev, err := NewDevice("Device1", addr, []byte(tt.old))
+			if err != nil {
+				t.Fatal(err)
+			}
+			old := *dev
+			changes, err := dev.SetDefinition([]byte(tt.new))
+			if tt.wantErr {
+				assert.Error(t, err)
+				assert.Equal(t, old, *dev)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, changes)
+			assert.Equal(t, tt.expected.Empty(), changes.Empty())
+			services, messages, _ := ParseDefinition([]byte(tt.new))
+			assert.Equal(t, services, dev.Services)
+			assert.Equal(t, messages, dev.Messages)
+		})
+	}
+}
@@ -0,0 +1,127 @@
+package device
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Endpoint is the full address a device is called on, written [scheme://]host[:port][/path],
+// the host is a hostname, an IPv4 address or an IPv6 address, in brackets when it has a port
+type Endpoint struct {
+	// Scheme is the scheme the device is called with, e.g. https, empty for the default of its transport
+	Scheme string
+	Host   string
+	// Port is empty for the default port of the scheme or transport
+	Port string
+	// Path is the base path the services are under, e.g. /api, empty when they are at the root
+	Path string
+}
+
+// ParseEndpoint parses an endpoint, e.g. 192.168.1.5, [fe80::1]:8080, lamp.local/api or https://lamp.local:8443
+func ParseEndpoint(s string) (*Endpoint, error) {
+	e := &Endpoint{}
+	if strings.Contains(s, "://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			return nil, fmt.Errorf("%v is not an endpoint", s)
+		}
+		e.Scheme = strings.ToLower(u.Scheme)
+		e.Host = u.Hostname()
+		e.Port = u.Port()
+		e.Path = u.Path
+	} else {
+		hostport := s
+		if i := strings.Index(s, "/"); i >= 0 {
+			hostport, e.Path = s[:i], s[i:]
+		}
+		host, port, err := net.SplitHostPort(hostport)
+		if err != nil {
+			// a host without port, an IPv6 address may be given without brackets
+			host, port = strings.TrimSuffix(strings.TrimPrefix(hostport, "["), "]"), ""
+		}
+		e.Host, e.Port = host, port
+	}
+	e.Path = strings.TrimRight(e.Path, "/")
+	if !validHost(e.Host) {
+		return nil, fmt.Errorf("%v is not a hostname or an IP address", e.Host)
+	}
+	if e.Port != "" {
+		port, err := strconv.Atoi(e.Port)
+		if err != nil || port <= 0 || port > 65535 {
+			return nil, fmt.Errorf("%v is not a valid port", e.Port)
+		}
+	}
+	return e, nil
+}
+
+// validHost reports whether host is empty, an IP address, with a zone for IPv6, or a hostname
+func validHost(host string) bool {
+	if i := strings.LastIndex(host, "%"); i >= 0 && strings.Contains(host, ":") {
+		host = host[:i]
+	}
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	for _, c := range host {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// Network returns the scheme of the endpoint, an Endpoint is the net.Addr of a device
+func (e *Endpoint) Network() string {
+	return e.Scheme
+}
+
+// HostPort returns the host and port of the endpoint, the host alone when it has no port
+func (e *Endpoint) HostPort() string {
+	if e.Port != "" {
+		return net.JoinHostPort(e.Host, e.Port)
+	}
+	if strings.Contains(e.Host, ":") {
+		return "[" + e.Host + "]"
+	}
+	return e.Host
+}
+
+// URLHost returns the host and port of the endpoint as the host of a URL, with the zone of an IPv6 address escaped
+func (e *Endpoint) URLHost() string {
+	return strings.Replace(e.HostPort(), "%", "%25", 1)
+}
+
+// HostPortOr returns the host and port of the endpoint, with port when it has none
+func (e *Endpoint) HostPortOr(port string) string {
+	if e.Port != "" {
+		port = e.Port
+	}
+	return net.JoinHostPort(e.Host, port)
+}
+
+// String returns the endpoint as it is parsed by ParseEndpoint
+func (e *Endpoint) String() string {
+	s := e.HostPort() + e.Path
+	if e.Scheme != "" {
+		s = e.Scheme + "://" + e.URLHost() + e.Path
+	}
+	return s
+}
+
+// Endpoint returns the endpoint the device is called on, parsed from its address when it is not an Endpoint
+func (d *Device) Endpoint() (*Endpoint, error) {
+	if d.Addr == nil {
+		return nil, errors.New("the device has no address")
+	}
+	if e, ok := d.Addr.(*Endpoint); ok {
+		return e, nil
+	}
+	return ParseEndpoint(d.Addr.String())
+}
@@ -0,0 +1,155 @@
+package device
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEndpoint(t *testing.T) {
+	tests := []struct {
+		name    string
+		s       string
+		want    *Endpoint
+		wantErr bool
+	}{
+		{
+			name: "IPv4",
+			s:    "192.168.1.5",
+			want: &Endpoint{Host: "192.168.1.5"},
+		},
+		{
+			name: "IPv4 and port",
+			s:    "192.168.1.5:8080",
+			want: &Endpoint{Host: "192.168.1.5", Port: "8080"},
+		},
+		{
+			name: "IPv6",
+			s:    "fe80::1",
+			want: &Endpoint{Host: "fe80::1"},
+		},
+		{
+			name: "IPv6 in brackets",
+			s:    "[fe80::1]",
+			want: &Endpoint{Host: "fe80::1"},
+		},
+		{
+			name: "IPv6 and port",
+			s:    "[fe80::1%eth0]:80",
+			want: &Endpoint{Host: "fe80::1%eth0", Port: "80"},
+		},
+		{
+			name: "Hostname and path",
+			s:    "lamp.local/api/",
+			want: &Endpoint{Host: "lamp.local", Path: "/api"},
+		},
+		{
+			name: "Port alone",
+			s:    ":8080",
+			want: &Endpoint{Port: "8080"},
+		},
+		{
+			name: "Scheme",
+			s:    "HTTPS://[fe80::1]:8443/api",
+			want: &Endpoint{Scheme: "https", Host: "fe80::1", Port: "8443", Path: "/api"},
+		},
+		{
+			name: "Scheme and zone",
+			s:    "http://[fe80::1%25eth0]:8080",
+			want: &Endpoint{Scheme: "http", Host: "fe80::1%eth0", Port: "8080"},
+		},
+		{
+			name:    "Invalid port",
+			s:       "192.168.1.5:http",
+			wantErr: true,
+		},
+		{
+			name:    "Port out of range",
+			s:       "coap://192.168.1.5:70000",
+			wantErr: true,
+		},
+		{
+			name:    "Invalid host",
+			s:       "not an address",
+			wantErr: true,
+		},
+		{
+			name:    "Query",
+			s:       "http://lamp.local/?a=1",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseEndpoint(tt.s)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			again, err := ParseEndpoint(got.String())
+			assert.NoError(t, err)
+			assert.Equal(t, got, again, "the endpoint is parsed back from its string")
+		})
+	}
+}
+
+func TestDevice_Endpoint(t *testing.T) {
+	dev := &Device{Addr: &net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 8080}}
+	e, err := dev.Endpoint()
+	assert.NoError(t, err)
+	assert.Equal(t, &Endpoint{Host: "fe80::1", Port: "8080"}, e)
+
+	dev.Addr = &net.IPAddr{IP: net.IPv4(192, 168, 1, 5)}
+	e, err = dev.Endpoint()
+	assert.NoError(t, err)
+	assert.Equal(t, "192.168.1.5:80", e.HostPortOr("80"))
+
+	dev.Addr = nil
+	_, err = dev.Endpoint()
+	assert.Error(t, err)
+}
+
+func TestServiceURL(t *testing.T) {
+	dev := &Device{Addr: &net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 8080, Zone: "eth0"}}
+	s, err := serviceURL(dev, "click", "a=1")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://[fe80::1%25eth0]:8080/click?a=1", s)
+	u, err := url.Parse(s)
+	assert.NoError(t, err, "the zone of a link-local address is escaped")
+	assert.Equal(t, "fe80::1%eth0", u.Hostname())
+
+	dev.Addr = &Endpoint{Scheme: "https", Host: "fe80::1%eth0", Path: "/api"}
+	s, err = serviceURL(dev, "click", "")
+	assert.NoError(t, err)
+	assert.Equal(t, "https://[fe80::1%25eth0]/api/click", s)
+}
+
+func TestDevice_CallEndpoint(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, r.URL.Path)
+	}))
+	defer server.Close()
+	e, err := ParseEndpoint(server.Listener.Addr().String() + "/api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, err := NewDevice("Device1", e, []byte(`def outbound click();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := dev.Call(context.Background(), "click", "", DefaultCallOptions)
+	assert.NoError(t, err)
+	assert.Equal(t, "/api/click", string(body))
+
+	e.Scheme = "coap"
+	_, err = dev.Call(context.Background(), "click", "", DefaultCallOptions)
+	assert.Error(t, err, "a coap endpoint is not called over HTTP")
+}
@@ -0,0 +1,42 @@
+package device
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+)
+
+// secretLength is the number of random bytes in a device secret
+const secretLength = 32
+
+// HashSecret returns the hash of a device secret as it is kept in the repository
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewSecret generates a new secret for the device and keeps its hash, the secret itself is only returned once
+func (d *Device) NewSecret() (string, error) {
+	buf := make([]byte, secretLength)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", err
+	}
+	secret := hex.EncodeToString(buf)
+	d.SecretHash = HashSecret(secret)
+	return secret, nil
+}
+
+// HasSecret reports whether the device was issued a secret
+func (d *Device) HasSecret() bool {
+	return d.SecretHash != ""
+}
+
+// CheckSecret reports whether secret is the secret of the device, a device without a secret accepts none
+func (d *Device) CheckSecret(secret string) bool {
+	if !d.HasSecret() || secret == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(HashSecret(secret)), []byte(d.SecretHash)) == 1
+}
@@ -0,0 +1,27 @@
+package device
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDevice_NewSecret(t *testing.T) {
+	d := &Device{}
+	assert.False(t, d.HasSecret())
+	assert.False(t, d.CheckSecret(""), "a device without a secret accepts none")
+
+	secret, err := d.NewSecret()
+	assert.NoError(t, err)
+	assert.True(t, d.HasSecret())
+	assert.NotEqual(t, secret, d.SecretHash, "only the hash is kept")
+	assert.True(t, d.CheckSecret(secret))
+	assert.False(t, d.CheckSecret(""))
+	assert.False(t, d.CheckSecret(secret+"0"))
+
+	other, err := d.NewSecret()
+	assert.NoError(t, err)
+	assert.NotEqual(t, secret, other)
+	assert.False(t, d.CheckSecret(secret), "a new secret replaces the old one")
+	assert.True(t, d.CheckSecret(other))
+}
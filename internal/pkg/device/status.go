@@ -0,0 +1,28 @@
+package device
+
+import "time"
+
+// Status defines whether the hub can reach a device, the zero Status is StatusUnknown
+type Status string
+
+const (
+	// StatusUnknown is the status of a device that has not been checked yet
+	StatusUnknown Status = ""
+	// StatusOnline is the status of a device that answered its last check
+	StatusOnline Status = "online"
+	// StatusOffline is the status of a device that did not answer its last check
+	StatusOffline Status = "offline"
+)
+
+func (s Status) String() string {
+	if s == StatusUnknown {
+		return "unknown"
+	}
+	return string(s)
+}
+
+// MarkSeen marks the device as online, last seen at t
+func (d *Device) MarkSeen(t time.Time) {
+	d.Status = StatusOnline
+	d.LastSeen = t
+}
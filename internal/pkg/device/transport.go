@@ -0,0 +1,207 @@
+package device
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+
+	"github.com/IktaS/go-home/internal/pkg/logging"
+)
+
+// Transports a device can be called through, selected when it connects
+const (
+	TransportHTTP = "http"
+	TransportMQTT = "mqtt"
+	TransportCoAP = "coap"
+)
+
+// Request defines a service call carried by a Transport, a call with a Body is a JSON call and a call without one has a URL query
+type Request struct {
+	Service string
+	Query   string
+	Body    []byte
+}
+
+// Transport carries service calls to devices, RoundTrip makes a single attempt that ends with ctx,
+// a device that answers with an error is returned as a *StatusError, and a failure to reach the device
+// that another attempt may not fail on is wrapped as ErrUnreachable
+type Transport interface {
+	RoundTrip(ctx context.Context, d *Device, req *Request) ([]byte, error)
+}
+
+// Observer is a Transport that watches the inbound services of devices, an event a device sends through it is pushed
+// the same as over HTTP, Observe replaces what was watched of the device before and Forget stops watching it
+type Observer interface {
+	Observe(d *Device) error
+	Forget(id string)
+}
+
+// StatusError is returned when a device answers a call with an error, it is ErrBadStatus and is retried when Temporary
+type StatusError struct {
+	Status    string
+	Temporary bool
+}
+
+func (e *StatusError) Error() string {
+	return ErrBadStatus.Error() + " : " + e.Status
+}
+
+// Is makes a StatusError match ErrBadStatus
+func (e *StatusError) Is(target error) bool {
+	return target == ErrBadStatus
+}
+
+// TransportName returns the transport the device is called through, HTTP for a device that did not select one
+func (d *Device) TransportName() string {
+	if d.Transport == "" {
+		return TransportHTTP
+	}
+	return d.Transport
+}
+
+// HTTPTransport calls a device service at http://[endpoint]/[service], or https for an endpoint with the https scheme,
+// with a GET and the query or a POST and the JSON body
+type HTTPTransport struct {
+	// Client calls the device, http.DefaultClient if nil
+	Client *http.Client
+	// VerifyDeviceID requires a device to be called over HTTPS and to present a certificate issued to its ID,
+	// as the certificates of the hub CA are, it is checked in the handshake before the call is sent
+	VerifyDeviceID bool
+
+	mu      sync.Mutex
+	clients map[string]*http.Client
+}
+
+// client returns the client a device is called with, with VerifyDeviceID a copy of Client of the device
+// that only completes the handshake with a certificate issued to the device
+func (t *HTTPTransport) client(d *Device) (*http.Client, error) {
+	client := t.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if !t.VerifyDeviceID {
+		return client, nil
+	}
+	id := d.ID.String()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c, ok := t.clients[id]; ok {
+		return c, nil
+	}
+	base := http.DefaultTransport
+	if client.Transport != nil {
+		base = client.Transport
+	}
+	httpTransport, ok := base.(*http.Transport)
+	if !ok {
+		return nil, fmt.Errorf("%w : the client has no TLS config to verify device %v with", ErrWrongIdentity, id)
+	}
+	tr := httpTransport.Clone()
+	if tr.TLSClientConfig == nil {
+		tr.TLSClientConfig = &tls.Config{}
+	}
+	verify := tr.TLSClientConfig.VerifyConnection
+	tr.TLSClientConfig.VerifyConnection = func(cs tls.ConnectionState) error {
+		if verify != nil {
+			if err := verify(cs); err != nil {
+				return err
+			}
+		}
+		if len(cs.PeerCertificates) == 0 || cs.PeerCertificates[0].Subject.CommonName != id {
+			return fmt.Errorf("%w : the certificate is not issued to device %v", ErrWrongIdentity, id)
+		}
+		return nil
+	}
+	c := *client
+	c.Transport = tr
+	if t.clients == nil {
+		t.clients = make(map[string]*http.Client)
+	}
+	t.clients[id] = &c
+	return &c, nil
+}
+
+func serviceURL(d *Device, service string, query string) (string, error) {
+	e, err := d.Endpoint()
+	if err != nil {
+		return "", err
+	}
+	scheme := e.Scheme
+	if scheme == "" {
+		scheme = "http"
+	}
+	if scheme != "http" && scheme != "https" {
+		return "", fmt.Errorf("%v cannot be called over HTTP", e)
+	}
+	connectionString := fmt.Sprintf("%v://%v%v/%v", scheme, e.URLHost(), e.Path, service)
+	if query != "" {
+		connectionString += "?" + query
+	}
+	u, err := url.Parse(connectionString)
+	if err != nil {
+		return "", err
+	}
+	if u.Scheme == "" || u.Host == "" || u.Path == "" {
+		return "", fmt.Errorf("Invalid URL")
+	}
+	return connectionString, nil
+}
+
+// RoundTrip calls a device service over HTTP
+func (t *HTTPTransport) RoundTrip(ctx context.Context, d *Device, req *Request) ([]byte, error) {
+	if t.VerifyDeviceID {
+		e, err := d.Endpoint()
+		if err != nil {
+			return nil, err
+		}
+		if e.Scheme != "https" {
+			return nil, fmt.Errorf("%w : %v is not called over HTTPS", ErrWrongIdentity, e)
+		}
+	}
+	client, err := t.client(d)
+	if err != nil {
+		return nil, err
+	}
+	var httpReq *http.Request
+	if req.Body == nil {
+		connectionString, err := serviceURL(d, req.Service, req.Query)
+		if err != nil {
+			return nil, err
+		}
+		logging.Debugf("calling to %v\n", connectionString)
+		httpReq, err = http.NewRequestWithContext(ctx, http.MethodGet, connectionString, nil)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		connectionString, err := serviceURL(d, req.Service, "")
+		if err != nil {
+			return nil, err
+		}
+		logging.Debugf("posting to %v\n", connectionString)
+		httpReq, err = http.NewRequestWithContext(ctx, http.MethodPost, connectionString, bytes.NewReader(req.Body))
+		if err != nil {
+			return nil, err
+		}
+		httpReq.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, &StatusError{Status: resp.Status, Temporary: resp.StatusCode >= 500}
+	}
+	return body, nil
+}
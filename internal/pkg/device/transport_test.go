@@ -0,0 +1,111 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type transportFunc func(ctx context.Context, d *Device, req *Request) ([]byte, error)
+
+func (f transportFunc) RoundTrip(ctx context.Context, d *Device, req *Request) ([]byte, error) {
+	return f(ctx, d, req)
+}
+
+func TestDevice_CallTransport(t *testing.T) {
+	dev, err := NewDevice("Device1", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}, []byte(`def outbound click();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.Transport = TransportMQTT
+
+	_, err = dev.Call(context.Background(), "click", "", CallOptions{})
+	assert.True(t, errors.Is(err, ErrUnreachable), "a hub without the device transport cannot call it, got %v", err)
+	assert.False(t, CallOptions{}.HasTransport(TransportMQTT))
+	assert.True(t, CallOptions{}.HasTransport(TransportHTTP))
+
+	var requests []*Request
+	var fail error
+	opts := CallOptions{
+		Retries: 2,
+		Transports: map[string]Transport{
+			TransportMQTT: transportFunc(func(ctx context.Context, d *Device, req *Request) ([]byte, error) {
+				requests = append(requests, req)
+				if fail != nil {
+					return nil, fail
+				}
+				return []byte("ok"), nil
+			}),
+		},
+	}
+	body, err := dev.Call(context.Background(), "click", "arg0=1", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+	body, err = dev.CallJSON(context.Background(), "click", []byte(`{"arg0":1}`), opts)
+	assert.NoError(t, err)
+	assert.Equal(t, "ok", string(body))
+	assert.Equal(t, []*Request{
+		{Service: "click", Query: "arg0=1"},
+		{Service: "click", Body: []byte(`{"arg0":1}`)},
+	}, requests)
+
+	tests := []struct {
+		name         string
+		err          error
+		wantErr      error
+		wantAttempts int
+	}{
+		{
+			name:         "Temporary device error",
+			err:          &StatusError{Status: "503 Service Unavailable", Temporary: true},
+			wantErr:      ErrBadStatus,
+			wantAttempts: 3,
+		},
+		{
+			name:         "Device error",
+			err:          &StatusError{Status: "400 Bad Request"},
+			wantErr:      ErrBadStatus,
+			wantAttempts: 1,
+		},
+		{
+			name:         "Transport error",
+			err:          fmt.Errorf("%w : not connected", ErrUnreachable),
+			wantErr:      ErrUnreachable,
+			wantAttempts: 3,
+		},
+		{
+			name:         "Endpoint the transport cannot call",
+			err:          errors.New("lamp.local cannot be called over MQTT"),
+			wantErr:      ErrUnreachable,
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests = nil
+			fail = tt.err
+			_, err := dev.Call(context.Background(), "click", "", opts)
+			assert.True(t, errors.Is(err, tt.wantErr), "expected %v, got %v", tt.wantErr, err)
+			assert.Len(t, requests, tt.wantAttempts)
+		})
+	}
+
+	t.Run("Timeout", func(t *testing.T) {
+		opts := CallOptions{
+			Timeout: 10 * time.Millisecond,
+			Transports: map[string]Transport{
+				TransportMQTT: transportFunc(func(ctx context.Context, d *Device, req *Request) ([]byte, error) {
+					<-ctx.Done()
+					return nil, ctx.Err()
+				}),
+			},
+		}
+		_, err := dev.Call(context.Background(), "click", "", opts)
+		assert.True(t, errors.Is(err, ErrTimeout), err)
+	})
+}
@@ -0,0 +1,186 @@
+package device
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+
+	"github.com/IktaS/go-serv/pkg/serv"
+)
+
+// Violation defines a single parameter that does not match the service definition
+type Violation struct {
+	Param    string `json:"param"`
+	Expected string `json:"expected,omitempty"`
+	Reason   string `json:"reason"`
+}
+
+const (
+	// ReasonMissing is the reason of a required parameter that is not given
+	ReasonMissing = "missing"
+	// ReasonInvalid is the reason of a parameter that cannot be parsed as its type
+	ReasonInvalid = "invalid"
+	// ReasonUnknown is the reason of a parameter that the service does not declare
+	ReasonUnknown = "unknown"
+	// ReasonRepeated is the reason of a parameter that is given more than once
+	ReasonRepeated = "repeated"
+)
+
+// Service returns the device service with the name, or nil
+func (d *Device) Service(name string) *serv.Service {
+	for _, s := range d.Services {
+		if s != nil && s.Name == name {
+			return s
+		}
+	}
+	return nil
+}
+
+// Message returns the device message with the name, or nil
+func (d *Device) Message(name string) *serv.Message {
+	for _, m := range d.Messages {
+		if m != nil && m.Name == name {
+			return m
+		}
+	}
+	return nil
+}
+
+// ScalarParam returns the name of the scalar request parameter at a position,
+// message request parameters are flattened to their field names instead
+func ScalarParam(position int) string {
+	return "arg" + strconv.Itoa(position)
+}
+
+// param defines a parameter a service accepts
+type param struct {
+	name     string
+	scalar   string
+	required bool
+}
+
+// params flattens the service request into the parameters it accepts,
+// a field of a referenced message that references another message is flattened as Field.SubField
+func (d *Device) params(s *serv.Service) ([]param, error) {
+	var params []param
+	for i, t := range s.Request {
+		if t == nil {
+			continue
+		}
+		if t.Reference == "" {
+			params = append(params, param{name: ScalarParam(i), scalar: t.Scalar.String(), required: true})
+			continue
+		}
+		p, err := d.messageParams("", t.Reference, true, map[string]bool{})
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, p...)
+	}
+	return params, nil
+}
+
+func (d *Device) messageParams(prefix string, name string, required bool, seen map[string]bool) ([]param, error) {
+	if seen[name] {
+		return nil, fmt.Errorf("message %v references itself", name)
+	}
+	m := d.Message(name)
+	if m == nil {
+		return nil, fmt.Errorf("message %v is not defined", name)
+	}
+	seen[name] = true
+	defer delete(seen, name)
+	var params []param
+	for _, md := range m.Definitions {
+		if md == nil || md.Field == nil || md.Field.Type == nil {
+			continue
+		}
+		f := md.Field
+		fieldRequired := required && !(f.Optional && !f.Required)
+		if f.Type.Reference == "" {
+			params = append(params, param{name: prefix + f.Name, scalar: f.Type.Scalar.String(), required: fieldRequired})
+			continue
+		}
+		p, err := d.messageParams(prefix+f.Name+".", f.Type.Reference, fieldRequired, seen)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, p...)
+	}
+	return params, nil
+}
+
+// ParamTypes returns the scalar of every parameter a service accepts, keyed by the parameter name
+func (d *Device) ParamTypes(s *serv.Service) (map[string]string, error) {
+	params, err := d.params(s)
+	if err != nil {
+		return nil, err
+	}
+	types := make(map[string]string, len(params))
+	for _, p := range params {
+		types[p.name] = p.scalar
+	}
+	return types, nil
+}
+
+// ValidateScalar checks whether a value can be parsed as a .serv scalar
+func ValidateScalar(scalar string, value string) bool {
+	var err error
+	switch scalar {
+	case "double":
+		_, err = strconv.ParseFloat(value, 64)
+	case "float":
+		_, err = strconv.ParseFloat(value, 32)
+	case "int32", "sint32", "sfixed32":
+		_, err = strconv.ParseInt(value, 10, 32)
+	case "int64", "sint64", "sfixed64":
+		_, err = strconv.ParseInt(value, 10, 64)
+	case "uint32", "fixed32":
+		_, err = strconv.ParseUint(value, 10, 32)
+	case "uint64", "fixed64":
+		_, err = strconv.ParseUint(value, 10, 64)
+	case "bool":
+		_, err = strconv.ParseBool(value)
+	}
+	return err == nil
+}
+
+// ValidateQuery validates query parameters of a service call against the service request,
+// an error is returned when the service definition itself cannot be resolved
+func (d *Device) ValidateQuery(s *serv.Service, query url.Values) ([]*Violation, error) {
+	params, err := d.params(s)
+	if err != nil {
+		return nil, err
+	}
+	violations := []*Violation{}
+	known := make(map[string]bool)
+	for _, p := range params {
+		known[p.name] = true
+		values, ok := query[p.name]
+		if !ok {
+			if p.required {
+				violations = append(violations, &Violation{Param: p.name, Expected: p.scalar, Reason: ReasonMissing})
+			}
+			continue
+		}
+		if len(values) > 1 {
+			violations = append(violations, &Violation{Param: p.name, Expected: p.scalar, Reason: ReasonRepeated})
+			continue
+		}
+		if !ValidateScalar(p.scalar, values[0]) {
+			violations = append(violations, &Violation{Param: p.name, Expected: p.scalar, Reason: ReasonInvalid})
+		}
+	}
+	var unknown []string
+	for name := range query {
+		if !known[name] {
+			unknown = append(unknown, name)
+		}
+	}
+	sort.Strings(unknown)
+	for _, name := range unknown {
+		violations = append(violations, &Violation{Param: name, Reason: ReasonUnknown})
+	}
+	return violations, nil
+}
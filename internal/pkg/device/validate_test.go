@@ -0,0 +1,110 @@
+package device
+
+import (
+	"net"
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDevice_ValidateQuery(t *testing.T) {
+	addr := &net.TCPAddr{
+		IP:   net.IPv4(127, 0, 0, 1),
+		Port: 80,
+	}
+	dev, err := NewDevice("Device1", addr, []byte(`
+		message Color{uint32 R; uint32 G; uint32 B;};
+		message Light{bool On; optional Color Color; optional double Level;};
+		message Loop{Loop Next;};
+		def outbound set(Light, int32);
+		def outbound click();
+		def outbound broken(Missing);
+		def outbound loop(Loop);
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		service  string
+		query    string
+		expected []*Violation
+		wantErr  bool
+	}{
+		{
+			name:     "Valid",
+			service:  "set",
+			query:    "On=true&Level=0.5&arg1=-3",
+			expected: []*Violation{},
+		},
+		{
+			name:     "Valid with optional message",
+			service:  "set",
+			query:    "On=1&Color.R=255&Color.G=0&Color.B=10&arg1=3",
+			expected: []*Violation{},
+		},
+		{
+			name:    "Missing and invalid",
+			service: "set",
+			query:   "Level=high&arg1=99999999999",
+			expected: []*Violation{
+				{Param: "On", Expected: "bool", Reason: ReasonMissing},
+				{Param: "Level", Expected: "double", Reason: ReasonInvalid},
+				{Param: "arg1", Expected: "int32", Reason: ReasonInvalid},
+			},
+		},
+		{
+			name:    "Optional message field is partially given",
+			service: "set",
+			query:   "On=true&Color.R=-1&arg1=1",
+			expected: []*Violation{
+				{Param: "Color.R", Expected: "uint32", Reason: ReasonInvalid},
+			},
+		},
+		{
+			name:    "Unknown and repeated",
+			service: "set",
+			query:   "On=true&On=false&arg1=1&Brightness=3",
+			expected: []*Violation{
+				{Param: "On", Expected: "bool", Reason: ReasonRepeated},
+				{Param: "Brightness", Reason: ReasonUnknown},
+			},
+		},
+		{
+			name:     "No parameter",
+			service:  "click",
+			query:    "",
+			expected: []*Violation{},
+		},
+		{
+			name:    "Undefined message",
+			service: "broken",
+			wantErr: true,
+		},
+		{
+			name:    "Recursive message",
+			service: "loop",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			query, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := dev.Service(tt.service)
+			if !assert.NotNil(t, s) {
+				return
+			}
+			violations, err := dev.ValidateQuery(s, query)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, violations)
+		})
+	}
+}
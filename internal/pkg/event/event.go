@@ -0,0 +1,40 @@
+package event
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Event defines a payload a device pushed to the hub through one of its inbound services,
+// it is the data of a bus.DeviceEvent message
+type Event struct {
+	ID        int64           `json:"id"`
+	DeviceID  uuid.UUID       `json:"device"`
+	Service   string          `json:"service"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"createdAt"`
+}
+
+// Repo is an interface that defines what an event repository should have,
+// SaveEvent sets the ID of the saved event
+type Repo interface {
+	SaveEvent(*Event) error
+	// GetEvents gets the events matching a query, newest first
+	GetEvents(*Query) ([]*Event, error)
+	// DeleteEventsBefore deletes every event created before t, and returns how many were deleted
+	DeleteEventsBefore(t time.Time) (int64, error)
+	// TrimEvents deletes every event of a device but its newest max, and returns how many were deleted
+	TrimEvents(max int) (int64, error)
+}
+
+// New makes an event of a device service received now
+func New(deviceID uuid.UUID, service string, payload json.RawMessage) *Event {
+	return &Event{
+		DeviceID:  deviceID,
+		Service:   service,
+		Payload:   payload,
+		CreatedAt: time.Now(),
+	}
+}
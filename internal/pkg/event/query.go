@@ -0,0 +1,80 @@
+package event
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// DefaultLimit is the number of events of a query without a limit
+const DefaultLimit = 50
+
+// MaxLimit is the largest number of events a query may ask for
+const MaxLimit = 500
+
+// ErrInvalidCursor is returned when a cursor was not made by EncodeCursor
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Query defines which events to get, newest first, a zero field does not filter
+type Query struct {
+	DeviceID string
+	Service  string
+	// Since is inclusive and Until is exclusive
+	Since time.Time
+	Until time.Time
+	// Before only keeps events older than the event with this ID, as given by a cursor
+	Before int64
+	Limit  int
+}
+
+// EncodeCursor makes an opaque cursor that continues a query after the event with id
+func EncodeCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+// DecodeCursor returns the event id of a cursor made by EncodeCursor
+func DecodeCursor(cursor string) (int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	id, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, ErrInvalidCursor
+	}
+	return id, nil
+}
+
+// Retention defines how long events are kept, a zero field keeps events forever
+type Retention struct {
+	// MaxAge deletes events older than it
+	MaxAge time.Duration
+	// MaxPerDevice keeps only the newest events of each device
+	MaxPerDevice int
+}
+
+// Enabled reports whether the retention deletes any event
+func (r Retention) Enabled() bool {
+	return r.MaxAge > 0 || r.MaxPerDevice > 0
+}
+
+// Apply deletes every event the retention does not keep at now, and returns how many were deleted
+func (r Retention) Apply(repo Repo, now time.Time) (int64, error) {
+	var deleted int64
+	if r.MaxAge > 0 {
+		n, err := repo.DeleteEventsBefore(now.Add(-r.MaxAge))
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+	if r.MaxPerDevice > 0 {
+		n, err := repo.TrimEvents(r.MaxPerDevice)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+	return deleted, nil
+}
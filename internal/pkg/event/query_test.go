@@ -0,0 +1,100 @@
+package event
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursor(t *testing.T) {
+	tests := []struct {
+		name     string
+		cursor   string
+		expected int64
+		wantErr  bool
+	}{
+		{
+			name:     "Round trip",
+			cursor:   EncodeCursor(42),
+			expected: 42,
+		},
+		{
+			name:    "Not base64",
+			cursor:  "!!",
+			wantErr: true,
+		},
+		{
+			name:    "Not an id",
+			cursor:  EncodeCursor(-1),
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			id, err := DecodeCursor(tt.cursor)
+			if tt.wantErr {
+				assert.Equal(t, ErrInvalidCursor, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.expected, id)
+		})
+	}
+}
+
+type retentionRepo struct {
+	before time.Time
+	max    int
+}
+
+func (r *retentionRepo) SaveEvent(*Event) error             { return nil }
+func (r *retentionRepo) GetEvents(*Query) ([]*Event, error) { return nil, nil }
+func (r *retentionRepo) DeleteEventsBefore(t time.Time) (int64, error) {
+	r.before = t
+	return 2, nil
+}
+func (r *retentionRepo) TrimEvents(max int) (int64, error) {
+	r.max = max
+	return 3, nil
+}
+
+func TestRetention_Apply(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	tests := []struct {
+		name        string
+		retention   Retention
+		wantBefore  time.Time
+		wantMax     int
+		wantDeleted int64
+	}{
+		{
+			name:      "Keep forever",
+			retention: Retention{},
+		},
+		{
+			name:        "Max age",
+			retention:   Retention{MaxAge: time.Hour},
+			wantBefore:  now.Add(-time.Hour),
+			wantDeleted: 2,
+		},
+		{
+			name:        "Max age and count",
+			retention:   Retention{MaxAge: time.Hour, MaxPerDevice: 100},
+			wantBefore:  now.Add(-time.Hour),
+			wantMax:     100,
+			wantDeleted: 5,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo := &retentionRepo{}
+			deleted, err := tt.retention.Apply(repo, now)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantDeleted, deleted)
+			assert.Equal(t, tt.wantBefore, repo.before)
+			assert.Equal(t, tt.wantMax, repo.max)
+			assert.Equal(t, tt.wantDeleted > 0, tt.retention.Enabled())
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package group
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/IktaS/go-home/internal/pkg/action"
+	"github.com/google/uuid"
+)
+
+// ErrInvalid is the error of a group that cannot be saved
+var ErrInvalid = errors.New("invalid group")
+
+// idPattern is what a group ID looks like, e.g. kitchen or living-room
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+/*
+Group defines the JSON schema of a room or any other group of devices, a device may be in many groups :
+	ID		`id`		: Group ID, lower case letters, digits, - and _, e.g. kitchen
+	Name	`name`		: Group name, the ID if empty
+	Devices	`devices`	: UUID of the devices in the group
+*/
+type Group struct {
+	ID      string   `json:"id"`
+	Name    string   `json:"name"`
+	Devices []string `json:"devices"`
+}
+
+// Repo is an interface that defines what a group repository should have
+type Repo interface {
+	// SaveGroup saves a group and its devices, replacing the group with the same ID
+	SaveGroup(*Group) error
+	// GetGroup gets a group by its ID, returns sql.ErrNoRows if it does not exist
+	GetGroup(id string) (*Group, error)
+	GetGroups() ([]*Group, error)
+	// DeleteGroup deletes a group by its ID, its devices are kept, returns sql.ErrNoRows if it does not exist
+	DeleteGroup(id string) error
+	// AddGroupDevice adds a device to a group, returns sql.ErrNoRows if the group does not exist
+	AddGroupDevice(id string, deviceID string) error
+	// RemoveGroupDevice removes a device from a group, returns sql.ErrNoRows if the device is not in the group
+	RemoveGroupDevice(id string, deviceID string) error
+}
+
+func invalid(format string, a ...interface{}) error {
+	return fmt.Errorf("%w : %v", ErrInvalid, fmt.Sprintf(format, a...))
+}
+
+// ValidateID checks a group ID, an error wrapping ErrInvalid is returned when it cannot be used
+func ValidateID(id string) error {
+	if !idPattern.MatchString(id) {
+		return invalid("%v is not a group ID, use lower case letters, digits, - and _", id)
+	}
+	return nil
+}
+
+// ValidateDevice checks a device exists, and returns its normalized UUID,
+// an error wrapping ErrInvalid is returned when it does not
+func ValidateDevice(devices action.DeviceRepo, id string) (string, error) {
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return "", invalid("%v is not a device UUID", id)
+	}
+	dev, err := devices.Get(uid.String())
+	if err == sql.ErrNoRows {
+		return "", invalid("device %v not found", id)
+	}
+	if err != nil {
+		return "", err
+	}
+	return dev.ID.String(), nil
+}
+
+// Validate checks a group and its devices, and normalizes its name and device UUIDs, devices are sorted by UUID the same as they are stored,
+// an error wrapping ErrInvalid is returned when the group cannot be saved
+func (g *Group) Validate(devices action.DeviceRepo) error {
+	err := ValidateID(g.ID)
+	if err != nil {
+		return err
+	}
+	if g.Name == "" {
+		g.Name = g.ID
+	}
+	ids := make([]string, 0, len(g.Devices))
+	seen := make(map[string]bool)
+	for _, d := range g.Devices {
+		id, err := ValidateDevice(devices, d)
+		if err != nil {
+			return err
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	g.Devices = ids
+	return nil
+}
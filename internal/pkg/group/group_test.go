@@ -0,0 +1,90 @@
+package group
+
+import (
+	"database/sql"
+	"errors"
+	"net"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryDevices map[string]*device.Device
+
+func (m memoryDevices) Get(id interface{}) (*device.Device, error) {
+	dev, ok := m[id.(string)]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return dev, nil
+}
+
+func TestGroup_Validate(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}
+	lamp, err := device.NewDevice("Lamp", addr, []byte(`def outbound on();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	kettle, err := device.NewDevice("Kettle", addr, []byte(`def outbound on();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices := memoryDevices{lamp.ID.String(): lamp, kettle.ID.String(): kettle}
+	sorted := []string{lamp.ID.String(), kettle.ID.String()}
+	sort.Strings(sorted)
+	tests := []struct {
+		name     string
+		group    *Group
+		wantName string
+		want     []string
+		wantErr  bool
+	}{
+		{
+			name:     "Devices are sorted and deduplicated",
+			group:    &Group{ID: "kitchen", Name: "Kitchen", Devices: []string{sorted[1], strings.ToUpper(sorted[0]), sorted[1]}},
+			wantName: "Kitchen",
+			want:     sorted,
+		},
+		{
+			name:     "Name defaults to ID",
+			group:    &Group{ID: "living-room_1"},
+			wantName: "living-room_1",
+			want:     []string{},
+		},
+		{
+			name:    "Invalid ID",
+			group:   &Group{ID: "Living Room"},
+			wantErr: true,
+		},
+		{
+			name:    "No ID",
+			group:   &Group{},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown device",
+			group:   &Group{ID: "kitchen", Devices: []string{"00000000-0000-0000-0000-000000000000"}},
+			wantErr: true,
+		},
+		{
+			name:    "Device that is not a UUID",
+			group:   &Group{ID: "kitchen", Devices: []string{"lamp"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.group.Validate(devices)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrInvalid), err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantName, tt.group.Name)
+			assert.Equal(t, tt.want, tt.group.Devices)
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/bus"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/logging"
+)
+
+// Service is the service a device may declare to be probed with, a device without it is probed with a TCP connect to its address
+const Service = "health"
+
+// StatusRepo defines a repository that keeps the status of devices
+type StatusRepo interface {
+	// SetStatus sets the status and last seen time of a device, returns sql.ErrNoRows if the device does not exist
+	SetStatus(id string, status device.Status, lastSeen time.Time) error
+}
+
+// Repo defines what the Monitor needs from a repository
+type Repo interface {
+	GetAll() ([]*device.Device, error)
+	StatusRepo
+}
+
+/*
+StatusChange defines the JSON schema of the data of a bus.DeviceStatus message :
+	Status		`status`	: New status of the device, online or offline
+	LastSeen	`lastSeen`	: Last time the device was reachable, null when it never was
+*/
+type StatusChange struct {
+	Status   string     `json:"status"`
+	LastSeen *time.Time `json:"lastSeen"`
+}
+
+// NewStatusChange makes the bus.DeviceStatus message of a device going online or offline
+func NewStatusChange(id string, status device.Status, lastSeen time.Time) *bus.Message {
+	change := &StatusChange{Status: status.String()}
+	if !lastSeen.IsZero() {
+		seen := lastSeen.UTC()
+		change.LastSeen = &seen
+	}
+	return &bus.Message{Type: bus.DeviceStatus, Device: id, Data: change}
+}
+
+// Prober checks whether a device is reachable in a timeout
+type Prober func(ctx context.Context, d *device.Device, timeout time.Duration) error
+
+// ErrNotProbed is returned for a device that cannot be probed, its status is kept as it is
+var ErrNotProbed = errors.New("device cannot be probed")
+
+// Probe checks whether a device is reachable, by calling its health service if it declares one as an outbound service
+// without parameters, or else by a TCP connect to its address, devices are only called over HTTP
+func Probe(ctx context.Context, d *device.Device, timeout time.Duration) error {
+	return probe(ctx, d, device.CallOptions{Timeout: timeout})
+}
+
+// NewProber makes a Prober that calls health services through the transports of opts,
+// a device on another transport than HTTP without a health service is not probed
+func NewProber(opts device.CallOptions) Prober {
+	return func(ctx context.Context, d *device.Device, timeout time.Duration) error {
+		opts.Timeout = timeout
+		opts.Retries = 0
+		return probe(ctx, d, opts)
+	}
+}
+
+func probe(ctx context.Context, d *device.Device, opts device.CallOptions) error {
+	if s := d.Service(Service); s != nil && s.Outbound && len(s.Request) == 0 {
+		_, err := d.Call(ctx, Service, "", opts)
+		return err
+	}
+	if d.TransportName() != device.TransportHTTP {
+		return ErrNotProbed
+	}
+	timeout := opts.Timeout
+	e, err := d.Endpoint()
+	if err != nil {
+		return err
+	}
+	// an endpoint without port is called on the port of its scheme
+	port := "80"
+	if e.Scheme == "https" {
+		port = "443"
+	}
+	dialer := net.Dialer{Timeout: timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", e.HostPortOr(port))
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// maxConcurrentProbes bounds how many devices are probed at once
+const maxConcurrentProbes = 16
+
+// Monitor periodically probes every device and keeps their status in the repository,
+// a device going online or offline is published on Bus when it is set
+type Monitor struct {
+	Repo     Repo
+	Interval time.Duration
+	Timeout  time.Duration
+	Probe    Prober
+	Bus      *bus.Bus
+}
+
+// NewMonitor makes a Monitor that uses Probe
+func NewMonitor(repo Repo, interval time.Duration, timeout time.Duration) *Monitor {
+	return &Monitor{
+		Repo:     repo,
+		Interval: interval,
+		Timeout:  timeout,
+		Probe:    Probe,
+	}
+}
+
+// Check probes every device once, an online device is last seen now and an offline device keeps its last seen time
+func (m *Monitor) Check(ctx context.Context) error {
+	devs, err := m.Repo.GetAll()
+	if err != nil {
+		return err
+	}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	sem := make(chan struct{}, maxConcurrentProbes)
+	for _, dev := range devs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(dev *device.Device) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			err := m.check(ctx, dev)
+			if err != nil {
+				mu.Lock()
+				if firstErr == nil {
+					firstErr = err
+				}
+				mu.Unlock()
+			}
+		}(dev)
+	}
+	wg.Wait()
+	return firstErr
+}
+
+func (m *Monitor) check(ctx context.Context, dev *device.Device) error {
+	status := device.StatusOnline
+	lastSeen := time.Now()
+	err := m.Probe(ctx, dev, m.Timeout)
+	if errors.Is(err, ErrNotProbed) {
+		return nil
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			// the monitor is stopping, the device was not really checked
+			return nil
+		}
+		status = device.StatusOffline
+		lastSeen = dev.LastSeen
+	}
+	if status == device.StatusOffline && dev.Status == device.StatusOffline {
+		// nothing changed
+		return nil
+	}
+	err = m.Repo.SetStatus(dev.ID.String(), status, lastSeen)
+	if err == sql.ErrNoRows {
+		// the device was deleted while it was probed
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if status != dev.Status {
+		logging.Infof("Device %v (%v) is %v\n", dev.Name, dev.ID, status)
+		m.Bus.Publish(NewStatusChange(dev.ID.String(), status, lastSeen))
+	}
+	return nil
+}
+
+// Run checks every device on the interval until the context is done
+func (m *Monitor) Run(ctx context.Context) {
+	ticker := time.NewTicker(m.Interval)
+	defer ticker.Stop()
+	for {
+		err := m.Check(ctx)
+		if err != nil {
+			logging.Errorf("Cannot check devices : %v\n", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package health
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/bus"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type statusUpdate struct {
+	status   device.Status
+	lastSeen time.Time
+}
+
+type memoryRepo struct {
+	mu      sync.Mutex
+	devices []*device.Device
+	updates map[string]statusUpdate
+}
+
+func (r *memoryRepo) GetAll() ([]*device.Device, error) {
+	return r.devices, nil
+}
+
+func (r *memoryRepo) SetStatus(id string, status device.Status, lastSeen time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, d := range r.devices {
+		if d.ID.String() == id {
+			r.updates[id] = statusUpdate{status: status, lastSeen: lastSeen}
+			return nil
+		}
+	}
+	return sql.ErrNoRows
+}
+
+func TestMonitor_Check(t *testing.T) {
+	lastSeen := time.Unix(1700000000, 0)
+	newDevice := func(name string, status device.Status) *device.Device {
+		return &device.Device{ID: uuid.New(), Name: name, Status: status, LastSeen: lastSeen}
+	}
+	online := newDevice("online", device.StatusOffline)
+	offline := newDevice("offline", device.StatusOnline)
+	stillOffline := newDevice("still offline", device.StatusOffline)
+	repo := &memoryRepo{
+		devices: []*device.Device{online, offline, stillOffline},
+		updates: make(map[string]statusUpdate),
+	}
+	m := NewMonitor(repo, time.Minute, time.Second)
+	m.Bus = bus.New()
+	sub := m.Bus.Subscribe(bus.Filter{})
+	defer sub.Close()
+	m.Probe = func(ctx context.Context, d *device.Device, timeout time.Duration) error {
+		if d == online {
+			return nil
+		}
+		return errors.New("no answer")
+	}
+
+	before := time.Now()
+	assert.NoError(t, m.Check(context.Background()))
+
+	assert.Len(t, repo.updates, 2)
+	update := repo.updates[online.ID.String()]
+	assert.Equal(t, device.StatusOnline, update.status)
+	assert.False(t, update.lastSeen.Before(before))
+	assert.Equal(t, statusUpdate{status: device.StatusOffline, lastSeen: lastSeen}, repo.updates[offline.ID.String()])
+	_, ok := repo.updates[stillOffline.ID.String()]
+	assert.False(t, ok, "an offline device that stays offline is not written again")
+
+	assert.Len(t, sub.C(), 2)
+	changes := make(map[string]*StatusChange)
+	for i := 0; i < 2; i++ {
+		m := <-sub.C()
+		assert.Equal(t, bus.DeviceStatus, m.Type)
+		changes[m.Device] = m.Data.(*StatusChange)
+	}
+	assert.Equal(t, "online", changes[online.ID.String()].Status)
+	seen := lastSeen.UTC()
+	assert.Equal(t, &StatusChange{Status: "offline", LastSeen: &seen}, changes[offline.ID.String()])
+}
+
+func TestProbe(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/"+Service {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+	serverAddr, err := net.ResolveTCPAddr("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := listener.Addr()
+	listener.Close()
+
+	tests := []struct {
+		name    string
+		addr    net.Addr
+		serv    string
+		wantErr bool
+	}{
+		{
+			name: "TCP connect",
+			addr: serverAddr,
+			serv: `def outbound click();`,
+		},
+		{
+			name:    "TCP connect refused",
+			addr:    closedAddr,
+			serv:    `def outbound click();`,
+			wantErr: true,
+		},
+		{
+			name:    "Health service fails",
+			addr:    serverAddr,
+			serv:    `def outbound health();`,
+			wantErr: true,
+		},
+		{
+			name: "Inbound health service is not called",
+			addr: serverAddr,
+			serv: `def inbound health();`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dev, err := device.NewDevice("Device1", tt.addr, []byte(tt.serv))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = Probe(context.Background(), dev, time.Second)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+type transportFunc func(ctx context.Context, d *device.Device, req *device.Request) ([]byte, error)
+
+func (f transportFunc) RoundTrip(ctx context.Context, d *device.Device, req *device.Request) ([]byte, error) {
+	return f(ctx, d, req)
+}
+
+func TestNewProber(t *testing.T) {
+	var called []string
+	probe := NewProber(device.CallOptions{
+		Retries: 3,
+		Transports: map[string]device.Transport{
+			device.TransportMQTT: transportFunc(func(ctx context.Context, d *device.Device, req *device.Request) ([]byte, error) {
+				called = append(called, req.Service)
+				return nil, &device.StatusError{Status: "503 Service Unavailable", Temporary: true}
+			}),
+		},
+	})
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}
+
+	dev, err := device.NewDevice("Sensor", addr, []byte(`def outbound health();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.Transport = device.TransportMQTT
+	err = probe(context.Background(), dev, time.Second)
+	assert.True(t, errors.Is(err, device.ErrBadStatus), err)
+	assert.Equal(t, []string{Service}, called, "the health service is called through the device transport, once")
+
+	dev, err = device.NewDevice("Sensor", addr, []byte(`def outbound click();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.Transport = device.TransportMQTT
+	err = probe(context.Background(), dev, time.Second)
+	assert.True(t, errors.Is(err, ErrNotProbed), err)
+}
@@ -0,0 +1,86 @@
+// Package logging logs the messages of the hub and of the packages it runs at a level,
+// messages below the level of the hub are not logged
+package logging
+
+import (
+	"fmt"
+	"log"
+	"sync"
+)
+
+// Level is how much the hub logs, from the most verbose Debug to Error
+type Level string
+
+// Log levels
+const (
+	Debug Level = "debug"
+	Info  Level = "info"
+	Warn  Level = "warn"
+	Error Level = "error"
+)
+
+var levels = map[Level]int{Debug: 0, Info: 1, Warn: 2, Error: 3}
+
+// Valid tells whether l is a known level
+func (l Level) Valid() bool {
+	_, ok := levels[l]
+	return ok
+}
+
+// Enabled tells whether messages of level are logged at the log level l
+func (l Level) Enabled(level Level) bool {
+	return levels[level] >= levels[l]
+}
+
+var (
+	mu      sync.RWMutex
+	current = Info
+)
+
+// SetLevel sets the level of the hub, Info until it is set
+func SetLevel(l Level) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = l
+}
+
+// Enabled tells whether messages of level are logged at the level of the hub
+func Enabled(level Level) bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return current.Enabled(level)
+}
+
+// Println logs a message at a level, with the operands formatted as log.Println does
+func Println(level Level, v ...interface{}) {
+	if Enabled(level) {
+		log.Output(2, fmt.Sprintln(v...))
+	}
+}
+
+// Printf logs a message at a level, with the operands formatted as log.Printf does
+func Printf(level Level, format string, v ...interface{}) {
+	if Enabled(level) {
+		log.Output(2, fmt.Sprintf(format, v...))
+	}
+}
+
+// Debugf logs a message at the debug level, e.g. every call the hub makes
+func Debugf(format string, v ...interface{}) {
+	Printf(Debug, format, v...)
+}
+
+// Infof logs a message at the info level
+func Infof(format string, v ...interface{}) {
+	Printf(Info, format, v...)
+}
+
+// Warnf logs a message at the warn level
+func Warnf(format string, v ...interface{}) {
+	Printf(Warn, format, v...)
+}
+
+// Errorf logs a message at the error level
+func Errorf(format string, v ...interface{}) {
+	Printf(Error, format, v...)
+}
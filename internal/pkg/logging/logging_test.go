@@ -0,0 +1,45 @@
+package logging
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLevel_Enabled(t *testing.T) {
+	assert.True(t, Info.Enabled(Info))
+	assert.True(t, Info.Enabled(Error))
+	assert.False(t, Info.Enabled(Debug))
+	assert.True(t, Debug.Enabled(Debug))
+	assert.False(t, Error.Enabled(Warn))
+	assert.True(t, Warn.Valid())
+	assert.False(t, Level("verbose").Valid())
+}
+
+func TestPrintf(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	defer SetLevel(Info)
+
+	Debugf("calling to %v\n", "lamp")
+	assert.Empty(t, buf.String(), "debug messages are not logged at the info level")
+	Infof("Rule %v fired\n", "night")
+	assert.Contains(t, buf.String(), "Rule night fired")
+
+	buf.Reset()
+	SetLevel(Error)
+	Warnf("Invalid event\n")
+	Println(Info, "App running")
+	assert.Empty(t, buf.String())
+	Errorf("Cannot get rules : %v\n", "closed")
+	assert.Contains(t, buf.String(), "Cannot get rules : closed")
+
+	buf.Reset()
+	SetLevel(Debug)
+	Debugf("calling to %v\n", "lamp")
+	assert.Contains(t, buf.String(), "calling to lamp")
+}
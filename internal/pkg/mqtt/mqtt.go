@@ -0,0 +1,221 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/logging"
+	"github.com/google/uuid"
+)
+
+// DefaultPrefix is the prefix of every topic when none is set
+const DefaultPrefix = "go-home"
+
+// Client defines what the Transport needs from a MQTT client, subscription handlers may be called concurrently
+type Client interface {
+	Publish(ctx context.Context, topic string, payload []byte) error
+	Subscribe(topic string, handler func(topic string, payload []byte)) error
+}
+
+// EventHandler handles an event a device pushed through one of its inbound services, with the secret it was sent with
+type EventHandler func(id string, service string, secret string, body []byte) error
+
+/*
+Command defines the JSON schema of a service call published to a device on its command topic :
+	ID		`id`	: Correlation ID the device answers with on its response topic
+	Query	`query`	: URL query of a call with parameters, e.g. arg0=1, omitted for a JSON call
+	Body	`body`	: JSON body of a JSON call, omitted for a call with a query
+*/
+type Command struct {
+	ID    string          `json:"id"`
+	Query string          `json:"query,omitempty"`
+	Body  json.RawMessage `json:"body,omitempty"`
+}
+
+/*
+Response defines the JSON schema of a device answering a command on its response topic :
+	ID		`id`		: Correlation ID of the command
+	Status	`status`	: HTTP status of the answer, 0 is the same as 200
+	Payload	`payload`	: Response of the service, as it would be answered over HTTP
+*/
+type Response struct {
+	ID      string          `json:"id"`
+	Status  int             `json:"status"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+/*
+Event defines the JSON schema of an event a device publishes on an event topic :
+	Secret	`secret`	: Device secret given on its first connection
+	Body	`body`		: JSON body of the event, keyed the same way as an event pushed over HTTP
+*/
+type Event struct {
+	Secret string          `json:"secret"`
+	Body   json.RawMessage `json:"body"`
+}
+
+// call is a command waiting for its response
+type call struct {
+	device   string
+	response chan *Response
+}
+
+// Transport carries service calls to devices through a MQTT broker, a call is published on the device command topic
+// and answered on its response topic, events published on event topics are given to the EventHandler
+type Transport struct {
+	client  Client
+	prefix  string
+	onEvent EventHandler
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// NewTransport makes a Transport on client and subscribes to the response and event topics of every device,
+// an empty prefix is DefaultPrefix and a nil onEvent ignores events
+func NewTransport(client Client, prefix string, onEvent EventHandler) (*Transport, error) {
+	if prefix == "" {
+		prefix = DefaultPrefix
+	}
+	t := &Transport{
+		client:  client,
+		prefix:  strings.TrimSuffix(prefix, "/"),
+		onEvent: onEvent,
+		calls:   make(map[string]*call),
+	}
+	err := client.Subscribe(t.ResponseTopic("+"), t.handleResponse)
+	if err != nil {
+		return nil, err
+	}
+	err = client.Subscribe(t.EventTopic("+", "+"), t.handleEvent)
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// CommandTopic is the topic calls to a device service are published on
+func (t *Transport) CommandTopic(id string, service string) string {
+	return t.prefix + "/device/" + id + "/command/" + service
+}
+
+// ResponseTopic is the topic a device answers calls on
+func (t *Transport) ResponseTopic(id string) string {
+	return t.prefix + "/device/" + id + "/response"
+}
+
+// EventTopic is the topic a device publishes the events of an inbound service on
+func (t *Transport) EventTopic(id string, service string) string {
+	return t.prefix + "/device/" + id + "/event/" + service
+}
+
+// RoundTrip publishes a call to a device and waits for its response until ctx is done
+func (t *Transport) RoundTrip(ctx context.Context, d *device.Device, req *device.Request) ([]byte, error) {
+	id := d.ID.String()
+	c := &call{device: id, response: make(chan *Response, 1)}
+	cmd := &Command{ID: uuid.New().String(), Query: req.Query}
+	if req.Body != nil {
+		cmd.Body = req.Body
+		cmd.Query = ""
+	}
+	payload, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	t.calls[cmd.ID] = c
+	t.mu.Unlock()
+	defer func() {
+		t.mu.Lock()
+		delete(t.calls, cmd.ID)
+		t.mu.Unlock()
+	}()
+
+	topic := t.CommandTopic(id, req.Service)
+	logging.Debugf("publishing to %v\n", topic)
+	err = t.client.Publish(ctx, topic, payload)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("%w : %v", device.ErrUnreachable, err)
+	}
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case res := <-c.response:
+		status := res.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if status < 200 || status > 299 {
+			return nil, &device.StatusError{
+				Status:    strconv.Itoa(status) + " " + http.StatusText(status),
+				Temporary: status >= 500,
+			}
+		}
+		return res.Payload, nil
+	}
+}
+
+// deviceTopic splits a topic under the device topics into the device ID and the rest of the topic
+func (t *Transport) deviceTopic(topic string) (string, []string, bool) {
+	rest := strings.TrimPrefix(topic, t.prefix+"/device/")
+	if rest == topic {
+		return "", nil, false
+	}
+	parts := strings.Split(rest, "/")
+	if parts[0] == "" {
+		return "", nil, false
+	}
+	return parts[0], parts[1:], true
+}
+
+// handleResponse gives a response to the call waiting for it, a response from another device than the one called is dropped
+func (t *Transport) handleResponse(topic string, payload []byte) {
+	id, _, ok := t.deviceTopic(topic)
+	if !ok {
+		return
+	}
+	var res Response
+	err := json.Unmarshal(payload, &res)
+	if err != nil {
+		logging.Warnf("Invalid response from device %v : %v\n", id, err)
+		return
+	}
+	t.mu.Lock()
+	c, ok := t.calls[res.ID]
+	t.mu.Unlock()
+	if !ok || c.device != id {
+		return
+	}
+	select {
+	case c.response <- &res:
+	default:
+		// the call was already answered
+	}
+}
+
+// handleEvent gives an event to the EventHandler
+func (t *Transport) handleEvent(topic string, payload []byte) {
+	id, rest, ok := t.deviceTopic(topic)
+	if !ok || len(rest) != 2 || rest[1] == "" || t.onEvent == nil {
+		return
+	}
+	var e Event
+	err := json.Unmarshal(payload, &e)
+	if err != nil {
+		logging.Warnf("Invalid event from device %v : %v\n", id, err)
+		return
+	}
+	err = t.onEvent(id, rest[1], e.Secret, e.Body)
+	if err != nil {
+		logging.Errorf("Cannot handle event %v of device %v : %v\n", rest[1], id, err)
+	}
+}
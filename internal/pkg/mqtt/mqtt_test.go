@@ -0,0 +1,162 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/stretchr/testify/assert"
+)
+
+type subscription struct {
+	filter  string
+	handler func(topic string, payload []byte)
+}
+
+// memoryBroker is a Client that delivers messages to its own subscriptions, as a broker would
+type memoryBroker struct {
+	mu   sync.Mutex
+	subs []subscription
+}
+
+func match(filter string, topic string) bool {
+	f := strings.Split(filter, "/")
+	t := strings.Split(topic, "/")
+	if len(f) != len(t) {
+		return false
+	}
+	for i := range f {
+		if f[i] != "+" && f[i] != t[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *memoryBroker) Publish(ctx context.Context, topic string, payload []byte) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, s := range b.subs {
+		if match(s.filter, topic) {
+			go s.handler(topic, payload)
+		}
+	}
+	return nil
+}
+
+func (b *memoryBroker) Subscribe(topic string, handler func(topic string, payload []byte)) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.subs = append(b.subs, subscription{filter: topic, handler: handler})
+	return nil
+}
+
+type pushedEvent struct {
+	id      string
+	service string
+	secret  string
+	body    string
+}
+
+func TestTransport(t *testing.T) {
+	broker := &memoryBroker{}
+	events := make(chan pushedEvent, 1)
+	tr, err := NewTransport(broker, "", func(id string, service string, secret string, body []byte) error {
+		events <- pushedEvent{id: id, service: service, secret: secret, body: string(body)}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, err := device.NewDevice("Lamp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}, []byte(`def outbound on(int32);def outbound off();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev.Transport = device.TransportMQTT
+	id := dev.ID.String()
+
+	// the device answers on with what it was called with, and off with an error
+	commands := make(chan *Command, 10)
+	err = broker.Subscribe(tr.CommandTopic(id, "+"), func(topic string, payload []byte) {
+		var cmd Command
+		assert.NoError(t, json.Unmarshal(payload, &cmd))
+		commands <- &cmd
+		res := &Response{ID: cmd.ID}
+		switch {
+		case strings.HasSuffix(topic, "/off"):
+			res.Status = 503
+		case cmd.Body != nil:
+			res.Payload = cmd.Body
+		default:
+			res.Payload, _ = json.Marshal(cmd.Query)
+		}
+		raw, _ := json.Marshal(res)
+		broker.Publish(context.Background(), tr.ResponseTopic(id), raw)
+	})
+	assert.NoError(t, err)
+	opts := device.CallOptions{
+		Timeout:    time.Second,
+		Transports: map[string]device.Transport{device.TransportMQTT: tr},
+	}
+
+	body, err := dev.Call(context.Background(), "on", "arg0=80", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, `"arg0=80"`, string(body))
+	assert.Equal(t, "arg0=80", (<-commands).Query)
+
+	body, err = dev.CallJSON(context.Background(), "on", []byte(`{"arg0":80}`), opts)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"arg0":80}`, string(body))
+	cmd := <-commands
+	assert.Empty(t, cmd.Query)
+	assert.JSONEq(t, `{"arg0":80}`, string(cmd.Body))
+
+	_, err = dev.Call(context.Background(), "off", "", opts)
+	assert.True(t, errors.Is(err, device.ErrBadStatus), err)
+	assert.Contains(t, err.Error(), "503 Service Unavailable")
+	<-commands
+
+	t.Run("No answer", func(t *testing.T) {
+		other := *dev
+		opts := opts
+		opts.Timeout = 20 * time.Millisecond
+		other.ID[0]++
+		_, err := other.Call(context.Background(), "on", "", opts)
+		assert.True(t, errors.Is(err, device.ErrTimeout), err)
+		assert.Empty(t, tr.calls, "a call that is not answered is forgotten")
+	})
+
+	t.Run("Answer from another device", func(t *testing.T) {
+		other := *dev
+		other.ID[0]++
+		otherID := other.ID.String()
+		broker.Subscribe(tr.CommandTopic(otherID, "+"), func(topic string, payload []byte) {
+			var cmd Command
+			json.Unmarshal(payload, &cmd)
+			raw, _ := json.Marshal(&Response{ID: cmd.ID})
+			// a device that is not the one called cannot answer for it
+			broker.Publish(context.Background(), tr.ResponseTopic(id), raw)
+		})
+		opts := opts
+		opts.Timeout = 20 * time.Millisecond
+		_, err := other.Call(context.Background(), "on", "", opts)
+		assert.True(t, errors.Is(err, device.ErrTimeout), err)
+	})
+
+	t.Run("Event", func(t *testing.T) {
+		err := broker.Publish(context.Background(), tr.EventTopic(id, "pressed"), []byte(`{"secret":"s3cret","body":{"Count":2}}`))
+		assert.NoError(t, err)
+		select {
+		case e := <-events:
+			assert.Equal(t, pushedEvent{id: id, service: "pressed", secret: "s3cret", body: `{"Count":2}`}, e)
+		case <-time.After(time.Second):
+			t.Fatal("event was not handled")
+		}
+	})
+}
@@ -0,0 +1,88 @@
+package mqtt
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/logging"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// qos is the quality of service of every publish and subscription, at least once
+const qos = 1
+
+// Options defines how the hub connects to a MQTT broker
+type Options struct {
+	// Broker is the URL of the broker, e.g. tcp://localhost:1883
+	Broker   string
+	ClientID string
+	Username string
+	Password string
+}
+
+// pahoClient is a Client on a paho connection, subscriptions are made again every time it reconnects
+type pahoClient struct {
+	client paho.Client
+
+	mu   sync.Mutex
+	subs map[string]paho.MessageHandler
+}
+
+// Dial connects to a MQTT broker, the connection is made again when it is lost
+func Dial(opts Options) (Client, error) {
+	c := &pahoClient{subs: make(map[string]paho.MessageHandler)}
+	clientOpts := paho.NewClientOptions().
+		AddBroker(opts.Broker).
+		SetClientID(opts.ClientID).
+		SetUsername(opts.Username).
+		SetPassword(opts.Password).
+		SetAutoReconnect(true).
+		SetConnectTimeout(10 * time.Second).
+		// handlers run concurrently, an event being stored does not hold back responses
+		SetOrderMatters(false).
+		SetOnConnectHandler(c.resubscribe).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			logging.Errorf("Lost connection to MQTT broker : %v\n", err)
+		})
+	c.client = paho.NewClient(clientOpts)
+	token := c.client.Connect()
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// resubscribe makes every subscription again on a new connection
+func (c *pahoClient) resubscribe(client paho.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for topic, handler := range c.subs {
+		client.Subscribe(topic, qos, handler)
+	}
+}
+
+// Publish publishes a message and waits for the broker to receive it until ctx is done
+func (c *pahoClient) Publish(ctx context.Context, topic string, payload []byte) error {
+	token := c.client.Publish(topic, qos, false, payload)
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-token.Done():
+		return token.Error()
+	}
+}
+
+// Subscribe subscribes to a topic filter
+func (c *pahoClient) Subscribe(topic string, handler func(topic string, payload []byte)) error {
+	h := func(_ paho.Client, m paho.Message) {
+		handler(m.Topic(), m.Payload())
+	}
+	c.mu.Lock()
+	c.subs[topic] = h
+	c.mu.Unlock()
+	token := c.client.Subscribe(topic, qos, h)
+	token.Wait()
+	return token.Error()
+}
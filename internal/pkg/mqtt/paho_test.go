@@ -0,0 +1,164 @@
+package mqtt
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/stretchr/testify/assert"
+)
+
+// tcpBroker is a MQTT broker on a local port that knows just enough of the protocol for one client,
+// it acknowledges what the client sends, reports its subscriptions and publishes, and answers a subscription with a message
+type tcpBroker struct {
+	listener   net.Listener
+	subscribed chan string
+	published  chan *packets.PublishPacket
+
+	mu    sync.Mutex
+	conns []net.Conn
+}
+
+func newTCPBroker(t *testing.T) *tcpBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &tcpBroker{
+		listener:   l,
+		subscribed: make(chan string, 10),
+		published:  make(chan *packets.PublishPacket, 10),
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			b.mu.Lock()
+			b.conns = append(b.conns, conn)
+			b.mu.Unlock()
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *tcpBroker) url() string {
+	return "tcp://" + b.listener.Addr().String()
+}
+
+// drop closes every connection, as a broker restarting would
+func (b *tcpBroker) drop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for _, conn := range b.conns {
+		conn.Close()
+	}
+	b.conns = nil
+}
+
+func (b *tcpBroker) close() {
+	b.listener.Close()
+	b.drop()
+}
+
+func (b *tcpBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		p, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		switch p := p.(type) {
+		case *packets.ConnectPacket:
+			ack := packets.NewControlPacket(packets.Connack).(*packets.ConnackPacket)
+			ack.ReturnCode = packets.Accepted
+			err = ack.Write(conn)
+		case *packets.SubscribePacket:
+			ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			ack.MessageID = p.MessageID
+			ack.ReturnCodes = make([]byte, len(p.Topics))
+			err = ack.Write(conn)
+			if err != nil {
+				return
+			}
+			for _, topic := range p.Topics {
+				b.subscribed <- topic
+				m := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+				m.TopicName = topic
+				m.Payload = []byte("hello")
+				err = m.Write(conn)
+			}
+		case *packets.PublishPacket:
+			ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+			ack.MessageID = p.MessageID
+			err = ack.Write(conn)
+			b.published <- p
+		case *packets.PingreqPacket:
+			err = packets.NewControlPacket(packets.Pingresp).Write(conn)
+		case *packets.DisconnectPacket:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+func TestDial(t *testing.T) {
+	b := newTCPBroker(t)
+	defer b.close()
+	c, err := Dial(Options{Broker: b.url(), ClientID: "go-home-test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.(*pahoClient).client.Disconnect(0)
+
+	received := make(chan string, 10)
+	err = c.Subscribe("go-home/device/response", func(topic string, payload []byte) {
+		received <- topic + " " + string(payload)
+	})
+	assert.NoError(t, err)
+	expect := func(t *testing.T, ch chan string, want string) {
+		t.Helper()
+		select {
+		case got := <-ch:
+			assert.Equal(t, want, got)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%v did not arrive", want)
+		}
+	}
+	expect(t, b.subscribed, "go-home/device/response")
+	expect(t, received, "go-home/device/response hello")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	assert.NoError(t, c.Publish(ctx, "go-home/device/command", []byte(`{"id":"1"}`)))
+	select {
+	case p := <-b.published:
+		assert.Equal(t, "go-home/device/command", p.TopicName)
+		assert.Equal(t, `{"id":"1"}`, string(p.Payload))
+		assert.Equal(t, byte(qos), p.Qos)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the publish did not arrive")
+	}
+
+	b.drop()
+	expect(t, b.subscribed, "go-home/device/response")
+	expect(t, received, "go-home/device/response hello")
+}
+
+func TestDial_Unreachable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	_, err = Dial(Options{Broker: "tcp://" + addr, ClientID: "go-home-test"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,186 @@
+package pki
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+// newCSR makes the key of a device and a PEM encoded certificate signing request of it
+func newCSR(t *testing.T, name string) (*ecdsa.PrivateKey, []byte) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: name}}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+// enroll issues the certificate of a device with its key
+func enroll(t *testing.T, ca *CA, id string) tls.Certificate {
+	key, csr := newCSR(t, "anything")
+	certPEM, err := ca.SignDevice(csr, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(certPEM)
+	return tls.Certificate{Certificate: [][]byte{block.Bytes}, PrivateKey: key}
+}
+
+func TestLoadCA(t *testing.T) {
+	dir := t.TempDir()
+	ca, err := LoadCA(dir)
+	assert.NoError(t, err)
+	assert.True(t, ca.Cert.IsCA)
+
+	again, err := LoadCA(dir)
+	assert.NoError(t, err)
+	assert.Equal(t, ca.CertPEM(), again.CertPEM(), "the CA is kept")
+
+	id := uuid.New().String()
+	cert := enroll(t, again, id)
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = leaf.Verify(x509.VerifyOptions{Roots: ca.Pool(), KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	assert.NoError(t, err, "a certificate of the loaded CA is verified by the CA it was made as")
+}
+
+func TestCA_SignDevice(t *testing.T) {
+	ca, err := NewCA("test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, csr := newCSR(t, "hub")
+	tests := []struct {
+		name    string
+		csr     []byte
+		wantErr error
+	}{
+		{
+			name: "Signing request",
+			csr:  csr,
+		},
+		{
+			name:    "Not PEM encoded",
+			csr:     []byte("csr"),
+			wantErr: ErrInvalidCSR,
+		},
+		{
+			name:    "Certificate instead of a signing request",
+			csr:     ca.CertPEM(),
+			wantErr: ErrInvalidCSR,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			certPEM, err := ca.SignDevice(tt.csr, "device-id")
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), err)
+				return
+			}
+			assert.NoError(t, err)
+			block, _ := pem.Decode(certPEM)
+			cert, err := x509.ParseCertificate(block.Bytes)
+			assert.NoError(t, err)
+			assert.Equal(t, "device-id", cert.Subject.CommonName, "the device cannot ask for another name")
+			assert.WithinDuration(t, time.Now().Add(CertValidity), cert.NotAfter, time.Minute)
+		})
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca, err := NewCA("test CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hubCert, err := ca.IssueHub([]string{"localhost", "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, err := device.NewDevice("Lamp", &device.Endpoint{}, []byte(`def outbound on();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the device serves its certificate and sees the hub through its certificate
+	var conns, requests int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		fmt.Fprint(w, ClientID(r.TLS))
+	}))
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&conns, 1)
+		}
+	}
+	server.TLS = ca.ServerTLSConfig(enroll(t, ca, dev.ID.String()))
+	server.StartTLS()
+	defer server.Close()
+	dev.Addr, err = device.ParseEndpoint("https://" + server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := device.CallOptions{
+		Timeout: time.Second,
+		Retries: 2,
+		Transports: map[string]device.Transport{device.TransportHTTP: &device.HTTPTransport{
+			Client:         &http.Client{Transport: &http.Transport{TLSClientConfig: ca.ClientTLSConfig(hubCert)}},
+			VerifyDeviceID: true,
+		}},
+	}
+	body, err := dev.Call(context.Background(), "on", "", opts)
+	assert.NoError(t, err)
+	assert.Equal(t, HubName, string(body))
+
+	other := *dev
+	other.ID = uuid.New()
+	atomic.StoreInt32(&conns, 0)
+	atomic.StoreInt32(&requests, 0)
+	_, err = other.Call(context.Background(), "on", "", opts)
+	assert.True(t, errors.Is(err, device.ErrWrongIdentity), "a device has to present its own certificate, %v", err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&requests), "the call is not sent to another device")
+	assert.Equal(t, int32(1), atomic.LoadInt32(&conns), "a device of the wrong identity is not retried")
+
+	plain := *dev
+	plain.Addr, err = device.ParseEndpoint("http://" + server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&conns, 0)
+	_, err = plain.Call(context.Background(), "on", "", opts)
+	assert.True(t, errors.Is(err, device.ErrWrongIdentity), "a device is verified over HTTPS only, %v", err)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&conns))
+
+	stranger, err := NewCA("another CA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.Transports[device.TransportHTTP] = &device.HTTPTransport{
+		Client: &http.Client{Transport: &http.Transport{TLSClientConfig: stranger.ClientTLSConfig(hubCert)}},
+	}
+	_, err = dev.Call(context.Background(), "on", "", opts)
+	assert.Error(t, err, "a device certificate of another CA is not verified")
+}
@@ -0,0 +1,51 @@
+package rule
+
+import "sync"
+
+// Cache keeps the rules of a Repo in memory so they are not queried for every message,
+// the rules saved and deleted through it are read again, as are the rules after Invalidate
+type Cache struct {
+	Repo
+	mu     sync.Mutex
+	rules  []*Rule
+	cached bool
+}
+
+// NewCache makes a Cache of the rules of repo
+func NewCache(repo Repo) *Cache {
+	return &Cache{Repo: repo}
+}
+
+// GetRules gets every rule, from memory once they were read
+func (c *Cache) GetRules() ([]*Rule, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.cached {
+		return c.rules, nil
+	}
+	rules, err := c.Repo.GetRules()
+	if err != nil {
+		return nil, err
+	}
+	c.rules, c.cached = rules, true
+	return rules, nil
+}
+
+// SaveRule saves a rule through the Repo
+func (c *Cache) SaveRule(r *Rule) error {
+	defer c.Invalidate()
+	return c.Repo.SaveRule(r)
+}
+
+// DeleteRule deletes a rule through the Repo
+func (c *Cache) DeleteRule(id string) error {
+	defer c.Invalidate()
+	return c.Repo.DeleteRule(id)
+}
+
+// Invalidate makes the rules be read again from the Repo, after they were changed without the Cache
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	c.rules, c.cached = nil, false
+	c.mu.Unlock()
+}
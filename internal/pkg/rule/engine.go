@@ -0,0 +1,145 @@
+package rule
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/bus"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/event"
+	"github.com/IktaS/go-home/internal/pkg/health"
+	"github.com/IktaS/go-home/internal/pkg/logging"
+)
+
+// DefaultTick is how often the Engine checks for due schedules
+const DefaultTick = 10 * time.Second
+
+// Engine fires rules on the event and status messages of Bus, and on their schedules, it receives every message
+// however far behind it falls, Rules is read on every message so it is best a Cache
+type Engine struct {
+	Rules       Repo
+	Devices     DeviceRepo
+	Bus         *bus.Bus
+	CallOptions device.CallOptions
+	Tick        time.Duration
+}
+
+// NewEngine makes an Engine that checks schedules every DefaultTick
+func NewEngine(rules Repo, devices DeviceRepo, b *bus.Bus, opts device.CallOptions) *Engine {
+	return &Engine{
+		Rules:       rules,
+		Devices:     devices,
+		Bus:         b,
+		CallOptions: opts,
+		Tick:        DefaultTick,
+	}
+}
+
+// triggered tells whether a message fires the trigger of a rule, and the conditions of the rule hold on it
+func triggered(r *Rule, m *bus.Message) bool {
+	t := r.Trigger
+	switch {
+	case t.Type == TriggerEvent && m.Type == bus.DeviceEvent:
+		e, ok := m.Data.(*event.Event)
+		if !ok {
+			return false
+		}
+		return t.Device == m.Device && t.Service == e.Service && r.HoldsOn(e.Payload)
+	case t.Type == TriggerStatus && m.Type == bus.DeviceStatus:
+		change, ok := m.Data.(*health.StatusChange)
+		if !ok {
+			return false
+		}
+		return (t.Device == "" || t.Device == m.Device) && (t.Status == "" || t.Status == change.Status)
+	}
+	return false
+}
+
+// Matching gets the enabled rules a message fires at a time
+func (e *Engine) Matching(m *bus.Message, now time.Time) ([]*Rule, error) {
+	rules, err := e.Rules.GetRules()
+	if err != nil {
+		return nil, err
+	}
+	var res []*Rule
+	for _, r := range rules {
+		if !r.Disabled && r.Trigger != nil && triggered(r, m) && r.Window.Contains(now) {
+			res = append(res, r)
+		}
+	}
+	return res, nil
+}
+
+// Due gets the enabled rules whose schedule fires after from until to
+func (e *Engine) Due(from time.Time, to time.Time) ([]*Rule, error) {
+	rules, err := e.Rules.GetRules()
+	if err != nil {
+		return nil, err
+	}
+	var res []*Rule
+	for _, r := range rules {
+		if !r.Disabled && r.Trigger != nil && r.Trigger.Due(from, to) && r.Window.Contains(to) {
+			res = append(res, r)
+		}
+	}
+	return res, nil
+}
+
+// Fire calls the actions of a rule in order, an action that fails does not stop the next ones,
+// the first error is returned
+func (e *Engine) Fire(ctx context.Context, r *Rule) error {
+	var firstErr error
+	for _, a := range r.Actions {
+		_, err := a.Call(ctx, e.Devices, e.CallOptions)
+		if err != nil {
+			err = fmt.Errorf("%v on %v : %w", a.Service, a.Device, err)
+			logging.Warnf("Rule %v (%v) cannot call %v\n", r.Name, r.ID, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+	return firstErr
+}
+
+func (e *Engine) fireAll(ctx context.Context, rules []*Rule) {
+	for _, r := range rules {
+		logging.Infof("Rule %v (%v) fired\n", r.Name, r.ID)
+		// a slow device must not hold back the messages of the bus
+		go e.Fire(ctx, r)
+	}
+}
+
+// Run fires rules until the context is done
+func (e *Engine) Run(ctx context.Context) {
+	sub := e.Bus.SubscribeAll(bus.Filter{Types: []bus.Type{bus.DeviceEvent, bus.DeviceStatus}})
+	defer sub.Close()
+	ticker := time.NewTicker(e.Tick)
+	defer ticker.Stop()
+	last := time.Now()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case m, ok := <-sub.C():
+			if !ok {
+				return
+			}
+			rules, err := e.Matching(m, time.Now())
+			if err != nil {
+				logging.Errorf("Cannot get rules : %v\n", err)
+				continue
+			}
+			e.fireAll(ctx, rules)
+		case now := <-ticker.C:
+			rules, err := e.Due(last, now)
+			if err != nil {
+				logging.Errorf("Cannot get rules : %v\n", err)
+				continue
+			}
+			last = now
+			e.fireAll(ctx, rules)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package rule
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/action"
+	"github.com/IktaS/go-home/internal/pkg/bus"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/event"
+	"github.com/IktaS/go-home/internal/pkg/health"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryRules []*Rule
+
+func (m memoryRules) SaveRule(*Rule) error             { return nil }
+func (m memoryRules) GetRule(id string) (*Rule, error) { return nil, nil }
+func (m memoryRules) GetRules() ([]*Rule, error)       { return m, nil }
+func (m memoryRules) DeleteRule(id string) error       { return nil }
+
+func TestEngine_Matching(t *testing.T) {
+	devices, door, lamp := testDevices(t)
+	actions := []*action.Action{{Device: lamp.ID.String(), Service: "on", Params: map[string]string{"arg0": "80"}}}
+	opened := &Rule{ID: uuid.New(), Name: "opened at night", Definition: Definition{
+		Trigger:    &Trigger{Type: TriggerEvent, Device: door.ID.String(), Service: "opened"},
+		Conditions: []*Condition{{Field: "Open", Op: OpEqual, Value: true}},
+		Window:     &Window{After: "22:00", Before: "06:00"},
+		Actions:    actions,
+	}}
+	offline := &Rule{ID: uuid.New(), Name: "offline", Definition: Definition{
+		Trigger: &Trigger{Type: TriggerStatus, Status: "offline"},
+		Actions: actions,
+	}}
+	disabled := &Rule{ID: uuid.New(), Name: "disabled", Disabled: true, Definition: Definition{
+		Trigger: &Trigger{Type: TriggerStatus},
+		Actions: actions,
+	}}
+	e := NewEngine(memoryRules{opened, offline, disabled}, devices, bus.New(), device.DefaultCallOptions)
+
+	night := time.Date(2021, 1, 1, 23, 0, 0, 0, time.Local)
+	noon := time.Date(2021, 1, 1, 12, 0, 0, 0, time.Local)
+	openedEvent := func(payload string) *bus.Message {
+		return &bus.Message{
+			Type:   bus.DeviceEvent,
+			Device: door.ID.String(),
+			Data:   event.New(door.ID, "opened", json.RawMessage(payload)),
+		}
+	}
+	tests := []struct {
+		name    string
+		message *bus.Message
+		now     time.Time
+		want    []*Rule
+	}{
+		{
+			name:    "Event at night",
+			message: openedEvent(`{"Open":true,"Who":"me"}`),
+			now:     night,
+			want:    []*Rule{opened},
+		},
+		{
+			name:    "Event at noon",
+			message: openedEvent(`{"Open":true,"Who":"me"}`),
+			now:     noon,
+		},
+		{
+			name:    "Event that does not hold",
+			message: openedEvent(`{"Open":false,"Who":"me"}`),
+			now:     night,
+		},
+		{
+			name:    "Device offline",
+			message: health.NewStatusChange(lamp.ID.String(), device.StatusOffline, time.Time{}),
+			now:     noon,
+			want:    []*Rule{offline},
+		},
+		{
+			name:    "Device online",
+			message: health.NewStatusChange(lamp.ID.String(), device.StatusOnline, noon),
+			now:     noon,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := e.Matching(tt.message, tt.now)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, rules)
+		})
+	}
+}
+
+func TestEngine_Fire(t *testing.T) {
+	var mu sync.Mutex
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls = append(calls, r.URL.String())
+		mu.Unlock()
+		if r.URL.Path == "/lock" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	addr, err := net.ResolveTCPAddr("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, door, lamp := testDevices(t)
+	door.Addr = addr
+	lamp.Addr = addr
+
+	r := &Rule{ID: uuid.New(), Name: "leave", Definition: Definition{
+		Trigger: &Trigger{Type: TriggerSchedule, At: "08:00"},
+		Actions: []*action.Action{
+			{Device: door.ID.String(), Service: "lock"},
+			{Device: lamp.ID.String(), Service: "on", Params: map[string]string{"arg0": "0"}},
+		},
+	}}
+	e := NewEngine(memoryRules{r}, devices, bus.New(), device.CallOptions{Timeout: time.Second})
+	err = e.Fire(context.Background(), r)
+	assert.Error(t, err, "the failed lock is returned")
+	assert.Equal(t, []string{"/lock", "/on?arg0=0"}, calls, "an action that fails does not stop the next ones")
+
+	day := time.Date(2021, 1, 1, 7, 59, 55, 0, time.Local)
+	due, err := e.Due(day, day.Add(10*time.Second))
+	assert.NoError(t, err)
+	assert.Equal(t, []*Rule{r}, due)
+}
+
+// countingRules counts how many times the rules are read
+type countingRules struct {
+	memoryRules
+	reads int
+}
+
+func (c *countingRules) GetRules() ([]*Rule, error) {
+	c.reads++
+	return c.memoryRules, nil
+}
+
+func TestCache(t *testing.T) {
+	repo := &countingRules{memoryRules: memoryRules{{ID: uuid.New(), Name: "rule"}}}
+	c := NewCache(repo)
+	for i := 0; i < 3; i++ {
+		rules, err := c.GetRules()
+		assert.NoError(t, err)
+		assert.Len(t, rules, 1)
+	}
+	assert.Equal(t, 1, repo.reads, "the rules are read once")
+
+	assert.NoError(t, c.SaveRule(&Rule{ID: uuid.New()}))
+	c.GetRules()
+	assert.Equal(t, 2, repo.reads, "saving a rule reads the rules again")
+	assert.NoError(t, c.DeleteRule(""))
+	c.GetRules()
+	assert.Equal(t, 3, repo.reads, "deleting a rule reads the rules again")
+	c.Invalidate()
+	c.GetRules()
+	assert.Equal(t, 4, repo.reads)
+}
+
+func TestEngine_RunBurst(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}))
+	defer server.Close()
+	addr, err := net.ResolveTCPAddr("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, door, lamp := testDevices(t)
+	lamp.Addr = addr
+	r := &Rule{ID: uuid.New(), Name: "offline", Definition: Definition{
+		Trigger: &Trigger{Type: TriggerStatus, Status: "offline"},
+		Actions: []*action.Action{{Device: lamp.ID.String(), Service: "on", Params: map[string]string{"arg0": "0"}}},
+	}}
+	b := bus.New()
+	e := NewEngine(NewCache(memoryRules{r}), devices, b, device.CallOptions{Timeout: time.Second})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go e.Run(ctx)
+	// let the engine subscribe
+	time.Sleep(50 * time.Millisecond)
+
+	n := bus.DefaultBuffer * 3
+	for i := 0; i < n; i++ {
+		b.Publish(&bus.Message{Type: bus.DeviceStatus, Device: door.ID.String(), Data: &health.StatusChange{Status: "offline"}})
+	}
+	assert.Eventually(t, func() bool {
+		return atomic.LoadInt32(&calls) == int32(n)
+	}, 5*time.Second, 10*time.Millisecond, "a burst of messages fires the rule for each of them")
+}
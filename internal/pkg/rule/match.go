@@ -0,0 +1,148 @@
+package rule
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/device"
+)
+
+// sinceMidnight is how long after midnight a time is, in its own location
+func sinceMidnight(t time.Time) time.Duration {
+	h, m, s := t.Clock()
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s)*time.Second
+}
+
+// Contains tells whether a time is in the window, a nil window contains every time
+func (w *Window) Contains(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	now := sinceMidnight(t)
+	after, _ := parseClock(w.After)
+	before := 24 * time.Hour
+	if w.Before != "" {
+		before, _ = parseClock(w.Before)
+	}
+	if after <= before {
+		return after <= now && now < before
+	}
+	// the window wraps past midnight
+	return now >= after || now < before
+}
+
+// Due tells whether a schedule trigger fires after from until to, a trigger that is not a schedule is never due
+func (t *Trigger) Due(from time.Time, to time.Time) bool {
+	if t.Type != TriggerSchedule || !to.After(from) {
+		return false
+	}
+	if t.Every != "" {
+		every, err := time.ParseDuration(t.Every)
+		if err != nil || every < minEvery {
+			return false
+		}
+		// intervals are aligned on the zero time, so they fire at the same times whenever the hub started
+		return to.Truncate(every).After(from)
+	}
+	at, err := parseClock(t.At)
+	if err != nil {
+		return false
+	}
+	for _, day := range []time.Time{from, to} {
+		y, m, d := day.Date()
+		fire := time.Date(y, m, d, 0, 0, 0, 0, to.Location()).Add(at)
+		if fire.After(from) && !fire.After(to) {
+			return true
+		}
+	}
+	return false
+}
+
+// lookup gets a field of a payload decoded with device.DecodeJSON, a nested field is given as Field.SubField
+func lookup(payload map[string]interface{}, field string) (interface{}, bool) {
+	var v interface{} = payload
+	for _, name := range strings.Split(field, ".") {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		v, ok = obj[name]
+		if !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case float64:
+		return n, true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// Holds tells whether the condition holds on a payload, a payload without the field never holds
+func (c *Condition) Holds(payload map[string]interface{}) bool {
+	v, ok := lookup(payload, c.Field)
+	if !ok {
+		return false
+	}
+	a, aNumber := toNumber(v)
+	b, bNumber := toNumber(c.Value)
+	_, isString := v.(string)
+	if !aNumber || !bNumber || isString {
+		switch c.Op {
+		case OpEqual:
+			return reflect.DeepEqual(v, c.Value)
+		case OpNotEqual:
+			return !reflect.DeepEqual(v, c.Value)
+		}
+		return false
+	}
+	switch c.Op {
+	case OpEqual:
+		return a == b
+	case OpNotEqual:
+		return a != b
+	case OpGreater:
+		return a > b
+	case OpGreaterOrEqual:
+		return a >= b
+	case OpLess:
+		return a < b
+	case OpLessOrEqual:
+		return a <= b
+	}
+	return false
+}
+
+// HoldsOn tells whether every condition holds on the JSON payload of an event
+func (d *Definition) HoldsOn(payload json.RawMessage) bool {
+	if len(d.Conditions) == 0 {
+		return true
+	}
+	obj := make(map[string]interface{})
+	if err := device.DecodeJSON(payload, &obj); err != nil {
+		return false
+	}
+	for _, c := range d.Conditions {
+		if !c.Holds(obj) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,297 @@
+package rule
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/action"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/google/uuid"
+)
+
+// Types of triggers
+const (
+	// TriggerEvent fires a rule when a device pushes an event through an inbound service
+	TriggerEvent = "event"
+	// TriggerStatus fires a rule when a device goes online or offline
+	TriggerStatus = "status"
+	// TriggerSchedule fires a rule every day at a time, or every interval
+	TriggerSchedule = "schedule"
+)
+
+// Operators of conditions
+const (
+	OpEqual          = "eq"
+	OpNotEqual       = "ne"
+	OpGreater        = "gt"
+	OpGreaterOrEqual = "gte"
+	OpLess           = "lt"
+	OpLessOrEqual    = "lte"
+)
+
+// clockLayout is the layout of the times of day used by schedules and windows
+const clockLayout = "15:04"
+
+// minEvery is the shortest interval of a schedule
+const minEvery = time.Minute
+
+// ErrInvalid is the error of a rule that does not match the devices it uses
+var ErrInvalid = errors.New("invalid rule")
+
+/*
+Trigger defines the JSON schema of what fires a rule :
+	Type	`type`		: One of event, status or schedule
+	Device	`device`	: UUID of the device, required for event and optional for status
+	Service	`service`	: Inbound service of the device, for event
+	Status	`status`	: Status the device goes to, online or offline, for status, empty for both
+	At		`at`		: Time of day as HH:MM in the hub time zone, for schedule
+	Every	`every`		: Interval as a duration of at least a minute, for schedule instead of at
+*/
+type Trigger struct {
+	Type    string `json:"type"`
+	Device  string `json:"device,omitempty"`
+	Service string `json:"service,omitempty"`
+	Status  string `json:"status,omitempty"`
+	At      string `json:"at,omitempty"`
+	Every   string `json:"every,omitempty"`
+}
+
+/*
+Condition defines the JSON schema of a check on the payload of an event :
+	Field	`field`	: Parameter of the event service, Field or Field.SubField for messages and arg0... for scalars
+	Op		`op`	: One of eq, ne, gt, gte, lt or lte, the last four only on numbers
+	Value	`value`	: Value compared to, of the field type
+*/
+type Condition struct {
+	Field string      `json:"field"`
+	Op    string      `json:"op"`
+	Value interface{} `json:"value"`
+}
+
+/*
+Window defines the JSON schema of the time of day a rule may fire in, it wraps past midnight when After is later than Before :
+	After	`after`		: Time of day as HH:MM the window starts at, empty for midnight
+	Before	`before`	: Time of day as HH:MM the window ends at, empty for midnight
+*/
+type Window struct {
+	After  string `json:"after,omitempty"`
+	Before string `json:"before,omitempty"`
+}
+
+// Definition defines what a rule does, it is stored as a whole
+type Definition struct {
+	Trigger    *Trigger         `json:"trigger"`
+	Conditions []*Condition     `json:"conditions,omitempty"`
+	Window     *Window          `json:"window,omitempty"`
+	Actions    []*action.Action `json:"actions"`
+}
+
+/*
+Rule defines the JSON schema of an automation rule, its actions are called in order when its trigger fires
+while its conditions hold and the time is in its window :
+	ID			`id`			: Rule UUID
+	Name		`name`			: Rule name
+	Disabled	`disabled`		: A disabled rule never fires
+	Trigger		`trigger`		: See Trigger
+	Conditions	`conditions`	: See Condition, every condition must hold
+	Window		`window`		: See Window, optional
+	Actions		`actions`		: See Action
+*/
+type Rule struct {
+	ID       uuid.UUID `json:"id"`
+	Name     string    `json:"name"`
+	Disabled bool      `json:"disabled"`
+	Definition
+}
+
+// Repo is an interface that defines what a rule repository should have
+type Repo interface {
+	// SaveRule saves a rule, replacing the rule with the same ID
+	SaveRule(*Rule) error
+	// GetRule gets a rule by its ID, returns sql.ErrNoRows if it does not exist
+	GetRule(id string) (*Rule, error)
+	GetRules() ([]*Rule, error)
+	// DeleteRule deletes a rule by its ID, returns sql.ErrNoRows if it does not exist
+	DeleteRule(id string) error
+}
+
+// DeviceRepo defines what rules need from a device repository
+type DeviceRepo interface {
+	action.DeviceRepo
+}
+
+func invalid(format string, a ...interface{}) error {
+	return fmt.Errorf("%w : %v", ErrInvalid, fmt.Sprintf(format, a...))
+}
+
+// getDevice gets a device a rule uses, a device that does not exist makes the rule invalid
+func getDevice(devices DeviceRepo, id string) (*device.Device, error) {
+	uid, err := uuid.Parse(id)
+	if err != nil {
+		return nil, invalid("%v is not a device UUID", id)
+	}
+	dev, err := devices.Get(uid.String())
+	if err == sql.ErrNoRows {
+		return nil, invalid("device %v not found", id)
+	}
+	return dev, err
+}
+
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse(clockLayout, s)
+	if err != nil {
+		return 0, invalid("%v is not a time of day as HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Validate checks a rule against the devices it uses, and normalizes their UUIDs,
+// an error wrapping ErrInvalid is returned when the rule cannot be used
+func (r *Rule) Validate(devices DeviceRepo) error {
+	if r.Name == "" {
+		return invalid("no name")
+	}
+	if r.Trigger == nil {
+		return invalid("no trigger")
+	}
+	err := r.validateTrigger(devices)
+	if err != nil {
+		return err
+	}
+	if r.Window != nil {
+		if r.Window.After != "" {
+			if _, err := parseClock(r.Window.After); err != nil {
+				return err
+			}
+		}
+		if r.Window.Before != "" {
+			if _, err := parseClock(r.Window.Before); err != nil {
+				return err
+			}
+		}
+	}
+	if len(r.Actions) == 0 {
+		return invalid("no actions")
+	}
+	for _, a := range r.Actions {
+		err := a.Validate(devices)
+		if errors.Is(err, action.ErrInvalid) {
+			return fmt.Errorf("%w : %v", ErrInvalid, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Devices gets the UUIDs of the devices the rule uses, by its trigger and its actions, each once
+func (r *Rule) Devices() []string {
+	res := action.Devices(r.Actions)
+	if r.Trigger == nil || r.Trigger.Device == "" {
+		return res
+	}
+	for _, id := range res {
+		if id == r.Trigger.Device {
+			return res
+		}
+	}
+	return append([]string{r.Trigger.Device}, res...)
+}
+
+// RemoveDevice removes the actions calling a deleted device, the rule is disabled when the device triggers it
+// or no action is left, so it shows what it lost instead of firing without it
+func (r *Rule) RemoveDevice(id string) {
+	r.Actions = action.Without(r.Actions, id)
+	if len(r.Actions) == 0 || (r.Trigger != nil && r.Trigger.Device == id) {
+		r.Disabled = true
+	}
+}
+
+func (r *Rule) validateTrigger(devices DeviceRepo) error {
+	t := r.Trigger
+	if t.Type != TriggerEvent && len(r.Conditions) > 0 {
+		return invalid("only event triggers have conditions")
+	}
+	switch t.Type {
+	case TriggerEvent:
+		dev, err := getDevice(devices, t.Device)
+		if err != nil {
+			return err
+		}
+		t.Device = dev.ID.String()
+		s := dev.Service(t.Service)
+		if s == nil || !s.Inbound {
+			return invalid("device %v has no inbound service %v", dev.Name, t.Service)
+		}
+		types, err := dev.ParamTypes(s)
+		if err != nil {
+			return invalid("service %v cannot be resolved : %v", t.Service, err)
+		}
+		for _, c := range r.Conditions {
+			err := validateCondition(c, types)
+			if err != nil {
+				return err
+			}
+		}
+	case TriggerStatus:
+		if t.Device != "" {
+			dev, err := getDevice(devices, t.Device)
+			if err != nil {
+				return err
+			}
+			t.Device = dev.ID.String()
+		}
+		if t.Status != "" && t.Status != string(device.StatusOnline) && t.Status != string(device.StatusOffline) {
+			return invalid("status must be %v or %v", device.StatusOnline, device.StatusOffline)
+		}
+	case TriggerSchedule:
+		if (t.At == "") == (t.Every == "") {
+			return invalid("a schedule has either at or every")
+		}
+		if t.At != "" {
+			if _, err := parseClock(t.At); err != nil {
+				return err
+			}
+		}
+		if t.Every != "" {
+			every, err := time.ParseDuration(t.Every)
+			if err != nil || every < minEvery {
+				return invalid("every must be a duration of at least %v", minEvery)
+			}
+		}
+	default:
+		return invalid("unknown trigger type %v", t.Type)
+	}
+	return nil
+}
+
+func isNumber(scalar string) bool {
+	switch scalar {
+	case "bool", "string", "bytes":
+		return false
+	}
+	return true
+}
+
+func validateCondition(c *Condition, types map[string]string) error {
+	scalar, ok := types[c.Field]
+	if !ok {
+		return invalid("unknown field %v", c.Field)
+	}
+	switch c.Op {
+	case OpEqual, OpNotEqual:
+	case OpGreater, OpGreaterOrEqual, OpLess, OpLessOrEqual:
+		if !isNumber(scalar) {
+			return invalid("%v only compares numbers, %v is %v", c.Op, c.Field, scalar)
+		}
+	default:
+		return invalid("unknown operator %v", c.Op)
+	}
+	if !device.ValidateScalarJSON(scalar, c.Value) {
+		return invalid("value of %v must be %v", c.Field, scalar)
+	}
+	return nil
+}
@@ -0,0 +1,369 @@
+package rule
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/action"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryDevices map[string]*device.Device
+
+func (m memoryDevices) Get(id interface{}) (*device.Device, error) {
+	dev, ok := m[id.(string)]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return dev, nil
+}
+
+func newDevice(t *testing.T, name string, addr net.Addr, s string) *device.Device {
+	dev, err := device.NewDevice(name, addr, []byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dev
+}
+
+func testDevices(t *testing.T) (memoryDevices, *device.Device, *device.Device) {
+	addr := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80}
+	door := newDevice(t, "Door", addr, `
+		message Battery{int32 Level;};
+		message DoorState{bool Open; string Who; optional Battery Battery;};
+		def inbound opened(DoorState);
+		def outbound lock();
+	`)
+	lamp := newDevice(t, "Lamp", addr, `
+		def inbound pressed();
+		def outbound on(int32);
+	`)
+	return memoryDevices{door.ID.String(): door, lamp.ID.String(): lamp}, door, lamp
+}
+
+func TestRule_Validate(t *testing.T) {
+	devices, door, lamp := testDevices(t)
+	onAction := []*action.Action{{Device: lamp.ID.String(), Service: "on", Params: map[string]string{"arg0": "80"}}}
+	tests := []struct {
+		name    string
+		rule    *Rule
+		wantErr bool
+	}{
+		{
+			name: "Event with conditions",
+			rule: &Rule{Name: "door", Definition: Definition{
+				Trigger: &Trigger{Type: TriggerEvent, Device: door.ID.String(), Service: "opened"},
+				Conditions: []*Condition{
+					{Field: "Open", Op: OpEqual, Value: true},
+					{Field: "Battery.Level", Op: OpLess, Value: json.Number("20")},
+				},
+				Window:  &Window{After: "22:00", Before: "06:00"},
+				Actions: onAction,
+			}},
+		},
+		{
+			name: "Status of any device",
+			rule: &Rule{Name: "offline", Definition: Definition{
+				Trigger: &Trigger{Type: TriggerStatus, Status: "offline"},
+				Actions: onAction,
+			}},
+		},
+		{
+			name: "Schedule",
+			rule: &Rule{Name: "night", Definition: Definition{
+				Trigger: &Trigger{Type: TriggerSchedule, At: "22:30"},
+				Actions: []*action.Action{{Device: door.ID.String(), Service: "lock"}},
+			}},
+		},
+		{
+			name: "No name",
+			rule: &Rule{Definition: Definition{
+				Trigger: &Trigger{Type: TriggerSchedule, Every: "1h"},
+				Actions: onAction,
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Outbound service as event",
+			rule: &Rule{Name: "door", Definition: Definition{
+				Trigger: &Trigger{Type: TriggerEvent, Device: door.ID.String(), Service: "lock"},
+				Actions: onAction,
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Unknown condition field",
+			rule: &Rule{Name: "door", Definition: Definition{
+				Trigger:    &Trigger{Type: TriggerEvent, Device: door.ID.String(), Service: "opened"},
+				Conditions: []*Condition{{Field: "Closed", Op: OpEqual, Value: true}},
+				Actions:    onAction,
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Ordering a string",
+			rule: &Rule{Name: "door", Definition: Definition{
+				Trigger:    &Trigger{Type: TriggerEvent, Device: door.ID.String(), Service: "opened"},
+				Conditions: []*Condition{{Field: "Who", Op: OpGreater, Value: "a"}},
+				Actions:    onAction,
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Condition value of another type",
+			rule: &Rule{Name: "door", Definition: Definition{
+				Trigger:    &Trigger{Type: TriggerEvent, Device: door.ID.String(), Service: "opened"},
+				Conditions: []*Condition{{Field: "Open", Op: OpEqual, Value: "yes"}},
+				Actions:    onAction,
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Conditions on a schedule",
+			rule: &Rule{Name: "night", Definition: Definition{
+				Trigger:    &Trigger{Type: TriggerSchedule, At: "22:30"},
+				Conditions: []*Condition{{Field: "Open", Op: OpEqual, Value: true}},
+				Actions:    onAction,
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Schedule too often",
+			rule: &Rule{Name: "night", Definition: Definition{
+				Trigger: &Trigger{Type: TriggerSchedule, Every: "1s"},
+				Actions: onAction,
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Invalid window",
+			rule: &Rule{Name: "night", Definition: Definition{
+				Trigger: &Trigger{Type: TriggerSchedule, At: "22:30"},
+				Window:  &Window{After: "25:00"},
+				Actions: onAction,
+			}},
+			wantErr: true,
+		},
+		{
+			name: "No actions",
+			rule: &Rule{Name: "night", Definition: Definition{
+				Trigger: &Trigger{Type: TriggerSchedule, At: "22:30"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Action with missing params",
+			rule: &Rule{Name: "night", Definition: Definition{
+				Trigger: &Trigger{Type: TriggerSchedule, At: "22:30"},
+				Actions: []*action.Action{{Device: lamp.ID.String(), Service: "on"}},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "Action on unknown device",
+			rule: &Rule{Name: "night", Definition: Definition{
+				Trigger: &Trigger{Type: TriggerSchedule, At: "22:30"},
+				Actions: []*action.Action{{Device: "00000000-0000-0000-0000-000000000000", Service: "on"}},
+			}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.rule.Validate(devices)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrInvalid), err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestWindow_Contains(t *testing.T) {
+	at := func(clock string) time.Time {
+		c, err := time.Parse(clockLayout, clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Date(2021, 1, 1, c.Hour(), c.Minute(), 0, 0, time.Local)
+	}
+	tests := []struct {
+		name   string
+		window *Window
+		in     []string
+		out    []string
+	}{
+		{
+			name: "No window",
+			in:   []string{"00:00", "12:00"},
+		},
+		{
+			name:   "Same day",
+			window: &Window{After: "08:00", Before: "17:00"},
+			in:     []string{"08:00", "16:59"},
+			out:    []string{"07:59", "17:00"},
+		},
+		{
+			name:   "Past midnight",
+			window: &Window{After: "22:00", Before: "06:00"},
+			in:     []string{"22:00", "23:59", "00:00", "05:59"},
+			out:    []string{"21:59", "06:00", "12:00"},
+		},
+		{
+			name:   "Until midnight",
+			window: &Window{After: "22:00"},
+			in:     []string{"22:00", "23:59"},
+			out:    []string{"00:00", "21:59"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, clock := range tt.in {
+				assert.True(t, tt.window.Contains(at(clock)), clock)
+			}
+			for _, clock := range tt.out {
+				assert.False(t, tt.window.Contains(at(clock)), clock)
+			}
+		})
+	}
+}
+
+func TestTrigger_Due(t *testing.T) {
+	day := time.Date(2021, 1, 1, 0, 0, 0, 0, time.Local)
+	tests := []struct {
+		name    string
+		trigger *Trigger
+		from    time.Duration
+		to      time.Duration
+		want    bool
+	}{
+		{
+			name:    "At in range",
+			trigger: &Trigger{Type: TriggerSchedule, At: "22:30"},
+			from:    22*time.Hour + 29*time.Minute + 55*time.Second,
+			to:      22*time.Hour + 30*time.Minute + 5*time.Second,
+			want:    true,
+		},
+		{
+			name:    "At just fired",
+			trigger: &Trigger{Type: TriggerSchedule, At: "22:30"},
+			from:    22*time.Hour + 30*time.Minute,
+			to:      22*time.Hour + 30*time.Minute + 10*time.Second,
+		},
+		{
+			name:    "At past midnight",
+			trigger: &Trigger{Type: TriggerSchedule, At: "00:00"},
+			from:    23*time.Hour + 59*time.Minute + 55*time.Second,
+			to:      24*time.Hour + 5*time.Second,
+			want:    true,
+		},
+		{
+			name:    "Every crossing an interval",
+			trigger: &Trigger{Type: TriggerSchedule, Every: "15m"},
+			from:    14*time.Minute + 55*time.Second,
+			to:      15*time.Minute + 5*time.Second,
+			want:    true,
+		},
+		{
+			name:    "Every inside an interval",
+			trigger: &Trigger{Type: TriggerSchedule, Every: "15m"},
+			from:    15*time.Minute + 5*time.Second,
+			to:      15*time.Minute + 15*time.Second,
+		},
+		{
+			name:    "Not a schedule",
+			trigger: &Trigger{Type: TriggerEvent},
+			from:    0,
+			to:      24 * time.Hour,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.trigger.Due(day.Add(tt.from), day.Add(tt.to)))
+		})
+	}
+}
+
+func TestDefinition_HoldsOn(t *testing.T) {
+	d := &Definition{Conditions: []*Condition{
+		{Field: "Open", Op: OpEqual, Value: true},
+		{Field: "Battery.Level", Op: OpGreaterOrEqual, Value: json.Number("20")},
+		{Field: "Who", Op: OpNotEqual, Value: "cat"},
+	}}
+	tests := []struct {
+		name    string
+		payload string
+		want    bool
+	}{
+		{
+			name:    "Every condition holds",
+			payload: `{"Open":true,"Who":"me","Battery":{"Level":20}}`,
+			want:    true,
+		},
+		{
+			name:    "A condition does not hold",
+			payload: `{"Open":true,"Who":"cat","Battery":{"Level":80}}`,
+		},
+		{
+			name:    "Number does not hold",
+			payload: `{"Open":true,"Who":"me","Battery":{"Level":19.5}}`,
+		},
+		{
+			name:    "Missing field",
+			payload: `{"Open":true,"Who":"me"}`,
+		},
+		{
+			name:    "Not an object",
+			payload: `[]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, d.HoldsOn(json.RawMessage(tt.payload)))
+		})
+	}
+}
+
+func TestRule_Devices(t *testing.T) {
+	lamp, kettle := uuid.New().String(), uuid.New().String()
+	r := &Rule{Definition: Definition{
+		Trigger: &Trigger{Type: TriggerEvent, Device: kettle, Service: "boiled"},
+		Actions: []*action.Action{{Device: lamp, Service: "on"}, {Device: kettle, Service: "off"}, {Device: lamp, Service: "off"}},
+	}}
+	assert.Equal(t, []string{lamp, kettle}, r.Devices())
+
+	r.Trigger = &Trigger{Type: TriggerStatus}
+	assert.Equal(t, []string{lamp, kettle}, r.Devices())
+	r.Trigger = &Trigger{Type: TriggerStatus, Device: uuid.New().String()}
+	assert.Equal(t, []string{r.Trigger.Device, lamp, kettle}, r.Devices())
+}
+
+func TestRule_RemoveDevice(t *testing.T) {
+	lamp, kettle := uuid.New().String(), uuid.New().String()
+	on, off := &action.Action{Device: lamp, Service: "on"}, &action.Action{Device: kettle, Service: "off"}
+	r := &Rule{Definition: Definition{
+		Trigger: &Trigger{Type: TriggerSchedule, At: "07:00"},
+		Actions: []*action.Action{on, off},
+	}}
+	r.RemoveDevice(kettle)
+	assert.Equal(t, []*action.Action{on}, r.Actions)
+	assert.False(t, r.Disabled, "a rule keeps firing with the actions left")
+	r.RemoveDevice(lamp)
+	assert.Equal(t, []*action.Action{}, r.Actions)
+	assert.True(t, r.Disabled, "a rule without actions is disabled")
+
+	r = &Rule{Definition: Definition{
+		Trigger: &Trigger{Type: TriggerEvent, Device: kettle, Service: "boiled"},
+		Actions: []*action.Action{on},
+	}}
+	r.RemoveDevice(kettle)
+	assert.Equal(t, []*action.Action{on}, r.Actions)
+	assert.True(t, r.Disabled, "a rule triggered by a deleted device is disabled")
+}
@@ -0,0 +1,159 @@
+package scene
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/IktaS/go-home/internal/pkg/action"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/google/uuid"
+)
+
+// ErrInvalid is the error of a scene that cannot be activated
+var ErrInvalid = errors.New("invalid scene")
+
+// ErrCanceled is the error of a step that did not finish because another step failed
+var ErrCanceled = errors.New("canceled after a failed step")
+
+// Mode defines what a scene does when a step fails
+type Mode string
+
+const (
+	// ModeBestEffort calls every step whatever the others do
+	ModeBestEffort Mode = "best-effort"
+	// ModeStopOnFailure cancels the steps still running when a step fails
+	ModeStopOnFailure Mode = "stop-on-failure"
+)
+
+/*
+Scene defines the JSON schema of service calls activated together :
+	ID		`id`	: Scene UUID
+	Name	`name`	: Scene name
+	Mode	`mode`	: best-effort or stop-on-failure, best-effort if empty
+	Steps	`steps`	: Outbound service calls, see action.Action
+*/
+type Scene struct {
+	ID    uuid.UUID        `json:"id"`
+	Name  string           `json:"name"`
+	Mode  Mode             `json:"mode"`
+	Steps []*action.Action `json:"steps"`
+}
+
+/*
+StepResult defines the JSON schema of the result of a step :
+	Step		`step`		: Position of the step in the scene
+	Device		`device`	: UUID of the device
+	Service		`service`	: Service called
+	OK			`ok`		: Whether the device answered the call successfully
+	Response	`response`	: Device answer, omitted if empty
+	Error		`error`		: Why the call failed, omitted if it succeeded
+*/
+type StepResult struct {
+	Step     int    `json:"step"`
+	Device   string `json:"device"`
+	Service  string `json:"service"`
+	OK       bool   `json:"ok"`
+	Response string `json:"response,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Repo is an interface that defines what a scene repository should have
+type Repo interface {
+	// SaveScene saves a scene, replacing the scene with the same ID
+	SaveScene(*Scene) error
+	// GetScene gets a scene by its ID, returns sql.ErrNoRows if it does not exist
+	GetScene(id string) (*Scene, error)
+	GetScenes() ([]*Scene, error)
+	// DeleteScene deletes a scene by its ID, returns sql.ErrNoRows if it does not exist
+	DeleteScene(id string) error
+}
+
+func invalid(format string, a ...interface{}) error {
+	return fmt.Errorf("%w : %v", ErrInvalid, fmt.Sprintf(format, a...))
+}
+
+// Validate checks a scene against the devices it calls, and normalizes its mode and device UUIDs,
+// an error wrapping ErrInvalid is returned when the scene cannot be activated
+func (s *Scene) Validate(devices action.DeviceRepo) error {
+	if s.Name == "" {
+		return invalid("no name")
+	}
+	switch s.Mode {
+	case "":
+		s.Mode = ModeBestEffort
+	case ModeBestEffort, ModeStopOnFailure:
+	default:
+		return invalid("unknown mode %v", s.Mode)
+	}
+	if len(s.Steps) == 0 {
+		return invalid("no steps")
+	}
+	for i, a := range s.Steps {
+		err := a.Validate(devices)
+		if errors.Is(err, action.ErrInvalid) {
+			return fmt.Errorf("%w : step %v : %v", ErrInvalid, i, err)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Devices gets the UUIDs of the devices the scene calls, each once
+func (s *Scene) Devices() []string {
+	return action.Devices(s.Steps)
+}
+
+// RemoveDevice removes the steps calling a deleted device, a scene left without steps is kept so it shows what it lost
+func (s *Scene) RemoveDevice(id string) {
+	s.Steps = action.Without(s.Steps, id)
+}
+
+// Activate calls every step of the scene concurrently, and returns their results in the scene order
+func (s *Scene) Activate(ctx context.Context, devices action.DeviceRepo, opts device.CallOptions) []*StepResult {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make([]*StepResult, len(s.Steps))
+	var mu sync.Mutex
+	failed := false
+	var wg sync.WaitGroup
+	for i, a := range s.Steps {
+		wg.Add(1)
+		go func(i int, a *action.Action) {
+			defer wg.Done()
+			res := &StepResult{Step: i, Device: a.Device, Service: a.Service, OK: true}
+			answer, err := a.Call(ctx, devices, opts)
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				res.OK = false
+				res.Error = err.Error()
+				if failed {
+					// the step was stopped by the cancel of another step
+					res.Error = ErrCanceled.Error()
+				} else if s.Mode == ModeStopOnFailure {
+					failed = true
+					cancel()
+				}
+			} else {
+				res.Response = string(answer)
+			}
+			results[i] = res
+		}(i, a)
+	}
+	wg.Wait()
+	return results
+}
+
+// Succeeded tells whether every step succeeded
+func Succeeded(results []*StepResult) bool {
+	for _, r := range results {
+		if !r.OK {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,146 @@
+package scene
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/action"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryDevices map[string]*device.Device
+
+func (m memoryDevices) Get(id interface{}) (*device.Device, error) {
+	dev, ok := m[id.(string)]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return dev, nil
+}
+
+func testDevices(t *testing.T, addr net.Addr) (memoryDevices, *device.Device, *device.Device) {
+	lamp, err := device.NewDevice("Lamp", addr, []byte(`def outbound on(int32):string; def outbound slow();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tv, err := device.NewDevice("TV", addr, []byte(`def outbound fail(); def inbound pressed();`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return memoryDevices{lamp.ID.String(): lamp, tv.ID.String(): tv}, lamp, tv
+}
+
+func TestScene_Validate(t *testing.T) {
+	devices, lamp, tv := testDevices(t, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80})
+	on := &action.Action{Device: lamp.ID.String(), Service: "on", Params: map[string]string{"arg0": "20"}}
+	tests := []struct {
+		name     string
+		scene    *Scene
+		wantMode Mode
+		wantErr  bool
+	}{
+		{
+			name:     "Default mode",
+			scene:    &Scene{Name: "movie", Steps: []*action.Action{on}},
+			wantMode: ModeBestEffort,
+		},
+		{
+			name:     "Stop on failure",
+			scene:    &Scene{Name: "movie", Mode: ModeStopOnFailure, Steps: []*action.Action{on}},
+			wantMode: ModeStopOnFailure,
+		},
+		{
+			name:    "No name",
+			scene:   &Scene{Steps: []*action.Action{on}},
+			wantErr: true,
+		},
+		{
+			name:    "Unknown mode",
+			scene:   &Scene{Name: "movie", Mode: "sometimes", Steps: []*action.Action{on}},
+			wantErr: true,
+		},
+		{
+			name:    "No steps",
+			scene:   &Scene{Name: "movie"},
+			wantErr: true,
+		},
+		{
+			name:    "Inbound service",
+			scene:   &Scene{Name: "movie", Steps: []*action.Action{on, {Device: tv.ID.String(), Service: "pressed"}}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.scene.Validate(devices)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrInvalid), err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantMode, tt.scene.Mode)
+		})
+	}
+}
+
+func TestScene_RemoveDevice(t *testing.T) {
+	on, off := &action.Action{Device: "lamp", Service: "on"}, &action.Action{Device: "kettle", Service: "off"}
+	s := &Scene{Name: "morning", Steps: []*action.Action{on, off, on}}
+	s.RemoveDevice("lamp")
+	assert.Equal(t, []*action.Action{off}, s.Steps)
+	s.RemoveDevice("kettle")
+	assert.Equal(t, []*action.Action{}, s.Steps, "a scene without steps is kept")
+}
+
+func TestScene_Activate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/on":
+			w.Write([]byte("on"))
+		case "/fail":
+			w.WriteHeader(http.StatusInternalServerError)
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(500 * time.Millisecond):
+			}
+		}
+	}))
+	defer server.Close()
+	addr, err := net.ResolveTCPAddr("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, lamp, tv := testDevices(t, addr)
+	steps := []*action.Action{
+		{Device: lamp.ID.String(), Service: "slow"},
+		{Device: tv.ID.String(), Service: "fail"},
+		{Device: lamp.ID.String(), Service: "on", Params: map[string]string{"arg0": "20"}},
+	}
+	opts := device.CallOptions{Timeout: time.Second}
+
+	s := &Scene{Name: "movie", Mode: ModeBestEffort, Steps: steps}
+	results := s.Activate(context.Background(), devices, opts)
+	assert.False(t, Succeeded(results))
+	assert.Len(t, results, 3)
+	assert.Equal(t, &StepResult{Step: 0, Device: lamp.ID.String(), Service: "slow", OK: true}, results[0], "a best effort scene waits for every step")
+	assert.False(t, results[1].OK)
+	assert.NotEmpty(t, results[1].Error)
+	assert.Equal(t, &StepResult{Step: 2, Device: lamp.ID.String(), Service: "on", OK: true, Response: "on"}, results[2])
+
+	s.Mode = ModeStopOnFailure
+	results = s.Activate(context.Background(), devices, opts)
+	assert.False(t, results[0].OK)
+	assert.Equal(t, ErrCanceled.Error(), results[0].Error, "the failure cancels the slow step")
+	assert.False(t, results[1].OK)
+
+	s.Steps = steps[2:]
+	assert.True(t, Succeeded(s.Activate(context.Background(), devices, opts)))
+}
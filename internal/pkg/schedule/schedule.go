@@ -0,0 +1,177 @@
+package schedule
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/action"
+	"github.com/google/uuid"
+	"github.com/robfig/cron/v3"
+)
+
+// ErrInvalid is the error of a schedule that cannot be run
+var ErrInvalid = errors.New("invalid schedule")
+
+/*
+Result defines the JSON schema of the last run of a schedule :
+	At		`at`	: Time the schedule ran
+	OK		`ok`	: Whether the device answered the call successfully
+	Error	`error`	: Why the call failed, empty when it succeeded
+*/
+type Result struct {
+	At    time.Time `json:"at"`
+	OK    bool      `json:"ok"`
+	Error string    `json:"error,omitempty"`
+}
+
+/*
+Schedule defines the JSON schema of a service call the hub makes on a cron expression or once at a time :
+	ID			`id`		: Schedule UUID
+	Name		`name`		: Schedule name
+	Disabled	`disabled`	: A disabled schedule never runs
+	Cron		`cron`		: Standard 5 field cron expression in the hub time zone, e.g. 0 23 * * *
+	At			`at`		: RFC 3339 time of a schedule that runs once, instead of cron
+	Device		`device`	: See action.Action
+	Service		`service`	: See action.Action
+	Params		`params`	: See action.Action
+	LastRun		`lastRun`	: Result of the last run, null if it never ran
+*/
+type Schedule struct {
+	ID       uuid.UUID  `json:"id"`
+	Name     string     `json:"name"`
+	Disabled bool       `json:"disabled"`
+	Cron     string     `json:"cron,omitempty"`
+	At       *time.Time `json:"at,omitempty"`
+	action.Action
+	LastRun *Result `json:"lastRun"`
+}
+
+// Repo is an interface that defines what a schedule repository should have
+type Repo interface {
+	// SaveSchedule saves a schedule, replacing the schedule with the same ID
+	SaveSchedule(*Schedule) error
+	// GetSchedule gets a schedule by its ID, returns sql.ErrNoRows if it does not exist
+	GetSchedule(id string) (*Schedule, error)
+	GetSchedules() ([]*Schedule, error)
+	// DeleteSchedule deletes a schedule by its ID, returns sql.ErrNoRows if it does not exist
+	DeleteSchedule(id string) error
+	// SetScheduleResult sets the last run of a schedule, returns sql.ErrNoRows if it does not exist
+	SetScheduleResult(id string, r *Result) error
+}
+
+func invalid(format string, a ...interface{}) error {
+	return fmt.Errorf("%w : %v", ErrInvalid, fmt.Sprintf(format, a...))
+}
+
+// parseCron parses a standard 5 field cron expression
+func parseCron(expr string) (cron.Schedule, error) {
+	return cron.ParseStandard(expr)
+}
+
+// ValidateTiming checks when a schedule runs, an error wrapping ErrInvalid is returned when it cannot be known,
+// or when it runs once at a time that has passed as it would never run
+func (s *Schedule) ValidateTiming() error {
+	return s.validateTiming(nil)
+}
+
+// validateTiming checks when a schedule runs, a passed at time is kept when it is the at time of the schedule it replaces
+func (s *Schedule) validateTiming(old *Schedule) error {
+	if (s.Cron == "") == (s.At == nil) {
+		return invalid("a schedule has either cron or at")
+	}
+	if s.At != nil && !s.At.After(time.Now()) && (old == nil || old.At == nil || !old.At.Equal(*s.At)) {
+		return invalid("%v has passed", s.At.Format(time.RFC3339))
+	}
+	if s.Cron != "" {
+		if _, err := parseCron(s.Cron); err != nil {
+			return invalid("%v is not a cron expression : %v", s.Cron, err)
+		}
+	}
+	return nil
+}
+
+// Validate checks a schedule against the device it calls, and normalizes the device UUID,
+// an error wrapping ErrInvalid is returned when the schedule cannot be run
+func (s *Schedule) Validate(devices action.DeviceRepo) error {
+	return s.validate(devices, nil)
+}
+
+// ValidateChange checks a schedule that replaces old like Validate, but keeps an at time that has passed if it did not change,
+// so a schedule that ran once can still be renamed or disabled
+func (s *Schedule) ValidateChange(old *Schedule, devices action.DeviceRepo) error {
+	return s.validate(devices, old)
+}
+
+func (s *Schedule) validate(devices action.DeviceRepo, old *Schedule) error {
+	if s.Name == "" {
+		return invalid("no name")
+	}
+	err := s.validateTiming(old)
+	if err != nil {
+		return err
+	}
+	err = s.Action.Validate(devices)
+	if errors.Is(err, action.ErrInvalid) {
+		return fmt.Errorf("%w : %v", ErrInvalid, err)
+	}
+	return err
+}
+
+// SameTiming tells whether the schedule runs at the same times as o
+func (s *Schedule) SameTiming(o *Schedule) bool {
+	if s.Cron != o.Cron || (s.At == nil) != (o.At == nil) {
+		return false
+	}
+	return s.At == nil || s.At.Equal(*o.At)
+}
+
+// Next gets up to n times the schedule runs after a time, a schedule that ran once has no next time
+func (s *Schedule) Next(after time.Time, n int) []time.Time {
+	times := []time.Time{}
+	if s.At != nil {
+		if s.At.After(after) && n > 0 {
+			times = append(times, *s.At)
+		}
+		return times
+	}
+	c, err := parseCron(s.Cron)
+	if err != nil {
+		return times
+	}
+	t := after
+	for len(times) < n {
+		t = c.Next(t)
+		if t.IsZero() {
+			break
+		}
+		times = append(times, t)
+	}
+	return times
+}
+
+// Missed tells whether the schedule was due while it could not run, after its last run and at most grace before now,
+// as a run that is too late may do more harm than good, a schedule due several times is still missed once,
+// a schedule that runs once and never ran is missed once its time has passed, a cron schedule that never ran is not
+func (s *Schedule) Missed(now time.Time, grace time.Duration) bool {
+	if s.Disabled || grace <= 0 {
+		return false
+	}
+	from := now.Add(-grace)
+	if s.At != nil {
+		return s.LastRun == nil && s.At.After(from) && !s.At.After(now)
+	}
+	if s.LastRun == nil {
+		return false
+	}
+	if s.LastRun.At.After(from) {
+		from = s.LastRun.At
+	}
+	return s.Due(from, now)
+}
+
+// Due tells whether the schedule runs after from until to
+func (s *Schedule) Due(from time.Time, to time.Time) bool {
+	next := s.Next(from, 1)
+	return !s.Disabled && len(next) > 0 && !next[0].After(to)
+}
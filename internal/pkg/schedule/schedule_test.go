@@ -0,0 +1,218 @@
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/action"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryDevices map[string]*device.Device
+
+func (m memoryDevices) Get(id interface{}) (*device.Device, error) {
+	dev, ok := m[id.(string)]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return dev, nil
+}
+
+type memorySchedules map[string]*Schedule
+
+func (m memorySchedules) SaveSchedule(s *Schedule) error               { m[s.ID.String()] = s; return nil }
+func (m memorySchedules) GetSchedule(id string) (*Schedule, error)     { return m[id], nil }
+func (m memorySchedules) DeleteSchedule(id string) error               { delete(m, id); return nil }
+func (m memorySchedules) SetScheduleResult(id string, r *Result) error { return nil }
+func (m memorySchedules) GetSchedules() ([]*Schedule, error) {
+	var res []*Schedule
+	for _, s := range m {
+		res = append(res, s)
+	}
+	return res, nil
+}
+
+func testLamp(t *testing.T, addr net.Addr) (memoryDevices, *device.Device) {
+	lamp, err := device.NewDevice("Lamp", addr, []byte(`def inbound pressed(); def outbound on(int32);`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return memoryDevices{lamp.ID.String(): lamp}, lamp
+}
+
+func TestSchedule_Validate(t *testing.T) {
+	devices, lamp := testLamp(t, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80})
+	at := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Minute)
+	on := action.Action{Device: lamp.ID.String(), Service: "on", Params: map[string]string{"arg0": "80"}}
+	tests := []struct {
+		name     string
+		schedule *Schedule
+		wantErr  bool
+	}{
+		{
+			name:     "Cron",
+			schedule: &Schedule{Name: "night", Cron: "0 23 * * 1-5", Action: on},
+		},
+		{
+			name:     "Once",
+			schedule: &Schedule{Name: "once", At: &at, Action: on},
+		},
+		{
+			name:     "Once in the past",
+			schedule: &Schedule{Name: "once", At: &past, Action: on},
+			wantErr:  true,
+		},
+		{
+			name:     "No name",
+			schedule: &Schedule{Cron: "0 23 * * *", Action: on},
+			wantErr:  true,
+		},
+		{
+			name:     "Neither cron nor at",
+			schedule: &Schedule{Name: "never", Action: on},
+			wantErr:  true,
+		},
+		{
+			name:     "Both cron and at",
+			schedule: &Schedule{Name: "both", Cron: "0 23 * * *", At: &at, Action: on},
+			wantErr:  true,
+		},
+		{
+			name:     "Invalid cron",
+			schedule: &Schedule{Name: "night", Cron: "0 25 * * *", Action: on},
+			wantErr:  true,
+		},
+		{
+			name:     "Inbound service",
+			schedule: &Schedule{Name: "night", Cron: "0 23 * * *", Action: action.Action{Device: lamp.ID.String(), Service: "pressed"}},
+			wantErr:  true,
+		},
+		{
+			name:     "Invalid parameter",
+			schedule: &Schedule{Name: "night", Cron: "0 23 * * *", Action: action.Action{Device: lamp.ID.String(), Service: "on", Params: map[string]string{"arg0": "bright"}}},
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.schedule.Validate(devices)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrInvalid), err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestSchedule_Next(t *testing.T) {
+	now := time.Date(2021, 1, 1, 22, 30, 0, 0, time.Local)
+	later := now.Add(time.Hour)
+	nightly := &Schedule{Cron: "0 23 * * *"}
+	assert.Equal(t, []time.Time{
+		time.Date(2021, 1, 1, 23, 0, 0, 0, time.Local),
+		time.Date(2021, 1, 2, 23, 0, 0, 0, time.Local),
+		time.Date(2021, 1, 3, 23, 0, 0, 0, time.Local),
+	}, nightly.Next(now, 3))
+	assert.True(t, nightly.Due(now, later))
+	assert.False(t, nightly.Due(now, now.Add(time.Minute)))
+
+	once := &Schedule{At: &later}
+	assert.Equal(t, []time.Time{later}, once.Next(now, 5))
+	assert.Empty(t, once.Next(later, 5), "a schedule that ran once has no next time")
+	assert.True(t, once.Due(now, later))
+
+	grace := time.Hour
+	assert.False(t, once.Missed(now, grace))
+	assert.True(t, once.Missed(later, grace), "a schedule that never ran is missed once its time passed")
+	assert.False(t, once.Missed(later.Add(2*time.Hour), grace), "a schedule is not missed later than the grace")
+	assert.False(t, once.Missed(later, 0), "nothing is missed without a grace")
+	once.LastRun = &Result{At: later, OK: true}
+	assert.False(t, once.Missed(later.Add(time.Minute), grace), "a schedule that ran is not missed")
+
+	assert.False(t, nightly.Missed(later, grace), "a cron schedule that never ran is not missed")
+	nightly.LastRun = &Result{At: now.Add(-24 * time.Hour)}
+	assert.True(t, nightly.Missed(later, grace), "the run of the day is missed within the grace")
+	assert.False(t, nightly.Missed(now, grace), "the run of the day before is later than the grace")
+	nightly.LastRun = &Result{At: now.Add(-7 * 24 * time.Hour)}
+	assert.True(t, nightly.Missed(later, 7*24*time.Hour), "a schedule due several times is missed")
+	nightly.LastRun = &Result{At: later.Add(-time.Minute)}
+	assert.False(t, nightly.Missed(later, grace), "a schedule that ran since is not missed")
+
+	once.Disabled = true
+	assert.False(t, once.Due(now, later))
+	once.LastRun = nil
+	assert.False(t, once.Missed(later, grace))
+}
+
+func TestScheduler_Execute(t *testing.T) {
+	var calls []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, r.URL.String())
+		if r.URL.Query().Get("arg0") == "0" {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+	addr, err := net.ResolveTCPAddr("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	devices, lamp := testLamp(t, addr)
+	on := &Schedule{ID: uuid.New(), Name: "on", Cron: "* * * * *", Action: action.Action{Device: lamp.ID.String(), Service: "on", Params: map[string]string{"arg0": "80"}}}
+	off := &Schedule{ID: uuid.New(), Name: "off", Cron: "* * * * *", Action: action.Action{Device: lamp.ID.String(), Service: "on", Params: map[string]string{"arg0": "0"}}}
+	s := NewScheduler(memorySchedules{on.ID.String(): on, off.ID.String(): off}, devices, device.CallOptions{Timeout: time.Second})
+
+	res := s.Execute(context.Background(), on)
+	assert.True(t, res.OK)
+	assert.Equal(t, res, on.LastRun)
+	res = s.Execute(context.Background(), off)
+	assert.False(t, res.OK)
+	assert.NotEmpty(t, res.Error)
+	assert.Equal(t, []string{"/on?arg0=80", "/on?arg0=0"}, calls)
+
+	now := time.Now()
+	due, err := s.Due(now, now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, due, 2)
+
+	missed, err := s.Missed(now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, missed, 2, "both ran before now")
+	s.MissedGrace = 0
+	missed, err = s.Missed(now.Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Empty(t, missed)
+}
+
+func TestSchedule_ValidateChange(t *testing.T) {
+	devices, lamp := testLamp(t, &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 80})
+	on := action.Action{Device: lamp.ID.String(), Service: "on", Params: map[string]string{"arg0": "80"}}
+	past := time.Now().Add(-time.Hour)
+	earlier := past.Add(-time.Hour)
+	old := &Schedule{Name: "once", At: &past, Action: on}
+
+	renamed := &Schedule{Name: "renamed", Disabled: true, At: &past, Action: on}
+	assert.NoError(t, renamed.ValidateChange(old, devices), "an at time that did not change is kept")
+	assert.True(t, renamed.SameTiming(old))
+	assert.True(t, errors.Is(renamed.Validate(devices), ErrInvalid), "a new schedule cannot run in the past")
+
+	moved := &Schedule{Name: "once", At: &earlier, Action: on}
+	assert.True(t, errors.Is(moved.ValidateChange(old, devices), ErrInvalid), "an at time that changed to the past is refused")
+	assert.False(t, moved.SameTiming(old))
+
+	nightly := &Schedule{Name: "nightly", Cron: "0 23 * * *", Action: on}
+	assert.NoError(t, nightly.ValidateChange(old, devices))
+	assert.False(t, nightly.SameTiming(old))
+	assert.True(t, nightly.SameTiming(&Schedule{Cron: "0 23 * * *"}))
+	assert.False(t, nightly.SameTiming(&Schedule{Cron: "0 22 * * *"}))
+}
@@ -0,0 +1,116 @@
+package schedule
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/IktaS/go-home/internal/pkg/action"
+	"github.com/IktaS/go-home/internal/pkg/device"
+	"github.com/IktaS/go-home/internal/pkg/logging"
+)
+
+// DefaultTick is how often the Scheduler checks for due schedules, cron expressions are to the minute
+const DefaultTick = 10 * time.Second
+
+// DefaultMissedGrace is how late a schedule missed while the hub was down still runs
+const DefaultMissedGrace = 15 * time.Minute
+
+// Scheduler runs the schedules of a repository when they are due, and the schedules missed at most MissedGrace before it started
+type Scheduler struct {
+	Repo        Repo
+	Devices     action.DeviceRepo
+	CallOptions device.CallOptions
+	Tick        time.Duration
+	MissedGrace time.Duration
+}
+
+// NewScheduler makes a Scheduler that checks schedules every DefaultTick, and runs the schedules missed within DefaultMissedGrace
+func NewScheduler(repo Repo, devices action.DeviceRepo, opts device.CallOptions) *Scheduler {
+	return &Scheduler{
+		Repo:        repo,
+		Devices:     devices,
+		CallOptions: opts,
+		Tick:        DefaultTick,
+		MissedGrace: DefaultMissedGrace,
+	}
+}
+
+// Due gets the enabled schedules that run after from until to
+func (s *Scheduler) Due(from time.Time, to time.Time) ([]*Schedule, error) {
+	schedules, err := s.Repo.GetSchedules()
+	if err != nil {
+		return nil, err
+	}
+	var res []*Schedule
+	for _, sch := range schedules {
+		if sch.Due(from, to) {
+			res = append(res, sch)
+		}
+	}
+	return res, nil
+}
+
+// Execute calls the service of a schedule and keeps the result as its last run
+func (s *Scheduler) Execute(ctx context.Context, sch *Schedule) *Result {
+	res := &Result{At: time.Now(), OK: true}
+	_, err := sch.Call(ctx, s.Devices, s.CallOptions)
+	if err != nil {
+		logging.Warnf("Schedule %v (%v) cannot call %v : %v\n", sch.Name, sch.ID, sch.Service, err)
+		res.OK = false
+		res.Error = err.Error()
+	}
+	err = s.Repo.SetScheduleResult(sch.ID.String(), res)
+	if err != nil && err != sql.ErrNoRows {
+		logging.Errorf("Cannot save schedule result : %v\n", err)
+	}
+	sch.LastRun = res
+	return res
+}
+
+// Missed gets the schedules that were due while the hub was down within MissedGrace, see Schedule.Missed
+func (s *Scheduler) Missed(now time.Time) ([]*Schedule, error) {
+	schedules, err := s.Repo.GetSchedules()
+	if err != nil {
+		return nil, err
+	}
+	var res []*Schedule
+	for _, sch := range schedules {
+		if sch.Missed(now, s.MissedGrace) {
+			res = append(res, sch)
+		}
+	}
+	return res, nil
+}
+
+// Run executes the schedules missed while the hub was down once each, then the schedules when they are due until the context is done
+func (s *Scheduler) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.Tick)
+	defer ticker.Stop()
+	last := time.Now()
+	missed, err := s.Missed(last)
+	if err != nil {
+		logging.Errorf("Cannot get schedules : %v\n", err)
+	}
+	for _, sch := range missed {
+		logging.Infof("Schedule %v (%v) was missed while the hub was down, running it now\n", sch.Name, sch.ID)
+		go s.Execute(ctx, sch)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			schedules, err := s.Due(last, now)
+			if err != nil {
+				logging.Errorf("Cannot get schedules : %v\n", err)
+				continue
+			}
+			last = now
+			for _, sch := range schedules {
+				// a slow device must not delay the other schedules
+				go s.Execute(ctx, sch)
+			}
+		}
+	}
+}
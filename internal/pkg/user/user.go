@@ -0,0 +1,282 @@
+package user
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ErrInvalid is the error of a user that cannot be saved
+var ErrInvalid = errors.New("invalid user")
+
+// ErrCredentials is returned when a name and password do not match a user
+var ErrCredentials = errors.New("invalid name or password")
+
+// MinPasswordLength is the length a password has at least
+const MinPasswordLength = 8
+
+// DefaultTokenTTL is how long a login session lasts when no other duration is set
+const DefaultTokenTTL = 24 * time.Hour
+
+// tokenLength is the number of random bytes of a token
+const tokenLength = 32
+
+// Role defines what a user is allowed to do, every role is allowed what the roles below it are
+type Role string
+
+const (
+	// RoleViewer reads devices, events and automations
+	RoleViewer Role = "viewer"
+	// RoleOperator also calls device services, scenes and groups
+	RoleOperator Role = "operator"
+	// RoleAdmin also manages devices, automations and users
+	RoleAdmin Role = "admin"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer:   1,
+	RoleOperator: 2,
+	RoleAdmin:    3,
+}
+
+// Valid tells whether the role exists
+func (r Role) Valid() bool {
+	_, ok := roleRanks[r]
+	return ok
+}
+
+// Allows tells whether the role is allowed what required is
+func (r Role) Allows(required Role) bool {
+	return r.Valid() && roleRanks[r] >= roleRanks[required]
+}
+
+/*
+User defines the JSON schema of a user of the hub API :
+	ID			`id`		: User UUID
+	Name		`name`		: Name the user logs in with
+	Role		`role`		: viewer, operator or admin
+	CreatedAt	`createdAt`	: Time the user was created
+*/
+type User struct {
+	ID           uuid.UUID `json:"id"`
+	Name         string    `json:"name"`
+	Role         Role      `json:"role"`
+	PasswordHash string    `json:"-"`
+	CreatedAt    time.Time `json:"createdAt"`
+}
+
+// TokenKind tells how a token was issued
+type TokenKind string
+
+const (
+	// TokenSession is issued by a login and expires
+	TokenSession TokenKind = "session"
+	// TokenKey is an API key issued for a script or a dashboard, it never expires
+	TokenKey TokenKind = "key"
+)
+
+/*
+Token defines the JSON schema of a stored bearer token, only the hash of the token is kept :
+	Hash		`id`		: SHA-256 of the token, identifies it
+	User		`user`		: UUID of the user the token authenticates
+	Kind		`kind`		: session or key
+	Name		`name`		: Name of an API key, empty for a session
+	ExpiresAt	`expiresAt`	: Time the token expires, null if it never does
+	CreatedAt	`createdAt`	: Time the token was issued
+*/
+type Token struct {
+	Hash      string     `json:"id"`
+	User      uuid.UUID  `json:"user"`
+	Kind      TokenKind  `json:"kind"`
+	Name      string     `json:"name,omitempty"`
+	ExpiresAt *time.Time `json:"expiresAt"`
+	CreatedAt time.Time  `json:"createdAt"`
+}
+
+// Expired reports whether the token is expired at now
+func (t *Token) Expired(now time.Time) bool {
+	return t.ExpiresAt != nil && !now.Before(*t.ExpiresAt)
+}
+
+// Repo is an interface that defines what a user repository should have
+type Repo interface {
+	// SaveUser saves a user, replacing the user with the same ID
+	SaveUser(*User) error
+	// GetUser gets a user by its ID, returns sql.ErrNoRows if it does not exist
+	GetUser(id string) (*User, error)
+	// GetUserByName gets a user by its name, returns sql.ErrNoRows if it does not exist
+	GetUserByName(name string) (*User, error)
+	GetUsers() ([]*User, error)
+	// DeleteUser deletes a user and its tokens, returns sql.ErrNoRows if it does not exist
+	DeleteUser(id string) error
+	SaveToken(*Token) error
+	// GetToken gets a token by its hash, returns sql.ErrNoRows if it does not exist
+	GetToken(hash string) (*Token, error)
+	// GetUserTokens gets the tokens of a user, newest first
+	GetUserTokens(id string) ([]*Token, error)
+	// DeleteToken deletes a token by its hash, returns sql.ErrNoRows if it does not exist
+	DeleteToken(hash string) error
+}
+
+func invalid(format string, a ...interface{}) error {
+	return fmt.Errorf("%w : %v", ErrInvalid, fmt.Sprintf(format, a...))
+}
+
+// HashPassword hashes a password as it is kept in the repository
+func HashPassword(password string) (string, error) {
+	if len(password) < MinPasswordLength {
+		return "", invalid("a password has at least %v characters", MinPasswordLength)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+// CheckPassword tells whether password is the password of the user
+func (u *User) CheckPassword(password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(u.PasswordHash), []byte(password)) == nil
+}
+
+// New makes a user with a hashed password, an error wrapping ErrInvalid is returned when it cannot be saved
+func New(name string, password string, role Role) (*User, error) {
+	u := &User{ID: uuid.New(), Name: strings.TrimSpace(name), Role: role, CreatedAt: time.Now()}
+	err := u.Validate()
+	if err != nil {
+		return nil, err
+	}
+	u.PasswordHash, err = HashPassword(password)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// Validate checks the name and role of a user, an error wrapping ErrInvalid is returned when it cannot be saved
+func (u *User) Validate() error {
+	if u.Name == "" {
+		return invalid("no name")
+	}
+	if !u.Role.Valid() {
+		return invalid("unknown role %v, use viewer, operator or admin", u.Role)
+	}
+	return nil
+}
+
+// HashToken returns the hash of a token as it is kept in the repository
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// NewToken generates a token for a user and saves it to the repo, a ttl of 0 never expires
+func NewToken(repo Repo, u *User, kind TokenKind, name string, ttl time.Duration) (string, *Token, error) {
+	buf := make([]byte, tokenLength)
+	_, err := rand.Read(buf)
+	if err != nil {
+		return "", nil, err
+	}
+	token := hex.EncodeToString(buf)
+	now := time.Now()
+	t := &Token{
+		Hash:      HashToken(token),
+		User:      u.ID,
+		Kind:      kind,
+		Name:      name,
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		expiresAt := now.Add(ttl)
+		t.ExpiresAt = &expiresAt
+	}
+	err = repo.SaveToken(t)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, t, nil
+}
+
+// dummyHash is compared when there is no such user, so a login takes as long whether the name exists or not
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("no such user"), bcrypt.DefaultCost)
+
+// Login checks a name and password, and issues a session token that expires after ttl,
+// ErrCredentials is returned when they do not match
+func Login(repo Repo, name string, password string, ttl time.Duration) (string, *Token, *User, error) {
+	u, err := repo.GetUserByName(strings.TrimSpace(name))
+	if err == sql.ErrNoRows {
+		bcrypt.CompareHashAndPassword(dummyHash, []byte(password))
+		return "", nil, nil, ErrCredentials
+	}
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if !u.CheckPassword(password) {
+		return "", nil, nil, ErrCredentials
+	}
+	token, t, err := NewToken(repo, u, TokenSession, "", ttl)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return token, t, u, nil
+}
+
+// Authenticate gets the user and the token a bearer token authenticates, nil if it does not, expired tokens are removed
+func Authenticate(repo Repo, token string) (*User, *Token, error) {
+	if token == "" {
+		return nil, nil, nil
+	}
+	hash := HashToken(token)
+	t, err := repo.GetToken(hash)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	if t.Expired(time.Now()) {
+		err = repo.DeleteToken(hash)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, nil, err
+		}
+		return nil, nil, nil
+	}
+	u, err := repo.GetUser(t.User.String())
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return u, t, nil
+}
+
+type contextKey struct{}
+
+type authenticated struct {
+	User  *User
+	Token *Token
+}
+
+// NewContext returns a context carrying the authenticated user and token
+func NewContext(ctx context.Context, u *User, t *Token) context.Context {
+	return context.WithValue(ctx, contextKey{}, &authenticated{User: u, Token: t})
+}
+
+// FromContext gets the authenticated user and token of a context, nil if there is none
+func FromContext(ctx context.Context) (*User, *Token) {
+	a, ok := ctx.Value(contextKey{}).(*authenticated)
+	if !ok {
+		return nil, nil
+	}
+	return a.User, a.Token
+}
@@ -0,0 +1,166 @@
+package user
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type memoryRepo struct {
+	users  map[string]*User
+	tokens map[string]*Token
+}
+
+func newMemoryRepo() *memoryRepo {
+	return &memoryRepo{users: make(map[string]*User), tokens: make(map[string]*Token)}
+}
+
+func (m *memoryRepo) SaveUser(u *User) error { m.users[u.ID.String()] = u; return nil }
+func (m *memoryRepo) GetUser(id string) (*User, error) {
+	u, ok := m.users[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return u, nil
+}
+func (m *memoryRepo) GetUserByName(name string) (*User, error) {
+	for _, u := range m.users {
+		if u.Name == name {
+			return u, nil
+		}
+	}
+	return nil, sql.ErrNoRows
+}
+func (m *memoryRepo) GetUsers() ([]*User, error)                { return nil, nil }
+func (m *memoryRepo) DeleteUser(id string) error                { delete(m.users, id); return nil }
+func (m *memoryRepo) SaveToken(t *Token) error                  { m.tokens[t.Hash] = t; return nil }
+func (m *memoryRepo) GetUserTokens(id string) ([]*Token, error) { return nil, nil }
+func (m *memoryRepo) GetToken(hash string) (*Token, error) {
+	t, ok := m.tokens[hash]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return t, nil
+}
+func (m *memoryRepo) DeleteToken(hash string) error {
+	if _, ok := m.tokens[hash]; !ok {
+		return sql.ErrNoRows
+	}
+	delete(m.tokens, hash)
+	return nil
+}
+
+func TestRole_Allows(t *testing.T) {
+	assert.True(t, RoleAdmin.Allows(RoleOperator))
+	assert.True(t, RoleOperator.Allows(RoleOperator))
+	assert.True(t, RoleOperator.Allows(RoleViewer))
+	assert.False(t, RoleViewer.Allows(RoleOperator))
+	assert.False(t, RoleOperator.Allows(RoleAdmin))
+	assert.False(t, Role("root").Allows(RoleViewer))
+}
+
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name     string
+		user     string
+		password string
+		role     Role
+		wantErr  bool
+	}{
+		{
+			name:     "Valid",
+			user:     " alice ",
+			password: "correct horse",
+			role:     RoleOperator,
+		},
+		{
+			name:     "No name",
+			password: "correct horse",
+			role:     RoleViewer,
+			wantErr:  true,
+		},
+		{
+			name:     "Short password",
+			user:     "alice",
+			password: "horse",
+			role:     RoleViewer,
+			wantErr:  true,
+		},
+		{
+			name:     "Unknown role",
+			user:     "alice",
+			password: "correct horse",
+			role:     "root",
+			wantErr:  true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := New(tt.user, tt.password, tt.role)
+			if tt.wantErr {
+				assert.True(t, errors.Is(err, ErrInvalid), err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "alice", u.Name)
+			assert.NotEqual(t, tt.password, u.PasswordHash)
+			assert.True(t, u.CheckPassword(tt.password))
+			assert.False(t, u.CheckPassword("wrong horse"))
+		})
+	}
+}
+
+func TestLogin(t *testing.T) {
+	repo := newMemoryRepo()
+	u, err := New("alice", "correct horse", RoleViewer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.NoError(t, repo.SaveUser(u))
+
+	_, _, _, err = Login(repo, "alice", "wrong horse", time.Hour)
+	assert.Equal(t, ErrCredentials, err)
+	_, _, _, err = Login(repo, "bob", "correct horse", time.Hour)
+	assert.Equal(t, ErrCredentials, err)
+
+	token, tok, logged, err := Login(repo, "alice", "correct horse", time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, u, logged)
+	assert.Equal(t, TokenSession, tok.Kind)
+	assert.NotNil(t, tok.ExpiresAt)
+	assert.Equal(t, HashToken(token), tok.Hash)
+
+	authenticated, authToken, err := Authenticate(repo, token)
+	assert.NoError(t, err)
+	assert.Equal(t, u, authenticated)
+	assert.Equal(t, tok, authToken)
+
+	authenticated, _, err = Authenticate(repo, "not a token")
+	assert.NoError(t, err)
+	assert.Nil(t, authenticated)
+
+	past := time.Now().Add(-time.Minute)
+	tok.ExpiresAt = &past
+	authenticated, _, err = Authenticate(repo, token)
+	assert.NoError(t, err)
+	assert.Nil(t, authenticated)
+	assert.Empty(t, repo.tokens, "an expired token is removed")
+
+	key, _, err := NewToken(repo, u, TokenKey, "dashboard", 0)
+	assert.NoError(t, err)
+	authenticated, keyToken, err := Authenticate(repo, key)
+	assert.NoError(t, err)
+	assert.Equal(t, u, authenticated)
+	assert.Nil(t, keyToken.ExpiresAt)
+
+	ctx := NewContext(context.Background(), u, keyToken)
+	ctxUser, ctxToken := FromContext(ctx)
+	assert.Equal(t, u, ctxUser)
+	assert.Equal(t, keyToken, ctxToken)
+	ctxUser, _ = FromContext(context.Background())
+	assert.Nil(t, ctxUser)
+}